@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-clix/cli"
+	"github.com/rs/zerolog/log"
+
+	"github.com/grafana/tanka/pkg/drift"
+	"github.com/grafana/tanka/pkg/helm"
+	"github.com/grafana/tanka/pkg/kustomize"
+	"github.com/grafana/tanka/pkg/tanka"
+)
+
+func watchDriftCmd(ctx context.Context) *cli.Command {
+	args := generateWorkflowArgs(ctx)
+	args.Validator = cli.ArgsRange(0, 1)
+
+	cmd := &cli.Command{
+		Use:   "watch-drift [<path>]",
+		Short: "periodically check environments for drift, exposing the results as Prometheus metrics",
+		Args:  args,
+	}
+
+	var jsonnetImplementation string
+	jsonnetImplementationFlag(cmd.Flags(), &jsonnetImplementation)
+	getLabelSelector := labelSelectorFlag(cmd.Flags())
+	getJsonnetOpts := jsonnetFlags(cmd.Flags())
+	interval := cmd.Flags().Duration("interval", 5*time.Minute, "time between checks")
+	listenAddr := cmd.Flags().String("listen-address", ":9180", "address to serve /metrics, /status and /healthz on")
+
+	cmd.Run = func(_ *cli.Command, args []string) error {
+		path := "."
+		if len(args) == 1 {
+			path = args[0]
+		}
+		if *interval <= 0 {
+			return errors.New("--interval must be positive")
+		}
+
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		w := drift.New(func(ctx context.Context) ([]tanka.DriftResult, error) {
+			ctx, span := tracer.Start(ctx, "watchDriftCmd.check")
+			defer span.End()
+
+			// charts and Kustomizations may have changed since the last check
+			helm.ClearTemplateCache()
+			kustomize.ClearBuildCache()
+
+			return tanka.FindDrift(ctx, path, tanka.DriftOpts{
+				Opts: tanka.Opts{
+					JsonnetOpts:           getJsonnetOpts(),
+					JsonnetImplementation: jsonnetImplementation,
+				},
+				Selector: getLabelSelector(),
+			})
+		}, *interval)
+
+		server := &http.Server{
+			Addr:              *listenAddr,
+			Handler:           w.Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		serveErr := make(chan error, 1)
+		go func() {
+			log.Info().Str("address", *listenAddr).Msg("Serving drift metrics")
+			serveErr <- server.ListenAndServe()
+		}()
+
+		checkCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		done := make(chan struct{})
+		go func() {
+			w.Run(checkCtx)
+			close(done)
+		}()
+
+		select {
+		case err := <-serveErr:
+			cancel()
+			<-done
+			return err
+		case <-ctx.Done():
+		}
+		<-done
+
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelShutdown()
+		return server.Shutdown(shutdownCtx)
+	}
+	return cmd
+}
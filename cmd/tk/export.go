@@ -4,11 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"regexp"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/go-clix/cli"
+	"github.com/rs/zerolog/log"
 
+	"github.com/grafana/tanka/pkg/cache"
+	"github.com/grafana/tanka/pkg/jsonnet"
 	"github.com/grafana/tanka/pkg/process"
 	"github.com/grafana/tanka/pkg/spec/v1alpha1"
 	"github.com/grafana/tanka/pkg/tanka"
@@ -16,12 +23,15 @@ import (
 
 func exportCmd(ctx context.Context) *cli.Command {
 	args := generateWorkflowArgs(ctx)
-	args.Validator = cli.ArgsMin(2)
+	args.Validator = cli.ValidateMin(1)
 
 	cmd := &cli.Command{
-		Use:   "export <outputDir> <path> [<path>...]",
+		Use:   "export <output> <path> [<path>...]",
 		Short: "export environments found in path(s)",
-		Args:  args,
+		Long: `export environments found in path(s)
+
+With --oci, <output> is omitted: tk export --oci <ref> <path> [<path>...]`,
+		Args: args,
 	}
 
 	format := cmd.Flags().String(
@@ -32,8 +42,13 @@ func exportCmd(ctx context.Context) *cli.Command {
 
 	extension := cmd.Flags().String("extension", "yaml", "File extension")
 	parallel := cmd.Flags().IntP("parallel", "p", 8, "Number of environments to process in parallel")
-	cachePath := cmd.Flags().StringP("cache-path", "c", "", "Local file path where cached evaluations should be stored")
+	cachePath := cmd.Flags().StringP("cache-path", "c", "", "Local file path or s3://bucket/prefix URL where cached evaluations should be stored")
 	cacheEnvs := cmd.Flags().StringArrayP("cache-envs", "e", nil, "Regexes which define which environment should be cached (if caching is enabled)")
+	cacheTTL := cmd.Flags().Duration("cache-ttl", 0, "Remove cached evaluations that were not used for this long. 0 disables expiry")
+	cacheMaxSize := cmd.Flags().Int64("cache-max-size", 0, "Maximum size of the evaluation cache in bytes. Least recently used evaluations are removed first. 0 means unlimited")
+	templateCachePath := cmd.Flags().String("template-cache-path", "", "Local directory where rendered helmTemplate and kustomizeBuild results should be cached across runs")
+	templateCacheTTL := cmd.Flags().Duration("template-cache-ttl", 7*24*time.Hour, "Remove cached templates that were not used for this long. 0 disables expiry")
+	templateCacheMaxSize := cmd.Flags().Int64("template-cache-max-size", 0, "Maximum size of the template cache in bytes. Least recently used templates are removed first. 0 means unlimited")
 	ballastBytes := cmd.Flags().Int("mem-ballast-size-bytes", 0, "Size of memory ballast to allocate. This may improve performance for large environments.")
 
 	merge := cmd.Flags().Bool("merge", false, "Allow merging with existing directory")
@@ -43,6 +58,9 @@ func exportCmd(ctx context.Context) *cli.Command {
 	mergeStrategy := cmd.Flags().String("merge-strategy", "", "What to do when exporting to an existing directory. The default setting is to disallow exporting to an existing directory. Values: 'fail-on-conflicts', 'replace-envs'")
 	mergeDeletedEnvs := cmd.Flags().StringArray("merge-deleted-envs", nil, "Tanka main files that have been deleted. This is used when using a merge strategy to also delete the files of these deleted environments.")
 	skipManifest := cmd.Flags().Bool("skip-manifest", false, "Skip generating manifest.json file that tracks exported files")
+	sink := cmd.Flags().String("sink", "", "How to write the exported manifests: 'dir' (one file per manifest), 'yaml' (multi-document stream), 'jsonl' (JSON Lines with environment metadata), 'tar.gz' or 'zip'. By default, '-' writes yaml to stdout, files ending in .tar.gz, .tgz, .zip or .jsonl use that format and anything else is a directory")
+	oci := cmd.Flags().String("oci", "", "Push each environment as an OCI artifact to a repository below this registry/repository[:tag] instead of writing to <output>. The tag defaults to the git revision of the sources")
+	since := cmd.Flags().String("since", "", "Only export environments affected by files changed since this git ref, and delete the files of environments deleted since then. Implies --merge-strategy=replace-envs")
 
 	vars := workflowFlags(cmd.Flags())
 	getJsonnetOpts := jsonnetFlags(cmd.Flags())
@@ -63,6 +81,25 @@ func exportCmd(ctx context.Context) *cli.Command {
 			return err
 		}
 
+		output, envPaths := "", args
+		if *oci == "" {
+			if err := cli.ValidateMin(2)(args); err != nil {
+				return err
+			}
+			output, envPaths = args[0], args[1:]
+		}
+
+		sinkFormat, err := exportSinkFormat(output, *sink)
+		if err != nil {
+			return err
+		}
+		if *oci != "" {
+			if *sink != "" {
+				return fmt.Errorf("--oci and --sink cannot be used together")
+			}
+			sinkFormat = ""
+		}
+
 		opts := tanka.ExportEnvOpts{
 			Format:    *format,
 			Extension: *extension,
@@ -81,6 +118,17 @@ func exportCmd(ctx context.Context) *cli.Command {
 		if opts.MergeStrategy, err = determineMergeStrategy(*merge, *mergeStrategy); err != nil {
 			return err
 		}
+		if *since != "" {
+			switch opts.MergeStrategy {
+			case tanka.ExportMergeStrategyNone, tanka.ExportMergeStrategyReplaceEnvs:
+				opts.MergeStrategy = tanka.ExportMergeStrategyReplaceEnvs
+			default:
+				return fmt.Errorf("--since requires --merge-strategy=%s", tanka.ExportMergeStrategyReplaceEnvs)
+			}
+		}
+		if (sinkFormat != "" || *oci != "") && opts.MergeStrategy != tanka.ExportMergeStrategyNone {
+			return fmt.Errorf("--merge-strategy and --since are only supported when exporting to a directory")
+		}
 
 		opts.Opts.CachePath = *cachePath
 		for _, expr := range *cacheEnvs {
@@ -91,11 +139,52 @@ func exportCmd(ctx context.Context) *cli.Command {
 			opts.Opts.CachePathRegexes = append(opts.Opts.CachePathRegexes, regex)
 		}
 
+		if *cachePath != "" {
+			evalCache, err := jsonnet.NewEvalCache(*cachePath, jsonnet.EvalCacheOpts{
+				TTL:     *cacheTTL,
+				MaxSize: *cacheMaxSize,
+			})
+			if err != nil {
+				return err
+			}
+			opts.Opts.Cache = evalCache
+			defer func() {
+				if err := evalCache.Evict(); err != nil {
+					log.Warn().Err(err).Msg("Failed to evict entries from the evaluation cache")
+				}
+			}()
+		}
+
+		if *templateCachePath != "" {
+			disk := &cache.Disk{
+				Directory: *templateCachePath,
+				TTL:       *templateCacheTTL,
+				MaxSize:   *templateCacheMaxSize,
+			}
+			cache.SetTemplates(disk)
+			defer func() {
+				if err := disk.Evict(); err != nil {
+					log.Warn().Err(err).Msg("Failed to evict entries from the template cache")
+				}
+			}()
+		}
+
+		paths := envPaths
+		if *since != "" {
+			changed, err := tanka.FindChangedEnvs(ctx, paths, *since)
+			if err != nil {
+				return err
+			}
+			log.Info().Int("changed", len(changed.Entrypoints)).Int("deleted", len(changed.Deleted)).Msgf("Found environments affected by changes since %s", *since)
+			opts.MergeDeletedEnvs = append(opts.MergeDeletedEnvs, changed.Deleted...)
+			paths = changed.Entrypoints
+		}
+
 		var exportEnvs []*v1alpha1.Environment
 		// find possible environments
 		if *recursive {
 			// get absolute path to Environment
-			envs, err := tanka.FindEnvsFromPaths(ctx, args[1:], tanka.FindOpts{Selector: opts.Selector, Parallelism: opts.Parallelism, JsonnetOpts: opts.Opts.JsonnetOpts})
+			envs, err := tanka.FindEnvsFromPaths(ctx, paths, tanka.FindOpts{Selector: opts.Selector, Parallelism: opts.Parallelism, JsonnetOpts: opts.Opts.JsonnetOpts})
 			if err != nil {
 				return err
 			}
@@ -107,31 +196,117 @@ func exportCmd(ctx context.Context) *cli.Command {
 				exportEnvs = append(exportEnvs, env)
 			}
 		} else {
-			if len(args[1:]) > 1 {
+			if len(envPaths) > 1 {
 				return fmt.Errorf("recursive flag is required when exporting multiple environments")
 			}
 
-			// validate environment
-			env, err := tanka.Peek(ctx, args[1], opts.Opts)
-			if err != nil {
-				switch err.(type) {
-				case tanka.ErrMultipleEnvs:
-					fmt.Println("Please use --name to export a single environment or --recursive to export multiple environments.")
-					return err
-				default:
-					return err
+			// validate environment, unless it is not affected by --since
+			if len(paths) > 0 {
+				env, err := tanka.Peek(ctx, envPaths[0], opts.Opts)
+				if err != nil {
+					switch err.(type) {
+					case tanka.ErrMultipleEnvs:
+						fmt.Println("Please use --name to export a single environment or --recursive to export multiple environments.")
+						return err
+					default:
+						return err
+					}
 				}
-			}
 
-			exportEnvs = append(exportEnvs, env)
+				exportEnvs = append(exportEnvs, env)
+			}
 		}
 
 		// export them
-		return tanka.ExportEnvironments(ctx, exportEnvs, args[0], &opts)
+		switch {
+		case *oci != "":
+			return exportToOCI(ctx, exportEnvs, envPaths[0], *oci, &opts)
+		case sinkFormat != "":
+			return exportToStream(ctx, exportEnvs, output, sinkFormat, &opts)
+		}
+		return tanka.ExportEnvironments(ctx, exportEnvs, output, &opts)
 	}
 	return cmd
 }
 
+// exportSinkFormat returns the format to write output in, or "" if output is
+// a directory
+func exportSinkFormat(output, flag string) (tanka.ExportSinkFormat, error) {
+	switch format := tanka.ExportSinkFormat(flag); format {
+	case "dir":
+		return "", nil
+	case tanka.ExportSinkYAML, tanka.ExportSinkJSONLines, tanka.ExportSinkTarGz, tanka.ExportSinkZip:
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("invalid sink: %q", flag)
+	}
+
+	switch {
+	case output == "-":
+		return tanka.ExportSinkYAML, nil
+	case strings.HasSuffix(output, ".tar.gz"), strings.HasSuffix(output, ".tgz"):
+		return tanka.ExportSinkTarGz, nil
+	case strings.HasSuffix(output, ".zip"):
+		return tanka.ExportSinkZip, nil
+	case strings.HasSuffix(output, ".jsonl"):
+		return tanka.ExportSinkJSONLines, nil
+	}
+	return "", nil
+}
+
+// exportToOCI pushes envs as OCI artifacts to ref. The git revision of
+// sources is recorded in the artifacts.
+func exportToOCI(ctx context.Context, envs []*v1alpha1.Environment, sources, ref string, opts *tanka.ExportEnvOpts) error {
+	sink, err := tanka.NewOCISink(tanka.OCIOpts{
+		Ref:          ref,
+		Revision:     tanka.SourceRevision(sources),
+		SkipManifest: opts.SkipManifest,
+	})
+	if err != nil {
+		return err
+	}
+	opts.Sink = sink
+
+	if err := tanka.ExportEnvironments(ctx, envs, "", opts); err != nil {
+		return err
+	}
+	return sink.Push(ctx)
+}
+
+// exportToStream exports envs to a single file, or stdout if output is "-".
+// The file is removed again if exporting fails.
+func exportToStream(ctx context.Context, envs []*v1alpha1.Environment, output string, format tanka.ExportSinkFormat, opts *tanka.ExportEnvOpts) (err error) {
+	var w io.Writer = os.Stdout
+	if output != "-" {
+		// not shadowing err, which the deferred cleanup depends on
+		f, cerr := os.Create(output)
+		if cerr != nil {
+			return cerr
+		}
+		defer func() {
+			if cerr := f.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(output)
+			}
+		}()
+		w = f
+	}
+
+	sink, err := tanka.NewStreamSink(w, format, opts.SkipManifest)
+	if err != nil {
+		return err
+	}
+	opts.Sink = sink
+
+	if err := tanka.ExportEnvironments(ctx, envs, output, opts); err != nil {
+		return err
+	}
+	return sink.Close()
+}
+
 // `--merge` is deprecated in favor of `--merge-strategy`. However, merge has to keep working for now.
 func determineMergeStrategy(deprecatedMergeFlag bool, mergeStrategy string) (tanka.ExportMergeStrategy, error) {
 	if deprecatedMergeFlag && mergeStrategy != "" {
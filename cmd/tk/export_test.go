@@ -2,10 +2,14 @@ package main
 
 import (
 	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/grafana/tanka/pkg/spec/v1alpha1"
 	"github.com/grafana/tanka/pkg/tanka"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDetermineMergeStrategy(t *testing.T) {
@@ -61,3 +65,52 @@ func TestDetermineMergeStrategy(t *testing.T) {
 		})
 	}
 }
+
+func TestExportSinkFormat(t *testing.T) {
+	cases := []struct {
+		name      string
+		output    string
+		sink      string
+		expected  tanka.ExportSinkFormat
+		expectErr error
+	}{
+		{name: "directory", output: "out"},
+		{name: "stdout", output: "-", expected: tanka.ExportSinkYAML},
+		{name: "tar.gz", output: "out.tar.gz", expected: tanka.ExportSinkTarGz},
+		{name: "tgz", output: "out.tgz", expected: tanka.ExportSinkTarGz},
+		{name: "zip", output: "out.zip", expected: tanka.ExportSinkZip},
+		{name: "jsonl", output: "out.jsonl", expected: tanka.ExportSinkJSONLines},
+		{name: "flag overrides extension", output: "-", sink: "jsonl", expected: tanka.ExportSinkJSONLines},
+		{name: "flag forces directory", output: "out.zip", sink: "dir"},
+		{name: "bad value", output: "out", sink: "blabla", expectErr: errors.New("invalid sink: \"blabla\"")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := exportSinkFormat(tc.output, tc.sink)
+			if tc.expectErr != nil {
+				assert.EqualError(t, err, tc.expectErr.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestExportToStreamFailureRemovesFile(t *testing.T) {
+	dir := t.TempDir()
+	env := v1alpha1.New()
+	env.Metadata.Name = "missing"
+	env.Metadata.Namespace = filepath.Join(dir, "missing", "main.jsonnet")
+
+	for _, format := range []tanka.ExportSinkFormat{tanka.ExportSinkTarGz, tanka.ExportSinkZip, tanka.ExportSinkJSONLines} {
+		t.Run(string(format), func(t *testing.T) {
+			output := filepath.Join(dir, "out."+string(format))
+			err := exportToStream(t.Context(), []*v1alpha1.Environment{env}, output, format, &tanka.ExportEnvOpts{})
+			require.Error(t, err)
+
+			_, err = os.Stat(output)
+			assert.True(t, os.IsNotExist(err), "partial %s was left behind", output)
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/pflag"
@@ -33,11 +34,15 @@ func workflowFlags(fs *pflag.FlagSet) *workflowFlagVars {
 
 func addDiffFlags(fs *pflag.FlagSet, opts *tanka.DiffBaseOpts) {
 	fs.StringVar(&opts.Color, "color", "auto", `controls color in diff output, must be "auto", "always", or "never"`)
+	fs.BoolVar(&opts.SideBySide, "side-by-side", false, "render diffs in two columns, live state on the left and desired state on the right")
+	fs.BoolVar(&opts.ShowSecrets, "show-secrets", false, "print secrets in plain text: the values of Secrets and decrypted values")
 }
 
 func addApplyFlags(fs *pflag.FlagSet, opts *tanka.ApplyBaseOpts, autoApproveDeprecated *bool, autoApprove *string) {
 	fs.StringVar(&opts.DryRun, "dry-run", "", `--dry-run parameter to pass down to kubectl, must be "none", "server", or "client"`)
 	fs.BoolVar(&opts.Force, "force", false, "force applying (kubectl apply --force)")
+	fs.BoolVar(&opts.Wait, "wait", false, "wait for applied resources to become ready (or deleted resources to be gone)")
+	fs.DurationVar(&opts.WaitTimeout, "wait-timeout", 5*time.Minute, "maximum time to --wait for, and to wait for each apply phase to be established. 0 waits forever")
 
 	// Parse the auto-approve flag (choice), still supporting the deprecated dangerous-auto-approve flag (boolean)
 	fs.BoolVar(autoApproveDeprecated, "dangerous-auto-approve", false, "skip interactive approval. Only for automation!")
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-clix/cli"
+	"github.com/posener/complete"
+
+	"github.com/grafana/tanka/pkg/process"
+	"github.com/grafana/tanka/pkg/tanka"
+)
+
+func historyCmd(ctx context.Context) *cli.Command {
+	cmd := &cli.Command{
+		Use:   "history <path>",
+		Short: "list the revisions previously applied to the environment",
+		Args:  generateWorkflowArgs(ctx),
+	}
+
+	vars := workflowFlags(cmd.Flags())
+	getJsonnetOpts := jsonnetFlags(cmd.Flags())
+
+	cmd.Run = func(_ *cli.Command, args []string) error {
+		ctx, span := tracer.Start(ctx, "historyCmd")
+		defer span.End()
+
+		revs, err := tanka.History(ctx, args[0], tanka.Opts{
+			JsonnetOpts:           getJsonnetOpts(),
+			Name:                  vars.name,
+			JsonnetImplementation: vars.jsonnetImplementation,
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(revs) == 0 {
+			fmt.Fprintln(os.Stderr, "No revisions recorded yet.")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', 0)
+		fmt.Fprintln(w, "REVISION\tAPPLIED\tRESOURCES\tDESCRIPTION")
+		for _, r := range revs {
+			fmt.Fprintf(w, "%d\t%s\t%d\t%s\n", r.Number, r.Timestamp.Local().Format(time.RFC3339), r.Resources, r.Description)
+		}
+		return w.Flush()
+	}
+	return cmd
+}
+
+func rollbackCmd(ctx context.Context) *cli.Command {
+	cmd := &cli.Command{
+		Use:   "rollback <path>",
+		Short: "re-apply a revision listed by `tk history`",
+		Args:  generateWorkflowArgs(ctx),
+		Predictors: complete.Flags{
+			"color":          colorValues,
+			"diff-strategy":  cli.PredictSet("native", "subset", "validate", "server", "semantic", "none"),
+			"apply-strategy": cli.PredictSet("client", "server"),
+		},
+	}
+
+	var opts tanka.RollbackOpts
+	cmd.Flags().IntVar(&opts.To, "to", 0, "revision to roll back to")
+	cmd.Flags().BoolVar(&opts.Validate, "validate", true, "validation of resources (kubectl --validate=false)")
+	cmd.Flags().StringVar(&opts.ApplyStrategy, "apply-strategy", "", "force the apply strategy to use. Automatically chosen if not set.")
+	cmd.Flags().StringVar(&opts.DiffStrategy, "diff-strategy", "", "force the diff strategy to use. Automatically chosen if not set.")
+
+	var (
+		autoApproveDeprecated bool
+		autoApproveString     string
+	)
+	addApplyFlags(cmd.Flags(), &opts.ApplyBaseOpts, &autoApproveDeprecated, &autoApproveString)
+	addDiffFlags(cmd.Flags(), &opts.DiffBaseOpts)
+	vars := workflowFlags(cmd.Flags())
+	getJsonnetOpts := jsonnetFlags(cmd.Flags())
+
+	cmd.Run = func(_ *cli.Command, args []string) error {
+		ctx, span := tracer.Start(ctx, "rollbackCmd")
+		defer span.End()
+		if opts.To <= 0 {
+			return fmt.Errorf("--to is required and must be a revision listed by `tk history`")
+		}
+		err := validateDryRun(opts.DryRun)
+		if err != nil {
+			return err
+		}
+		if opts.AutoApprove, err = validateAutoApprove(autoApproveDeprecated, autoApproveString); err != nil {
+			return err
+		}
+		if err := setForceColor(&opts.DiffBaseOpts); err != nil {
+			return err
+		}
+
+		filters, err := process.StrExps(vars.targets...)
+		if err != nil {
+			return err
+		}
+		opts.Filters = filters
+		opts.JsonnetOpts = getJsonnetOpts()
+		opts.Name = vars.name
+		opts.JsonnetImplementation = vars.jsonnetImplementation
+
+		return tanka.Rollback(ctx, args[0], opts)
+	}
+	return cmd
+}
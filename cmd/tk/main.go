@@ -51,6 +51,9 @@ func main() {
 		diffCmd(ctx),
 		pruneCmd(ctx),
 		deleteCmd(ctx),
+		historyCmd(ctx),
+		rollbackCmd(ctx),
+		watchDriftCmd(ctx),
 	)
 
 	addCommandsWithLogLevelOption(
@@ -31,6 +31,8 @@ func toolCmd(ctx context.Context) *cli.Command {
 		importersCmd(ctx),
 		importersCountCmd(ctx),
 		chartsCmd(ctx),
+		kustomizeCmd(ctx),
+		vendorCmd(ctx),
 	)
 	return cmd
 }
@@ -29,6 +29,7 @@ func chartsCmd(ctx context.Context) *cli.Command {
 		chartsVendorCmd(ctx),
 		chartsConfigCmd(ctx),
 		chartsVersionCheckCmd(ctx),
+		chartsUpdateCmd(ctx),
 	)
 
 	return cmd
@@ -40,6 +41,7 @@ func chartsVendorCmd(ctx context.Context) *cli.Command {
 		Short: "Download Charts to a local folder",
 	}
 	prune := cmd.Flags().Bool("prune", false, "also remove non-vendored files from the destination directory")
+	verify := cmd.Flags().Bool("verify", false, "instead of vendoring, check that the vendored charts match chartfile.lock and upstream")
 	repoConfigPath := cmd.Flags().String("repository-config", "", repoConfigFlagUsage)
 
 	cmd.Run = func(_ *cli.Command, _ []string) error {
@@ -50,6 +52,12 @@ func chartsVendorCmd(ctx context.Context) *cli.Command {
 			return err
 		}
 
+		if *verify {
+			if *prune {
+				return fmt.Errorf("--prune and --verify cannot be used together")
+			}
+			return c.Verify(*repoConfigPath)
+		}
 		return c.Vendor(*prune, *repoConfigPath)
 	}
 
@@ -60,6 +68,9 @@ func chartsAddCmd(ctx context.Context) *cli.Command {
 	cmd := &cli.Command{
 		Use:   "add [chart@version] [...]",
 		Short: "Adds Charts to the chartfile",
+		Long: `Adds Charts to the chartfile and vendors them. Charts are given as
+repo/chart@version(:path), or as oci://registry/org/chart@version(@sha256:digest)(:path)
+to pull them from an OCI registry directly, optionally pinned to a manifest digest.`,
 	}
 	repoConfigPath := cmd.Flags().String("repository-config", "", repoConfigFlagUsage)
 
@@ -189,6 +200,43 @@ func chartsVersionCheckCmd(ctx context.Context) *cli.Command {
 	return cmd
 }
 
+func chartsUpdateCmd(ctx context.Context) *cli.Command {
+	cmd := &cli.Command{
+		Use:   "update [chart...]",
+		Short: "Update charts to the latest version matching their constraint",
+		Long: `Sets the version of required charts to the latest version matching their
+constraint, vendors them and rewrites chartfile.yaml and chartfile.lock.
+Charts are selected by name or directory, all charts with a constraint are
+updated if none are given. Prints the version and appVersion changes.`,
+	}
+	repoConfigPath := cmd.Flags().String("repository-config", "", repoConfigFlagUsage)
+
+	cmd.Run = func(_ *cli.Command, args []string) error {
+		_, span := tracer.Start(ctx, "chartsUpdateCmd")
+		defer span.End()
+		c, err := loadChartfile()
+		if err != nil {
+			return err
+		}
+
+		updates, err := c.Update(args, *repoConfigPath)
+		if err != nil {
+			return err
+		}
+
+		if len(updates) == 0 {
+			fmt.Fprintln(os.Stderr, "All charts are up to date.")
+			return nil
+		}
+		for _, u := range updates {
+			fmt.Println(u)
+		}
+		return nil
+	}
+
+	return cmd
+}
+
 func loadChartfile() (*helm.Charts, error) {
 	wd, err := os.Getwd()
 	if err != nil {
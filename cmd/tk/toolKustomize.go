@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-clix/cli"
+	"github.com/grafana/tanka/pkg/kustomize"
+)
+
+func kustomizeCmd(ctx context.Context) *cli.Command {
+	cmd := &cli.Command{
+		Use:   "kustomize",
+		Short: "Declarative vendoring of remote Kustomize resources",
+		Args:  cli.ArgsMin(1), // Make sure we print out the help if no subcommand is given, `tk tool kustomize` is not valid
+	}
+
+	addCommandsWithLogLevelOption(
+		cmd,
+		kustomizeInitCmd(ctx),
+		kustomizeVendorCmd(ctx),
+	)
+
+	return cmd
+}
+
+func kustomizeVendorCmd(ctx context.Context) *cli.Command {
+	cmd := &cli.Command{
+		Use:   "vendor",
+		Short: "Download remote resources to a local folder",
+		Long: `Downloads the git and HTTP sources listed in kustomizefile.yaml to a local
+folder, and records what was downloaded in kustomizefile.lock. Reference the
+vendored directories from your Kustomizations instead of remote URLs.`,
+	}
+	prune := cmd.Flags().Bool("prune", false, "also remove non-vendored files from the destination directory")
+
+	cmd.Run = func(_ *cli.Command, _ []string) error {
+		_, span := tracer.Start(ctx, "kustomizeVendorCmd")
+		defer span.End()
+		k, err := loadKustomizefile()
+		if err != nil {
+			return err
+		}
+
+		return k.Vendor(*prune)
+	}
+
+	return cmd
+}
+
+func kustomizeInitCmd(ctx context.Context) *cli.Command {
+	cmd := &cli.Command{
+		Use:   "init",
+		Short: "Create a new Kustomizefile",
+	}
+
+	cmd.Run = func(_ *cli.Command, _ []string) error {
+		_, span := tracer.Start(ctx, "kustomizeInitCmd")
+		defer span.End()
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(wd, kustomize.Filename)
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("kustomizefile at '%s' already exists. Aborting", path)
+		}
+
+		if _, err := kustomize.InitKustomizefile(path); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Success! New Kustomizefile created at '%s'", path)
+		return nil
+	}
+
+	return cmd
+}
+
+func loadKustomizefile() (*kustomize.Remotes, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return kustomize.LoadKustomizefile(wd)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/go-clix/cli"
+
+	"github.com/grafana/tanka/pkg/jsonnet/bundler"
+	"github.com/grafana/tanka/pkg/jsonnet/jpath"
+)
+
+func vendorCmd(ctx context.Context) *cli.Command {
+	cmd := &cli.Command{
+		Use:   "vendor",
+		Short: "Vendor the Jsonnet dependencies listed in jsonnetfile.json",
+		Long: `Resolves the git and local dependencies listed in the jsonnetfile.json of the
+project and of environments that have their own, records their commits in
+jsonnetfile.lock.json and populates the vendor/ directories next to them.
+Fails if the same dependency is required at different versions.`,
+		Args: cli.ArgsNone(),
+	}
+	mode := cmd.Flags().String("mode", string(bundler.ModeCopy), "how to place dependencies into vendor/. Values: 'copy', 'symlink' (to a shared cache directory)")
+	update := cmd.Flags().Bool("update", false, "resolve the versions in jsonnetfile.json again, instead of using the locked commits")
+	allowConflicts := cmd.Flags().Bool("allow-conflicts", false, "vendor even if dependencies are required at different versions")
+
+	cmd.Run = func(_ *cli.Command, _ []string) error {
+		_, span := tracer.Start(ctx, "vendorCmd")
+		defer span.End()
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		root, err := jpath.FindRoot(wd)
+		if err != nil {
+			return err
+		}
+
+		return bundler.Vendor(root, bundler.Opts{
+			Mode:           bundler.Mode(*mode),
+			Update:         *update,
+			AllowConflicts: *allowConflicts,
+		})
+	}
+
+	return cmd
+}
@@ -2,16 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 
 	"github.com/fatih/color"
 	"github.com/go-clix/cli"
 	"github.com/posener/complete"
+	"sigs.k8s.io/yaml"
 
+	"github.com/grafana/tanka/pkg/kubernetes"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
 	"github.com/grafana/tanka/pkg/process"
+	"github.com/grafana/tanka/pkg/secrets"
 	"github.com/grafana/tanka/pkg/tanka"
-	"github.com/grafana/tanka/pkg/term"
 )
 
 // special exit codes for tk diff
@@ -77,7 +81,7 @@ func applyCmd(ctx context.Context) *cli.Command {
 		Args:  generateWorkflowArgs(ctx),
 		Predictors: complete.Flags{
 			"color":          colorValues,
-			"diff-strategy":  cli.PredictSet("native", "subset", "validate", "server", "none"),
+			"diff-strategy":  cli.PredictSet("native", "subset", "validate", "server", "semantic", "none"),
 			"apply-strategy": cli.PredictSet("client", "server"),
 		},
 	}
@@ -86,6 +90,7 @@ func applyCmd(ctx context.Context) *cli.Command {
 	cmd.Flags().BoolVar(&opts.Validate, "validate", true, "validation of resources (kubectl --validate=false)")
 	cmd.Flags().StringVar(&opts.ApplyStrategy, "apply-strategy", "", "force the apply strategy to use. Automatically chosen if not set.")
 	cmd.Flags().StringVar(&opts.DiffStrategy, "diff-strategy", "", "force the diff strategy to use. Automatically chosen if not set.")
+	cmd.Flags().BoolVar(&opts.WaitPhases, "wait-phases", false, "wait for each apply phase to be established before applying the next one, not only the crds and namespaces phases. Implied by --wait")
 
 	var (
 		autoApproveDeprecated bool
@@ -230,17 +235,20 @@ func diffCmd(ctx context.Context) *cli.Command {
 		Args:  generateWorkflowArgs(ctx),
 		Predictors: complete.Flags{
 			"color":         colorValues,
-			"diff-strategy": cli.PredictSet("native", "subset", "validate", "server"),
+			"diff-strategy": cli.PredictSet("native", "subset", "validate", "server", "semantic"),
+			"output":        cli.PredictSet("text", "json", "yaml"),
 		},
 	}
 
 	var opts tanka.DiffOpts
+	var output string
 	addDiffFlags(cmd.Flags(), &opts.DiffBaseOpts)
 	cmd.Flags().StringVar(&opts.Strategy, "diff-strategy", "", "force the diff-strategy to use. Automatically chosen if not set.")
 	cmd.Flags().BoolVarP(&opts.Summarize, "summarize", "s", false, "print summary of the differences, not the actual contents")
 	cmd.Flags().BoolVarP(&opts.WithPrune, "with-prune", "p", false, "include objects deleted from the configuration in the differences")
 	cmd.Flags().BoolVarP(&opts.ExitZero, "exit-zero", "z", false, "Exit with 0 even when differences are found.")
 	cmd.Flags().BoolVar(&opts.ListModifiedEnvs, "list-modified-envs", false, "List environments with changes")
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "output format: text (unified diff), json or yaml (changes per object and field)")
 
 	vars := workflowFlags(cmd.Flags())
 	getJsonnetOpts := jsonnetFlags(cmd.Flags())
@@ -260,6 +268,25 @@ func diffCmd(ctx context.Context) *cli.Command {
 		opts.Name = vars.name
 		opts.JsonnetImplementation = vars.jsonnetImplementation
 
+		switch output {
+		case "text":
+		case "json", "yaml":
+			if opts.Summarize || opts.ListModifiedEnvs {
+				return fmt.Errorf("--output=%s cannot be combined with --summarize or --list-modified-envs", output)
+			}
+			changes, err := structuredDiff(ctx, args[0], opts, output)
+			if err != nil {
+				return err
+			}
+			span.End()
+			if changes && !opts.ExitZero {
+				os.Exit(ExitStatusDiff)
+			}
+			os.Exit(ExitStatusClean)
+		default:
+			return fmt.Errorf(`--output must be either: "text", "json" or "yaml"`)
+		}
+
 		changes, err := tanka.Diff(ctx, args[0], opts)
 		if err != nil {
 			return err
@@ -278,7 +305,7 @@ func diffCmd(ctx context.Context) *cli.Command {
 		if opts.ListModifiedEnvs {
 			fmt.Print(*changes)
 		} else {
-			r := term.Colordiff(*changes)
+			r := opts.RenderDiff(*changes)
 			if err := fPageln(r); err != nil {
 				return err
 			}
@@ -301,6 +328,29 @@ func diffCmd(ctx context.Context) *cli.Command {
 	return cmd
 }
 
+// structuredDiff prints the differences per object in the given format and
+// returns whether there are any
+func structuredDiff(ctx context.Context, path string, opts tanka.DiffOpts, format string) (bool, error) {
+	result, err := tanka.StructuredDiff(ctx, path, opts)
+	if err != nil {
+		return false, err
+	}
+
+	var out []byte
+	if format == "json" {
+		out, err = json.MarshalIndent(result, "", "  ")
+		out = append(out, '\n')
+	} else {
+		out, err = yaml.Marshal(result)
+	}
+	if err != nil {
+		return false, err
+	}
+	fmt.Print(opts.Redact(string(out)))
+
+	return result.HasChanges(), nil
+}
+
 func showCmd(ctx context.Context) *cli.Command {
 	cmd := &cli.Command{
 		Use:   "show <path>",
@@ -309,6 +359,7 @@ func showCmd(ctx context.Context) *cli.Command {
 	}
 
 	allowRedirectFlag := cmd.Flags().Bool("dangerous-allow-redirect", false, "allow redirecting output to a file or a pipe.")
+	showSecrets := cmd.Flags().Bool("show-secrets", false, "print secrets in plain text: the values of Secrets and decrypted values")
 
 	vars := workflowFlags(cmd.Flags())
 	getJsonnetOpts := jsonnetFlags(cmd.Flags())
@@ -346,7 +397,27 @@ to bypass this check.`)
 			return err
 		}
 
-		return pageln(pretty.String())
+		out, err := showOutput(pretty, *showSecrets, !interactive)
+		if err != nil {
+			return err
+		}
+		return pageln(out)
 	}
 	return cmd
 }
+
+// showOutput renders list for tk show, masking Secrets and redacting decrypted
+// values unless showSecrets is set. Redirected output is likely applied, which
+// would overwrite the secrets with their masked values, so it is refused then.
+func showOutput(list manifest.List, showSecrets, redirected bool) (string, error) {
+	out := list.String()
+	if showSecrets {
+		return out, nil
+	}
+
+	masked := secrets.Redact(kubernetes.MaskSecrets(list).String())
+	if redirected && masked != out {
+		return "", fmt.Errorf("refusing to redirect the output of tk show, as it contains Secrets or decrypted values that would be masked. Pass --show-secrets to print them in plain text")
+	}
+	return masked, nil
+}
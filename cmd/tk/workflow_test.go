@@ -4,8 +4,10 @@ import (
 	"errors"
 	"testing"
 
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
 	"github.com/grafana/tanka/pkg/tanka"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestValidateAutoApprove(t *testing.T) {
@@ -63,3 +65,33 @@ func TestValidateAutoApprove(t *testing.T) {
 		})
 	}
 }
+
+func TestShowOutput(t *testing.T) {
+	secret := manifest.List{{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": "db"},
+		"stringData": map[string]interface{}{"password": "hunter22"},
+	}}
+	configMap := manifest.List{{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "cfg"},
+	}}
+
+	out, err := showOutput(secret, false, false)
+	require.NoError(t, err)
+	assert.NotContains(t, out, "hunter22")
+
+	// masked values must not be applied by accident
+	_, err = showOutput(secret, false, true)
+	assert.Error(t, err)
+
+	out, err = showOutput(secret, true, true)
+	require.NoError(t, err)
+	assert.Contains(t, out, "hunter22")
+
+	out, err = showOutput(configMap, false, true)
+	require.NoError(t, err)
+	assert.Equal(t, configMap.String(), out)
+}
@@ -0,0 +1,227 @@
+// Package ocitest provides an in-memory OCI registry for tests
+package ocitest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Token is the bearer token handed out by a Registry with credentials
+const Token = "t0ken"
+
+// Registry is a minimal OCI registry, supporting pulls and single-request
+// pushes. If User is set, requests need a bearer token, obtained using basic
+// auth at /token. If RefreshToken is set, it can be exchanged for a token
+// there as well, like Docker identity tokens are.
+type Registry struct {
+	*httptest.Server
+
+	User         string
+	Password     string
+	RefreshToken string
+
+	// UploadLocation is returned as the location of blob uploads. Defaults to
+	// a path of the registry
+	UploadLocation string
+
+	mu sync.Mutex
+	// Manifests and Blobs by digest, Tags map repository:tag to the digest of
+	// a manifest
+	Manifests map[string][]byte
+	Blobs     map[string][]byte
+	Tags      map[string]string
+	// Scopes tokens were requested for
+	Scopes []string
+}
+
+// NewRegistry starts a Registry that is stopped at the end of the test
+func NewRegistry(t testing.TB) *Registry {
+	r := &Registry{
+		Manifests: make(map[string][]byte),
+		Blobs:     make(map[string][]byte),
+		Tags:      make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", r.token)
+	mux.HandleFunc("/v2/", r.serve)
+	r.Server = httptest.NewServer(mux)
+	t.Cleanup(r.Close)
+	return r
+}
+
+// Host returns the host of r, which is accessed using plain HTTP
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+// AddBlob stores data and returns its digest
+func (r *Registry) AddBlob(data []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	digest := Digest(data)
+	r.Blobs[digest] = data
+	return digest
+}
+
+// AddManifest stores manifest as repository:tag and returns its digest
+func (r *Registry) AddManifest(repository, tag string, manifest []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	digest := Digest(manifest)
+	r.Manifests[digest] = manifest
+	r.Tags[repository+":"+tag] = digest
+	return digest
+}
+
+// Manifest returns the manifest tagged repository:tag
+func (r *Registry) Manifest(repository, tag string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, ok := r.Manifests[r.Tags[repository+":"+tag]]
+	return data, ok
+}
+
+// Blob returns the blob with digest
+func (r *Registry) Blob(digest string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, ok := r.Blobs[digest]
+	return data, ok
+}
+
+// TagList returns all repository:tag pairs, sorted
+func (r *Registry) TagList() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tags := make([]string, 0, len(r.Tags))
+	for tag := range r.Tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Digest returns the sha256 digest of data
+func Digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+func (r *Registry) token(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPost {
+		if r.RefreshToken == "" || req.PostFormValue("grant_type") != "refresh_token" || req.PostFormValue("refresh_token") != r.RefreshToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.mu.Lock()
+		r.Scopes = append(r.Scopes, req.PostFormValue("scope"))
+		r.mu.Unlock()
+		fmt.Fprintf(w, `{"access_token": %q}`, Token)
+		return
+	}
+
+	user, pass, ok := req.BasicAuth()
+	if !ok || user != r.User || pass != r.Password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	r.mu.Lock()
+	r.Scopes = append(r.Scopes, req.URL.Query().Get("scope"))
+	r.mu.Unlock()
+	fmt.Fprintf(w, `{"token": %q}`, Token)
+}
+
+func (r *Registry) serve(w http.ResponseWriter, req *http.Request) {
+	if r.User != "" && req.Header.Get("Authorization") != "Bearer "+Token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="ocitest"`, r.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.HasSuffix(p, "/tags/list"):
+		prefix := strings.TrimSuffix(p, "/tags/list") + ":"
+		var tags []string
+		for tag := range r.Tags {
+			if t, ok := strings.CutPrefix(tag, prefix); ok {
+				tags = append(tags, t)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"tags": tags})
+	case strings.HasSuffix(p, "/blobs/uploads/") && req.Method == http.MethodPost:
+		location := r.UploadLocation
+		if location == "" {
+			location = "/v2/" + p + "session?state=1"
+		}
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasSuffix(p, "/blobs/uploads/session") && req.Method == http.MethodPut:
+		data, _ := io.ReadAll(req.Body)
+		digest := Digest(data)
+		if req.URL.Query().Get("state") != "1" || req.URL.Query().Get("digest") != digest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Blobs[digest] = data
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(p, "/manifests/") && req.Method == http.MethodPut:
+		repository, tag, _ := strings.Cut(p, "/manifests/")
+		data, _ := io.ReadAll(req.Body)
+		digest := Digest(data)
+		r.Manifests[digest] = data
+		r.Tags[repository+":"+tag] = digest
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(p, "/manifests/"):
+		repository, reference, _ := strings.Cut(p, "/manifests/")
+		if d, ok := r.Tags[repository+":"+reference]; ok {
+			reference = d
+		}
+		data, ok := r.Manifests[reference]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		var m struct {
+			MediaType string `json:"mediaType"`
+		}
+		_ = json.Unmarshal(data, &m)
+		w.Header().Set("Content-Type", m.MediaType)
+		w.Header().Set("Docker-Content-Digest", Digest(data))
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		if req.Method != http.MethodHead {
+			_, _ = w.Write(data)
+		}
+	case strings.Contains(p, "/blobs/"):
+		data, ok := r.Blobs[p[strings.LastIndex(p, "/")+1:]]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", Digest(data))
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		if req.Method != http.MethodHead {
+			_, _ = w.Write(data)
+		}
+	default:
+		http.NotFound(w, req)
+	}
+}
@@ -0,0 +1,204 @@
+// Package cache persists the results of expensive native functions, such as
+// helmTemplate and kustomizeBuild, across runs of Tanka.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/grafana/tanka/pkg/secrets"
+)
+
+// Disk is a cache storing JSON documents in Directory. Entries that were not
+// used for TTL expire, and the least recently used entries are evicted once
+// the cache grows beyond MaxSize bytes. Zero values disable either limit.
+// A nil *Disk is a valid, disabled cache.
+type Disk struct {
+	Directory string
+	TTL       time.Duration
+	MaxSize   int64
+}
+
+// Key returns a cache key for the given parts
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (d *Disk) path(key string) string {
+	return filepath.Join(d.Directory, key[:2], key+".json")
+}
+
+// Get reads the entry for key into v. Returns false if there is none.
+func (d *Disk) Get(key string, v interface{}) (bool, error) {
+	if d == nil {
+		return false, nil
+	}
+
+	p := d.path(key)
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if d.expired(info.ModTime()) {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		// evicted concurrently
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		log.Debug().Str("path", p).Err(err).Msg("ignoring corrupt cache entry")
+		return false, nil
+	}
+
+	// the modification time tracks the last use, for TTL and LRU eviction
+	now := time.Now()
+	_ = os.Chtimes(p, now, now)
+	return true, nil
+}
+
+// Store saves v as the entry for key. Values containing decrypted secrets are
+// never written to disk.
+func (d *Disk) Store(key string, v interface{}) error {
+	if d == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if secrets.Redact(string(data)) != string(data) {
+		log.Debug().Str("key", key).Msg("not caching result, as it contains decrypted secrets")
+		return nil
+	}
+
+	p := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+
+	// write atomically, as parallel evaluations may store the same key
+	f, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Evict removes expired entries, and the least recently used ones while the
+// cache is larger than MaxSize
+func (d *Disk) Evict() error {
+	if d == nil {
+		return nil
+	}
+
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var entries []entry
+	var total int64
+
+	err := filepath.WalkDir(d.Directory, func(p string, de fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if de.IsDir() || !strings.HasSuffix(p, ".json") {
+			return nil
+		}
+
+		info, err := de.Info()
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if d.expired(info.ModTime()) {
+			return remove(p)
+		}
+
+		entries = append(entries, entry{path: p, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if d.MaxSize <= 0 || total <= d.MaxSize {
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	for _, e := range entries {
+		if total <= d.MaxSize {
+			break
+		}
+		if err := remove(e.path); err != nil {
+			return err
+		}
+		total -= e.size
+	}
+	return nil
+}
+
+func (d *Disk) expired(lastUsed time.Time) bool {
+	return d.TTL > 0 && time.Since(lastUsed) > d.TTL
+}
+
+func remove(p string) error {
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// templates is the cache used by helmTemplate and kustomizeBuild
+var templates atomic.Pointer[Disk]
+
+// SetTemplates sets the cache used by helmTemplate and kustomizeBuild. nil
+// disables caching.
+func SetTemplates(d *Disk) {
+	templates.Store(d)
+}
+
+// Templates returns the cache set using SetTemplates, or nil
+func Templates() *Disk {
+	return templates.Load()
+}
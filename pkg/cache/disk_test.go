@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/tanka/pkg/secrets"
+)
+
+type entry struct {
+	Name string `json:"name"`
+}
+
+func TestDisk(t *testing.T) {
+	d := &Disk{Directory: t.TempDir()}
+	key := Key("helmTemplate", "demo")
+	assert.NotEqual(t, key, Key("helmTemplate", "dem", "o"))
+
+	var got entry
+	ok, err := d.Get(key, &got)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, d.Store(key, entry{Name: "demo"}))
+	ok, err = d.Get(key, &got)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, entry{Name: "demo"}, got)
+
+	// corrupt entries are misses
+	require.NoError(t, os.WriteFile(d.path(key), []byte("{"), 0644))
+	ok, err = d.Get(key, &got)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// a nil cache is disabled
+	var disabled *Disk
+	require.NoError(t, disabled.Store(key, entry{Name: "demo"}))
+	ok, err = disabled.Get(key, &got)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, disabled.Evict())
+}
+
+func TestDiskSecrets(t *testing.T) {
+	d := &Disk{Directory: t.TempDir()}
+	secrets.Register("disk-cache-secret")
+
+	key := Key("secret")
+	require.NoError(t, d.Store(key, entry{Name: "disk-cache-secret"}))
+	assert.NoFileExists(t, d.path(key))
+}
+
+func TestDiskTTL(t *testing.T) {
+	d := &Disk{Directory: t.TempDir(), TTL: time.Hour}
+	used, unused := Key("used"), Key("unused")
+	require.NoError(t, d.Store(used, entry{Name: "used"}))
+	require.NoError(t, d.Store(unused, entry{Name: "unused"}))
+
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(d.path(unused), old, old))
+
+	var got entry
+	ok, err := d.Get(unused, &got)
+	require.NoError(t, err)
+	assert.False(t, ok, "expired entries are misses")
+
+	// using an entry extends its lifetime
+	recent := time.Now().Add(-30 * time.Minute)
+	require.NoError(t, os.Chtimes(d.path(used), recent, recent))
+	ok, err = d.Get(used, &got)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	info, err := os.Stat(d.path(used))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), info.ModTime(), time.Minute)
+
+	require.NoError(t, d.Evict())
+	assert.FileExists(t, d.path(used))
+	assert.NoFileExists(t, d.path(unused))
+}
+
+func TestDiskMaxSize(t *testing.T) {
+	d := &Disk{Directory: t.TempDir()}
+	keys := []string{Key("a"), Key("b"), Key("c")}
+	for i, key := range keys {
+		require.NoError(t, d.Store(key, entry{Name: key}))
+		mtime := time.Now().Add(time.Duration(i-len(keys)) * time.Minute)
+		require.NoError(t, os.Chtimes(d.path(key), mtime, mtime))
+	}
+
+	info, err := os.Stat(d.path(keys[0]))
+	require.NoError(t, err)
+
+	// room for two entries: the least recently used one goes
+	d.MaxSize = 2 * info.Size()
+	require.NoError(t, d.Evict())
+	assert.NoFileExists(t, d.path(keys[0]))
+	assert.FileExists(t, d.path(keys[1]))
+	assert.FileExists(t, d.path(keys[2]))
+
+	// temporary files are not entries
+	require.NoError(t, os.WriteFile(filepath.Join(d.Directory, ".tmp-1"), []byte("partial"), 0644))
+	require.NoError(t, d.Evict())
+	assert.FileExists(t, d.path(keys[2]))
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "templates"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte("version: 1.0.0\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "templates", "a.yaml"), []byte("a"), 0644))
+
+	hash, err := HashDir(dir)
+	require.NoError(t, err)
+	assert.Regexp(t, `^sha256:[a-f0-9]{64}$`, hash)
+
+	// modes are ignored
+	require.NoError(t, os.Chmod(filepath.Join(dir, "Chart.yaml"), 0600))
+	same, err := HashDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, hash, same)
+
+	// contents are not
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "templates", "a.yaml"), []byte("b"), 0644))
+	changed, err := HashDir(dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, changed)
+
+	// nor are paths
+	require.NoError(t, os.Rename(filepath.Join(dir, "templates", "a.yaml"), filepath.Join(dir, "templates", "b.yaml")))
+	renamed, err := HashDir(dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, changed, renamed)
+}
+
+func TestHashDirSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.yaml"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.yaml"), []byte("a"), 0644))
+	require.NoError(t, os.Symlink("a.yaml", filepath.Join(dir, "link.yaml")))
+
+	hash, err := HashDir(dir)
+	require.NoError(t, err)
+
+	// links are hashed by their target, not followed
+	require.NoError(t, os.Remove(filepath.Join(dir, "link.yaml")))
+	require.NoError(t, os.Symlink("b.yaml", filepath.Join(dir, "link.yaml")))
+	retargeted, err := HashDir(dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, retargeted)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "link.yaml")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "link.yaml"), []byte("a"), 0644))
+	copied, err := HashDir(dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, copied)
+}
@@ -0,0 +1,157 @@
+// Package drift periodically checks environments for changes compared to the
+// cluster and exposes the results as Prometheus metrics and JSON.
+package drift
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/grafana/tanka/pkg/tanka"
+)
+
+// CheckFunc returns the drift of all watched environments, e.g. using
+// tanka.FindDrift. An error means the environments could not be found at all.
+type CheckFunc func(ctx context.Context) ([]tanka.DriftResult, error)
+
+// EnvStatus is the drift state of a single environment
+type EnvStatus struct {
+	Name string `json:"name"`
+	// Drifted reports whether the cluster differs from the environment
+	Drifted bool `json:"drifted"`
+	// LastCheck is the time of the most recent check
+	LastCheck time.Time `json:"lastCheck"`
+	// Error of the most recent check, if it failed
+	Error string `json:"error,omitempty"`
+	// Errors is the number of failed checks since startup
+	Errors int `json:"errors"`
+}
+
+// Status is the state of all watched environments
+type Status struct {
+	// LastRun is the time the most recent check was started
+	LastRun time.Time `json:"lastRun"`
+	// Duration of the most recent check, in seconds
+	Duration float64 `json:"durationSeconds"`
+	// Runs is the number of checks since startup
+	Runs int `json:"runs"`
+	// Error is set if the environments could not be found during the most
+	// recent check
+	Error        string      `json:"error,omitempty"`
+	Environments []EnvStatus `json:"environments"`
+}
+
+// Watcher checks environments for drift in an interval and keeps the results
+type Watcher struct {
+	check    CheckFunc
+	interval time.Duration
+
+	mu              sync.RWMutex
+	status          Status
+	envs            map[string]*EnvStatus
+	discoveryErrors int
+
+	now func() time.Time
+}
+
+// New returns a Watcher that calls check every interval
+func New(check CheckFunc, interval time.Duration) *Watcher {
+	return &Watcher{
+		check:    check,
+		interval: interval,
+		envs:     make(map[string]*EnvStatus),
+		now:      time.Now,
+	}
+}
+
+// Run checks for drift immediately and then every interval, until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.Check(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check checks all environments for drift once and records the results.
+// Environments that no longer exist are forgotten.
+func (w *Watcher) Check(ctx context.Context) {
+	start := w.now()
+	results, err := w.check(ctx)
+	end := w.now()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.status.LastRun = start
+	w.status.Duration = end.Sub(start).Seconds()
+	w.status.Runs++
+	w.status.Error = ""
+
+	if err != nil {
+		// keep the previous results, as the environments are still there
+		log.Error().Err(err).Msg("Failed to find environments")
+		w.status.Error = err.Error()
+		w.discoveryErrors++
+		return
+	}
+
+	seen := make(map[string]bool, len(results))
+	drifted := 0
+	for _, r := range results {
+		seen[r.Env] = true
+
+		env, ok := w.envs[r.Env]
+		if !ok {
+			env = &EnvStatus{Name: r.Env}
+			w.envs[r.Env] = env
+		}
+		env.Drifted = r.Changed
+		env.LastCheck = end
+		env.Error = ""
+		if r.Err != nil {
+			env.Error = r.Err.Error()
+			env.Errors++
+		}
+		if r.Changed {
+			drifted++
+		}
+	}
+
+	for name := range w.envs {
+		if !seen[name] {
+			delete(w.envs, name)
+		}
+	}
+
+	log.Info().Int("environments", len(results)).Int("drifted", drifted).Dur("duration", end.Sub(start)).Msg("Checked environments for drift")
+}
+
+// Status returns the current state, environments sorted by name
+func (w *Watcher) Status() Status {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	s := w.status
+	s.Environments = make([]EnvStatus, 0, len(w.envs))
+	for _, env := range w.envs {
+		s.Environments = append(s.Environments, *env)
+	}
+	sort.Slice(s.Environments, func(i, j int) bool {
+		return s.Environments[i].Name < s.Environments[j].Name
+	})
+	return s
+}
@@ -0,0 +1,150 @@
+package drift
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/tanka/pkg/tanka"
+)
+
+// fakeClock advances by one second on every call
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.t = c.t.Add(time.Second)
+	return c.t
+}
+
+func TestWatcher(t *testing.T) {
+	var results []tanka.DriftResult
+	var checkErr error
+	w := New(func(context.Context) ([]tanka.DriftResult, error) {
+		return results, checkErr
+	}, time.Minute)
+	clock := &fakeClock{t: time.Unix(1000, 0).UTC()}
+	w.now = clock.now
+
+	results = []tanka.DriftResult{
+		{Env: "prod", Changed: true},
+		{Env: "dev", Changed: false},
+		{Env: "broken", Changed: true, Err: errors.New("checking for changes: boom")},
+	}
+	w.Check(context.Background())
+
+	assert.Equal(t, Status{
+		LastRun:  time.Unix(1001, 0).UTC(),
+		Duration: 1,
+		Runs:     1,
+		Environments: []EnvStatus{
+			{Name: "broken", Drifted: true, LastCheck: time.Unix(1002, 0).UTC(), Error: "checking for changes: boom", Errors: 1},
+			{Name: "dev", LastCheck: time.Unix(1002, 0).UTC()},
+			{Name: "prod", Drifted: true, LastCheck: time.Unix(1002, 0).UTC()},
+		},
+	}, w.Status())
+
+	// failing to find environments keeps the previous results
+	checkErr = errors.New("no such directory")
+	w.Check(context.Background())
+	s := w.Status()
+	assert.Equal(t, "no such directory", s.Error)
+	assert.Equal(t, 2, s.Runs)
+	assert.Len(t, s.Environments, 3)
+
+	// removed environments are forgotten, errors are counted
+	checkErr = nil
+	results = []tanka.DriftResult{
+		{Env: "prod", Changed: false},
+		{Env: "broken", Changed: true, Err: errors.New("checking for changes: boom")},
+	}
+	w.Check(context.Background())
+	s = w.Status()
+	assert.Empty(t, s.Error)
+	require.Len(t, s.Environments, 2)
+	assert.Equal(t, "broken", s.Environments[0].Name)
+	assert.Equal(t, 2, s.Environments[0].Errors)
+	assert.Equal(t, "prod", s.Environments[1].Name)
+	assert.False(t, s.Environments[1].Drifted)
+
+	var metrics strings.Builder
+	w.WriteMetrics(&metrics)
+	for _, line := range []string{
+		"# TYPE tanka_drift_detected gauge",
+		"tanka_drift_runs_total 3",
+		"tanka_drift_discovery_errors_total 1",
+		"tanka_drift_last_run_timestamp_seconds 1005",
+		"tanka_drift_last_run_duration_seconds 1",
+		"tanka_drift_environments 2",
+		`tanka_drift_detected{environment="broken"} 1`,
+		`tanka_drift_detected{environment="prod"} 0`,
+		`tanka_drift_check_error{environment="broken"} 1`,
+		`tanka_drift_check_error{environment="prod"} 0`,
+		`tanka_drift_check_errors_total{environment="broken"} 2`,
+		`tanka_drift_last_check_timestamp_seconds{environment="prod"} 1006`,
+	} {
+		assert.Contains(t, metrics.String(), line+"\n")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	w := New(func(context.Context) ([]tanka.DriftResult, error) {
+		return []tanka.DriftResult{{Env: `we"ird\env`, Changed: true}}, nil
+	}, time.Minute)
+	server := httptest.NewServer(w.Handler())
+	defer server.Close()
+
+	get := func(path string) (*http.Response, string) {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	resp, _ := get("/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	w.Check(context.Background())
+
+	resp, _ = get("/healthz")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body := get("/metrics")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+	assert.Contains(t, body, `tanka_drift_detected{environment="we\"ird\\env"} 1`)
+
+	resp, body = get("/status")
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var status Status
+	require.NoError(t, json.Unmarshal([]byte(body), &status))
+	require.Len(t, status.Environments, 1)
+	assert.True(t, status.Environments[0].Drifted)
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	w := New(func(context.Context) ([]tanka.DriftResult, error) {
+		calls++
+		if calls == 3 {
+			cancel()
+		}
+		return nil, nil
+	}, time.Millisecond)
+
+	w.Run(ctx)
+	assert.Equal(t, 3, w.Status().Runs)
+}
@@ -0,0 +1,103 @@
+package drift
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Handler serves the state of w:
+//   - /metrics: Prometheus text format
+//   - /status: Status as JSON
+//   - /healthz: 200 once the first check completed
+func (w *Watcher) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.WriteMetrics(rw)
+	})
+	mux.HandleFunc("/status", func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(rw)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(w.Status())
+	})
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, _ *http.Request) {
+		if w.Status().Runs == 0 {
+			http.Error(rw, "first check still running", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(rw, "ok")
+	})
+	return mux
+}
+
+// WriteMetrics writes the state of w in the Prometheus text exposition format
+func (w *Watcher) WriteMetrics(out io.Writer) {
+	s := w.Status()
+
+	w.mu.RLock()
+	discoveryErrors := w.discoveryErrors
+	w.mu.RUnlock()
+
+	metric(out, "tanka_drift_runs_total", "counter", "Number of drift checks since startup.")
+	fmt.Fprintf(out, "tanka_drift_runs_total %d\n", s.Runs)
+
+	metric(out, "tanka_drift_discovery_errors_total", "counter", "Number of drift checks that failed to find environments.")
+	fmt.Fprintf(out, "tanka_drift_discovery_errors_total %d\n", discoveryErrors)
+
+	metric(out, "tanka_drift_last_run_timestamp_seconds", "gauge", "Unix time the most recent drift check started.")
+	fmt.Fprintf(out, "tanka_drift_last_run_timestamp_seconds %s\n", timestamp(s.LastRun.Unix(), s.LastRun.IsZero()))
+
+	metric(out, "tanka_drift_last_run_duration_seconds", "gauge", "Duration of the most recent drift check.")
+	fmt.Fprintf(out, "tanka_drift_last_run_duration_seconds %g\n", s.Duration)
+
+	metric(out, "tanka_drift_environments", "gauge", "Number of environments checked for drift.")
+	fmt.Fprintf(out, "tanka_drift_environments %d\n", len(s.Environments))
+
+	metric(out, "tanka_drift_detected", "gauge", "Whether the cluster differs from the environment (1) or not (0).")
+	for _, env := range s.Environments {
+		fmt.Fprintf(out, "tanka_drift_detected{environment=\"%s\"} %d\n", escapeLabel(env.Name), boolValue(env.Drifted))
+	}
+
+	metric(out, "tanka_drift_check_error", "gauge", "Whether the most recent check of the environment failed (1) or not (0).")
+	for _, env := range s.Environments {
+		fmt.Fprintf(out, "tanka_drift_check_error{environment=\"%s\"} %d\n", escapeLabel(env.Name), boolValue(env.Error != ""))
+	}
+
+	metric(out, "tanka_drift_check_errors_total", "counter", "Number of failed checks of the environment since startup.")
+	for _, env := range s.Environments {
+		fmt.Fprintf(out, "tanka_drift_check_errors_total{environment=\"%s\"} %d\n", escapeLabel(env.Name), env.Errors)
+	}
+
+	metric(out, "tanka_drift_last_check_timestamp_seconds", "gauge", "Unix time the environment was last checked.")
+	for _, env := range s.Environments {
+		fmt.Fprintf(out, "tanka_drift_last_check_timestamp_seconds{environment=\"%s\"} %s\n", escapeLabel(env.Name), timestamp(env.LastCheck.Unix(), env.LastCheck.IsZero()))
+	}
+}
+
+func metric(out io.Writer, name, typ, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func timestamp(unix int64, zero bool) string {
+	if zero {
+		return "0"
+	}
+	return fmt.Sprint(unix)
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,105 @@
+// Package git checks out remote git repositories using the `git` executable,
+// for vendoring remote dependencies
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Checkout fetches ref (a branch, tag or commit) of the repository at remote
+// into dir, which must exist and be empty, and returns the checked out commit.
+// An empty ref checks out the default branch.
+func Checkout(remote, ref, dir string) (string, error) {
+	// these would be parsed as options of git
+	if strings.HasPrefix(remote, "-") {
+		return "", fmt.Errorf("invalid remote %q: must not start with '-'", remote)
+	}
+	if strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("invalid ref %q: must not start with '-'", ref)
+	}
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if _, err := Run(dir, "init", "--quiet"); err != nil {
+		return "", err
+	}
+
+	// fetching only ref is much faster, but does not work for abbreviated
+	// commits, which need the whole history
+	rev := "FETCH_HEAD"
+	if _, err := Run(dir, "fetch", "--quiet", "--depth", "1", "--end-of-options", remote, ref); err != nil {
+		if _, err := Run(dir, "fetch", "--quiet", "--tags", "--end-of-options", remote, "+refs/heads/*:refs/remotes/origin/*"); err != nil {
+			return "", err
+		}
+		rev = ref + "^{commit}"
+	}
+
+	commit, err := Run(dir, "rev-parse", "--verify", "--quiet", rev)
+	if err != nil {
+		return "", fmt.Errorf("%s has no branch, tag or commit %q", remote, ref)
+	}
+	if _, err := Run(dir, "-c", "advice.detachedHead=false", "checkout", "--quiet", commit); err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+// Run runs git in dir and returns its trimmed output
+func Run(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// ChangedFiles returns the files of the repository containing dir that differ
+// between ref and the working tree, including untracked files. Paths are
+// absolute. Files that no longer exist are returned separately as deleted.
+func ChangedFiles(dir, ref string) (changed, deleted []string, err error) {
+	top, err := Run(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// resolving ref first keeps it from being parsed as an option of diff
+	commit, err := Run(top, "rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}")
+	if err != nil {
+		return nil, nil, fmt.Errorf("%q is not a commit", ref)
+	}
+
+	diff, err := Run(top, "diff", "--name-status", "--no-renames", "-z", commit, "--")
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := strings.Split(strings.TrimSuffix(diff, "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		file := filepath.Join(top, filepath.FromSlash(fields[i+1]))
+		if fields[i] == "D" {
+			deleted = append(deleted, file)
+			continue
+		}
+		changed = append(changed, file)
+	}
+
+	untracked, err := Run(top, "ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range strings.Split(untracked, "\x00") {
+		if f != "" {
+			changed = append(changed, filepath.Join(top, filepath.FromSlash(f)))
+		}
+	}
+
+	return changed, deleted, nil
+}
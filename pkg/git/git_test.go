@@ -0,0 +1,112 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func commit(t *testing.T, repo, file, content string) string {
+	require.NoError(t, os.WriteFile(filepath.Join(repo, file), []byte(content), 0644))
+	for _, args := range [][]string{
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", file},
+	} {
+		_, err := Run(repo, args...)
+		require.NoError(t, err)
+	}
+	sha, err := Run(repo, "rev-parse", "HEAD")
+	require.NoError(t, err)
+	return sha
+}
+
+func TestCheckout(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repo := t.TempDir()
+	_, err := Run(repo, "init", "--quiet")
+	require.NoError(t, err)
+	first := commit(t, repo, "a", "1")
+	_, err = Run(repo, "tag", "v1")
+	require.NoError(t, err)
+	second := commit(t, repo, "b", "2")
+	remote := "file://" + repo
+	marker := filepath.Join(t.TempDir(), "pwned")
+
+	testCases := []struct {
+		name   string
+		ref    string
+		commit string
+		err    string
+	}{
+		{name: "default-branch", ref: "", commit: second},
+		{name: "tag", ref: "v1", commit: first},
+		{name: "commit", ref: first, commit: first},
+		{name: "abbreviated-commit", ref: first[:8], commit: first},
+		{name: "missing", ref: "v2", err: `has no branch, tag or commit "v2"`},
+		{name: "option", ref: "--upload-pack=touch " + marker, err: `invalid ref "--upload-pack`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			got, err := Checkout(remote, tc.ref, dir)
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.commit, got)
+			assert.FileExists(t, filepath.Join(dir, "a"))
+		})
+	}
+
+	// remotes must not be taken as options either
+	_, err = Checkout("--upload-pack=touch "+marker+";false", "", t.TempDir())
+	assert.ErrorContains(t, err, `invalid remote "--upload-pack`)
+	assert.NoFileExists(t, marker)
+}
+
+func TestChangedFiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repo := t.TempDir()
+	_, err := Run(repo, "init", "--quiet")
+	require.NoError(t, err)
+	commit(t, repo, "a", "1")
+	commit(t, repo, "b", "1")
+	base := commit(t, repo, "c", "1")
+
+	// committed, staged, unstaged and untracked changes are all included
+	commit(t, repo, "a", "2")
+	require.NoError(t, os.Remove(filepath.Join(repo, "b")))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "c"), []byte("2"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(repo, "dir"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "dir", "d"), []byte("1"), 0644))
+
+	changed, deleted, err := ChangedFiles(filepath.Join(repo, "dir"), base)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(repo, "a"),
+		filepath.Join(repo, "c"),
+		filepath.Join(repo, "dir", "d"),
+	}, changed)
+	assert.Equal(t, []string{filepath.Join(repo, "b")}, deleted)
+
+	_, _, err = ChangedFiles(repo, "missing")
+	assert.ErrorContains(t, err, `"missing" is not a commit`)
+
+	// refs are never taken as options
+	out := filepath.Join(t.TempDir(), "out")
+	_, _, err = ChangedFiles(repo, "--output="+out)
+	assert.Error(t, err)
+	assert.NoFileExists(t, out)
+}
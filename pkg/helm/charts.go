@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/grafana/tanka/pkg/cache"
 	"github.com/rs/zerolog/log"
 	"sigs.k8s.io/yaml"
 )
@@ -14,6 +15,8 @@ import (
 var (
 	// https://regex101.com/r/9m42pQ/1
 	chartExp = regexp.MustCompile(`^(?P<chart>[\w+-\/.]+)@(?P<version>[^:\n\s]+)(?:\:(?P<path>[\w-. ]+))?$`)
+	// oci://registry[:port]/org/chart@version(@sha256:digest)(:path)
+	ociChartExp = regexp.MustCompile(`^(?P<chart>oci://[\w\-./:]+?)@(?P<version>[^@:\n\s]+)(?:@(?P<digest>sha256:[a-f0-9]{64}))?(?:\:(?P<path>[\w-. ]+))?$`)
 	// https://regex101.com/r/xoAx8c/1
 	repoExp = regexp.MustCompile(`^[\w-]+$`)
 )
@@ -55,6 +58,7 @@ func LoadChartfile(projectRoot string) (*Charts, error) {
 
 		// default to ExecHelm, but allow injecting from the outside
 		Helm: ExecHelm{},
+		OCI:  &OCIClient{},
 	}
 	return charts, nil
 }
@@ -93,12 +97,16 @@ type Charts struct {
 	// Helm is the helm implementation underneath. ExecHelm is the default, but
 	// any implementation of the Helm interface may be used
 	Helm Helm
+
+	// OCI pulls charts referenced as oci://registry/org/chart
+	OCI *OCIClient
 }
 
 // chartManifest represents a Helm chart's Chart.yaml
 type chartManifest struct {
-	Name    string `yaml:"name"`
-	Version string `yaml:"version"`
+	Name       string `yaml:"name"`
+	Version    string `yaml:"version"`
+	AppVersion string `yaml:"appVersion"`
 }
 
 // ChartDir returns the directory pulled charts are saved in
@@ -112,7 +120,9 @@ func (c Charts) ManifestFile() string {
 }
 
 // Vendor pulls all Charts specified in the manifest into the local charts
-// directory. It fetches the repository index before doing so.
+// directory. It fetches the repository index before doing so. What was pulled
+// is recorded in the lock file. Charts that are locked are only pulled again
+// if upstream still has the locked digest.
 func (c Charts) Vendor(prune bool, repoConfigPath string) error {
 	dir := c.ChartDir()
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
@@ -129,6 +139,12 @@ func (c Charts) Vendor(prune bool, repoConfigPath string) error {
 		return err
 	}
 
+	lock, err := loadLock(c.LockFile())
+	if err != nil {
+		return err
+	}
+	newLock := Lockfile{Version: LockVersion}
+
 	expectedDirs := make(map[string]bool)
 
 	repositoriesUpdated := false
@@ -141,6 +157,7 @@ func (c Charts) Vendor(prune bool, repoConfigPath string) error {
 		chartPath := filepath.Join(dir, chartSubDir)
 		chartManifestPath := filepath.Join(chartPath, "Chart.yaml")
 		expectedDirs[chartSubDir] = true
+		locked, isLocked := lock.Find(r, chartSubDir)
 
 		chartDirExists, chartManifestExists := false, false
 		if _, err := os.Stat(chartPath); err == nil {
@@ -164,11 +181,18 @@ func (c Charts) Vendor(prune bool, repoConfigPath string) error {
 				return fmt.Errorf("unmarshalling chart manifest: %w", err)
 			}
 
-			if chartYAML.Version == r.Version {
+			if chartYAML.Version == r.Version && isLocked {
+				if hash, err := cache.HashDir(chartPath); err != nil || hash != locked.Hash {
+					log.Warn().Msgf("%s was modified after vendoring. Run `tk tool charts vendor --verify` for details", r)
+				}
 				log.Info().Msgf("%s exists", r)
+				newLock.Charts = append(newLock.Charts, locked)
 				continue
 			}
 
+			if chartYAML.Version == r.Version {
+				log.Info().Msgf("%s is missing from %s, pulling it again", r, LockFilename)
+			}
 			log.Info().Msgf("Removing %s", r)
 			if err := os.RemoveAll(chartPath); err != nil {
 				return err
@@ -181,27 +205,34 @@ func (c Charts) Vendor(prune bool, repoConfigPath string) error {
 			}
 		}
 
-		if !repositoriesUpdated {
+		if !IsOCI(r.Chart) && !repositoriesUpdated {
 			log.Info().Msg("Syncing Repositories ...")
 			if err := c.Helm.RepoUpdate(Opts{Repositories: repositories}); err != nil {
 				return err
 			}
 			repositoriesUpdated = true
 		}
-		log.Info().Msg("Pulling Charts ...")
-		if repoName := parseReqRepo(r.Chart); !repositories.HasName(repoName) {
-			return fmt.Errorf("repository %q not found for chart %q", repoName, r.Chart)
+
+		// pull exactly what was locked before, if possible
+		digest := r.Digest
+		if isLocked && IsOCI(r.Chart) {
+			digest = locked.Digest
 		}
-		err := c.Helm.Pull(r.Chart, r.Version, PullOpts{
-			Destination:      dir,
-			ExtractDirectory: r.Directory,
-			Opts:             Opts{Repositories: repositories},
-		})
+
+		log.Info().Msg("Pulling Charts ...")
+		pulled, err := c.pull(r, digest, dir, chartSubDir, repositories)
 		if err != nil {
 			return err
 		}
+		if isLocked && (pulled.Digest != locked.Digest || pulled.Hash != locked.Hash) {
+			if err := os.RemoveAll(chartPath); err != nil {
+				return err
+			}
+			return ErrorLockMismatch{Requirement: r, Locked: locked, Pulled: pulled}
+		}
+		newLock.Charts = append(newLock.Charts, pulled)
 
-		log.Info().Msgf("%s@%s downloaded", r.Chart, r.Version)
+		log.Info().Msgf("%s@%s downloaded (%s)", r.Chart, r.Version, pulled.Digest)
 	}
 
 	if prune {
@@ -223,9 +254,153 @@ func (c Charts) Vendor(prune bool, repoConfigPath string) error {
 		}
 	}
 
+	return writeLock(newLock, c.LockFile())
+}
+
+// Verify checks that the vendored Charts are exactly what the lock file
+// records, and that upstream still serves the locked Charts, by pulling them
+// again into a temporary directory. Nothing is modified.
+func (c Charts) Verify(repoConfigPath string) error {
+	repositories, err := c.getRepositories(repoConfigPath)
+	if err != nil {
+		return err
+	}
+	if err := c.Manifest.Requires.Validate(); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(c.LockFile()); os.IsNotExist(err) {
+		return fmt.Errorf("%s not found. Run `tk tool charts vendor` to create it", LockFilename)
+	}
+	lock, err := loadLock(c.LockFile())
+	if err != nil {
+		return err
+	}
+
+	tempDir, err := os.MkdirTemp("", "charts-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	var problems []string
+	required := make(map[LockedChart]bool)
+	repositoriesUpdated := false
+	log.Info().Msg("Verifying...")
+	for _, r := range c.Manifest.Requires {
+		chartSubDir := parseReqName(r.Chart)
+		if r.Directory != "" {
+			chartSubDir = r.Directory
+		}
+
+		locked, ok := lock.Find(r, chartSubDir)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s is not locked", r))
+			continue
+		}
+		required[locked] = true
+
+		hash, err := cache.HashDir(filepath.Join(c.ChartDir(), chartSubDir))
+		switch {
+		case os.IsNotExist(err):
+			problems = append(problems, fmt.Sprintf("%s is not vendored", r))
+		case err != nil:
+			return err
+		case hash != locked.Hash:
+			problems = append(problems, fmt.Sprintf("%s was modified: its content hash is %s, but %s is locked", r, hash, locked.Hash))
+		}
+
+		if !IsOCI(r.Chart) && !repositoriesUpdated {
+			log.Info().Msg("Syncing Repositories ...")
+			if err := c.Helm.RepoUpdate(Opts{Repositories: repositories}); err != nil {
+				return err
+			}
+			repositoriesUpdated = true
+		}
+
+		digest := ""
+		if IsOCI(r.Chart) {
+			digest = locked.Digest
+		}
+		upstream, err := c.pull(r, digest, tempDir, chartSubDir, repositories)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s could not be pulled: %s", r, err))
+		case upstream.Repository != locked.Repository:
+			problems = append(problems, fmt.Sprintf("%s resolves to repository %s, but %s is locked", r, upstream.Repository, locked.Repository))
+		case upstream.Digest != locked.Digest:
+			problems = append(problems, fmt.Sprintf("%s does not match upstream: its digest is %s, but %s is locked", r, upstream.Digest, locked.Digest))
+		case upstream.Hash != locked.Hash:
+			problems = append(problems, fmt.Sprintf("%s does not match upstream: its content hash is %s, but %s is locked", r, upstream.Hash, locked.Hash))
+		}
+	}
+
+	for _, l := range lock.Charts {
+		if !required[l] {
+			problems = append(problems, fmt.Sprintf("%s@%s (dir: %s) is locked, but not required", l.Chart, l.Version, l.Directory))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("verification failed:\n - %s", strings.Join(problems, "\n - "))
+	}
+
+	log.Info().Msgf("%v Charts match %s", len(c.Manifest.Requires), LockFilename)
 	return nil
 }
 
+// pull downloads r into dest/subDir and returns what was pulled. OCI charts
+// are pulled by digest if one is given. Repositories must be up to date.
+func (c Charts) pull(r Requirement, digest, dest, subDir string, repositories Repos) (LockedChart, error) {
+	pulled := LockedChart{
+		Chart:     r.Chart,
+		Version:   r.Version,
+		Directory: subDir,
+	}
+
+	if IsOCI(r.Chart) {
+		ref, err := ParseOCIReference(r.Chart)
+		if err != nil {
+			return pulled, err
+		}
+		pulled.Repository = ref.String()
+		pulled.Digest, err = c.OCI.Pull(r.Chart, r.Version, digest, PullOpts{
+			Destination:      dest,
+			ExtractDirectory: subDir,
+		})
+		if err != nil {
+			return pulled, err
+		}
+	} else {
+		repoName := parseReqRepo(r.Chart)
+		for _, repo := range repositories {
+			if repo.Name == repoName {
+				pulled.Repository = repo.URL
+			}
+		}
+		if pulled.Repository == "" {
+			return pulled, fmt.Errorf("repository %q not found for chart %q", repoName, r.Chart)
+		}
+
+		var err error
+		pulled.Digest, err = c.Helm.Pull(r.Chart, r.Version, PullOpts{
+			Destination:      dest,
+			ExtractDirectory: subDir,
+			Opts:             Opts{Repositories: repositories},
+		})
+		if err != nil {
+			return pulled, err
+		}
+	}
+
+	hash, err := cache.HashDir(filepath.Join(dest, subDir))
+	if err != nil {
+		return pulled, err
+	}
+	pulled.Hash = hash
+	return pulled, nil
+}
+
 // Add adds every Chart in reqs to the Manifest after validation, and runs
 // Vendor afterwards
 func (c *Charts) Add(reqs []string, repoConfigPath string) error {
@@ -311,7 +486,12 @@ func (c *Charts) VersionCheck(repoConfigPath string) (map[string]RequiresVersion
 	}
 
 	for _, r := range c.Manifest.Requires {
-		searchVersions, err := c.Helm.SearchRepo(r.Chart, r.Version, Opts{Repositories: repositories})
+		var searchVersions ChartSearchVersions
+		if IsOCI(r.Chart) {
+			searchVersions, err = c.OCI.SearchVersions(r.Chart, r.Version)
+		} else {
+			searchVersions, err = c.Helm.SearchRepo(r.Chart, r.Version, Opts{Repositories: repositories})
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -372,7 +552,12 @@ func write(c Chartfile, dest string) error {
 }
 
 // parseReq parses a requirement from a string of the format `repo/name@version`
+// or `oci://registry/org/name@version(@digest)`
 func parseReq(s string) (*Requirement, error) {
+	if IsOCI(s) {
+		return parseOCIReq(s)
+	}
+
 	matches := chartExp.FindStringSubmatch(s)
 	if matches == nil {
 		return nil, fmt.Errorf("not of form 'repo/chart@version(:path)' where repo contains no special characters")
@@ -392,6 +577,25 @@ func parseReq(s string) (*Requirement, error) {
 	}, nil
 }
 
+// parseOCIReq parses a requirement from a string of the format
+// `oci://registry/org/name@version(@sha256:digest)(:path)`
+func parseOCIReq(s string) (*Requirement, error) {
+	matches := ociChartExp.FindStringSubmatch(s)
+	if matches == nil {
+		return nil, fmt.Errorf("not of form 'oci://registry/chart@version(@sha256:digest)(:path)'")
+	}
+	if _, err := ParseOCIReference(matches[1]); err != nil {
+		return nil, err
+	}
+
+	return &Requirement{
+		Chart:     matches[1],
+		Version:   matches[2],
+		Digest:    matches[3],
+		Directory: matches[4],
+	}, nil
+}
+
 // parseReqRepo parses a repo from a string of the format `repo/name`
 func parseReqRepo(s string) string {
 	elems := strings.SplitN(s, "/", 2)
@@ -399,8 +603,12 @@ func parseReqRepo(s string) string {
 	return repo
 }
 
-// parseReqName parses a name from a string of the format `repo/name`
+// parseReqName parses a name from a string of the format `repo/name` or
+// `oci://registry/org/name`
 func parseReqName(s string) string {
+	if ref, err := ParseOCIReference(s); err == nil {
+		return ref.Name()
+	}
 	elems := strings.SplitN(s, "/", 2)
 	if len(elems) == 1 {
 		return ""
@@ -0,0 +1,67 @@
+package helm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+)
+
+// DockerConfigFiles returns the files registry logins are read from: the
+// Docker config ($DOCKER_CONFIG/config.json or ~/.docker/config.json) and the
+// Helm registry config ($HELM_REGISTRY_CONFIG or
+// $XDG_CONFIG_HOME/helm/registry/config.json)
+func DockerConfigFiles() []string {
+	var files []string
+
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		files = append(files, filepath.Join(dir, "config.json"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".docker", "config.json"))
+	}
+
+	if file := os.Getenv("HELM_REGISTRY_CONFIG"); file != "" {
+		files = append(files, file)
+	} else {
+		dir := os.Getenv("XDG_CONFIG_HOME")
+		if dir == "" {
+			if home, err := os.UserHomeDir(); err == nil {
+				dir = filepath.Join(home, ".config")
+			}
+		}
+		if dir != "" {
+			files = append(files, filepath.Join(dir, "helm", "registry", "config.json"))
+		}
+	}
+
+	return files
+}
+
+// DockerCredentials returns the login for registry from the first of
+// DockerConfigFiles that has one. Like Docker, a credential helper configured
+// for the registry (`credHelpers`) takes precedence, then the default one
+// (`credsStore`), then `auths`. Identity tokens are returned as RefreshToken.
+// Returns an empty credential if there is no login.
+func DockerCredentials(ctx context.Context, registry string) (auth.Credential, error) {
+	var stores []credentials.Store
+	for _, file := range DockerConfigFiles() {
+		store, err := credentials.NewStore(file, credentials.StoreOptions{})
+		if err != nil {
+			return auth.EmptyCredential, errors.Wrapf(err, "reading %s", file)
+		}
+		stores = append(stores, store)
+	}
+	if len(stores) == 0 {
+		return auth.EmptyCredential, nil
+	}
+
+	store := credentials.NewStoreWithFallbacks(stores[0], stores[1:]...)
+	cred, err := store.Get(ctx, credentials.ServerAddressFromRegistry(registry))
+	if err != nil {
+		return auth.EmptyCredential, errors.Wrapf(err, "reading credentials for %s", registry)
+	}
+	return cred, nil
+}
@@ -2,6 +2,7 @@ package helm
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -15,8 +16,9 @@ import (
 
 // Helm provides high level access to some Helm operations
 type Helm interface {
-	// Pull downloads a Helm Chart from a remote
-	Pull(chart, version string, opts PullOpts) error
+	// Pull downloads a Helm Chart from a remote. Returns the digest of the
+	// chart archive (sha256:...)
+	Pull(chart, version string, opts PullOpts) (string, error)
 
 	// RepoUpdate fetches the latest remote index
 	RepoUpdate(opts Opts) error
@@ -29,6 +31,10 @@ type Helm interface {
 
 	// SearchRepo searches the repository for an updated chart version
 	SearchRepo(chart, currVersion string, opts Opts) (ChartSearchVersions, error)
+
+	// LatestVersion returns the latest version of the chart matching the
+	// semver constraint. Repositories must be up to date (see RepoUpdate)
+	LatestVersion(chart, constraint string, opts Opts) (ChartSearchVersion, error)
 }
 
 // PullOpts are additional, non-required options for Helm.Pull
@@ -93,17 +99,16 @@ type Opts struct {
 type ExecHelm struct{}
 
 // Pull implements Helm.Pull
-func (e ExecHelm) Pull(chart, version string, opts PullOpts) error {
+func (e ExecHelm) Pull(chart, version string, opts PullOpts) (string, error) {
 	repoFile, err := writeRepoTmpFile(opts.Repositories)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer os.Remove(repoFile)
 
-	// Pull to a temp dir within the destination directory (not /tmp) to avoid possible cross-device issues when renaming
-	tempDir, err := os.MkdirTemp(opts.Destination, ".pull-")
+	tempDir, err := os.MkdirTemp("", "charts-pull-")
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer os.RemoveAll(tempDir)
 
@@ -122,23 +127,34 @@ func (e ExecHelm) Pull(chart, version string, opts PullOpts) error {
 		"--version", version,
 		"--repository-config", repoFile,
 		"--destination", tempDir,
-		"--untar",
 	)
 
 	if err = cmd.Run(); err != nil {
-		return err
+		return "", err
+	}
+
+	// keep the archive to compute its digest, which is what repository
+	// indexes and OCI registries record
+	archives, err := filepath.Glob(filepath.Join(tempDir, "*.tgz"))
+	if err != nil {
+		return "", err
+	}
+	if len(archives) != 1 {
+		return "", fmt.Errorf("expected helm to pull a single chart archive, got %d", len(archives))
+	}
+	archive, err := os.ReadFile(archives[0])
+	if err != nil {
+		return "", err
 	}
 
 	if opts.ExtractDirectory == "" {
 		opts.ExtractDirectory = chartName
 	}
+	if err := extractChart(archive, opts.Destination, opts.ExtractDirectory); err != nil {
+		return "", err
+	}
 
-	// It is not possible to tell `helm pull` to extract to a specific directory
-	// so we extract to a temp dir and then move the files to the destination
-	return os.Rename(
-		filepath.Join(tempDir, chartName),
-		filepath.Join(opts.Destination, opts.ExtractDirectory),
-	)
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(archive)), nil
 }
 
 // RepoUpdate implements Helm.RepoUpdate
@@ -242,6 +258,40 @@ func (e ExecHelm) SearchRepo(chart, currVersion string, opts Opts) (ChartSearchV
 	return chartVersions, nil
 }
 
+// LatestVersion implements Helm.LatestVersion
+func (e ExecHelm) LatestVersion(chart, constraint string, opts Opts) (ChartSearchVersion, error) {
+	repoFile, err := writeRepoTmpFile(opts.Repositories)
+	if err != nil {
+		return ChartSearchVersion{}, err
+	}
+	defer os.Remove(repoFile)
+
+	// helm search only returns the latest version matching the constraint
+	cmd := e.cmd("search", "repo",
+		"--repository-config", repoFile,
+		"--regexp", fmt.Sprintf("\v%s\v", chart),
+		"--version", constraint,
+		"-o", "json",
+	)
+	var errBuf bytes.Buffer
+	var outBuf bytes.Buffer
+	cmd.Stderr = &errBuf
+	cmd.Stdout = &outBuf
+
+	if err := cmd.Run(); err != nil {
+		return ChartSearchVersion{}, fmt.Errorf("%s\n%s", errBuf.String(), err)
+	}
+
+	var versions ChartSearchVersions
+	if err := json.Unmarshal(outBuf.Bytes(), &versions); err != nil {
+		return ChartSearchVersion{}, err
+	}
+	if len(versions) == 0 {
+		return ChartSearchVersion{}, ErrorNoMatchingVersion{Chart: chart, Constraint: constraint}
+	}
+	return versions[0], nil
+}
+
 // cmd returns a prepared exec.Cmd to use the `helm` binary
 func (e ExecHelm) cmd(action string, args ...string) *exec.Cmd {
 	argv := []string{action}
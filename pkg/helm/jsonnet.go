@@ -5,10 +5,13 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/google/go-jsonnet"
 	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/tanka/pkg/cache"
 	"github.com/grafana/tanka/pkg/kubernetes/manifest"
 	"github.com/rs/zerolog/log"
 )
@@ -16,9 +19,17 @@ import (
 // DefaultNameFormat to use when no nameFormat is supplied
 const DefaultNameFormat = `{{ print .kind "_" .metadata.name | snakecase }}`
 
-// helmTemplateCache caches the inline environments' rendered helm templates.
+// helmTemplateCache caches the inline environments' rendered helm templates,
+// as manifest.List
 var helmTemplateCache sync.Map
 
+// ClearTemplateCache forgets all rendered templates. Long-running processes
+// evaluating environments repeatedly need to call it in between, so changes
+// to the charts are picked up.
+func ClearTemplateCache() {
+	helmTemplateCache.Clear()
+}
+
 // JsonnetOpts are additional properties the consumer of the native func might
 // pass.
 type JsonnetOpts struct {
@@ -29,6 +40,9 @@ type JsonnetOpts struct {
 	CalledFrom string `json:"calledFrom"`
 	// NameTemplate is used to create the keys in the resulting map
 	NameFormat string `json:"nameFormat"`
+	// AsList returns the resources as an array in rendering order instead of
+	// a map. Use listAsMap to convert it after post-processing
+	AsList bool `json:"asList"`
 }
 
 // NativeFunc returns a jsonnet native function that provides the same
@@ -64,34 +78,138 @@ func NativeFunc(h Helm) *jsonnet.NativeFunction {
 				return nil, fmt.Errorf("helmTemplate: Failed to find a chart at '%s': %s. See https://tanka.dev/helm#failed-to-find-chart", chart, err)
 			}
 
+			// files are relative to the caller, like the chart
+			resolved := opts.TemplateOpts.resolve(filepath.Dir(opts.CalledFrom))
+
 			// check if resources exist in cache
-			helmKey, err := templateKey(name, chartpath, opts.TemplateOpts)
+			helmKey, err := templateKey(name, chart, resolved)
 			if err != nil {
 				return nil, err
 			}
-			if entry, ok := helmTemplateCache.Load(helmKey); ok {
+			list, ok := helmTemplateCache.Load(helmKey)
+			if ok {
 				log.Debug().Msgf("Using cached template for %s", name)
-				return entry, nil
+			} else {
+				// the disk cache is shared by environments, so it is keyed
+				// by the relative paths and the contents of the files
+				diskKey, err := templateKey(name, chartpath, opts.TemplateOpts)
+				if err != nil {
+					return nil, err
+				}
+
+				// render resources
+				rendered, err := template(h, name, chart, diskKey, resolved)
+				if err != nil {
+					return nil, err
+				}
+				helmTemplateCache.Store(helmKey, rendered)
+				list = rendered
 			}
 
-			// render resources
-			list, err := h.Template(name, chart, opts.TemplateOpts)
-			if err != nil {
-				return nil, err
+			if opts.AsList {
+				out := make([]interface{}, 0, len(list.(manifest.List)))
+				for _, m := range list.(manifest.List) {
+					out = append(out, map[string]interface{}(m))
+				}
+				return out, nil
 			}
 
 			// convert list to map
-			out, err := manifest.ListAsMap(list, opts.NameFormat)
-			if err != nil {
-				return nil, err
-			}
-
-			helmTemplateCache.Store(helmKey, out)
-			return out, nil
+			return manifest.ListAsMap(list.(manifest.List), opts.NameFormat)
 		},
 	}
 }
 
+// template renders the chart, using the disk cache if one is configured. Its
+// entries are keyed by the contents of the chart, so changes to the vendored
+// chart invalidate them.
+func template(h Helm, name, chart, helmKey string, opts TemplateOpts) (manifest.List, error) {
+	disk := cache.Templates()
+	if disk == nil {
+		return h.Template(name, chart, opts)
+	}
+
+	hash, err := cache.HashDir(chart)
+	if err != nil {
+		return nil, err
+	}
+	files, err := hashFiles(opts)
+	if err != nil {
+		return nil, err
+	}
+	key := cache.Key("helmTemplate", fmt.Sprintf("%T", h), helmKey, hash, files)
+
+	var list manifest.List
+	if ok, err := disk.Get(key, &list); err != nil {
+		return nil, err
+	} else if ok {
+		log.Debug().Msgf("Using template for %s from disk cache", name)
+		return list, nil
+	}
+
+	list, err = h.Template(name, chart, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := disk.Store(key, list); err != nil {
+		log.Warn().Err(err).Msgf("Failed to cache template for %s", name)
+	}
+	return list, nil
+}
+
+// resolve returns a copy of t with relative ValueFiles and SetFile paths
+// resolved against dir
+func (t TemplateOpts) resolve(dir string) TemplateOpts {
+	abs := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+
+	if t.ValueFiles != nil {
+		files := make([]string, len(t.ValueFiles))
+		for i, f := range t.ValueFiles {
+			files[i] = abs(f)
+		}
+		t.ValueFiles = files
+	}
+	if t.SetFile != nil {
+		files := make(map[string]string, len(t.SetFile))
+		for k, f := range t.SetFile {
+			files[k] = abs(f)
+		}
+		t.SetFile = files
+	}
+	return t
+}
+
+// hashFiles returns a hash over the contents of the ValueFiles and SetFile
+// files, which must be resolved. The paths themselves are not part of it.
+func hashFiles(opts TemplateOpts) (string, error) {
+	h := sha256.New()
+	add := func(name, path string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%x  %s\n", sha256.Sum256(data), name)
+		return nil
+	}
+
+	for i, f := range opts.ValueFiles {
+		if err := add(fmt.Sprintf("valueFiles[%d]", i), f); err != nil {
+			return "", err
+		}
+	}
+	for _, k := range sortedKeys(opts.SetFile) {
+		if err := add("setFile."+k, opts.SetFile[k]); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
 // templateKey returns the key identifier used in the template cache for the given helm chart.
 func templateKey(chartName string, chartPath string, opts TemplateOpts) (string, error) {
 	hasher := sha256.New()
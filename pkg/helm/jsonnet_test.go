@@ -1,11 +1,15 @@
 package helm
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
+	"github.com/grafana/tanka/pkg/cache"
 	"github.com/grafana/tanka/pkg/kubernetes/manifest"
 )
 
@@ -18,9 +22,9 @@ type MockHelm struct {
 }
 
 // fulfill the Helm interface
-func (m *MockHelm) Pull(chart, version string, opts PullOpts) error {
+func (m *MockHelm) Pull(chart, version string, opts PullOpts) (string, error) {
 	args := m.Called(chart, version, opts)
-	return args.Error(0)
+	return args.String(0), args.Error(1)
 }
 
 func (m *MockHelm) RepoUpdate(opts Opts) error {
@@ -50,6 +54,11 @@ func (m *MockHelm) SearchRepo(chart, currVersion string, opts Opts) (ChartSearch
 	return args.Get(0).(ChartSearchVersions), args.Error(1)
 }
 
+func (m *MockHelm) LatestVersion(chart, constraint string, opts Opts) (ChartSearchVersion, error) {
+	args := m.Called(chart, constraint, opts)
+	return args.Get(0).(ChartSearchVersion), args.Error(1)
+}
+
 func callNativeFunction(t *testing.T, expectedHelmTemplateOptions TemplateOpts, inputOptionsFromJsonnet map[string]interface{}) []string {
 	t.Helper()
 
@@ -200,6 +209,60 @@ func TestTemplateCachingWorks(t *testing.T) {
 	require.Equal(t, firstCommandArgs, secondCommandArgs)
 }
 
+// TestTemplateDiskCache tests that templates are reused across processes as
+// long as the chart does not change
+func TestTemplateDiskCache(t *testing.T) {
+	cache.SetTemplates(&cache.Disk{Directory: t.TempDir()})
+	t.Cleanup(func() { cache.SetTemplates(nil) })
+
+	dir := t.TempDir()
+	chart := filepath.Join(dir, "chart")
+	require.NoError(t, os.MkdirAll(chart, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(chart, "Chart.yaml"), []byte("version: 1.0.0\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "values.yaml"), []byte("replicas: 1\n"), 0644))
+
+	opts := TemplateOpts{
+		IncludeCRDs: true,
+		Values:      map[string]interface{}{"disk": "cache"},
+		ValueFiles:  []string{filepath.Join(dir, "values.yaml")},
+	}
+	list := manifest.List{{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "cached"},
+	}}
+
+	helmMock := &MockHelm{}
+	helmMock.On("ChartExists", "./chart", mock.AnythingOfType("*helm.JsonnetOpts")).Return(chart, nil)
+	helmMock.On("Template", "disk", chart, opts).Return(list, nil)
+
+	render := func() interface{} {
+		// a new process starts with an empty in-memory cache
+		ClearTemplateCache()
+		out, err := NativeFunc(helmMock).Func([]interface{}{"disk", "./chart", map[string]interface{}{
+			"calledFrom": filepath.Join(dir, "main.jsonnet"),
+			"values":     opts.Values,
+			"valueFiles": []interface{}{"values.yaml"},
+		}})
+		require.NoError(t, err)
+		return out
+	}
+
+	first := render()
+	assert.Equal(t, first, render())
+	helmMock.AssertNumberOfCalls(t, "Template", 1)
+
+	// changes to the chart invalidate the entry
+	require.NoError(t, os.WriteFile(filepath.Join(chart, "Chart.yaml"), []byte("version: 1.0.1\n"), 0644))
+	assert.Equal(t, first, render())
+	helmMock.AssertNumberOfCalls(t, "Template", 2)
+
+	// as do changes to value files
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "values.yaml"), []byte("replicas: 2\n"), 0644))
+	assert.Equal(t, first, render())
+	helmMock.AssertNumberOfCalls(t, "Template", 3)
+}
+
 type templateData struct {
 	chartName string
 	chartPath string
@@ -225,7 +288,7 @@ var templateTestCases = []struct {
 			chartName: "bigChart",
 			chartPath: "./chart/bigPath",
 			opts: TemplateOpts{
-				map[string]interface{}{
+				Values: map[string]interface{}{
 					"installCRDs": true,
 					"multitenancy": map[string]interface{}{
 						"enabled":               false,
@@ -240,12 +303,12 @@ var templateTestCases = []struct {
 					},
 					"baz": []int32{12, 13},
 				},
-				[]string{"asdf", "qwer", "zxcv"},
-				true,
-				false,
-				"version",
-				"namespace",
-				false,
+				APIVersions: []string{"asdf", "qwer", "zxcv"},
+				IncludeCRDs: true,
+				SkipTests:   false,
+				KubeVersion: "version",
+				Namespace:   "namespace",
+				NoHooks:     false,
 			},
 		},
 	},
@@ -0,0 +1,107 @@
+package helm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// LockVersion of the current lock file implementation
+	LockVersion = 1
+
+	// LockFilename of the lock file, which is kept next to the Chartfile
+	LockFilename = "chartfile.lock"
+)
+
+// Lockfile records what was vendored for each Requirement of the Chartfile
+type Lockfile struct {
+	// Version of the lock file schema
+	Version uint `json:"version"`
+
+	// Charts that were vendored, in the order of the requirements
+	Charts []LockedChart `json:"charts"`
+}
+
+// LockedChart is a vendored Chart
+type LockedChart struct {
+	Chart     string `json:"chart"`
+	Version   string `json:"version"`
+	Directory string `json:"directory"`
+
+	// Repository is the URL the Chart was pulled from
+	Repository string `json:"repository"`
+
+	// Digest of the chart archive, or of the manifest for OCI charts
+	Digest string `json:"digest"`
+
+	// Hash of the contents of the extracted Chart, see cache.HashDir
+	Hash string `json:"hash"`
+}
+
+// Find returns the locked Chart for r, which is vendored to dir. The chart,
+// version and directory must match, as well as the digest if r pins one.
+func (l Lockfile) Find(r Requirement, dir string) (LockedChart, bool) {
+	for _, c := range l.Charts {
+		if c.Chart != r.Chart || c.Version != r.Version || c.Directory != dir {
+			continue
+		}
+		if r.Digest != "" && c.Digest != r.Digest {
+			continue
+		}
+		return c, true
+	}
+	return LockedChart{}, false
+}
+
+// LockFile returns the full path to the chartfile.lock
+func (c Charts) LockFile() string {
+	return filepath.Join(c.projectRoot, LockFilename)
+}
+
+// loadLock reads the lock file. A missing lock file yields an empty Lockfile
+func loadLock(file string) (*Lockfile, error) {
+	lock := &Lockfile{Version: LockVersion}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return lock, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.UnmarshalStrict(data, lock); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+	return lock, nil
+}
+
+// writeLock saves a Lockfile to dest
+func writeLock(l Lockfile, dest string) error {
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dest, data, 0644)
+}
+
+// ErrorLockMismatch means that upstream no longer serves the locked Chart
+type ErrorLockMismatch struct {
+	Requirement    Requirement
+	Locked, Pulled LockedChart
+}
+
+func (e ErrorLockMismatch) Error() string {
+	return fmt.Sprintf(`%s does not match %s:
+  locked: digest %s, content hash %s
+  pulled: digest %s, content hash %s
+If this change is expected, remove the chart from %s and vendor again`,
+		e.Requirement, LockFilename,
+		e.Locked.Digest, e.Locked.Hash,
+		e.Pulled.Digest, e.Pulled.Hash,
+		LockFilename,
+	)
+}
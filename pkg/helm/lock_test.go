@@ -0,0 +1,124 @@
+package helm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafana/tanka/pkg/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVendorLockOCI(t *testing.T) {
+	r := newFakeRegistry(t)
+	digest := r.push(t, "1.0.0", "v2")
+	loginTo(t, r)
+
+	tempDir := t.TempDir()
+	c, err := InitChartfile(filepath.Join(tempDir, Filename))
+	require.NoError(t, err)
+	require.NoError(t, c.Add([]string{r.Ref() + "@1.0.0"}, ""))
+
+	lock, err := loadLock(c.LockFile())
+	require.NoError(t, err)
+	require.Len(t, lock.Charts, 1)
+	hash, err := cache.HashDir(filepath.Join(tempDir, "charts", "demo"))
+	require.NoError(t, err)
+	assert.Equal(t, LockedChart{
+		Chart:      r.Ref(),
+		Version:    "1.0.0",
+		Directory:  "demo",
+		Repository: r.Ref(),
+		Digest:     digest,
+		Hash:       hash,
+	}, lock.Charts[0])
+
+	assert.NoError(t, c.Verify(""))
+
+	// re-pushing the tag does not change what is vendored
+	r.push(t, "1.0.0", "v2-rebuilt")
+	require.NoError(t, os.RemoveAll(filepath.Join(tempDir, "charts")))
+	require.NoError(t, c.Vendor(false, ""))
+	chart, err := os.ReadFile(filepath.Join(tempDir, "charts", "demo", "Chart.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(chart), "appVersion: v2\n")
+	assert.NoError(t, c.Verify(""))
+
+	// hand-edited charts fail verification
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "charts", "demo", "values.yaml"), []byte("replicas: 3\n"), 0644))
+	err = c.Verify("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "verification failed:\n - "+r.Ref()+"@1.0.0 (dir: demo) was modified")
+
+	// as do charts that vanished upstream
+	delete(r.Manifests, digest)
+	err = c.Verify("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), r.Ref()+"@1.0.0 (dir: demo) could not be pulled")
+}
+
+func TestVendorLockHTTP(t *testing.T) {
+	tempDir := t.TempDir()
+	c, err := InitChartfile(filepath.Join(tempDir, Filename))
+	require.NoError(t, err)
+	c.Manifest.Requires = Requirements{{Chart: "stable/demo", Version: "1.0.0"}}
+
+	helmMock := &MockHelm{}
+	// upstream serves a chart with appVersion under digest
+	upstream := func(digest, appVersion string) {
+		helmMock.ExpectedCalls = nil
+		helmMock.On("RepoUpdate", mock.Anything).Return(nil)
+		helmMock.On("Pull", "stable/demo", "1.0.0", mock.Anything).Run(func(args mock.Arguments) {
+			opts := args.Get(2).(PullOpts)
+			dir := filepath.Join(opts.Destination, opts.ExtractDirectory)
+			require.NoError(t, os.MkdirAll(dir, 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte("version: 1.0.0\nappVersion: "+appVersion+"\n"), 0644))
+		}).Return(digest, nil)
+	}
+	upstream("sha256:aaaa", "v2")
+	c.Helm = helmMock
+
+	require.NoError(t, c.Vendor(false, ""))
+	lock, err := loadLock(c.LockFile())
+	require.NoError(t, err)
+	require.Len(t, lock.Charts, 1)
+	assert.Equal(t, "https://charts.helm.sh/stable", lock.Charts[0].Repository)
+	assert.Equal(t, "sha256:aaaa", lock.Charts[0].Digest)
+	assert.NoError(t, c.Verify(""))
+
+	// vendored charts are not pulled again
+	helmMock.Calls = nil
+	require.NoError(t, c.Vendor(false, ""))
+	helmMock.AssertNotCalled(t, "Pull", mock.Anything, mock.Anything, mock.Anything)
+
+	// upstream changed the chart
+	upstream("sha256:bbbb", "v3")
+	err = c.Verify("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "stable/demo@1.0.0 (dir: demo) does not match upstream: its digest is sha256:bbbb, but sha256:aaaa is locked")
+
+	require.NoError(t, os.RemoveAll(filepath.Join(tempDir, "charts", "demo")))
+	err = c.Vendor(false, "")
+	var mismatch ErrorLockMismatch
+	require.ErrorAs(t, err, &mismatch)
+	assert.Equal(t, "sha256:bbbb", mismatch.Pulled.Digest)
+	assert.NoDirExists(t, filepath.Join(tempDir, "charts", "demo"))
+}
+
+func TestVerifyLockfile(t *testing.T) {
+	tempDir := t.TempDir()
+	c, err := InitChartfile(filepath.Join(tempDir, Filename))
+	require.NoError(t, err)
+	c.Manifest.Requires = Requirements{{Chart: "stable/demo", Version: "1.0.0"}}
+
+	assert.EqualError(t, c.Verify(""), "chartfile.lock not found. Run `tk tool charts vendor` to create it")
+
+	require.NoError(t, writeLock(Lockfile{Version: LockVersion, Charts: []LockedChart{
+		{Chart: "stable/demo", Version: "0.9.0", Directory: "demo"},
+	}}, c.LockFile()))
+	assert.EqualError(t, c.Verify(""), `verification failed:
+ - stable/demo@1.0.0 (dir: demo) is not locked
+ - stable/demo@0.9.0 (dir: demo) is locked, but not required`)
+}
@@ -0,0 +1,530 @@
+package helm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/memory"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+)
+
+// OCIPrefix marks chart references pointing to an OCI registry
+const OCIPrefix = "oci://"
+
+// Media types of Helm charts stored in OCI registries
+const (
+	OCIManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	OCIEmptyConfigMediaType = "application/vnd.oci.empty.v1+json"
+	OCIChartLayerMediaType  = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	OCIChartConfigMediaType = "application/vnd.cncf.helm.config.v1+json"
+
+	// legacyChartLayerMediaType was used by Helm before 3.7
+	legacyChartLayerMediaType = "application/tar+gzip"
+)
+
+// maxManifestSize limits the size of manifests read from registries
+const maxManifestSize = 4 << 20
+
+var digestExp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// IsOCI reports whether chart is a reference to an OCI registry, such as
+// oci://registry.example.com/org/chart
+func IsOCI(chart string) bool {
+	return strings.HasPrefix(chart, OCIPrefix)
+}
+
+// OCIReference is a chart repository in an OCI registry
+type OCIReference struct {
+	// Registry host, optionally with port
+	Registry string
+	// Repository within the registry, e.g. org/chart
+	Repository string
+}
+
+// ParseOCIReference parses a reference of the form oci://registry/repository
+func ParseOCIReference(s string) (OCIReference, error) {
+	if !IsOCI(s) {
+		return OCIReference{}, fmt.Errorf("%q does not start with %s", s, OCIPrefix)
+	}
+
+	registry, repository, ok := strings.Cut(strings.TrimPrefix(s, OCIPrefix), "/")
+	if !ok || registry == "" || repository == "" || strings.HasSuffix(repository, "/") {
+		return OCIReference{}, fmt.Errorf("%q is not of form %sregistry/repository", s, OCIPrefix)
+	}
+	if strings.ContainsAny(repository, "@: ") {
+		return OCIReference{}, fmt.Errorf("%q must not contain a tag or digest, use 'version' and 'digest' instead", s)
+	}
+
+	return OCIReference{Registry: registry, Repository: repository}, nil
+}
+
+// Name of the chart, the last element of the repository
+func (r OCIReference) Name() string {
+	return path.Base(r.Repository)
+}
+
+func (r OCIReference) String() string {
+	return OCIPrefix + r.Registry + "/" + r.Repository
+}
+
+// OCIClient pulls Helm charts from OCI registries and pushes artifacts to
+// them, using oras. The zero value is ready to use.
+type OCIClient struct {
+	// HTTP client to use. Defaults to http.DefaultClient
+	HTTP *http.Client
+	// Credentials returns the login for a registry. Defaults to
+	// DockerCredentials
+	Credentials auth.CredentialFunc
+
+	once  sync.Once
+	cache auth.Cache
+}
+
+// OCIArtifact is pushed to a registry as a manifest with a single layer and an
+// empty config, following the OCI guidelines for artifacts
+type OCIArtifact struct {
+	// ArtifactType of the manifest
+	ArtifactType string
+	// Annotations of the manifest
+	Annotations map[string]string
+
+	// Layer is the content of the artifact
+	Layer []byte
+	// LayerMediaType is the media type of Layer
+	LayerMediaType string
+	// LayerAnnotations are the annotations of Layer, such as its file name
+	// (org.opencontainers.image.title)
+	LayerAnnotations map[string]string
+}
+
+// Push uploads the artifact to the repository of ref and tags it. Blobs that
+// exist already are not uploaded again. Returns the digest of the manifest.
+func (c *OCIClient) Push(ref OCIReference, tag string, artifact OCIArtifact) (string, error) {
+	ctx := context.Background()
+	store := memory.New()
+
+	config := ocispec.DescriptorEmptyJSON
+	if err := store.Push(ctx, config, bytes.NewReader(config.Data)); err != nil {
+		return "", err
+	}
+	config.Data = nil
+
+	layer := content.NewDescriptorFromBytes(artifact.LayerMediaType, artifact.Layer)
+	layer.Annotations = artifact.LayerAnnotations
+	if err := store.Push(ctx, layer, bytes.NewReader(artifact.Layer)); err != nil {
+		return "", err
+	}
+
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned:    specs.Versioned{SchemaVersion: 2},
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: artifact.ArtifactType,
+		Config:       config,
+		Layers:       []ocispec.Descriptor{layer},
+		Annotations:  artifact.Annotations,
+	})
+	if err != nil {
+		return "", err
+	}
+	desc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageManifest, manifest)
+	if err := store.Push(ctx, desc, bytes.NewReader(manifest)); err != nil {
+		return "", err
+	}
+	if err := store.Tag(ctx, desc, tag); err != nil {
+		return "", err
+	}
+
+	repo, err := c.repository(ref)
+	if err != nil {
+		return "", err
+	}
+	if _, err := oras.Copy(ctx, store, tag, repo, tag, oras.DefaultCopyOptions); err != nil {
+		return "", c.error(ctx, ref, errors.Wrapf(err, "pushing %s:%s", ref, tag))
+	}
+	return desc.Digest.String(), nil
+}
+
+// Pull downloads the chart version from the registry and extracts it to
+// opts.Destination/opts.ExtractDirectory. If digest is set, the manifest with
+// this digest is pulled instead of the version tag, and the chart must be of
+// the given version. Returns the digest of the manifest.
+func (c *OCIClient) Pull(chart, version, digest string, opts PullOpts) (string, error) {
+	ref, err := ParseOCIReference(chart)
+	if err != nil {
+		return "", err
+	}
+
+	reference := versionTag(version)
+	if digest != "" {
+		if !digestExp.MatchString(digest) {
+			return "", fmt.Errorf("digest %q is not of form sha256:<hex>", digest)
+		}
+		reference = digest
+	}
+
+	ctx := context.Background()
+	repo, err := c.repository(ref)
+	if err != nil {
+		return "", err
+	}
+	desc, manifest, err := c.manifest(ctx, repo, ref, reference)
+	if err != nil {
+		return "", err
+	}
+
+	var layer *ocispec.Descriptor
+	for i, l := range manifest.Layers {
+		if l.MediaType == OCIChartLayerMediaType || l.MediaType == legacyChartLayerMediaType {
+			layer = &manifest.Layers[i]
+			break
+		}
+	}
+	if layer == nil {
+		return "", fmt.Errorf("%s@%s is not a Helm chart: no layer of type %s", ref, reference, OCIChartLayerMediaType)
+	}
+
+	archive, err := content.FetchAll(ctx, repo, *layer)
+	if err != nil {
+		return "", c.error(ctx, ref, errors.Wrapf(err, "pulling %s@%s", ref, reference))
+	}
+
+	if opts.ExtractDirectory == "" {
+		opts.ExtractDirectory = ref.Name()
+	}
+	if err := extractChart(archive, opts.Destination, opts.ExtractDirectory); err != nil {
+		return "", errors.Wrapf(err, "extracting %s@%s", ref, version)
+	}
+
+	if digest != "" {
+		extracted := filepath.Join(opts.Destination, opts.ExtractDirectory)
+		if v, err := chartVersion(extracted); err != nil || v != version {
+			os.RemoveAll(extracted)
+			return "", fmt.Errorf("%s@%s is version %q, not %q", ref, digest, v, version)
+		}
+	}
+
+	return desc.Digest.String(), nil
+}
+
+// SearchVersions returns the latest version of the chart, the latest matching
+// the major version of currVersion and the latest matching its minor version,
+// like ExecHelm.SearchRepo does for repositories
+func (c *OCIClient) SearchVersions(chart, currVersion string) (ChartSearchVersions, error) {
+	ref, err := ParseOCIReference(chart)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := c.versions(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ChartSearchVersions
+	for _, constraint := range []string{">=" + currVersion, "^" + currVersion, "~" + currVersion} {
+		found, err := c.latest(ref, versions, constraint)
+		var noMatch ErrorNoMatchingVersion
+		if errors.As(err, &noMatch) {
+			found = ChartSearchVersion{
+				Name:        chart,
+				Version:     currVersion,
+				Description: "search did not return 1 version",
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		result = append(result, found)
+	}
+
+	return result, nil
+}
+
+// LatestVersion returns the latest version of the chart matching the semver
+// constraint, like ExecHelm.LatestVersion does for repositories
+func (c *OCIClient) LatestVersion(chart, constraint string) (ChartSearchVersion, error) {
+	ref, err := ParseOCIReference(chart)
+	if err != nil {
+		return ChartSearchVersion{}, err
+	}
+
+	versions, err := c.versions(ref)
+	if err != nil {
+		return ChartSearchVersion{}, err
+	}
+	return c.latest(ref, versions, constraint)
+}
+
+// versions returns the tags of ref that are semantic versions, latest first
+func (c *OCIClient) versions(ref OCIReference) ([]*semver.Version, error) {
+	tags, err := c.Tags(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []*semver.Version
+	for _, t := range tags {
+		v, err := semver.NewVersion(strings.ReplaceAll(t, "_", "+"))
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	sort.Sort(sort.Reverse(semver.Collection(versions)))
+	return versions, nil
+}
+
+// latest returns the first of versions matching constraint
+func (c *OCIClient) latest(ref OCIReference, versions []*semver.Version, constraint string) (ChartSearchVersion, error) {
+	cons, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return ChartSearchVersion{}, errors.Wrapf(err, "parsing version constraint of %s", ref)
+	}
+	for _, v := range versions {
+		if cons.Check(v) {
+			return c.searchVersion(ref, v.Original())
+		}
+	}
+	return ChartSearchVersion{}, ErrorNoMatchingVersion{Chart: ref.String(), Constraint: constraint}
+}
+
+// searchVersion reads the metadata of a chart version from its config
+func (c *OCIClient) searchVersion(ref OCIReference, version string) (ChartSearchVersion, error) {
+	ctx := context.Background()
+	repo, err := c.repository(ref)
+	if err != nil {
+		return ChartSearchVersion{}, err
+	}
+	_, manifest, err := c.manifest(ctx, repo, ref, versionTag(version))
+	if err != nil {
+		return ChartSearchVersion{}, err
+	}
+
+	result := ChartSearchVersion{Name: ref.String(), Version: version}
+	if manifest.Config.MediaType != OCIChartConfigMediaType {
+		return result, nil
+	}
+
+	data, err := content.FetchAll(ctx, repo, manifest.Config)
+	if err != nil {
+		return ChartSearchVersion{}, c.error(ctx, ref, errors.Wrapf(err, "pulling config of %s@%s", ref, version))
+	}
+	var meta struct {
+		AppVersion  string `json:"appVersion"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return ChartSearchVersion{}, errors.Wrapf(err, "parsing config of %s@%s", ref, version)
+	}
+	result.AppVersion = meta.AppVersion
+	result.Description = meta.Description
+	return result, nil
+}
+
+// Tags lists all tags of the repository
+func (c *OCIClient) Tags(ref OCIReference) ([]string, error) {
+	ctx := context.Background()
+	repo, err := c.repository(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	err = repo.Tags(ctx, "", func(page []string) error {
+		tags = append(tags, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, c.error(ctx, ref, errors.Wrapf(err, "listing tags of %s", ref))
+	}
+	return tags, nil
+}
+
+// manifest fetches the manifest of reference, which is a tag or a digest.
+// Digests are verified.
+func (c *OCIClient) manifest(ctx context.Context, repo *remote.Repository, ref OCIReference, reference string) (ocispec.Descriptor, *ocispec.Manifest, error) {
+	desc, data, err := oras.FetchBytes(ctx, repo, reference, oras.FetchBytesOptions{MaxBytes: maxManifestSize})
+	if err != nil {
+		return desc, nil, c.error(ctx, ref, errors.Wrapf(err, "pulling manifest of %s@%s", ref, reference))
+	}
+
+	var m ocispec.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return desc, nil, errors.Wrapf(err, "parsing manifest of %s@%s", ref, reference)
+	}
+	return desc, &m, nil
+}
+
+// repository returns the oras client for the repository of ref
+func (c *OCIClient) repository(ref OCIReference) (*remote.Repository, error) {
+	repo, err := remote.NewRepository(ref.Registry + "/" + ref.Repository)
+	if err != nil {
+		return nil, err
+	}
+
+	c.once.Do(func() {
+		c.cache = auth.NewCache()
+	})
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	repo.PlainHTTP = plainHTTP(ref.Registry)
+	repo.Client = &auth.Client{
+		Client:     client,
+		Cache:      c.cache,
+		Credential: c.credentials(),
+		ClientID:   "tanka",
+	}
+	return repo, nil
+}
+
+// error returns ErrorNoCredentials if the registry of ref rejected err because
+// there is no login for it
+func (c *OCIClient) error(ctx context.Context, ref OCIReference, err error) error {
+	var resp *errcode.ErrorResponse
+	if !errors.As(err, &resp) || resp.StatusCode != http.StatusUnauthorized {
+		return err
+	}
+
+	if cred, cerr := c.credentials()(ctx, ref.Registry); cerr == nil && cred == auth.EmptyCredential {
+		return ErrorNoCredentials{Registry: ref.Registry}
+	}
+	return err
+}
+
+// credentials returns the function looking up the login for a registry
+func (c *OCIClient) credentials() auth.CredentialFunc {
+	if c.Credentials != nil {
+		return c.Credentials
+	}
+	return DockerCredentials
+}
+
+// plainHTTP tells whether registry is accessed using plain HTTP, which is the
+// case for registries on the loopback interface, like for Docker
+func plainHTTP(registry string) bool {
+	host := registry
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	return host == "localhost" || (ip != nil && ip.IsLoopback())
+}
+
+// versionTag returns the tag Helm uses for version. OCI tags must not contain
+// `+`, which is replaced by `_`
+func versionTag(version string) string {
+	return strings.ReplaceAll(version, "+", "_")
+}
+
+// chartVersion returns the version in the Chart.yaml in dir
+func chartVersion(dir string) (string, error) {
+	chart, err := readChartManifest(dir)
+	return chart.Version, err
+}
+
+// extractChart extracts the chart archive into dest/dir. Like `helm pull`
+// archives contain a single top-level directory, which is renamed to dir.
+func extractChart(archive []byte, dest, dir string) error {
+	// extract next to the destination (not /tmp) to avoid cross-device renames
+	tempDir, err := os.MkdirTemp(dest, ".pull-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+
+	top := ""
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if name == "." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			return fmt.Errorf("invalid path %q in archive", hdr.Name)
+		}
+		first, _, _ := strings.Cut(name, "/")
+		if top == "" {
+			top = first
+		} else if first != top {
+			return fmt.Errorf("archive has multiple top-level directories: %q and %q", top, first)
+		}
+
+		target := filepath.Join(tempDir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if top == "" {
+		return errors.New("archive is empty")
+	}
+
+	return os.Rename(filepath.Join(tempDir, top), filepath.Join(dest, dir))
+}
+
+// ErrorNoCredentials means that a registry requires a login, but none was
+// found
+type ErrorNoCredentials struct {
+	Registry string
+}
+
+func (e ErrorNoCredentials) Error() string {
+	return fmt.Sprintf("registry requires a login, but no credentials were found. Run `docker login %s` or `helm registry login %s`", e.Registry, e.Registry)
+}
+
+// ErrorNoMatchingVersion means that no version of a chart matches a constraint
+type ErrorNoMatchingVersion struct {
+	Chart      string
+	Constraint string
+}
+
+func (e ErrorNoMatchingVersion) Error() string {
+	return fmt.Sprintf("no version of %s matches %q", e.Chart, e.Constraint)
+}
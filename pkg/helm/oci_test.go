@@ -0,0 +1,354 @@
+package helm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"oras.land/oras-go/v2/registry/remote/auth"
+
+	"github.com/grafana/tanka/internal/ocitest"
+)
+
+// fakeRegistry serves the demo chart, requiring a bearer token obtained using
+// basic auth
+type fakeRegistry struct {
+	*ocitest.Registry
+}
+
+const (
+	fakeUser = "user"
+	fakePass = "pass"
+
+	fakeRepository = "charts/demo"
+)
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	r := &fakeRegistry{ocitest.NewRegistry(t)}
+	r.User, r.Password = fakeUser, fakePass
+	return r
+}
+
+// Ref returns the oci:// reference of the chart served by r
+func (r *fakeRegistry) Ref() string {
+	return OCIPrefix + r.Host() + "/" + fakeRepository
+}
+
+// tag returns the digest of the manifest tagged tag
+func (r *fakeRegistry) tag(tag string) string {
+	return r.Tags[fakeRepository+":"+tag]
+}
+
+// push adds version of the demo chart and returns its manifest digest
+func (r *fakeRegistry) push(t *testing.T, version, appVersion string) string {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	files := map[string]string{
+		"demo/Chart.yaml":  fmt.Sprintf("apiVersion: v2\nname: demo\nversion: %s\nappVersion: %s\n", version, appVersion),
+		"demo/values.yaml": "replicas: 1\n",
+	}
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	config := []byte(fmt.Sprintf(`{"name":"demo","version":%q,"appVersion":%q,"description":"A demo chart"}`, version, appVersion))
+	manifest, err := json.Marshal(ocispec.Manifest{
+		MediaType: OCIManifestMediaType,
+		Config:    r.blob(config, OCIChartConfigMediaType),
+		Layers:    []ocispec.Descriptor{r.blob(buf.Bytes(), OCIChartLayerMediaType)},
+	})
+	require.NoError(t, err)
+
+	return r.AddManifest(fakeRepository, versionTag(version), manifest)
+}
+
+func (r *fakeRegistry) blob(data []byte, mediaType string) ocispec.Descriptor {
+	return ocispec.Descriptor{MediaType: mediaType, Digest: digest.Digest(r.AddBlob(data)), Size: int64(len(data))}
+}
+
+// dockerLogin writes a docker config with a login for registry
+func dockerLogin(t *testing.T, config string) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600))
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("HELM_REGISTRY_CONFIG", filepath.Join(dir, "missing.json"))
+}
+
+func loginTo(t *testing.T, r *fakeRegistry) {
+	auth := base64.StdEncoding.EncodeToString([]byte(fakeUser + ":" + fakePass))
+	dockerLogin(t, fmt.Sprintf(`{"auths": {%q: {"auth": %q}}}`, r.Host(), auth))
+}
+
+func TestParseOCIReference(t *testing.T) {
+	ref, err := ParseOCIReference("oci://registry.example.com:5000/org/sub/chart")
+	require.NoError(t, err)
+	assert.Equal(t, OCIReference{Registry: "registry.example.com:5000", Repository: "org/sub/chart"}, ref)
+	assert.Equal(t, "chart", ref.Name())
+	assert.Equal(t, "oci://registry.example.com:5000/org/sub/chart", ref.String())
+
+	for _, s := range []string{"registry/chart", "oci://registry", "oci:///chart", "oci://registry/chart:1.0.0"} {
+		_, err := ParseOCIReference(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestParseOCIReq(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	req, err := parseReq("oci://localhost:5000/charts/demo@1.0.0@" + digest + ":my-path")
+	require.NoError(t, err)
+	assert.Equal(t, &Requirement{
+		Chart:     "oci://localhost:5000/charts/demo",
+		Version:   "1.0.0",
+		Digest:    digest,
+		Directory: "my-path",
+	}, req)
+
+	req, err = parseReq("oci://ghcr.io/org/demo@v1.2.3+build")
+	require.NoError(t, err)
+	assert.Equal(t, &Requirement{Chart: "oci://ghcr.io/org/demo", Version: "v1.2.3+build"}, req)
+
+	_, err = parseReq("oci://ghcr.io/org/demo")
+	assert.Error(t, err)
+	_, err = parseReq("oci://ghcr.io/org/demo@1.0.0@sha256:tooshort")
+	assert.Error(t, err)
+}
+
+func TestValidateOCI(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	assert.NoError(t, Requirements{{Chart: "oci://ghcr.io/org/demo", Version: "1.0.0", Digest: digest}}.Validate())
+
+	err := Requirements{
+		{Chart: "oci://ghcr.io", Version: "1.0.0"},
+		{Chart: "oci://ghcr.io/org/other", Version: "1.0.0", Digest: "sha256:abc"},
+		{Chart: "stable/demo", Version: "1.0.0", Digest: digest},
+	}.Validate()
+	require.Error(t, err)
+	assert.Equal(t, `validation errors:
+ - Chart name "oci://ghcr.io" is not of form oci://registry/repository
+ - Digest "sha256:abc" of chart "oci://ghcr.io/org/other" is not valid. Expecting sha256:<hex>.
+ - Chart "stable/demo" has a digest, which is only supported for OCI charts.`, err.Error())
+}
+
+func TestOCIPull(t *testing.T) {
+	r := newFakeRegistry(t)
+	digest := r.push(t, "1.0.0", "v2")
+	r.push(t, "1.1.0", "v3")
+	loginTo(t, r)
+
+	c := &OCIClient{}
+
+	dir := t.TempDir()
+	got, err := c.Pull(r.Ref(), "1.0.0", "", PullOpts{Destination: dir})
+	require.NoError(t, err)
+	assert.Equal(t, digest, got)
+	version, err := chartVersion(filepath.Join(dir, "demo"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", version)
+
+	// pinned digest
+	got, err = c.Pull(r.Ref(), "1.0.0", digest, PullOpts{Destination: dir, ExtractDirectory: "pinned"})
+	require.NoError(t, err)
+	assert.Equal(t, digest, got)
+	assert.FileExists(t, filepath.Join(dir, "pinned", "values.yaml"))
+
+	// digest of another version
+	_, err = c.Pull(r.Ref(), "1.1.0", digest, PullOpts{Destination: dir, ExtractDirectory: "mismatch"})
+	assert.EqualError(t, err, fmt.Sprintf(`%s@%s is version "1.0.0", not "1.1.0"`, r.Ref(), digest))
+	assert.NoDirExists(t, filepath.Join(dir, "mismatch"))
+
+	// tampered manifest
+	r.Manifests[digest] = append(r.Manifests[digest], ' ')
+	_, err = c.Pull(r.Ref(), "1.0.0", digest, PullOpts{Destination: dir, ExtractDirectory: "tampered"})
+	assert.ErrorContains(t, err, "digest mismatch")
+	assert.NoDirExists(t, filepath.Join(dir, "tampered"))
+}
+
+func TestOCIPullNoCredentials(t *testing.T) {
+	r := newFakeRegistry(t)
+	r.push(t, "1.0.0", "v2")
+	dockerLogin(t, `{}`)
+
+	_, err := (&OCIClient{}).Pull(r.Ref(), "1.0.0", "", PullOpts{Destination: t.TempDir()})
+	var noCreds ErrorNoCredentials
+	assert.ErrorAs(t, err, &noCreds)
+}
+
+func TestAddOCIRegistry(t *testing.T) {
+	r := newFakeRegistry(t)
+	digest := r.push(t, "1.0.0", "v2")
+	loginTo(t, r)
+
+	tempDir := t.TempDir()
+	c, err := InitChartfile(filepath.Join(tempDir, Filename))
+	require.NoError(t, err)
+	c.Helm = nil // OCI charts must not need the helm binary
+
+	err = c.Add([]string{r.Ref() + "@1.0.0", r.Ref() + "@1.0.0@" + digest + ":pinned"}, "")
+	require.NoError(t, err)
+
+	assert.FileExists(t, filepath.Join(tempDir, "charts", "demo", "Chart.yaml"))
+	assert.FileExists(t, filepath.Join(tempDir, "charts", "pinned", "Chart.yaml"))
+
+	loaded, err := LoadChartfile(tempDir)
+	require.NoError(t, err)
+	assert.Equal(t, Requirements{
+		{Chart: r.Ref(), Version: "1.0.0"},
+		{Chart: r.Ref(), Version: "1.0.0", Directory: "pinned", Digest: digest},
+	}, loaded.Manifest.Requires)
+}
+
+func TestOCIVersionCheck(t *testing.T) {
+	r := newFakeRegistry(t)
+	r.push(t, "1.0.0", "v2")
+	r.push(t, "1.0.1", "v2.1")
+	r.push(t, "1.1.0", "v3")
+	r.push(t, "2.0.0", "v4")
+	loginTo(t, r)
+
+	tempDir := t.TempDir()
+	c, err := InitChartfile(filepath.Join(tempDir, Filename))
+	require.NoError(t, err)
+	require.NoError(t, c.Add([]string{r.Ref() + "@1.0.0"}, ""))
+
+	versions, err := c.VersionCheck("")
+	require.NoError(t, err)
+
+	version := func(v, app string) ChartSearchVersion {
+		return ChartSearchVersion{Name: r.Ref(), Version: v, AppVersion: app, Description: "A demo chart"}
+	}
+	assert.Equal(t, RequiresVersionInfo{
+		Name:                       r.Ref(),
+		CurrentVersion:             "1.0.0",
+		LatestVersion:              version("2.0.0", "v4"),
+		LatestMatchingMajorVersion: version("1.1.0", "v3"),
+		LatestMatchingMinorVersion: version("1.0.1", "v2.1"),
+	}, versions[r.Ref()+"@1.0.0"])
+}
+
+func TestDockerCredentials(t *testing.T) {
+	login := base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+	dockerLogin(t, fmt.Sprintf(`{
+  "auths": {
+    "https://registry.example.com/v1/": {"auth": %q},
+    "https://index.docker.io/v1/": {"username": "bob", "password": "hunter2"},
+    "helper.example.com": {"auth": %q},
+    "identity.example.com": {"auth": %q, "identitytoken": "refresh"}
+  },
+  "credHelpers": {"helper.example.com": "tanka-test-missing"}
+}`, login, login, base64.StdEncoding.EncodeToString([]byte("<token>:"))))
+
+	ctx := context.Background()
+	cred, err := DockerCredentials(ctx, "registry.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, auth.Credential{Username: "alice", Password: "secret"}, cred)
+
+	cred, err = DockerCredentials(ctx, "docker.io")
+	require.NoError(t, err)
+	assert.Equal(t, auth.Credential{Username: "bob", Password: "hunter2"}, cred)
+
+	cred, err = DockerCredentials(ctx, "identity.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, auth.Credential{Username: "<token>", RefreshToken: "refresh"}, cred)
+
+	cred, err = DockerCredentials(ctx, "unknown.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, auth.EmptyCredential, cred)
+
+	// credHelpers take precedence over auths
+	_, err = DockerCredentials(ctx, "helper.example.com")
+	assert.ErrorContains(t, err, "docker-credential-tanka-test-missing")
+}
+
+func TestOCIPullIdentityToken(t *testing.T) {
+	r := newFakeRegistry(t)
+	r.RefreshToken = "refresh"
+	digest := r.push(t, "1.0.0", "v2")
+	dockerLogin(t, fmt.Sprintf(`{"auths": {%q: {"auth": %q, "identitytoken": "refresh"}}}`,
+		r.Host(), base64.StdEncoding.EncodeToString([]byte("<token>:"))))
+
+	got, err := (&OCIClient{}).Pull(r.Ref(), "1.0.0", "", PullOpts{Destination: t.TempDir()})
+	require.NoError(t, err)
+	assert.Equal(t, digest, got)
+	assert.Contains(t, r.Scopes, "repository:charts/demo:pull")
+}
+
+func TestOCIPush(t *testing.T) {
+	r := newFakeRegistry(t)
+	loginTo(t, r)
+	ref, err := ParseOCIReference(r.Ref())
+	require.NoError(t, err)
+
+	artifact := OCIArtifact{
+		ArtifactType:     "application/vnd.example.test.v1",
+		Annotations:      map[string]string{"org.opencontainers.image.revision": "abc"},
+		Layer:            []byte("content"),
+		LayerMediaType:   "application/vnd.example.layer.v1",
+		LayerAnnotations: map[string]string{"org.opencontainers.image.title": "content.txt"},
+	}
+	c := &OCIClient{}
+	digest, err := c.Push(ref, "v1", artifact)
+	require.NoError(t, err)
+	assert.Equal(t, digest, r.tag("v1"))
+	assert.Contains(t, r.Scopes, "repository:charts/demo:pull,push")
+
+	data, ok := r.Manifest(fakeRepository, "v1")
+	require.True(t, ok)
+	var manifest ocispec.Manifest
+	require.NoError(t, json.Unmarshal(data, &manifest))
+	assert.Equal(t, "application/vnd.example.test.v1", manifest.ArtifactType)
+	assert.Equal(t, map[string]string{"org.opencontainers.image.revision": "abc"}, manifest.Annotations)
+	assert.Equal(t, OCIEmptyConfigMediaType, manifest.Config.MediaType)
+	require.Len(t, manifest.Layers, 1)
+	layer, ok := r.Blob(manifest.Layers[0].Digest.String())
+	require.True(t, ok)
+	assert.Equal(t, "content", string(layer))
+	assert.Equal(t, "content.txt", manifest.Layers[0].Annotations["org.opencontainers.image.title"])
+
+	// pushing the same artifact again, with its blobs present, yields the same
+	// digest
+	delete(r.Tags, fakeRepository+":v1")
+	again, err := c.Push(ref, "v1", artifact)
+	require.NoError(t, err)
+	assert.Equal(t, digest, again)
+}
+
+func TestOCIPushUploadElsewhere(t *testing.T) {
+	var authorization []string
+	blobStore := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		authorization = append(authorization, req.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer blobStore.Close()
+
+	r := newFakeRegistry(t)
+	r.UploadLocation = blobStore.URL + "/upload?state=1"
+	loginTo(t, r)
+	ref, err := ParseOCIReference(r.Ref())
+	require.NoError(t, err)
+
+	_, err = (&OCIClient{}).Push(ref, "v1", OCIArtifact{Layer: []byte("content"), LayerMediaType: "application/vnd.example.layer.v1"})
+	assert.ErrorContains(t, err, "different host")
+
+	// uploads are not sent elsewhere, where the token could leak
+	assert.Empty(t, authorization)
+	assert.Empty(t, r.tag("v1"))
+}
@@ -0,0 +1,237 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	yaml "gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"helm.sh/helm/v3/pkg/strvals"
+)
+
+// EnvTemplateEngine selects the Helm implementation used by helmTemplate:
+// "sdk" (default) renders in-process, "exec" runs the `helm` binary
+const EnvTemplateEngine = "TANKA_HELM_TEMPLATE"
+
+// DefaultNamespace is used by SDKHelm if TemplateOpts.Namespace is unset.
+// Unlike the `helm` binary, SDKHelm never reads the namespace from the
+// kubeconfig
+const DefaultNamespace = "default"
+
+// DefaultHelm returns the Helm implementation to use for templating, as
+// selected by EnvTemplateEngine
+func DefaultHelm() Helm {
+	switch engine := os.Getenv(EnvTemplateEngine); engine {
+	case "exec":
+		return ExecHelm{}
+	case "", "sdk":
+		return SDKHelm{}
+	default:
+		log.Warn().Msgf("Unknown %s=%q, rendering Helm charts in-process", EnvTemplateEngine, engine)
+		return SDKHelm{}
+	}
+}
+
+// SDKHelm is a Helm implementation that renders Charts in-process using the
+// Helm Go SDK, so no `helm` binary is required for templating. All other
+// operations are delegated to ExecHelm.
+type SDKHelm struct {
+	ExecHelm
+}
+
+// Template expands a Helm Chart into a regular manifest.List, like `helm
+// template` does
+func (s SDKHelm) Template(name, chart string, opts TemplateOpts) (manifest.List, error) {
+	ch, err := loader.Load(chart)
+	if err != nil {
+		return nil, errors.Wrap(err, "Loading Helm Chart")
+	}
+	if ch.Metadata.Type != "" && ch.Metadata.Type != "application" {
+		return nil, fmt.Errorf("Expanding Helm Chart: %s charts are not installable", ch.Metadata.Type)
+	}
+	if deps := ch.Metadata.Dependencies; deps != nil {
+		if err := action.CheckDependencies(ch, deps); err != nil {
+			return nil, errors.Wrap(err, "Expanding Helm Chart")
+		}
+	}
+
+	values, err := mergeValues(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	client := action.NewInstall(&action.Configuration{
+		Log: func(format string, v ...interface{}) {
+			log.Debug().Msgf("helm: "+format, v...)
+		},
+	})
+	client.DryRun = true
+	client.DryRunOption = "true"
+	client.ClientOnly = true
+	client.Replace = true
+	client.ReleaseName = name
+	client.Namespace = opts.Namespace
+	if client.Namespace == "" {
+		client.Namespace = DefaultNamespace
+	}
+	client.IncludeCRDs = opts.IncludeCRDs
+	client.DisableHooks = opts.NoHooks
+	client.SkipSchemaValidation = opts.SkipSchemaValidation
+	client.IsUpgrade = opts.IsUpgrade
+	client.APIVersions = chartutil.VersionSet(opts.APIVersions)
+	if opts.KubeVersion != "" {
+		kubeVersion, err := chartutil.ParseKubeVersion(opts.KubeVersion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kube version '%s': %s", opts.KubeVersion, err)
+		}
+		client.KubeVersion = kubeVersion
+	}
+
+	rel, err := client.Run(ch, values)
+	if err != nil {
+		return nil, errors.Wrap(err, "Expanding Helm Chart")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, strings.TrimSpace(rel.Manifest))
+	if !opts.NoHooks {
+		for _, h := range rel.Hooks {
+			if opts.SkipTests && isTestHook(h) {
+				continue
+			}
+			fmt.Fprintf(&buf, "---\n# Source: %s\n%s\n", h.Path, h.Manifest)
+		}
+	}
+
+	if len(opts.ShowOnly) > 0 {
+		shown, err := showOnly(buf.String(), opts.ShowOnly)
+		if err != nil {
+			return nil, errors.Wrap(err, "Expanding Helm Chart")
+		}
+		buf.Reset()
+		buf.WriteString(shown)
+	}
+
+	return parseManifests(&buf)
+}
+
+// mergeValues merges the values of all options in the same order as `helm
+// template` does: ValueFiles, Values, SetString and finally SetFile
+func mergeValues(opts TemplateOpts) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, file := range opts.ValueFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "Reading Helm values")
+		}
+		v, err := chartutil.ReadValues(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Parsing Helm values from %s", file)
+		}
+		values = mergeMaps(values, v)
+	}
+
+	// pass values through YAML, exactly like ExecHelm does
+	data, err := yaml.Marshal(opts.Values)
+	if err != nil {
+		return nil, errors.Wrap(err, "Converting Helm values to YAML")
+	}
+	v, err := chartutil.ReadValues(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "Converting Helm values to YAML")
+	}
+	values = mergeMaps(values, v)
+
+	for _, key := range sortedKeys(opts.SetString) {
+		if err := strvals.ParseIntoString(key+"="+opts.SetString[key], values); err != nil {
+			return nil, errors.Wrap(err, "Parsing setString")
+		}
+	}
+
+	for _, key := range sortedKeys(opts.SetFile) {
+		reader := func(rs []rune) (interface{}, error) {
+			data, err := os.ReadFile(string(rs))
+			return string(data), err
+		}
+		if err := strvals.ParseIntoFile(key+"="+opts.SetFile[key], values, reader); err != nil {
+			return nil, errors.Wrap(err, "Parsing setFile")
+		}
+	}
+
+	return values, nil
+}
+
+// mergeMaps merges b into a, recursing into nested maps
+func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(a))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		if v, ok := v.(map[string]interface{}); ok {
+			if av, ok := out[k].(map[string]interface{}); ok {
+				out[k] = mergeMaps(av, v)
+				continue
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// manifestSource matches the template a manifest was rendered from
+var manifestSource = regexp.MustCompile("# Source: [^/]+/(.+)")
+
+// showOnly returns the manifests rendered from the given templates, like
+// `helm template --show-only` does
+func showOnly(manifests string, templates []string) (string, error) {
+	split := releaseutil.SplitManifests(manifests)
+	keys := make([]string, 0, len(split))
+	for k := range split {
+		keys = append(keys, k)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	var buf strings.Builder
+	for _, t := range templates {
+		t = filepath.ToSlash(t)
+		missing := true
+		for _, k := range keys {
+			m := split[k]
+			match := manifestSource.FindStringSubmatch(m)
+			if match == nil {
+				continue
+			}
+			if ok, _ := filepath.Match(t, match[1]); !ok {
+				continue
+			}
+			fmt.Fprintf(&buf, "---\n%s\n", m)
+			missing = false
+		}
+		if missing {
+			return "", fmt.Errorf("could not find template %s in chart", t)
+		}
+	}
+	return buf.String(), nil
+}
+
+func isTestHook(h *release.Hook) bool {
+	for _, e := range h.Events {
+		if e == release.HookTest {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package helm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+// testChart writes a chart with a CRD, a hook and a test hook to dir/demo
+func testChart(t *testing.T, dir string) string {
+	chart := filepath.Join(dir, "demo")
+	files := map[string]string{
+		"Chart.yaml":  "apiVersion: v2\nname: demo\nversion: 0.1.0\n",
+		"values.yaml": "replicas: 1\n",
+		"crds/crd.yaml": `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+`,
+		"templates/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}
+  namespace: {{ .Release.Namespace }}
+  annotations:
+    kubeVersion: {{ .Capabilities.KubeVersion.Version | quote }}
+    widgets: {{ .Capabilities.APIVersions.Has "example.com/v1" | quote }}
+spec:
+  replicas: {{ .Values.replicas }}
+`,
+		"templates/hook.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}-hook
+  annotations:
+    helm.sh/hook: pre-install
+`,
+		"templates/test.yaml": `apiVersion: v1
+kind: Pod
+metadata:
+  name: {{ .Release.Name }}-test
+  annotations:
+    helm.sh/hook: test
+`,
+	}
+	for name, content := range files {
+		p := filepath.Join(chart, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	return chart
+}
+
+func names(list manifest.List) []string {
+	var out []string
+	for _, m := range list {
+		out = append(out, m.Kind()+"/"+m.Metadata().Name())
+	}
+	return out
+}
+
+func TestSDKHelmTemplate(t *testing.T) {
+	chart := testChart(t, t.TempDir())
+
+	testCases := []struct {
+		name  string
+		opts  TemplateOpts
+		names []string
+	}{
+		{
+			name:  "defaults",
+			opts:  TemplateOpts{},
+			names: []string{"Deployment/app", "ConfigMap/app-hook", "Pod/app-test"},
+		},
+		{
+			name:  "include-crds",
+			opts:  TemplateOpts{IncludeCRDs: true},
+			names: []string{"CustomResourceDefinition/widgets.example.com", "Deployment/app", "ConfigMap/app-hook", "Pod/app-test"},
+		},
+		{
+			name:  "skip-tests",
+			opts:  TemplateOpts{SkipTests: true},
+			names: []string{"Deployment/app", "ConfigMap/app-hook"},
+		},
+		{
+			name:  "no-hooks",
+			opts:  TemplateOpts{NoHooks: true},
+			names: []string{"Deployment/app"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			list, err := SDKHelm{}.Template("app", chart, tc.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tc.names, names(list))
+		})
+	}
+}
+
+func TestSDKHelmTemplateOpts(t *testing.T) {
+	chart := testChart(t, t.TempDir())
+
+	list, err := SDKHelm{}.Template("app", chart, TemplateOpts{NoHooks: true})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, DefaultNamespace, list[0].Metadata().Namespace())
+	assert.Equal(t, "false", list[0].Metadata().Annotations()["widgets"])
+	assert.Equal(t, float64(1), list[0]["spec"].(map[string]interface{})["replicas"])
+
+	list, err = SDKHelm{}.Template("app", chart, TemplateOpts{
+		NoHooks:     true,
+		Namespace:   "monitoring",
+		KubeVersion: "1.29.3",
+		APIVersions: []string{"example.com/v1"},
+		Values:      map[string]interface{}{"replicas": 3},
+	})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "monitoring", list[0].Metadata().Namespace())
+	assert.Equal(t, "v1.29.3", list[0].Metadata().Annotations()["kubeVersion"])
+	assert.Equal(t, "true", list[0].Metadata().Annotations()["widgets"])
+	assert.Equal(t, float64(3), list[0]["spec"].(map[string]interface{})["replicas"])
+
+	_, err = SDKHelm{}.Template("app", chart, TemplateOpts{KubeVersion: "latest"})
+	assert.ErrorContains(t, err, "invalid kube version 'latest'")
+
+	_, err = SDKHelm{}.Template("Not_A_Valid_Name", chart, TemplateOpts{})
+	assert.ErrorContains(t, err, `release name "Not_A_Valid_Name"`)
+}
+
+func TestSDKHelmTemplateValues(t *testing.T) {
+	dir := t.TempDir()
+	chart := testChart(t, dir)
+	files := map[string]string{
+		"templates/values.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}-values
+data:
+  replicas: {{ .Values.replicas | quote }}
+  tag: {{ kindIs "string" .Values.image.tag | quote }}
+  config: {{ .Values.config | quote }}
+  upgrade: {{ .Release.IsUpgrade | quote }}
+`,
+		"values.schema.json": `{"properties": {"replicas": {"type": "integer", "maximum": 5}}}`,
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(chart, name), []byte(content), 0644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "base.yaml"), []byte("replicas: 2\nimage: {tag: 1}\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "prod.yaml"), []byte("replicas: 3\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.ini"), []byte("[main]"), 0644))
+
+	data := func(list manifest.List) map[string]interface{} {
+		require.Len(t, list, 1)
+		return list[0]["data"].(map[string]interface{})
+	}
+
+	opts := TemplateOpts{
+		ValueFiles: []string{filepath.Join(dir, "base.yaml"), filepath.Join(dir, "prod.yaml")},
+		SetString:  map[string]string{"image.tag": "1"},
+		SetFile:    map[string]string{"config": filepath.Join(dir, "config.ini")},
+		ShowOnly:   []string{"templates/values.yaml"},
+	}
+	list, err := SDKHelm{}.Template("app", chart, opts)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"replicas": "3",
+		"tag":      "true",
+		"config":   "[main]",
+		"upgrade":  "false",
+	}, data(list))
+
+	// inline values take precedence over files
+	opts.Values = map[string]interface{}{"replicas": 4}
+	opts.IsUpgrade = true
+	list, err = SDKHelm{}.Template("app", chart, opts)
+	require.NoError(t, err)
+	assert.Equal(t, "4", data(list)["replicas"])
+	assert.Equal(t, "true", data(list)["upgrade"])
+
+	opts.Values = map[string]interface{}{"replicas": 10}
+	_, err = SDKHelm{}.Template("app", chart, opts)
+	assert.ErrorContains(t, err, "values don't meet the specifications of the schema")
+
+	opts.SkipSchemaValidation = true
+	list, err = SDKHelm{}.Template("app", chart, opts)
+	require.NoError(t, err)
+	assert.Equal(t, "10", data(list)["replicas"])
+
+	opts.ShowOnly = []string{"templates/missing.yaml"}
+	_, err = SDKHelm{}.Template("app", chart, opts)
+	assert.ErrorContains(t, err, "could not find template templates/missing.yaml in chart")
+}
+
+func TestSDKHelmNativeFunc(t *testing.T) {
+	dir := t.TempDir()
+	testChart(t, dir)
+
+	out, err := NativeFunc(SDKHelm{}).Func([]interface{}{
+		"native", "./demo", map[string]interface{}{
+			"calledFrom": filepath.Join(dir, "main.jsonnet"),
+			"noHooks":    true,
+		},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out, "deployment_native")
+	assert.Contains(t, out, "custom_resource_definition_widgets.example.com")
+}
+
+func TestDefaultHelm(t *testing.T) {
+	t.Setenv(EnvTemplateEngine, "")
+	assert.Equal(t, SDKHelm{}, DefaultHelm())
+
+	t.Setenv(EnvTemplateEngine, "exec")
+	assert.Equal(t, ExecHelm{}, DefaultHelm())
+}
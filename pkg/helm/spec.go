@@ -3,6 +3,8 @@ package helm
 import (
 	"fmt"
 	"strings"
+
+	"github.com/Masterminds/semver"
 )
 
 const (
@@ -82,11 +84,16 @@ func (r Repos) HasName(repoName string) bool {
 }
 
 // Requirement describes a single required Helm Chart.
-// Both, Chart and Version are required
+// Both, Chart and Version are required. Chart is either of form `repo/name`,
+// or a reference to an OCI registry: `oci://registry/org/name`
 type Requirement struct {
 	Chart     string `json:"chart"`
 	Version   string `json:"version"`
 	Directory string `json:"directory,omitempty"`
+	// Digest pins OCI charts to the manifest with this digest (sha256:...)
+	Digest string `json:"digest,omitempty"`
+	// Constraint limits the versions `tk tool charts update` may choose, e.g. ~1.4
+	Constraint string `json:"constraint,omitempty"`
 }
 
 func (r Requirement) String() string {
@@ -116,9 +123,28 @@ func (r Requirements) Validate() error {
 	errs := make([]string, 0)
 
 	for _, req := range r {
-		if !strings.Contains(req.Chart, "/") {
+		if IsOCI(req.Chart) {
+			if _, err := ParseOCIReference(req.Chart); err != nil {
+				errs = append(errs, fmt.Sprintf("Chart name %s", err))
+				continue
+			}
+			if req.Digest != "" && !digestExp.MatchString(req.Digest) {
+				errs = append(errs, fmt.Sprintf("Digest %q of chart %q is not valid. Expecting sha256:<hex>.", req.Digest, req.Chart))
+				continue
+			}
+		} else if !strings.Contains(req.Chart, "/") {
 			errs = append(errs, fmt.Sprintf("Chart name %q is not valid. Expecting a repo/name format.", req.Chart))
 			continue
+		} else if req.Digest != "" {
+			errs = append(errs, fmt.Sprintf("Chart %q has a digest, which is only supported for OCI charts.", req.Chart))
+			continue
+		}
+
+		if req.Constraint != "" {
+			if _, err := semver.NewConstraint(req.Constraint); err != nil {
+				errs = append(errs, fmt.Sprintf("Constraint %q of chart %q is not valid: %s", req.Constraint, req.Chart, err))
+				continue
+			}
 		}
 
 		dir := req.Directory
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"os"
+	"sort"
 
 	"github.com/grafana/tanka/pkg/kubernetes/manifest"
 	"github.com/pkg/errors"
@@ -11,10 +12,10 @@ import (
 )
 
 func (e ExecHelm) templateCommandArgs(name, chart string, opts TemplateOpts) []string {
-	args := []string{name, chart,
-		"--values", "-", // values from stdin
-	}
+	args := []string{name, chart}
 	args = append(args, opts.Flags()...)
+	// values from stdin, taking precedence over ValueFiles
+	args = append(args, "--values", "-")
 	return args
 }
 
@@ -38,8 +39,13 @@ func (e ExecHelm) Template(name, chart string, opts TemplateOpts) (manifest.List
 		return nil, errors.Wrap(err, "Expanding Helm Chart")
 	}
 
+	return parseManifests(&buf)
+}
+
+// parseManifests parses the YAML stream output by `helm template`
+func parseManifests(r io.Reader) (manifest.List, error) {
 	var list manifest.List
-	d := yaml.NewDecoder(&buf)
+	d := yaml.NewDecoder(r)
 	for {
 		var m manifest.Manifest
 		if err := d.Decode(&m); err != nil {
@@ -79,6 +85,21 @@ type TemplateOpts struct {
 	Namespace string
 	// NoHooks specifies whether hooks should be excluded from the template output
 	NoHooks bool
+
+	// ValueFiles to pass to Helm using --values. Values take precedence over
+	// these
+	ValueFiles []string
+	// SetString sets values using --set-string, forcing them to be strings
+	SetString map[string]string
+	// SetFile sets values to the contents of files using --set-file
+	SetFile map[string]string
+	// SkipSchemaValidation disables validating the values against the
+	// values.schema.json of the chart
+	SkipSchemaValidation bool
+	// IsUpgrade sets .Release.IsUpgrade instead of .Release.IsInstall
+	IsUpgrade bool
+	// ShowOnly limits the output to manifests rendered from these templates
+	ShowOnly []string
 }
 
 // Flags returns all options apart from Values as their respective `helm
@@ -110,5 +131,38 @@ func (t TemplateOpts) Flags() []string {
 		flags = append(flags, "--namespace="+t.Namespace)
 	}
 
+	for _, file := range t.ValueFiles {
+		flags = append(flags, "--values="+file)
+	}
+
+	for _, key := range sortedKeys(t.SetString) {
+		flags = append(flags, "--set-string="+key+"="+t.SetString[key])
+	}
+
+	for _, key := range sortedKeys(t.SetFile) {
+		flags = append(flags, "--set-file="+key+"="+t.SetFile[key])
+	}
+
+	if t.SkipSchemaValidation {
+		flags = append(flags, "--skip-schema-validation")
+	}
+
+	if t.IsUpgrade {
+		flags = append(flags, "--is-upgrade")
+	}
+
+	for _, file := range t.ShowOnly {
+		flags = append(flags, "--show-only="+file)
+	}
+
 	return flags
 }
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
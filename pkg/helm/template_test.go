@@ -0,0 +1,48 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateCommandArgs(t *testing.T) {
+	args := ExecHelm{}.templateCommandArgs("app", "./demo", TemplateOpts{
+		Namespace:            "monitoring",
+		ValueFiles:           []string{"/env/values.yaml", "/env/prod.yaml"},
+		SetString:            map[string]string{"image.tag": "1.0", "a": "b"},
+		SetFile:              map[string]string{"config": "/env/config.ini"},
+		SkipSchemaValidation: true,
+		IsUpgrade:            true,
+		ShowOnly:             []string{"templates/deployment.yaml"},
+	})
+
+	assert.Equal(t, []string{"app", "./demo",
+		"--namespace=monitoring",
+		"--values=/env/values.yaml",
+		"--values=/env/prod.yaml",
+		"--set-string=a=b",
+		"--set-string=image.tag=1.0",
+		"--set-file=config=/env/config.ini",
+		"--skip-schema-validation",
+		"--is-upgrade",
+		"--show-only=templates/deployment.yaml",
+		// inline values take precedence over ValueFiles
+		"--values", "-",
+	}, args)
+}
+
+func TestTemplateOptsResolve(t *testing.T) {
+	opts := TemplateOpts{
+		ValueFiles: []string{"values.yaml", "/abs/values.yaml"},
+		SetFile:    map[string]string{"config": "files/config.ini"},
+	}
+
+	resolved := opts.resolve("/env")
+	assert.Equal(t, []string{"/env/values.yaml", "/abs/values.yaml"}, resolved.ValueFiles)
+	assert.Equal(t, map[string]string{"config": "/env/files/config.ini"}, resolved.SetFile)
+
+	// the original is left untouched
+	assert.Equal(t, []string{"values.yaml", "/abs/values.yaml"}, opts.ValueFiles)
+	assert.Equal(t, "files/config.ini", opts.SetFile["config"])
+}
@@ -0,0 +1,210 @@
+package helm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Masterminds/semver"
+	"github.com/rs/zerolog/log"
+	"sigs.k8s.io/yaml"
+)
+
+// ChartUpdate describes how Update changed a required Chart
+type ChartUpdate struct {
+	Chart     string `json:"chart"`
+	Directory string `json:"directory"`
+
+	OldVersion string `json:"old_version"`
+	NewVersion string `json:"new_version"`
+
+	// AppVersions are read from the Chart.yaml before and after updating
+	OldAppVersion string `json:"old_app_version,omitempty"`
+	NewAppVersion string `json:"new_app_version,omitempty"`
+}
+
+func (u ChartUpdate) String() string {
+	s := fmt.Sprintf("%s (dir: %s): %s → %s", u.Chart, u.Directory, u.OldVersion, u.NewVersion)
+	if u.OldAppVersion != u.NewAppVersion {
+		s += fmt.Sprintf(", appVersion %s → %s", orNone(u.OldAppVersion), orNone(u.NewAppVersion))
+	}
+	return s
+}
+
+// Update sets the version of the required Charts to the latest version
+// matching their constraint, vendors them and rewrites the Chartfile. If
+// charts is not empty, only requirements whose chart or directory is listed are
+// updated. Requirements without a constraint are never updated.
+func (c *Charts) Update(charts []string, repoConfigPath string) ([]ChartUpdate, error) {
+	repositories, err := c.getRepositories(repoConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Manifest.Requires.Validate(); err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]bool)
+	for _, s := range charts {
+		selected[s] = false
+	}
+
+	requirements := make(Requirements, len(c.Manifest.Requires))
+	copy(requirements, c.Manifest.Requires)
+
+	var updates []ChartUpdate
+	pinned := make(map[int]bool)
+	repositoriesUpdated := false
+	for i, r := range requirements {
+		dir := r.Directory
+		if dir == "" {
+			dir = parseReqName(r.Chart)
+		}
+
+		if len(charts) > 0 {
+			_, byChart := selected[r.Chart]
+			_, byDir := selected[dir]
+			if !byChart && !byDir {
+				continue
+			}
+			if byChart {
+				selected[r.Chart] = true
+			}
+			if byDir {
+				selected[dir] = true
+			}
+		}
+
+		if r.Constraint == "" {
+			skip(r.String(), fmt.Errorf("no version constraint"))
+			continue
+		}
+
+		var latest ChartSearchVersion
+		if IsOCI(r.Chart) {
+			latest, err = c.OCI.LatestVersion(r.Chart, r.Constraint)
+		} else {
+			if !repositoriesUpdated {
+				log.Info().Msg("Syncing Repositories ...")
+				if err := c.Helm.RepoUpdate(Opts{Repositories: repositories}); err != nil {
+					return nil, err
+				}
+				repositoriesUpdated = true
+			}
+			latest, err = c.Helm.LatestVersion(r.Chart, r.Constraint, Opts{Repositories: repositories})
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !isUpdate(r.Version, latest.Version, r.Constraint) {
+			log.Info().Msgf("%s is up to date", r)
+			continue
+		}
+
+		old, err := readChartManifest(filepath.Join(c.ChartDir(), dir))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		updates = append(updates, ChartUpdate{
+			Chart:         r.Chart,
+			Directory:     dir,
+			OldVersion:    r.Version,
+			NewVersion:    latest.Version,
+			OldAppVersion: old.AppVersion,
+		})
+
+		requirements[i].Version = latest.Version
+		// the pinned digest belongs to the old version. Pin the new one after
+		// vendoring it
+		if r.Digest != "" {
+			requirements[i].Digest = ""
+			pinned[i] = true
+		}
+	}
+
+	for _, s := range charts {
+		if !selected[s] {
+			return nil, fmt.Errorf("%q is neither the chart nor the directory of a requirement in %s", s, Filename)
+		}
+	}
+
+	if len(updates) == 0 {
+		return nil, nil
+	}
+
+	previous := c.Manifest.Requires
+	c.Manifest.Requires = requirements
+	if err := c.Vendor(false, repoConfigPath); err != nil {
+		c.Manifest.Requires = previous
+		return nil, err
+	}
+
+	lock, err := loadLock(c.LockFile())
+	if err != nil {
+		return nil, err
+	}
+	for i := range pinned {
+		r := requirements[i]
+		dir := r.Directory
+		if dir == "" {
+			dir = parseReqName(r.Chart)
+		}
+		locked, _ := lock.Find(r, dir)
+		requirements[i].Digest = locked.Digest
+	}
+
+	for i, u := range updates {
+		m, err := readChartManifest(filepath.Join(c.ChartDir(), u.Directory))
+		if err != nil {
+			return nil, err
+		}
+		updates[i].NewAppVersion = m.AppVersion
+	}
+
+	if err := write(c.Manifest, c.ManifestFile()); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+// isUpdate reports whether latest should replace current: it must be newer,
+// unless current does not satisfy the constraint
+func isUpdate(current, latest, constraint string) bool {
+	if current == latest {
+		return false
+	}
+
+	cur, err := semver.NewVersion(current)
+	if err != nil {
+		return true
+	}
+	lat, err := semver.NewVersion(latest)
+	if err != nil {
+		return true
+	}
+	if lat.GreaterThan(cur) {
+		return true
+	}
+
+	cons, err := semver.NewConstraint(constraint)
+	return err == nil && !cons.Check(cur)
+}
+
+// readChartManifest reads the Chart.yaml in dir
+func readChartManifest(dir string) (chartManifest, error) {
+	var chart chartManifest
+	data, err := os.ReadFile(filepath.Join(dir, "Chart.yaml"))
+	if err != nil {
+		return chart, err
+	}
+	err = yaml.Unmarshal(data, &chart)
+	return chart, err
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
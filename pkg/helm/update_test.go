@@ -0,0 +1,126 @@
+package helm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsUpdate(t *testing.T) {
+	testCases := []struct {
+		current, latest, constraint string
+		want                        bool
+	}{
+		{current: "1.4.0", latest: "1.4.2", constraint: "~1.4", want: true},
+		{current: "1.4.2", latest: "1.4.2", constraint: "~1.4", want: false},
+		// never downgrade within the constraint
+		{current: "1.4.3", latest: "1.4.2", constraint: "~1.4", want: false},
+		// but move back into it
+		{current: "2.0.0", latest: "1.4.2", constraint: "~1.4", want: true},
+		{current: "v1.0.0", latest: "v1.1.0", constraint: "^1", want: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.current+"→"+tc.latest, func(t *testing.T) {
+			assert.Equal(t, tc.want, isUpdate(tc.current, tc.latest, tc.constraint))
+		})
+	}
+}
+
+func TestValidateConstraint(t *testing.T) {
+	assert.NoError(t, Requirements{{Chart: "stable/demo", Version: "1.4.0", Constraint: "~1.4"}}.Validate())
+
+	err := Requirements{{Chart: "stable/demo", Version: "1.4.0", Constraint: "one point four"}}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `Constraint "one point four" of chart "stable/demo" is not valid`)
+}
+
+func TestUpdateOCI(t *testing.T) {
+	r := newFakeRegistry(t)
+	oldDigest := r.push(t, "1.4.0", "v1")
+	r.push(t, "1.4.2", "v2")
+	r.push(t, "1.5.0", "v3")
+	loginTo(t, r)
+
+	tempDir := t.TempDir()
+	c, err := InitChartfile(filepath.Join(tempDir, Filename))
+	require.NoError(t, err)
+	c.Manifest.Requires = Requirements{
+		{Chart: r.Ref(), Version: "1.4.0", Constraint: "~1.4"},
+		{Chart: r.Ref(), Version: "1.4.0", Constraint: "~1.4", Directory: "pinned", Digest: oldDigest},
+		{Chart: r.Ref(), Version: "1.4.0", Directory: "fixed"},
+	}
+	require.NoError(t, c.Vendor(false, ""))
+
+	updates, err := c.Update(nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, []ChartUpdate{
+		{Chart: r.Ref(), Directory: "demo", OldVersion: "1.4.0", NewVersion: "1.4.2", OldAppVersion: "v1", NewAppVersion: "v2"},
+		{Chart: r.Ref(), Directory: "pinned", OldVersion: "1.4.0", NewVersion: "1.4.2", OldAppVersion: "v1", NewAppVersion: "v2"},
+	}, updates)
+	assert.Equal(t, r.Ref()+" (dir: demo): 1.4.0 → 1.4.2, appVersion v1 → v2", updates[0].String())
+
+	// the chartfile is rewritten, pinning the new digest
+	loaded, err := LoadChartfile(tempDir)
+	require.NoError(t, err)
+	newDigest := r.tag("1.4.2")
+	assert.Equal(t, Requirements{
+		{Chart: r.Ref(), Version: "1.4.2", Constraint: "~1.4"},
+		{Chart: r.Ref(), Version: "1.4.2", Constraint: "~1.4", Directory: "pinned", Digest: newDigest},
+		{Chart: r.Ref(), Version: "1.4.0", Directory: "fixed"},
+	}, loaded.Manifest.Requires)
+
+	// as is the lock file
+	lock, err := loadLock(c.LockFile())
+	require.NoError(t, err)
+	require.Len(t, lock.Charts, 3)
+	assert.Equal(t, "1.4.2", lock.Charts[0].Version)
+	assert.Equal(t, newDigest, lock.Charts[1].Digest)
+	assert.NoError(t, loaded.Verify(""))
+
+	version, err := chartVersion(filepath.Join(tempDir, "charts", "demo"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.4.2", version)
+
+	// nothing left to do
+	updates, err = loaded.Update(nil, "")
+	require.NoError(t, err)
+	assert.Empty(t, updates)
+
+	_, err = loaded.Update([]string{"missing"}, "")
+	assert.EqualError(t, err, `"missing" is neither the chart nor the directory of a requirement in chartfile.yaml`)
+}
+
+func TestUpdateSelected(t *testing.T) {
+	tempDir := t.TempDir()
+	c, err := InitChartfile(filepath.Join(tempDir, Filename))
+	require.NoError(t, err)
+	c.Manifest.Requires = Requirements{
+		{Chart: "stable/demo", Version: "1.0.0", Constraint: "^1"},
+		{Chart: "stable/other", Version: "1.0.0", Constraint: "^1"},
+	}
+
+	helmMock := &MockHelm{}
+	helmMock.On("RepoUpdate", mock.Anything).Return(nil)
+	helmMock.On("LatestVersion", "stable/demo", "^1", mock.Anything).Return(ChartSearchVersion{Name: "stable/demo", Version: "1.2.0"}, nil)
+	helmMock.On("Pull", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		opts := args.Get(2).(PullOpts)
+		dir := filepath.Join(opts.Destination, opts.ExtractDirectory)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte("version: "+args.String(1)+"\nappVersion: v5\n"), 0644))
+	}).Return("sha256:aaaa", nil)
+	c.Helm = helmMock
+
+	updates, err := c.Update([]string{"demo"}, "")
+	require.NoError(t, err)
+	assert.Equal(t, []ChartUpdate{
+		{Chart: "stable/demo", Directory: "demo", OldVersion: "1.0.0", NewVersion: "1.2.0", NewAppVersion: "v5"},
+	}, updates)
+	assert.Equal(t, "stable/demo (dir: demo): 1.0.0 → 1.2.0, appVersion (none) → v5", updates[0].String())
+	helmMock.AssertNotCalled(t, "LatestVersion", "stable/other", mock.Anything, mock.Anything)
+	helmMock.AssertCalled(t, "Pull", "stable/demo", "1.2.0", mock.Anything)
+}
@@ -0,0 +1,105 @@
+package history
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/process"
+)
+
+const (
+	// LabelHistory marks objects holding revisions of the environment with
+	// the given NameLabel
+	LabelHistory = process.MetadataPrefix + "/history"
+	// LabelRevision is the number of the revision stored in an object
+	LabelRevision = process.MetadataPrefix + "/revision"
+
+	// dataKey is the key the encoded revision is stored at
+	dataKey = "revision.json.gz"
+)
+
+// cluster stores revisions as ConfigMaps or Secrets, one per revision
+type cluster struct {
+	ctl       client.Client
+	kind      string
+	namespace string
+	env       string
+}
+
+func newCluster(ctl client.Client, kind, namespace, env string) *cluster {
+	return &cluster{ctl: ctl, kind: kind, namespace: namespace, env: env}
+}
+
+func (c *cluster) load() ([][]byte, error) {
+	list, err := c.ctl.GetByLabels(c.namespace, strings.ToLower(c.kind)+"s", map[string]string{
+		LabelHistory: c.env,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([][]byte, 0, len(list))
+	for _, m := range list {
+		field := "binaryData"
+		if c.kind == "Secret" {
+			field = "data"
+		}
+
+		data, _ := m[field].(map[string]interface{})
+		encoded, ok := data[dataKey].(string)
+		if !ok {
+			return nil, fmt.Errorf("%s is missing %s.%s", m.KindName(), field, dataKey)
+		}
+		b, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decoding %s", m.KindName())
+		}
+		raw = append(raw, b)
+	}
+	return raw, nil
+}
+
+func (c *cluster) store(rev Revision, data []byte) error {
+	m := manifest.Manifest{
+		"apiVersion": "v1",
+		"kind":       c.kind,
+		"metadata": map[string]interface{}{
+			"name":      c.name(rev.Number),
+			"namespace": c.namespace,
+			"labels": map[string]interface{}{
+				LabelHistory:  c.env,
+				LabelRevision: strconv.Itoa(rev.Number),
+			},
+		},
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	if c.kind == "Secret" {
+		m["type"] = "Opaque"
+		m["data"] = map[string]interface{}{dataKey: encoded}
+	} else {
+		m["binaryData"] = map[string]interface{}{dataKey: encoded}
+	}
+
+	// server-side, because the last-applied annotation would exceed the
+	// annotation size limit for larger states
+	return c.ctl.Apply(manifest.List{m}, client.ApplyOpts{
+		ApplyStrategy: "server",
+		Force:         true,
+		Validate:      true,
+	})
+}
+
+func (c *cluster) remove(number int) error {
+	return c.ctl.Delete(c.namespace, "v1", c.kind, c.name(number), client.DeleteOpts{})
+}
+
+func (c *cluster) name(number int) string {
+	return fmt.Sprintf("tanka-history.%s.v%d", c.env, number)
+}
@@ -0,0 +1,248 @@
+// Package history records the states applied to an environment as numbered
+// revisions, so that these can be inspected and re-applied later on.
+package history
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/spec/v1alpha1"
+)
+
+// Storage backends selectable using spec.history.storage
+const (
+	StorageConfigMap = "configmap"
+	StorageSecret    = "secret"
+	StorageLocal     = "local"
+)
+
+// DefaultLimit is the number of revisions kept if spec.history.limit is unset
+const DefaultLimit = 10
+
+// DefaultPath is the directory local revisions are stored in if
+// spec.history.path is unset. Relative to the project root
+const DefaultPath = ".tanka/history"
+
+// Revision is a state that was applied to an environment
+type Revision struct {
+	Number      int       `json:"revision"`
+	Timestamp   time.Time `json:"timestamp"`
+	Description string    `json:"description"`
+
+	// Resources is the number of resources in State
+	Resources int `json:"resources"`
+	// State is the applied manifest.List
+	State manifest.List `json:"state"`
+}
+
+// ErrorRevisionNotFound occurs when a revision is requested that does not
+// exist (anymore)
+type ErrorRevisionNotFound struct {
+	Number int
+}
+
+func (e ErrorRevisionNotFound) Error() string {
+	return fmt.Sprintf("revision %d not found. Use `tk history` to list available revisions", e.Number)
+}
+
+// ErrorDisabled occurs when history is used on an environment that does not
+// set spec.history.storage
+type ErrorDisabled struct {
+	Env string
+}
+
+func (e ErrorDisabled) Error() string {
+	return fmt.Sprintf("history is not enabled for environment `%s`. Set spec.history.storage to one of [%s, %s, %s]", e.Env, StorageConfigMap, StorageSecret, StorageLocal)
+}
+
+// backend persists encoded revisions
+type backend interface {
+	// load returns all stored revisions
+	load() ([][]byte, error)
+	// store saves an encoded revision
+	store(rev Revision, data []byte) error
+	// remove deletes a revision
+	remove(number int) error
+}
+
+// Store keeps the most recent revisions of an environment
+type Store struct {
+	backend backend
+	limit   int
+
+	now func() time.Time
+}
+
+// Enabled returns whether spec.history is configured for env
+func Enabled(env v1alpha1.Environment) bool {
+	return env.Spec.History.Storage != ""
+}
+
+// Local reports whether env stores its history outside of the cluster, so no
+// client is required by New
+func Local(env v1alpha1.Environment) bool {
+	return env.Spec.History.Storage == StorageLocal
+}
+
+// New returns the Store configured by spec.history of env. root is the project
+// root, which relative local paths are resolved against. ctl may be nil for
+// local storage.
+func New(env v1alpha1.Environment, root string, ctl client.Client) (*Store, error) {
+	h := env.Spec.History
+	limit := h.Limit
+	if limit == 0 {
+		limit = DefaultLimit
+	}
+
+	switch h.Storage {
+	case "":
+		return nil, ErrorDisabled{Env: env.Metadata.Name}
+	case StorageLocal:
+		path := h.Path
+		if path == "" {
+			path = DefaultPath
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		return newStore(newLocal(filepath.Join(path, dirName(env.Metadata.Name))), limit), nil
+	case StorageConfigMap, StorageSecret:
+		if ctl == nil {
+			return nil, fmt.Errorf("history storage `%s` requires a cluster connection", h.Storage)
+		}
+		namespace := h.Namespace
+		if namespace == "" {
+			namespace = env.Spec.Namespace
+		}
+		label, err := env.NameLabel()
+		if err != nil {
+			return nil, err
+		}
+		kind := "ConfigMap"
+		if h.Storage == StorageSecret {
+			kind = "Secret"
+		}
+		return newStore(newCluster(ctl, kind, namespace, label), limit), nil
+	default:
+		return nil, fmt.Errorf("history storage `%s` does not exist. Pick one of: [%s, %s, %s]", h.Storage, StorageConfigMap, StorageSecret, StorageLocal)
+	}
+}
+
+func newStore(b backend, limit int) *Store {
+	return &Store{backend: b, limit: limit, now: time.Now}
+}
+
+// List returns all stored revisions, oldest first
+func (s *Store) List() ([]Revision, error) {
+	raw, err := s.backend.load()
+	if err != nil {
+		return nil, err
+	}
+
+	revs := make([]Revision, 0, len(raw))
+	for _, data := range raw {
+		rev, err := decode(data)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, *rev)
+	}
+
+	sort.Slice(revs, func(i, j int) bool {
+		return revs[i].Number < revs[j].Number
+	})
+	return revs, nil
+}
+
+// Get returns the revision with the given number
+func (s *Store) Get(number int) (*Revision, error) {
+	revs, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range revs {
+		if r.Number == number {
+			return &r, nil
+		}
+	}
+	return nil, ErrorRevisionNotFound{Number: number}
+}
+
+// Save records state as a new revision. Revisions exceeding the limit are
+// removed, oldest first.
+func (s *Store) Save(state manifest.List, description string) (*Revision, error) {
+	revs, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	rev := Revision{
+		Number:      1,
+		Timestamp:   s.now().UTC().Truncate(time.Second),
+		Description: description,
+		Resources:   len(state),
+		State:       state,
+	}
+	if len(revs) > 0 {
+		rev.Number = revs[len(revs)-1].Number + 1
+	}
+
+	data, err := encode(rev)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.backend.store(rev, data); err != nil {
+		return nil, errors.Wrapf(err, "storing revision %d", rev.Number)
+	}
+
+	revs = append(revs, rev)
+	for len(revs) > s.limit {
+		if err := s.backend.remove(revs[0].Number); err != nil {
+			return nil, errors.Wrapf(err, "removing revision %d", revs[0].Number)
+		}
+		revs = revs[1:]
+	}
+
+	return &rev, nil
+}
+
+// encode returns rev as gzip compressed JSON
+func encode(rev Revision) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(rev); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte) (*Revision, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "decompressing revision")
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, errors.Wrap(err, "decompressing revision")
+	}
+
+	var rev Revision
+	if err := json.Unmarshal(raw, &rev); err != nil {
+		return nil, errors.Wrap(err, "parsing revision")
+	}
+	return &rev, nil
+}
@@ -0,0 +1,176 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/spec/v1alpha1"
+)
+
+// fakeClient keeps applied objects in memory
+type fakeClient struct {
+	client.Client
+	objects map[string]manifest.Manifest
+	applied []client.ApplyOpts
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{objects: make(map[string]manifest.Manifest)}
+}
+
+func (f *fakeClient) GetByLabels(namespace, kind string, labels map[string]string) (manifest.List, error) {
+	var list manifest.List
+	for _, m := range f.objects {
+		if m.Metadata().Namespace() != namespace {
+			continue
+		}
+		match := true
+		for k, v := range labels {
+			if m.Metadata().Labels()[k] != v {
+				match = false
+			}
+		}
+		if match {
+			list = append(list, m)
+		}
+	}
+	return list, nil
+}
+
+func (f *fakeClient) Apply(data manifest.List, opts client.ApplyOpts) error {
+	for _, m := range data {
+		f.objects[m.Metadata().Name()] = m
+	}
+	f.applied = append(f.applied, opts)
+	return nil
+}
+
+func (f *fakeClient) Delete(namespace, apiVersion, kind, name string, opts client.DeleteOpts) error {
+	delete(f.objects, name)
+	return nil
+}
+
+func testState(names ...string) manifest.List {
+	var list manifest.List
+	for _, n := range names {
+		list = append(list, manifest.Manifest{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": n, "namespace": "default"},
+		})
+	}
+	return list
+}
+
+func testEnv(h v1alpha1.History) v1alpha1.Environment {
+	env := v1alpha1.New()
+	env.Metadata.Name = "environments/default"
+	env.Spec.History = h
+	return *env
+}
+
+func testStore(t *testing.T, s *Store) {
+	t.Helper()
+
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		clock = clock.Add(time.Minute)
+		return clock
+	}
+
+	revs, err := s.List()
+	require.NoError(t, err)
+	assert.Empty(t, revs)
+
+	for i, state := range []manifest.List{testState("a"), testState("a", "b"), testState("c")} {
+		rev, err := s.Save(state, "Apply")
+		require.NoError(t, err)
+		assert.Equal(t, i+1, rev.Number)
+	}
+
+	// limit is 2, so the first revision is gone
+	revs, err = s.List()
+	require.NoError(t, err)
+	require.Len(t, revs, 2)
+	assert.Equal(t, 2, revs[0].Number)
+	assert.Equal(t, 2, revs[0].Resources)
+	assert.Equal(t, "Apply", revs[0].Description)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 2, 0, 0, time.UTC), revs[0].Timestamp)
+	assert.Equal(t, 3, revs[1].Number)
+
+	rev, err := s.Get(2)
+	require.NoError(t, err)
+	assert.Equal(t, testState("a", "b"), rev.State)
+
+	_, err = s.Get(1)
+	assert.Equal(t, ErrorRevisionNotFound{Number: 1}, err)
+
+	// numbers keep increasing after old revisions were removed
+	rev, err = s.Save(testState("a"), "Rollback to 2")
+	require.NoError(t, err)
+	assert.Equal(t, 4, rev.Number)
+}
+
+func TestLocal(t *testing.T) {
+	root := t.TempDir()
+	s, err := New(testEnv(v1alpha1.History{Storage: StorageLocal, Limit: 2}), root, nil)
+	require.NoError(t, err)
+
+	testStore(t, s)
+
+	files, err := filepath.Glob(filepath.Join(root, DefaultPath, "environments_default", "*"))
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	// unrelated files are ignored
+	require.NoError(t, os.WriteFile(filepath.Join(root, DefaultPath, "environments_default", "README"), nil, 0o644))
+	revs, err := s.List()
+	require.NoError(t, err)
+	assert.Len(t, revs, 2)
+}
+
+func TestCluster(t *testing.T) {
+	for _, storage := range []string{StorageConfigMap, StorageSecret} {
+		t.Run(storage, func(t *testing.T) {
+			ctl := newFakeClient()
+			env := testEnv(v1alpha1.History{Storage: storage, Namespace: "tanka", Limit: 2})
+			s, err := New(env, "", ctl)
+			require.NoError(t, err)
+
+			testStore(t, s)
+
+			label, err := env.NameLabel()
+			require.NoError(t, err)
+			require.Len(t, ctl.objects, 2)
+			m := ctl.objects["tanka-history."+label+".v4"]
+			require.NotNil(t, m)
+			assert.Equal(t, "tanka", m.Metadata().Namespace())
+			assert.Equal(t, "4", m.Metadata().Labels()[LabelRevision])
+			assert.Equal(t, "server", ctl.applied[0].ApplyStrategy)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(testEnv(v1alpha1.History{}), "", nil)
+	assert.Equal(t, ErrorDisabled{Env: "environments/default"}, err)
+
+	_, err = New(testEnv(v1alpha1.History{Storage: "s3"}), "", nil)
+	assert.EqualError(t, err, "history storage `s3` does not exist. Pick one of: [configmap, secret, local]")
+
+	_, err = New(testEnv(v1alpha1.History{Storage: StorageSecret}), "", nil)
+	assert.Error(t, err)
+}
+
+func TestDirName(t *testing.T) {
+	assert.Equal(t, "environments_default", dirName("environments/default"))
+	assert.Equal(t, "foo_bar", dirName("../foo bar"))
+	assert.Equal(t, "default", dirName(""))
+}
@@ -0,0 +1,78 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const localExt = ".json.gz"
+
+// local stores revisions as files in a directory, one per revision
+type local struct {
+	dir string
+}
+
+func newLocal(dir string) *local {
+	return &local{dir: dir}
+}
+
+func (l *local) load() ([][]byte, error) {
+	entries, err := os.ReadDir(l.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var raw [][]byte
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), localExt) {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimSuffix(e.Name(), localExt)); err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(l.dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		raw = append(raw, data)
+	}
+	return raw, nil
+}
+
+func (l *local) store(rev Revision, data []byte) error {
+	if err := os.MkdirAll(l.dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(l.file(rev.Number), data, 0o644)
+}
+
+func (l *local) remove(number int) error {
+	err := os.Remove(l.file(number))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *local) file(number int) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%d%s", number, localExt))
+}
+
+var unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// dirName turns an environment name into a single, safe path segment
+func dirName(env string) string {
+	name := unsafeChars.ReplaceAllString(env, "_")
+	name = strings.Trim(name, "._")
+	if name == "" {
+		return "default"
+	}
+	return name
+}
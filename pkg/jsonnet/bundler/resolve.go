@@ -0,0 +1,236 @@
+package bundler
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/grafana/tanka/pkg/git"
+)
+
+// resolved is a dependency as recorded in the lock file, along with the
+// directory holding its files
+type resolved struct {
+	Dependency
+	dir string
+}
+
+// resolver resolves the dependencies of jsonnetfiles. Repositories are only
+// checked out once per version.
+type resolver struct {
+	opts Opts
+	tmp  string
+
+	checkouts map[string]checkout
+}
+
+type checkout struct {
+	dir    string
+	commit string
+}
+
+// resolve returns the dependencies of the jsonnetfile in dir, including the
+// transitive ones. The first requirement of a dependency wins, so direct
+// dependencies take precedence.
+func (r *resolver) resolve(dir string) (*Jsonnetfile, []resolved, error) {
+	jf, err := Load(filepath.Join(dir, Filename))
+	if err != nil {
+		return nil, nil, err
+	}
+	lock, err := loadLock(filepath.Join(dir, LockFilename))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type item struct {
+		dep Dependency
+		// from is the directory local dependencies are relative to. Local
+		// dependencies of git dependencies are not supported.
+		from string
+		by   string
+	}
+	var queue []item
+	for _, d := range jf.Dependencies {
+		queue = append(queue, item{dep: d, from: dir, by: Filename})
+	}
+
+	seen := make(map[string]Dependency)
+	var deps []resolved
+	for len(queue) > 0 {
+		it := queue[0]
+		queue = queue[1:]
+
+		if it.dep.Source.Local != nil && it.from == "" {
+			log.Warn().Msgf("Ignoring local dependency %s of %s", it.dep.Source.Local.Directory, it.by)
+			continue
+		}
+
+		name := it.dep.ImportPath()
+		if first, ok := seen[name]; ok {
+			if first.Version != it.dep.Version {
+				log.Warn().Msgf("%s requires %s, using %s", it.by, it.dep, first)
+			}
+			continue
+		}
+		seen[name] = it.dep
+
+		var d resolved
+		var from string
+		if it.dep.Source.Local != nil {
+			d, err = resolveLocal(it.dep, it.from, dir)
+			from = d.dir
+		} else {
+			d, err = r.resolveGit(it.dep, *lock, dir)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		deps = append(deps, d)
+
+		sub, err := Load(filepath.Join(d.dir, Filename))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		for _, s := range sub.Dependencies {
+			queue = append(queue, item{dep: s, from: from, by: name})
+		}
+	}
+
+	return jf, deps, nil
+}
+
+// resolveLocal resolves a local dependency relative to from. The lock file
+// records it relative to the jsonnetfile in dir.
+func resolveLocal(d Dependency, from, dir string) (resolved, error) {
+	abs := d.Source.Local.Directory
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(from, abs)
+	}
+	if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+		return resolved{}, fmt.Errorf("local dependency %s is not a directory", abs)
+	}
+
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return resolved{}, err
+	}
+	locked := d
+	locked.Source = Source{Local: &Local{Directory: filepath.ToSlash(rel)}}
+	return resolved{Dependency: locked, dir: abs}, nil
+}
+
+// resolveGit resolves a git dependency of the jsonnetfile in dir. Locked
+// versions are used unless Opts.Update is set, and taken from the vendor
+// directory or cache instead of the remote if possible.
+func (r *resolver) resolveGit(d Dependency, lock Jsonnetfile, dir string) (resolved, error) {
+	locked, isLocked := lock.find(d)
+	isLocked = isLocked && !r.opts.Update
+
+	ref := d.Version
+	if isLocked {
+		ref = locked.Version
+		candidates := []string{
+			filepath.Join(dir, "vendor", filepath.FromSlash(d.ImportPath())),
+			r.cachePath(d.ImportPath(), locked.Version),
+		}
+		for _, c := range candidates {
+			c, err := filepath.EvalSymlinks(c)
+			if err != nil {
+				continue
+			}
+			if sum, err := hashDir(c); err == nil && sum == locked.Sum {
+				return resolved{Dependency: lockDep(d, locked.Version, sum), dir: c}, nil
+			}
+		}
+	}
+
+	log.Info().Msgf("Fetching %s ...", Dependency{Source: d.Source, Version: ref})
+	co, err := r.checkout(d.Source.Git.Remote, ref)
+	if err != nil {
+		return resolved{}, fmt.Errorf("fetching %s: %w", d, err)
+	}
+	content := filepath.Join(co.dir, filepath.FromSlash(d.Source.Git.Subdir))
+	if info, err := os.Stat(content); err != nil || !info.IsDir() {
+		return resolved{}, fmt.Errorf("%s: subdir %q does not exist", d, d.Source.Git.Subdir)
+	}
+
+	sum, err := hashDir(content)
+	if err != nil {
+		return resolved{}, err
+	}
+	if isLocked && sum != locked.Sum {
+		return resolved{}, fmt.Errorf("%s does not match %s: its sum is %s, but %s is locked. If this change is expected, vendor again with --update", d, LockFilename, sum, locked.Sum)
+	}
+	return resolved{Dependency: lockDep(d, co.commit, sum), dir: content}, nil
+}
+
+// checkout checks out ref of remote into a temporary directory, once
+func (r *resolver) checkout(remote, ref string) (checkout, error) {
+	key := remote + "\x00" + ref
+	if co, ok := r.checkouts[key]; ok {
+		return co, nil
+	}
+
+	dir, err := os.MkdirTemp(r.tmp, "checkout-")
+	if err != nil {
+		return checkout{}, err
+	}
+	commit, err := git.Checkout(remote, ref, dir)
+	if err != nil {
+		return checkout{}, err
+	}
+	if err := os.RemoveAll(filepath.Join(dir, ".git")); err != nil {
+		return checkout{}, err
+	}
+
+	co := checkout{dir: dir, commit: commit}
+	r.checkouts[key] = co
+	return co, nil
+}
+
+// cachePath returns where ModeSymlink keeps the files of a dependency
+func (r *resolver) cachePath(importPath, commit string) string {
+	return filepath.Join(r.opts.CacheDir, filepath.FromSlash(importPath)+"@"+commit)
+}
+
+// lockDep returns d as recorded in the lock file
+func lockDep(d Dependency, commit, sum string) Dependency {
+	d.Ref = d.Version
+	d.Version = commit
+	d.Sum = sum
+	return d
+}
+
+// hashDir returns the sha256 over the contents of all files in dir, in
+// lexical order, like jsonnet-bundler does
+func hashDir(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,182 @@
+package bundler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Filename of the jsonnetfile
+	Filename = "jsonnetfile.json"
+
+	// LockFilename of the lock file, which is kept next to the jsonnetfile
+	LockFilename = "jsonnetfile.lock.json"
+
+	// Version of the jsonnetfile schema written to lock files
+	Version = 1
+)
+
+// Jsonnetfile is the schema of jsonnet-bundler's jsonnetfile.json, which
+// lists the dependencies of a project or environment. The lock file uses the
+// same schema, with the commits the versions resolved to.
+type Jsonnetfile struct {
+	Version      uint         `json:"version"`
+	Dependencies []Dependency `json:"dependencies"`
+
+	// LegacyImports creates `vendor/<name>` symlinks, so dependencies can be
+	// imported by name instead of their full import path. Defaults to true.
+	LegacyImports bool `json:"legacyImports"`
+}
+
+// Dependency is a single required Jsonnet library
+type Dependency struct {
+	Source Source `json:"source"`
+
+	// Version is the branch, tag or commit to vendor. In the lock file, this
+	// is the commit it resolved to.
+	Version string `json:"version"`
+
+	// Sum is the hash of the vendored files. Only used in the lock file.
+	Sum string `json:"sum,omitempty"`
+
+	// Name of the legacy import symlink. Defaults to the last element of the
+	// import path
+	Name string `json:"name,omitempty"`
+
+	// Ref is the Version requested by the jsonnetfile. Only used in the lock
+	// file, to notice when it changes.
+	Ref string `json:"ref,omitempty"`
+}
+
+// Source is where a Dependency is vendored from. Exactly one of the fields
+// is set.
+type Source struct {
+	Git   *Git   `json:"git,omitempty"`
+	Local *Local `json:"local,omitempty"`
+}
+
+// Git is a directory of a git repository
+type Git struct {
+	Remote string `json:"remote"`
+	Subdir string `json:"subdir"`
+}
+
+// Local is a directory relative to the jsonnetfile
+type Local struct {
+	Directory string `json:"directory"`
+}
+
+// ImportPath returns the directory the dependency is vendored to, relative to
+// `vendor/`: `host/org/repo/subdir` for git, the directory name for local
+// dependencies
+func (d Dependency) ImportPath() string {
+	if d.Source.Local != nil {
+		return filepath.Base(d.Source.Local.Directory)
+	}
+	return path.Join(remotePath(d.Source.Git.Remote), d.Source.Git.Subdir)
+}
+
+// LegacyName returns the name of the legacy import symlink of the dependency
+func (d Dependency) LegacyName() string {
+	if d.Name != "" {
+		return d.Name
+	}
+	return path.Base(d.ImportPath())
+}
+
+func (d Dependency) String() string {
+	if d.Source.Local != nil {
+		return d.ImportPath()
+	}
+	return d.ImportPath() + "@" + d.Version
+}
+
+// remotePath returns `host/path` of a git remote, without the `.git` suffix.
+// Supports URLs and scp-like remotes (git@github.com:org/repo.git)
+func remotePath(remote string) string {
+	p := remote
+	if u, err := url.Parse(remote); err == nil && u.Scheme != "" {
+		p = u.Host + "/" + u.Path
+	} else if host, rest, ok := strings.Cut(remote, ":"); ok {
+		_, host, _ = strings.Cut(host, "@")
+		p = host + "/" + rest
+	}
+	return strings.TrimSuffix(path.Clean("/" + p)[1:], ".git")
+}
+
+// Load reads a jsonnetfile or lock file
+func Load(file string) (*Jsonnetfile, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	jf := &Jsonnetfile{LegacyImports: true}
+	if err := json.Unmarshal(data, jf); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+
+	for i, d := range jf.Dependencies {
+		if (d.Source.Git == nil) == (d.Source.Local == nil) {
+			return nil, fmt.Errorf("%s: dependencies[%v]: exactly one of 'source.git' or 'source.local' must be set", file, i)
+		}
+		if d.Source.Git != nil && d.Source.Git.Remote == "" {
+			return nil, fmt.Errorf("%s: dependencies[%v]: 'source.git.remote' must be set", file, i)
+		}
+		if d.Source.Local != nil && d.Source.Local.Directory == "" {
+			return nil, fmt.Errorf("%s: dependencies[%v]: 'source.local.directory' must be set", file, i)
+		}
+
+		// these become paths below `vendor/`
+		if d.Source.Git != nil && d.Source.Git.Subdir != "" && !filepath.IsLocal(filepath.FromSlash(d.Source.Git.Subdir)) {
+			return nil, fmt.Errorf("%s: dependencies[%v]: 'source.git.subdir' %q must be relative to the repository root", file, i, d.Source.Git.Subdir)
+		}
+		if !filepath.IsLocal(filepath.FromSlash(d.ImportPath())) {
+			return nil, fmt.Errorf("%s: dependencies[%v]: import path %q must be relative to the vendor directory", file, i, d.ImportPath())
+		}
+		if !filepath.IsLocal(filepath.FromSlash(d.LegacyName())) {
+			return nil, fmt.Errorf("%s: dependencies[%v]: 'name' %q must be relative to the vendor directory", file, i, d.LegacyName())
+		}
+	}
+	return jf, nil
+}
+
+// loadLock reads the lock file. A missing lock file yields an empty one
+func loadLock(file string) (*Jsonnetfile, error) {
+	lock, err := Load(file)
+	if os.IsNotExist(err) {
+		return &Jsonnetfile{Version: Version}, nil
+	}
+	return lock, err
+}
+
+// find returns the locked dependency for d, unless its requested version
+// changed since it was locked
+func (j Jsonnetfile) find(d Dependency) (Dependency, bool) {
+	for _, l := range j.Dependencies {
+		if l.Source.Git == nil || d.Source.Git == nil || *l.Source.Git != *d.Source.Git {
+			continue
+		}
+		// lock files written by jsonnet-bundler do not record the ref
+		if l.Ref != "" && l.Ref != d.Version {
+			continue
+		}
+		return l, true
+	}
+	return Dependency{}, false
+}
+
+// writeLock saves a lock file to dest
+func writeLock(l Jsonnetfile, dest string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dest, append(data, '\n'), 0644)
+}
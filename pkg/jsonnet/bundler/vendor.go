@@ -0,0 +1,360 @@
+// Package bundler vendors the Jsonnet dependencies listed in jsonnetfile.json
+// files, like jsonnet-bundler does, so `vendor/` can be populated without it
+package bundler
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/grafana/tanka/pkg/jsonnet/jpath"
+)
+
+// Mode is how git dependencies are placed into `vendor/`
+type Mode string
+
+const (
+	// ModeCopy copies the files of dependencies into `vendor/`
+	ModeCopy Mode = "copy"
+	// ModeSymlink keeps the files of dependencies in a shared cache directory
+	// and symlinks them into `vendor/`
+	ModeSymlink Mode = "symlink"
+)
+
+// Opts modify the behaviour of Vendor
+type Opts struct {
+	Mode Mode
+
+	// Update resolves the versions requested by the jsonnetfiles again,
+	// instead of using the locked commits
+	Update bool
+
+	// AllowConflicts vendors even if the jsonnetfiles of the project require
+	// different versions of the same dependency
+	AllowConflicts bool
+
+	// CacheDir is where ModeSymlink keeps dependencies. Defaults to
+	// DefaultCacheDir()
+	CacheDir string
+}
+
+// DefaultCacheDir returns the directory ModeSymlink keeps dependencies in
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tanka", "jsonnet"), nil
+}
+
+// Vendor populates the `vendor/` directories of the project at root: the one
+// of the project itself, and those of environments with their own
+// jsonnetfile.json. All of them are resolved before anything is written, so
+// that conflicting versions are caught early.
+func Vendor(root string, opts Opts) error {
+	switch opts.Mode {
+	case "":
+		opts.Mode = ModeCopy
+	case ModeCopy, ModeSymlink:
+	default:
+		return fmt.Errorf("invalid mode %q, must be one of %s or %s", opts.Mode, ModeCopy, ModeSymlink)
+	}
+	if opts.Mode == ModeSymlink && opts.CacheDir == "" {
+		dir, err := DefaultCacheDir()
+		if err != nil {
+			return err
+		}
+		opts.CacheDir = dir
+	}
+
+	dirs, err := FindJsonnetfiles(root)
+	if err != nil {
+		return err
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("no %s found in %s", Filename, root)
+	}
+
+	tmp, err := os.MkdirTemp("", "tanka-vendor-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	r := &resolver{opts: opts, tmp: tmp, checkouts: make(map[string]checkout)}
+
+	type project struct {
+		dir  string
+		jf   *Jsonnetfile
+		deps []resolved
+	}
+	var projects []project
+	for _, dir := range dirs {
+		log.Info().Msgf("Resolving %s ...", relTo(root, filepath.Join(dir, Filename)))
+		jf, deps, err := r.resolve(dir)
+		if err != nil {
+			return err
+		}
+		projects = append(projects, project{dir: dir, jf: jf, deps: deps})
+	}
+
+	versions := make(map[string]map[string][]string)
+	for _, p := range projects {
+		for _, d := range p.deps {
+			version := d.Version
+			if d.Source.Local != nil {
+				version = relTo(root, filepath.Join(p.dir, filepath.FromSlash(d.Source.Local.Directory)))
+			}
+			if versions[d.ImportPath()] == nil {
+				versions[d.ImportPath()] = make(map[string][]string)
+			}
+			versions[d.ImportPath()][version] = append(versions[d.ImportPath()][version], relTo(root, filepath.Join(p.dir, Filename)))
+		}
+	}
+	var conflicts ErrorConflicts
+	for name, v := range versions {
+		if len(v) > 1 {
+			conflicts = append(conflicts, Conflict{Dependency: name, Versions: v})
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Dependency < conflicts[j].Dependency })
+		if !opts.AllowConflicts {
+			return conflicts
+		}
+		log.Warn().Msg(conflicts.Error())
+	}
+
+	for _, p := range projects {
+		if err := r.write(p.dir, *p.jf, p.deps); err != nil {
+			return err
+		}
+		log.Info().Msgf("Vendored %v dependencies to %s", len(p.deps), relTo(root, filepath.Join(p.dir, "vendor")))
+	}
+	return nil
+}
+
+// FindJsonnetfiles returns the directories holding a jsonnetfile.json in the
+// project at root: root itself, and environments (directories with a
+// main.jsonnet) that have their own.
+func FindJsonnetfiles(root string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if p != root && (d.Name() == "vendor" || strings.HasPrefix(d.Name(), ".")) {
+			return filepath.SkipDir
+		}
+
+		if _, err := os.Stat(filepath.Join(p, Filename)); err != nil {
+			return nil
+		}
+		if p == root {
+			dirs = append(dirs, p)
+		} else if _, err := os.Stat(filepath.Join(p, jpath.DefaultEntrypoint)); err == nil {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// write replaces the `vendor/` directory next to the jsonnetfile in dir with
+// deps, and writes the lock file
+func (r *resolver) write(dir string, jf Jsonnetfile, deps []resolved) error {
+	tmp, err := os.MkdirTemp(dir, ".vendor-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	// MkdirTemp creates private directories
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+
+	lock := Jsonnetfile{Version: Version, Dependencies: []Dependency{}, LegacyImports: jf.LegacyImports}
+	legacy := make(map[string]string)
+	for _, d := range deps {
+		lock.Dependencies = append(lock.Dependencies, d.Dependency)
+
+		name := filepath.FromSlash(d.ImportPath())
+		target := filepath.Join(tmp, name)
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+
+		if d.Source.Local != nil {
+			// relative to where the link ends up
+			rel, err := filepath.Rel(filepath.Dir(filepath.Join(dir, "vendor", name)), d.dir)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(rel, target); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if r.opts.Mode == ModeSymlink {
+			cached, err := r.cache(d)
+			if err != nil {
+				return err
+			}
+			err = os.Symlink(cached, target)
+			if err != nil {
+				return err
+			}
+		} else if err := copyDir(d.dir, target); err != nil {
+			return err
+		}
+
+		if jf.LegacyImports && d.LegacyName() != d.ImportPath() {
+			if other, ok := legacy[d.LegacyName()]; ok {
+				log.Warn().Msgf("Not linking %s to %s, as it is already linked to %s", d.LegacyName(), d.ImportPath(), other)
+				continue
+			}
+			legacy[d.LegacyName()] = d.ImportPath()
+		}
+	}
+
+	for name, importPath := range legacy {
+		link := filepath.Join(tmp, filepath.FromSlash(name))
+		if _, err := os.Lstat(link); err == nil {
+			log.Warn().Msgf("Not linking %s to %s, as it already exists", name, importPath)
+			continue
+		}
+		if err := os.Symlink(filepath.FromSlash(importPath), link); err != nil {
+			return err
+		}
+	}
+
+	// swap in the new vendor directory
+	vendor := filepath.Join(dir, "vendor")
+	old, err := os.MkdirTemp(dir, ".vendor-old-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(old)
+	if err := os.Rename(vendor, filepath.Join(old, "vendor")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(tmp, vendor); err != nil {
+		return err
+	}
+
+	return writeLock(lock, filepath.Join(dir, LockFilename))
+}
+
+// cache returns the directory of d in the cache directory, copying it there
+// if it is missing
+func (r *resolver) cache(d resolved) (string, error) {
+	cached := r.cachePath(d.ImportPath(), d.Version)
+	if sum, err := hashDir(cached); err == nil && sum == d.Sum {
+		return cached, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cached), os.ModePerm); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(cached), ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyDir(d.dir, filepath.Join(tmp, "files")); err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(cached); err != nil {
+		return "", err
+	}
+	return cached, os.Rename(filepath.Join(tmp, "files"), cached)
+}
+
+// copyDir copies the files, directories and symlinks in src to dst
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, os.ModePerm)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+func relTo(root, p string) string {
+	if rel, err := filepath.Rel(root, p); err == nil {
+		return rel
+	}
+	return p
+}
+
+// Conflict is a dependency that is vendored at different versions
+type Conflict struct {
+	Dependency string
+	// Versions maps the versions (or local directories) to the jsonnetfiles
+	// requiring them
+	Versions map[string][]string
+}
+
+// ErrorConflicts means that the jsonnetfiles of a project require different
+// versions of the same dependencies. As environments see the project's
+// `vendor/` as well, this may lead to libraries being used with other
+// versions of their dependencies than they were written for.
+type ErrorConflicts []Conflict
+
+func (e ErrorConflicts) Error() string {
+	var b strings.Builder
+	b.WriteString("dependencies are required at different versions:")
+	for _, c := range e {
+		var versions []string
+		for v, files := range c.Versions {
+			versions = append(versions, fmt.Sprintf("%s (%s)", v, strings.Join(files, ", ")))
+		}
+		sort.Strings(versions)
+		fmt.Fprintf(&b, "\n - %s: %s", c.Dependency, strings.Join(versions, ", "))
+	}
+	b.WriteString("\nRequire the same versions, or use --allow-conflicts")
+	return b.String()
+}
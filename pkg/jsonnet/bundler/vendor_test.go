@@ -0,0 +1,191 @@
+package bundler
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/tanka/pkg/git"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+}
+
+// gitRepo commits files to the repository in dir and tags the commit
+func gitRepo(t *testing.T, dir, tag string, files map[string]string) string {
+	writeFiles(t, dir, files)
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", tag},
+		{"tag", "--force", tag},
+	} {
+		_, err := git.Run(dir, args...)
+		require.NoError(t, err)
+	}
+	commit, err := git.Run(dir, "rev-parse", "HEAD")
+	require.NoError(t, err)
+	return commit
+}
+
+func gitDep(remote, subdir, version string) string {
+	return `{"source": {"git": {"remote": "` + remote + `", "subdir": "` + subdir + `"}}, "version": "` + version + `"}`
+}
+
+func TestRemotePath(t *testing.T) {
+	for remote, expected := range map[string]string{
+		"https://github.com/grafana/jsonnet-libs.git": "github.com/grafana/jsonnet-libs",
+		"https://github.com/grafana/jsonnet-libs":     "github.com/grafana/jsonnet-libs",
+		"git@github.com:grafana/jsonnet-libs.git":     "github.com/grafana/jsonnet-libs",
+		"ssh://git@github.com/grafana/jsonnet-libs":   "github.com/grafana/jsonnet-libs",
+		"file:///srv/git/lib":                         "srv/git/lib",
+	} {
+		assert.Equal(t, expected, remotePath(remote), remote)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"empty/jsonnetfile.json":   `{}`,
+		"invalid/jsonnetfile.json": `{"dependencies": [{"source": {}}]}`,
+	})
+
+	jf, err := Load(filepath.Join(dir, "empty", Filename))
+	require.NoError(t, err)
+	assert.True(t, jf.LegacyImports)
+
+	_, err = Load(filepath.Join(dir, "invalid", Filename))
+	assert.ErrorContains(t, err, "dependencies[0]: exactly one of 'source.git' or 'source.local' must be set")
+
+	// dependencies must not escape the vendor directory
+	for want, dep := range map[string]string{
+		"'source.git.subdir' \"../../..\" must be relative": gitDep("https://example.com/lib.git", "../../..", "main"),
+		"import path \"..\" must be relative":               `{"source": {"local": {"directory": ".."}}}`,
+		"'name' \"../lib\" must be relative":                `{"source": {"local": {"directory": "lib"}}, "name": "../lib"}`,
+	} {
+		writeFiles(t, dir, map[string]string{"escape/jsonnetfile.json": `{"dependencies": [` + dep + `]}`})
+		_, err = Load(filepath.Join(dir, "escape", Filename))
+		assert.ErrorContains(t, err, want)
+	}
+}
+
+func TestVendor(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repos := t.TempDir()
+	libB := filepath.Join(repos, "lib-b")
+	b1 := gitRepo(t, libB, "v1", map[string]string{"b.libsonnet": "{ version: 1 }"})
+	b2 := gitRepo(t, libB, "v2", map[string]string{"b.libsonnet": "{ version: 2 }"})
+	libA := filepath.Join(repos, "lib-a")
+	a := gitRepo(t, libA, "v1", map[string]string{
+		"a/main.libsonnet":   "import 'b.libsonnet'",
+		"a/jsonnetfile.json": `{"version": 1, "dependencies": [` + gitDep("file://"+libB, "", "v1") + `]}`,
+	})
+	pathA := remotePath("file://"+libA) + "/a"
+	pathB := remotePath("file://" + libB)
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"jsonnetfile.json": `{"version": 1, "dependencies": [` +
+			gitDep("file://"+libA, "a", "v1") + `,` +
+			`{"source": {"local": {"directory": "lib/mine"}}, "version": ""}` +
+			`]}`,
+		"lib/mine/mine.libsonnet":                       "{}",
+		"environments/default/main.jsonnet":             "{}",
+		"environments/prod/main.jsonnet":                "{}",
+		"environments/prod/jsonnetfile.json":            `{"version": 1, "dependencies": [` + gitDep("file://"+libB, "", "v2") + `]}`,
+		"environments/prod/vendor/ignored/main.jsonnet": "{}",
+	})
+
+	dirs, err := FindJsonnetfiles(root)
+	require.NoError(t, err)
+	assert.Equal(t, []string{root, filepath.Join(root, "environments/prod")}, dirs)
+
+	// the environment requires another version of lib-b than lib-a
+	err = Vendor(root, Opts{})
+	var conflicts ErrorConflicts
+	require.ErrorAs(t, err, &conflicts)
+	assert.Equal(t, ErrorConflicts{{
+		Dependency: pathB,
+		Versions: map[string][]string{
+			b1: {"jsonnetfile.json"},
+			b2: {"environments/prod/jsonnetfile.json"},
+		},
+	}}, conflicts)
+	assert.NoDirExists(t, filepath.Join(root, "vendor"))
+
+	require.NoError(t, Vendor(root, Opts{AllowConflicts: true}))
+
+	vendor := filepath.Join(root, "vendor")
+	data, err := os.ReadFile(filepath.Join(vendor, pathB, "b.libsonnet"))
+	require.NoError(t, err)
+	assert.Equal(t, "{ version: 1 }", string(data))
+	data, err = os.ReadFile(filepath.Join(root, "environments/prod/vendor", pathB, "b.libsonnet"))
+	require.NoError(t, err)
+	assert.Equal(t, "{ version: 2 }", string(data))
+	assert.FileExists(t, filepath.Join(vendor, "mine", "mine.libsonnet"))
+
+	// legacy imports
+	assert.FileExists(t, filepath.Join(vendor, "a", "main.libsonnet"))
+	link, err := os.Readlink(filepath.Join(vendor, "a"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.FromSlash(pathA), link)
+	link, err = os.Readlink(filepath.Join(vendor, "mine"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("..", "lib", "mine"), link)
+
+	lock, err := Load(filepath.Join(root, LockFilename))
+	require.NoError(t, err)
+	require.Len(t, lock.Dependencies, 3)
+	assert.Equal(t, a, lock.Dependencies[0].Version)
+	assert.Equal(t, "v1", lock.Dependencies[0].Ref)
+	assert.NotEmpty(t, lock.Dependencies[0].Sum)
+	assert.Equal(t, "lib/mine", lock.Dependencies[1].Source.Local.Directory)
+	assert.Equal(t, b1, lock.Dependencies[2].Version)
+
+	// locked versions are kept, even if the remote changes
+	b3 := gitRepo(t, libB, "v1", map[string]string{"b.libsonnet": "{ version: 3 }"})
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "environments/prod/jsonnetfile.json")))
+	require.NoError(t, Vendor(root, Opts{}))
+	data, err = os.ReadFile(filepath.Join(vendor, pathB, "b.libsonnet"))
+	require.NoError(t, err)
+	assert.Equal(t, "{ version: 1 }", string(data))
+
+	require.NoError(t, Vendor(root, Opts{Update: true}))
+	data, err = os.ReadFile(filepath.Join(vendor, pathB, "b.libsonnet"))
+	require.NoError(t, err)
+	assert.Equal(t, "{ version: 3 }", string(data))
+	lock, err = Load(filepath.Join(root, LockFilename))
+	require.NoError(t, err)
+	assert.Equal(t, b3, lock.Dependencies[2].Version)
+
+	// symlink mode keeps the files in the cache
+	cache := t.TempDir()
+	require.NoError(t, Vendor(root, Opts{Mode: ModeSymlink, CacheDir: cache}))
+	link, err = os.Readlink(filepath.Join(vendor, pathB))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(cache, pathB+"@"+b3), link)
+	assert.FileExists(t, filepath.Join(vendor, "a", "main.libsonnet"))
+
+	// no leftovers of the swap
+	entries, err := os.ReadDir(root)
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.False(t, strings.HasPrefix(e.Name(), ".vendor"), e.Name())
+	}
+
+	assert.EqualError(t, Vendor(root, Opts{Mode: "hardlink"}), `invalid mode "hardlink", must be one of copy or symlink`)
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/grafana/tanka/pkg/jsonnet/implementations/goimpl"
 	"github.com/grafana/tanka/pkg/jsonnet/implementations/types"
 	"github.com/grafana/tanka/pkg/jsonnet/jpath"
+	"github.com/grafana/tanka/pkg/secrets"
 )
 
 // Modifier allows to set optional parameters on the Jsonnet VM.
@@ -38,7 +39,10 @@ type Opts struct {
 	TLACode     InjectedCode
 	ImportPaths []string
 	EvalScript  string
-	CachePath   string
+	// CachePath is the location of the evaluation cache, see NewEvalCache.
+	// Cache takes precedence if set.
+	CachePath string
+	Cache     *EvalCache
 
 	CachePathRegexes []*regexp.Regexp
 }
@@ -73,6 +77,7 @@ func (o Opts) Clone() Opts {
 		EvalScript:  o.EvalScript,
 
 		CachePath:        o.CachePath,
+		Cache:            o.Cache,
 		CachePathRegexes: o.CachePathRegexes,
 	}
 }
@@ -104,9 +109,15 @@ func Evaluate(_ context.Context, path string, impl types.JsonnetImplementation,
 type evalFunc func(evaluator types.JsonnetEvaluator) (string, error)
 
 func evaluateSnippet(jsonnetImpl types.JsonnetImplementation, evalFunc evalFunc, path, data string, opts Opts) (string, error) {
-	var cache *FileEvalCache
-	if opts.CachePath != "" && opts.PathIsCached(path) {
-		cache = NewFileEvalCache(opts.CachePath)
+	var cache *EvalCache
+	if (opts.Cache != nil || opts.CachePath != "") && opts.PathIsCached(path) {
+		cache = opts.Cache
+		if cache == nil {
+			var err error
+			if cache, err = NewEvalCache(opts.CachePath, EvalCacheOpts{}); err != nil {
+				return "", err
+			}
+		}
 	}
 
 	jpath, _, _, err := jpath.Resolve(path, false)
@@ -134,11 +145,19 @@ func evaluateSnippet(jsonnetImpl types.JsonnetImplementation, evalFunc evalFunc,
 		cacheLog.Bool("cache_hit", false).Msg("computed snippet hash")
 	}
 
+	decryptions := secrets.Decryptions()
 	content, err := evalFunc(evaluator)
 	if err != nil {
 		return "", err
 	}
 
+	// Results containing decrypted secrets are not cached: they must not be
+	// written to disk, and need to be decrypted again to be redacted
+	if cache != nil && secrets.Decryptions() != decryptions {
+		log.Debug().Str("path", path).Msg("not caching evaluation, as it decrypted secrets")
+		return content, nil
+	}
+
 	if cache != nil {
 		return content, cache.Store(hash, content)
 	}
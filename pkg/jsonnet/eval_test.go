@@ -1,13 +1,16 @@
 package jsonnet
 
 import (
+	"bytes"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"testing"
 
+	"filippo.io/age"
 	"github.com/grafana/tanka/pkg/jsonnet/implementations/binary"
 	"github.com/grafana/tanka/pkg/jsonnet/implementations/goimpl"
+	"github.com/grafana/tanka/pkg/secrets"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -122,3 +125,28 @@ func TestEvaluateFileWithCaching(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "R_3hy-dRfOwXN-fezQ50ZF4dnrFcBcbQ9LztR_XWzJA=.json", result)
 }
+
+func TestEvaluateFileWithCachingSecrets(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	t.Setenv(secrets.EnvAgeKey, id.String())
+
+	tmp := t.TempDir()
+	var encrypted bytes.Buffer
+	w, err := age.Encrypt(&encrypted, id.Recipient())
+	require.NoError(t, err)
+	_, err = w.Write([]byte("s3cr3t-password"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, "password.age"), encrypted.Bytes(), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, "jsonnetfile.json"), []byte("{}"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, "main.jsonnet"), []byte(`{ password: std.native('decryptAge')('password.age', { calledFrom: std.thisFile }) }`), 0644))
+
+	cachePath := filepath.Join(tmp, "cache")
+	result, err := EvaluateFile(t.Context(), jsonnetImpl, filepath.Join(tmp, "main.jsonnet"), Opts{CachePath: cachePath})
+	require.NoError(t, err)
+	assert.Contains(t, result, "s3cr3t-password")
+
+	// decrypted secrets are never written to the cache
+	assert.NoDirExists(t, cachePath)
+}
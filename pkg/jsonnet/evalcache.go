@@ -1,13 +1,110 @@
 package jsonnet
 
 import (
+	"net/url"
 	"os"
 	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/grafana/tanka/pkg/cache"
 )
 
+// EvalCacheBackend stores evaluation results, keyed by the hash of the
+// evaluated code and its imports
+type EvalCacheBackend interface {
+	// Get returns the cached result for hash, or an empty string if there is
+	// none
+	Get(hash string) (string, error)
+	// Store saves the result for hash
+	Store(hash, content string) error
+	// Evict removes expired entries, and the oldest ones while the cache is
+	// larger than its size limit
+	Evict() error
+}
+
+// EvalCacheOpts bound the size of an evaluation cache. Zero values disable
+// either limit.
+type EvalCacheOpts struct {
+	// TTL is how long entries are kept after they were last used
+	TTL time.Duration
+	// MaxSize of all entries in bytes
+	MaxSize int64
+}
+
+// EvalCache is an evaluation cache that counts its hits and misses
+type EvalCache struct {
+	Backend EvalCacheBackend
+
+	hits, misses atomic.Int64
+}
+
+// NewEvalCache returns the evaluation cache at location, which is either a
+// local directory, or an S3-compatible blob store given as s3://bucket/prefix
+func NewEvalCache(location string, opts EvalCacheOpts) (*EvalCache, error) {
+	if u, err := url.Parse(location); err == nil && u.Scheme == "s3" {
+		backend, err := NewS3EvalCache(u, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &EvalCache{Backend: backend}, nil
+	}
+
+	backend := NewFileEvalCache(location)
+	backend.TTL = opts.TTL
+	backend.MaxSize = opts.MaxSize
+	return &EvalCache{Backend: backend}, nil
+}
+
+// Get returns the cached result for hash, or an empty string if there is none
+func (c *EvalCache) Get(hash string) (string, error) {
+	v, err := c.Backend.Get(hash)
+	if err != nil {
+		return "", err
+	}
+	if v == "" {
+		c.misses.Add(1)
+	} else {
+		c.hits.Add(1)
+	}
+	return v, nil
+}
+
+// Store saves the result for hash
+func (c *EvalCache) Store(hash, content string) error {
+	return c.Backend.Store(hash, content)
+}
+
+// Evict removes expired and excess entries, see EvalCacheBackend
+func (c *EvalCache) Evict() error {
+	return c.Backend.Evict()
+}
+
+// Hits returns how many lookups found a cached result
+func (c *EvalCache) Hits() int64 {
+	return c.hits.Load()
+}
+
+// Misses returns how many lookups found no cached result
+func (c *EvalCache) Misses() int64 {
+	return c.misses.Load()
+}
+
+// Attributes returns the hit and miss counters as OpenTelemetry attributes
+func (c *EvalCache) Attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int64("tanka.eval_cache.hits", c.Hits()),
+		attribute.Int64("tanka.eval_cache.misses", c.Misses()),
+	}
+}
+
 // FileEvalCache is an evaluation cache that stores its data on the local filesystem
 type FileEvalCache struct {
 	Directory string
+	TTL       time.Duration
+	MaxSize   int64
 }
 
 func NewFileEvalCache(cachePath string) *FileEvalCache {
@@ -26,7 +123,20 @@ func (c *FileEvalCache) Get(hash string) (string, error) {
 		return "", err
 	}
 
+	info, err := os.Stat(cachePath)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if c.TTL > 0 && time.Since(info.ModTime()) > c.TTL {
+		return "", nil
+	}
+
 	if bytes, err := os.ReadFile(cachePath); err == nil {
+		// the modification time tracks the last use, for eviction
+		now := time.Now()
+		_ = os.Chtimes(cachePath, now, now)
 		return string(bytes), err
 	} else if !os.IsNotExist(err) {
 		return "", err
@@ -46,3 +156,10 @@ func (c *FileEvalCache) Store(hash, content string) error {
 
 	return os.WriteFile(cachePath, []byte(content), 0644)
 }
+
+// Evict removes entries that were not used for TTL, and the least recently
+// used ones while the cache is larger than MaxSize
+func (c *FileEvalCache) Evict() error {
+	d := cache.Disk{Directory: c.Directory, TTL: c.TTL, MaxSize: c.MaxSize}
+	return d.Evict()
+}
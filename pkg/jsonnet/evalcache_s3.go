@@ -0,0 +1,202 @@
+package jsonnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/rs/zerolog/log"
+)
+
+// S3EvalCache is an evaluation cache that stores its data in an S3-compatible
+// blob store, so it can be shared by multiple machines, such as CI runners.
+// Objects are addressed path-style (endpoint/bucket/key). As S3 does not track
+// when objects are read, each hit copies the object onto itself, so that its
+// modification time is the time it was last used.
+type S3EvalCache struct {
+	Client *s3.Client
+	Bucket string
+	Prefix string
+
+	TTL     time.Duration
+	MaxSize int64
+}
+
+// NewS3EvalCache returns the S3EvalCache for s3://bucket/prefix. Credentials
+// and region are taken from the default AWS configuration, e.g. the usual
+// AWS_* environment variables. The endpoint defaults to AWS, and can be set
+// using AWS_ENDPOINT_URL_S3, AWS_ENDPOINT_URL or the endpoint query parameter,
+// such as s3://bucket/prefix?endpoint=http://localhost:9000&region=eu-west-1
+func NewS3EvalCache(u *url.URL, opts EvalCacheOpts) (*S3EvalCache, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid cache location %q: bucket missing, expecting s3://bucket/prefix", u.String())
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	if v := u.Query().Get("region"); v != "" {
+		cfg.Region = v
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = true
+		if v := u.Query().Get("endpoint"); v != "" {
+			o.BaseEndpoint = aws.String(v)
+		}
+		// not all S3-compatible stores support the newer checksums
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	})
+
+	c := &S3EvalCache{
+		Client:  client,
+		Bucket:  u.Host,
+		Prefix:  strings.TrimPrefix(u.Path, "/"),
+		TTL:     opts.TTL,
+		MaxSize: opts.MaxSize,
+	}
+	if c.Prefix != "" && !strings.HasSuffix(c.Prefix, "/") {
+		c.Prefix += "/"
+	}
+	return c, nil
+}
+
+func (c *S3EvalCache) Get(hash string) (string, error) {
+	ctx := context.Background()
+	key := c.Prefix + hash + ".json"
+
+	out, err := c.Client.GetObject(ctx, &s3.GetObjectInput{Bucket: &c.Bucket, Key: &key})
+	if isS3NotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer out.Body.Close()
+
+	if c.TTL > 0 && out.LastModified != nil && time.Since(*out.LastModified) > c.TTL {
+		return "", nil
+	}
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return "", err
+	}
+
+	// a failed refresh only makes the entry expire earlier
+	if err := c.touch(ctx, key); err != nil {
+		log.Debug().Err(err).Str("key", key).Msg("Failed to refresh cached evaluation")
+	}
+	return string(data), nil
+}
+
+func (c *S3EvalCache) Store(hash, content string) error {
+	key := c.Prefix + hash + ".json"
+	_, err := c.Client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:      &c.Bucket,
+		Key:         &key,
+		Body:        strings.NewReader(content),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// touch updates the modification time of key by copying it onto itself. S3
+// only allows this when replacing the metadata.
+func (c *S3EvalCache) touch(ctx context.Context, key string) error {
+	if c.TTL <= 0 && c.MaxSize <= 0 {
+		return nil
+	}
+
+	source := url.PathEscape(c.Bucket) + "/" + strings.ReplaceAll(url.PathEscape(key), "%2F", "/")
+	_, err := c.Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            &c.Bucket,
+		Key:               &key,
+		CopySource:        &source,
+		ContentType:       aws.String("application/json"),
+		MetadataDirective: types.MetadataDirectiveReplace,
+	})
+	return err
+}
+
+// Evict removes entries that were not used for longer than TTL, and the least
+// recently used ones while the cache is larger than MaxSize
+func (c *S3EvalCache) Evict() error {
+	if c.TTL <= 0 && c.MaxSize <= 0 {
+		return nil
+	}
+	ctx := context.Background()
+
+	var objects []types.Object
+	pages := s3.NewListObjectsV2Paginator(c.Client, &s3.ListObjectsV2Input{Bucket: &c.Bucket, Prefix: &c.Prefix})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, o := range page.Contents {
+			if o.Key != nil && o.LastModified != nil && strings.HasSuffix(*o.Key, ".json") {
+				objects = append(objects, o)
+			}
+		}
+	}
+
+	var keep []types.Object
+	var total int64
+	for _, o := range objects {
+		if c.TTL > 0 && time.Since(*o.LastModified) > c.TTL {
+			if err := c.delete(ctx, *o.Key); err != nil {
+				return err
+			}
+			continue
+		}
+		keep = append(keep, o)
+		total += aws.ToInt64(o.Size)
+	}
+
+	if c.MaxSize <= 0 || total <= c.MaxSize {
+		return nil
+	}
+	sort.Slice(keep, func(i, j int) bool {
+		return keep[i].LastModified.Before(*keep[j].LastModified)
+	})
+	for _, o := range keep {
+		if total <= c.MaxSize {
+			break
+		}
+		if err := c.delete(ctx, *o.Key); err != nil {
+			return err
+		}
+		total -= aws.ToInt64(o.Size)
+	}
+	return nil
+}
+
+func (c *S3EvalCache) delete(ctx context.Context, key string) error {
+	_, err := c.Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &c.Bucket, Key: &key})
+	if isS3NotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// isS3NotFound tells whether err is the response to a missing object
+func isS3NotFound(err error) bool {
+	var resp *awshttp.ResponseError
+	return errors.As(err, &resp) && resp.HTTPStatusCode() == http.StatusNotFound
+}
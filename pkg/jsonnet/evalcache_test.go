@@ -0,0 +1,240 @@
+package jsonnet
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestFileEvalCacheEvict(t *testing.T) {
+	dir := t.TempDir()
+	c := &FileEvalCache{Directory: dir, TTL: time.Hour, MaxSize: 20}
+
+	for _, hash := range []string{"old", "a", "b", "c"} {
+		require.NoError(t, c.Store(hash, "0123456789"))
+	}
+	age := func(hash string, d time.Duration) {
+		then := time.Now().Add(-d)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, hash+".json"), then, then))
+	}
+	age("old", 2*time.Hour)
+	age("a", 3*time.Minute)
+	age("b", 2*time.Minute)
+	age("c", time.Minute)
+
+	// expired entries are misses
+	v, err := c.Get("old")
+	require.NoError(t, err)
+	assert.Empty(t, v)
+
+	// using an entry makes it the most recently used one
+	v, err = c.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", v)
+
+	require.NoError(t, c.Evict())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"a.json", "c.json"}, names)
+}
+
+func TestEvalCacheCounters(t *testing.T) {
+	c, err := NewEvalCache(t.TempDir(), EvalCacheOpts{})
+	require.NoError(t, err)
+
+	v, err := c.Get("hash")
+	require.NoError(t, err)
+	assert.Empty(t, v)
+	require.NoError(t, c.Store("hash", `{"a":1}`))
+	v, err = c.Get("hash")
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, v)
+
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.Int64("tanka.eval_cache.hits", 1),
+		attribute.Int64("tanka.eval_cache.misses", 1),
+	}, c.Attributes())
+}
+
+type fakeObject struct {
+	data     []byte
+	modified time.Time
+}
+
+// fakeS3 is a minimal S3-compatible blob store, holding a single bucket
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string]fakeObject
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test/") {
+		http.Error(w, "<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>", http.StatusForbidden)
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.bucket {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code><Message>no such bucket</Message></Error>", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		s.list(w, r.URL.Query())
+	case r.Method == http.MethodGet:
+		o, ok := s.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Last-Modified", o.modified.UTC().Format(http.TimeFormat))
+		_, _ = w.Write(o.data)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		o, ok := s.objects[strings.TrimPrefix(source, s.bucket+"/")]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		s.objects[key] = fakeObject{data: o.data, modified: time.Now()}
+		_, _ = fmt.Fprintf(w, "<CopyObjectResult><LastModified>%s</LastModified></CopyObjectResult>", time.Now().UTC().Format(time.RFC3339))
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		s.objects[key] = fakeObject{data: data, modified: time.Now()}
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// list implements ListObjectsV2, returning one object per page
+func (s *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	var keys []string
+	for k := range s.objects {
+		if strings.HasPrefix(k, query.Get("prefix")) && k > query.Get("continuation-token") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	type object struct {
+		Key          string
+		LastModified string
+		Size         int
+	}
+	var result struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []object
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}
+	if len(keys) > 0 {
+		o := s.objects[keys[0]]
+		result.Contents = []object{{Key: keys[0], LastModified: o.modified.UTC().Format(time.RFC3339), Size: len(o.data)}}
+		result.IsTruncated = len(keys) > 1
+		if result.IsTruncated {
+			result.NextContinuationToken = keys[0]
+		}
+	}
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func TestS3EvalCache(t *testing.T) {
+	s3 := &fakeS3{bucket: "tanka", objects: map[string]fakeObject{"other/keep.json": {data: []byte("{}")}}}
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	c, err := NewEvalCache("s3://tanka/ci/evals?endpoint="+srv.URL, EvalCacheOpts{TTL: time.Hour, MaxSize: 20})
+	require.NoError(t, err)
+
+	v, err := c.Get("a=")
+	require.NoError(t, err)
+	assert.Empty(t, v)
+	for _, hash := range []string{"a=", "b=", "c=", "old="} {
+		require.NoError(t, c.Store(hash, "0123456789"))
+	}
+	v, err = c.Get("a=")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", v)
+	assert.Contains(t, s3.objects, "ci/evals/a=.json")
+
+	age := func(key string, d time.Duration) {
+		o := s3.objects[key]
+		o.modified = time.Now().Add(-d)
+		s3.objects[key] = o
+	}
+	age("ci/evals/old=.json", 2*time.Hour)
+	age("ci/evals/a=.json", 3*time.Minute)
+	age("ci/evals/b=.json", 2*time.Minute)
+	age("ci/evals/c=.json", time.Minute)
+
+	v, err = c.Get("old=")
+	require.NoError(t, err)
+	assert.Empty(t, v)
+
+	// hits refresh the entry, so the least recently used are evicted
+	v, err = c.Get("a=")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", v)
+	require.NoError(t, c.Evict())
+	var keys []string
+	for k := range s3.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	assert.Equal(t, []string{"ci/evals/a=.json", "ci/evals/c=.json", "other/keep.json"}, keys)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "wrong")
+	c, err = NewEvalCache("s3://tanka/ci/evals?endpoint="+srv.URL, EvalCacheOpts{})
+	require.NoError(t, err)
+	_, err = c.Get("a=")
+	assert.ErrorContains(t, err, "StatusCode: 403")
+	assert.ErrorContains(t, err, "AccessDenied: Access Denied")
+
+	_, err = NewEvalCache("s3:///prefix", EvalCacheOpts{})
+	assert.EqualError(t, err, `invalid cache location "s3:///prefix": bucket missing, expecting s3://bucket/prefix`)
+}
+
+func TestEvaluateFileWithS3Caching(t *testing.T) {
+	s3 := &fakeS3{bucket: "tanka", objects: map[string]fakeObject{}}
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	c, err := NewEvalCache("s3://tanka?endpoint="+srv.URL, EvalCacheOpts{})
+	require.NoError(t, err)
+
+	for range 2 {
+		result, err := EvaluateFile(t.Context(), jsonnetImpl, "testdata/thisFile/main.jsonnet", Opts{Cache: c})
+		require.NoError(t, err)
+		assert.Equal(t, thisFileResult, result)
+	}
+	assert.Len(t, s3.objects, 1)
+	assert.Equal(t, int64(1), c.Hits())
+	assert.Equal(t, int64(1), c.Misses())
+}
@@ -65,15 +65,17 @@ func FindTransitiveImportersForFile(root string, files []string) ([]string, erro
 	for _, file := range files {
 		if strings.HasPrefix(file, "deleted:") {
 			deletedFile := strings.TrimPrefix(file, "deleted:")
+			if filepath.IsAbs(deletedFile) {
+				filesToCheck = append(filesToCheck, filepath.Clean(deletedFile))
+				continue
+			}
 			// Try with both the absolute path and the path relative to the root
-			if !filepath.IsAbs(deletedFile) {
-				absFilePath, err := filepath.Abs(deletedFile)
-				if err != nil {
-					return nil, err
-				}
-				filesToCheck = append(filesToCheck, absFilePath)
-				filesToCheck = append(filesToCheck, filepath.Clean(filepath.Join(root, deletedFile)))
+			absFilePath, err := filepath.Abs(deletedFile)
+			if err != nil {
+				return nil, err
 			}
+			filesToCheck = append(filesToCheck, absFilePath)
+			filesToCheck = append(filesToCheck, filepath.Clean(filepath.Join(root, deletedFile)))
 			continue
 		}
 
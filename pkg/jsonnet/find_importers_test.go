@@ -217,6 +217,15 @@ func findImportersTestCases(t testing.TB) []findImportersTestCase {
 				absPath(t, "testdata/findImporters/environments/no-imports/main.jsonnet"),
 			},
 		},
+		{
+			name: "deleted file with absolute path",
+			files: []string{
+				"deleted:" + absPath(t, "testdata/findImporters/environments/no-imports/deleted-dir/deleted-file.libsonnet"),
+			},
+			expectedImporters: []string{
+				absPath(t, "testdata/findImporters/environments/no-imports/main.jsonnet"),
+			},
+		},
 		{
 			name: "imports through a main file are followed",
 			files: []string{
@@ -12,7 +12,9 @@ import (
 	jsonnet "github.com/google/go-jsonnet"
 	"github.com/google/go-jsonnet/ast"
 	"github.com/grafana/tanka/pkg/helm"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
 	"github.com/grafana/tanka/pkg/kustomize"
+	"github.com/grafana/tanka/pkg/secrets"
 	"github.com/pkg/errors"
 	yaml "gopkg.in/yaml.v3"
 )
@@ -37,8 +39,13 @@ func Funcs() []*jsonnet.NativeFunction {
 		// Hash functions
 		hashSha256(),
 
-		helm.NativeFunc(helm.ExecHelm{}),
-		kustomize.NativeFunc(kustomize.ExecKustomize{}),
+		helm.NativeFunc(helm.DefaultHelm()),
+		kustomize.NativeFunc(kustomize.DefaultKustomize()),
+		listAsMap(),
+
+		// Secrets
+		secrets.SopsNativeFunc(secrets.Identities),
+		secrets.AgeNativeFunc(secrets.Identities),
 	}
 }
 
@@ -103,6 +110,39 @@ func parseYAML() *jsonnet.NativeFunction {
 	}
 }
 
+// listAsMap converts an array of resources into an object, like helmTemplate
+// does. null elements are skipped
+func listAsMap() *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Name:   "listAsMap",
+		Params: ast.Identifiers{"list", "nameFormat"},
+		Func: func(data []interface{}) (interface{}, error) {
+			elems, ok := data[0].([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("first argument 'list' must be of 'array' type, got '%T' instead", data[0])
+			}
+			nameFormat, ok := data[1].(string)
+			if !ok {
+				return nil, fmt.Errorf("second argument 'nameFormat' must be of 'string' type, got '%T' instead", data[1])
+			}
+
+			var list manifest.List
+			for i, e := range elems {
+				if e == nil {
+					continue
+				}
+				m, ok := e.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("element %d of 'list' must be of 'object' type, got '%T' instead", i, e)
+				}
+				list = append(list, m)
+			}
+
+			return manifest.ListAsMap(list, nameFormat)
+		},
+	}
+}
+
 // manifestJSONFromJSON reserializes JSON which allows to change the indentation.
 func manifestJSONFromJSON() *jsonnet.NativeFunction {
 	return &jsonnet.NativeFunction{
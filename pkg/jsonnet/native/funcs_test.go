@@ -3,9 +3,13 @@ package native
 import (
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
+	jsonnet "github.com/google/go-jsonnet"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // callNative calls a native function used by jsonnet VM.
@@ -208,3 +212,86 @@ func TestRegexSubstInvalid(t *testing.T) {
 	assert.Empty(t, ret)
 	assert.NotEmpty(t, err)
 }
+
+func TestListAsMap(t *testing.T) {
+	list := []interface{}{
+		map[string]interface{}{"kind": "Deployment", "metadata": map[string]interface{}{"name": "grafana"}},
+		nil,
+		map[string]interface{}{"kind": "Service", "metadata": map[string]interface{}{"name": "grafana"}},
+	}
+
+	ret, err, callerr := callNative("listAsMap", []interface{}{list, ""})
+	assert.Empty(t, callerr)
+	assert.Empty(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"deployment_grafana": list[0],
+		"service_grafana":    list[2],
+	}, ret)
+
+	ret, err, callerr = callNative("listAsMap", []interface{}{list, "{{ .metadata.name }}"})
+	assert.Empty(t, callerr)
+	assert.Empty(t, ret)
+	assert.Error(t, err)
+
+	_, err, _ = callNative("listAsMap", []interface{}{[]interface{}{"grafana"}, ""})
+	assert.EqualError(t, err, "element 0 of 'list' must be of 'object' type, got 'string' instead")
+}
+
+// TestHelmPostRender post-processes the resources of a chart in Jsonnet
+// before converting them to an object
+func TestHelmPostRender(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"demo/Chart.yaml": "apiVersion: v2\nname: demo\nversion: 0.1.0\n",
+		"demo/templates/configmap.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}
+data:
+  replicas: {{ .Values.replicas | quote }}
+`,
+		"demo/templates/secret.yaml": `apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ .Release.Name }}
+`,
+		"values.yaml": "replicas: 2\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+
+	vm := jsonnet.MakeVM()
+	for _, nf := range Funcs() {
+		vm.NativeFunction(nf)
+	}
+	vm.ExtVar("calledFrom", filepath.Join(dir, "main.jsonnet"))
+	out, err := vm.EvaluateAnonymousSnippet("main.jsonnet", `
+local hook(r) =
+  if r.kind == 'Secret' then null
+  else r { metadata+: { name: 'renamed' } };
+
+local list = std.native('helmTemplate')('demo', './demo', {
+  calledFrom: std.extVar('calledFrom'),
+  asList: true,
+  valueFiles: ['values.yaml'],
+});
+std.native('listAsMap')(std.map(hook, list), '')
+`)
+	require.NoError(t, err)
+
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(out), &got))
+	assert.Equal(t, []string{"config_map_renamed"}, keys(got))
+	assert.Equal(t, "2", got["config_map_renamed"].(map[string]interface{})["data"].(map[string]interface{})["replicas"])
+}
+
+func keys(m map[string]interface{}) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -1,6 +1,7 @@
 package kubernetes
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -12,11 +13,154 @@ import (
 )
 
 // ApplyOpts allow set additional parameters for the apply operation
-type ApplyOpts client.ApplyOpts
+type ApplyOpts struct {
+	client.ApplyOpts
 
-// Apply receives a state object generated using `Reconcile()` and may apply it to the target system
-func (k *Kubernetes) Apply(state manifest.List, opts ApplyOpts) error {
-	return k.ctl.Apply(state, client.ApplyOpts(opts))
+	// WaitPhases waits for each apply phase to be established before the
+	// next one is started. The phases of CRDs and Namespaces are always
+	// awaited, as the following phases can't be applied otherwise
+	WaitPhases bool
+	// PhaseTimeout limits how long to wait for an apply phase, including
+	// phases of Helm hooks. Zero waits forever
+	PhaseTimeout time.Duration
+}
+
+// Apply receives a state object generated using `Reconcile()` and may apply it to the target system.
+// The state is applied in phases (see process.Phases). CRDs and Namespaces
+// need to be established before the next phase is applied, other phases only
+// if opts.WaitPhases is set.
+// Phases of Helm hooks are handled by applyHooks, hooks that are not run on
+// install or upgrade are skipped.
+func (k *Kubernetes) Apply(ctx context.Context, state manifest.List, opts ApplyOpts) error {
+	for _, m := range state {
+		if process.IsSkippedHook(m) {
+			log.Info().Str("resource", m.KindName()).Msg("Skipping Helm hook that is not run on install or upgrade")
+		}
+	}
+	state = process.WithoutSkippedHooks(state)
+
+	phases := process.Phases(state)
+	if len(phases) == 0 || len(phases) == 1 && !phases[0].Hook {
+		return k.ctl.Apply(state, opts.ApplyOpts)
+	}
+
+	for i, phase := range phases {
+		log.Info().Str("phase", phase.Name).Int("resources", len(phase.Manifests)).Msg("Applying phase")
+		if phase.Hook {
+			if err := k.applyHooks(ctx, phase, opts); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := k.ctl.Apply(phase.Manifests, opts.ApplyOpts); err != nil {
+			return err
+		}
+
+		// nothing is created on dry-runs, and the last phase is covered by --wait
+		if !opts.WaitPhases && !alwaysAwaited[phase.Name] || client.IsDryRun(opts.DryRun) || i == len(phases)-1 {
+			continue
+		}
+
+		if err := k.Wait(ctx, phase.Manifests, WaitOpts{Timeout: opts.PhaseTimeout}); err != nil {
+			return fmt.Errorf("apply phase `%s` was not established: %w", phase.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// alwaysAwaited are the phases later phases depend on: custom resources
+// can't be created before their CRD is established, nor namespaced resources
+// before their Namespace
+var alwaysAwaited = map[string]bool{
+	process.PhaseCRDs:       true,
+	process.PhaseNamespaces: true,
+}
+
+// applyHooks applies a phase of Helm hooks the way Helm runs them: hooks with
+// the before-hook-creation delete policy are deleted first, and all hooks are
+// awaited, e.g. until Jobs complete. Afterwards, hooks are deleted if they
+// have the hook-succeeded or hook-failed delete policy, depending on the
+// outcome.
+func (k *Kubernetes) applyHooks(ctx context.Context, phase process.Phase, opts ApplyOpts) error {
+	dryRun := client.IsDryRun(opts.DryRun)
+
+	if !dryRun {
+		deleted, err := k.deleteHooks(hooksWithPolicy(phase.Manifests, process.HookBeforeCreation))
+		if err != nil {
+			return err
+		}
+		if len(deleted) > 0 {
+			if err := k.Wait(ctx, deleted, WaitOpts{Timeout: opts.PhaseTimeout, Deleted: true}); err != nil {
+				return fmt.Errorf("previous hooks of apply phase `%s` were not deleted: %w", phase.Name, err)
+			}
+		}
+	}
+
+	if err := k.ctl.Apply(phase.Manifests, opts.ApplyOpts); err != nil {
+		return err
+	}
+
+	// nothing is created on dry-runs
+	if dryRun {
+		return nil
+	}
+
+	waitErr := k.Wait(ctx, phase.Manifests, WaitOpts{Timeout: opts.PhaseTimeout})
+
+	policy := process.HookSucceeded
+	if waitErr != nil {
+		policy = process.HookFailed
+	}
+	if _, err := k.deleteHooks(hooksWithPolicy(phase.Manifests, policy)); err != nil {
+		return err
+	}
+
+	if waitErr != nil {
+		return fmt.Errorf("hooks of apply phase `%s` did not succeed: %w", phase.Name, waitErr)
+	}
+	return nil
+}
+
+// deleteHooks deletes those hooks that exist in the cluster. Unlike `tk
+// apply --force`, this is never forced
+func (k *Kubernetes) deleteHooks(hooks manifest.List) (manifest.List, error) {
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+
+	live, err := k.ctl.GetByState(hooks, client.GetByStateOpts{IgnoreNotFound: true})
+	if _, ok := err.(client.ErrorNothingReturned); ok {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	index := indexObjects(live)
+
+	var deleted manifest.List
+	for _, m := range hooks {
+		if _, ok := lookupObject(index, m); !ok {
+			continue
+		}
+
+		log.Info().Str("resource", m.KindName()).Msg("Deleting hook")
+		if err := k.ctl.Delete(m.Metadata().Namespace(), m.APIVersion(), m.Kind(), m.Metadata().Name(), client.DeleteOpts{}); err != nil {
+			return nil, fmt.Errorf("deleting hook %s: %w", m.KindName(), err)
+		}
+		deleted = append(deleted, m)
+	}
+	return deleted, nil
+}
+
+func hooksWithPolicy(list manifest.List, policy string) manifest.List {
+	var out manifest.List
+	for _, m := range list {
+		if process.HasHookDeletePolicy(m, policy) {
+			out = append(out, m)
+		}
+	}
+	return out
 }
 
 // AnnoationLastApplied is the last-applied-configuration annotation used by kubectl
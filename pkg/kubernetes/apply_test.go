@@ -36,9 +36,16 @@ func (f *fakeClient) GetByState(data manifest.List, opts client.GetByStateOpts)
 	return f.byState, f.byStateErr
 }
 
+func (f *fakeClient) Events(namespace, kind, name string) (manifest.List, error) {
+	return nil, nil
+}
+
 func (f *fakeClient) Apply(data manifest.List, opts client.ApplyOpts) error { return nil }
-func (f *fakeClient) DiffServerSide(data manifest.List) (*string, error)    { return nil, nil }
-func (f *fakeClient) DiffExitCode(data manifest.List) (bool, error)         { return false, nil }
+func (f *fakeClient) DryRunApply(data manifest.List, opts client.ApplyOpts) (manifest.List, error) {
+	return data, nil
+}
+func (f *fakeClient) DiffServerSide(data manifest.List) (*string, error) { return nil, nil }
+func (f *fakeClient) DiffExitCode(data manifest.List) (bool, error)      { return false, nil }
 func (f *fakeClient) Delete(namespace, apiVersion, kind, name string, opts client.DeleteOpts) error {
 	return nil
 }
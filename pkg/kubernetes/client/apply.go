@@ -1,6 +1,9 @@
 package client
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -52,3 +55,31 @@ func (k Kubectl) Apply(data manifest.List, opts ApplyOpts) error {
 
 	return cmd.Run()
 }
+
+// DryRunApply applies the given yaml using `--dry-run=server` and returns the
+// objects as the server would persist them
+func (k Kubectl) DryRunApply(data manifest.List, opts ApplyOpts) (manifest.List, error) {
+	opts.DryRun = "server"
+	cmd := k.applyCtl(data, opts)
+	cmd.Args = append(cmd.Args, "-o", "json")
+
+	var sout, serr bytes.Buffer
+	cmd.Stdout = &sout
+	cmd.Stderr = &serr
+	cmd.Stdin = strings.NewReader(data.String())
+
+	if err := cmd.Run(); err != nil {
+		return nil, errors.New(strings.TrimPrefix(fmt.Sprintf("%s\n%s", serr.String(), err), "\n"))
+	}
+
+	var m manifest.Manifest
+	if err := json.Unmarshal(sout.Bytes(), &m); err != nil {
+		return nil, err
+	}
+
+	// a single object is not wrapped into a List
+	if m.Kind() != "List" {
+		return manifest.List{m}, nil
+	}
+	return unwrapList(m)
+}
@@ -11,10 +11,17 @@ type Client interface {
 	GetByLabels(namespace, kind string, labels map[string]string) (manifest.List, error)
 	GetByState(data manifest.List, opts GetByStateOpts) (manifest.List, error)
 
+	// Events returns the events the cluster recorded for the given object
+	Events(namespace, kind, name string) (manifest.List, error)
+
 	// Apply the configuration to the cluster. `data` must contain a plaintext
 	// format that is `kubectl-apply(1)` compatible
 	Apply(data manifest.List, opts ApplyOpts) error
 
+	// DryRunApply applies data using a server-side dry-run and returns the
+	// objects as the server would persist them
+	DryRunApply(data manifest.List, opts ApplyOpts) (manifest.List, error)
+
 	// DiffServerSide runs the diff operation on the server and returns the
 	// result in `diff(1)` format
 	DiffServerSide(data manifest.List) (*string, error)
@@ -61,6 +68,12 @@ type ApplyOpts struct {
 	ApplyStrategy string
 }
 
+// IsDryRun tells whether the --dry-run mode prevents changes to the cluster.
+// This is the case for all modes but "none"
+func IsDryRun(mode string) bool {
+	return mode != "" && mode != "none"
+}
+
 // DeleteOpts allow to specify additional parameters for delete operations
 // Currently not different from ApplyOpts, but may be required in the future
 type DeleteOpts ApplyOpts
@@ -78,7 +78,7 @@ func (k Kubectl) Delete(namespace, apiVersion, kind, name string, opts DeleteOpt
 		log.Trace().Msgf("Delete failed: %s", stderr.String())
 		return err
 	}
-	if opts.DryRun != "" {
+	if IsDryRun(opts.DryRun) {
 		print(stdout.String())
 	}
 
@@ -70,6 +70,13 @@ func (k Kubectl) DiffExitCode(data manifest.List) (bool, error) {
 }
 
 func (k Kubectl) diff(data manifest.List, serverSide bool) (*string, error) {
+	// prevent https://github.com/kubernetes/kubernetes/issues/89762 until fixed
+	if k.info.ClientVersion.Equal(semver.MustParse("1.18.0")) {
+		return nil, fmt.Errorf(`you seem to be using kubectl 1.18.0, which contains an unfixed issue
+that makes 'kubectl diff' modify resources in your cluster.
+Please upgrade kubectl to at least version 1.18.1`)
+	}
+
 	fw := FilterWriter{filters: []*regexp.Regexp{regexp.MustCompile(`exit status \d`)}}
 
 	args := []string{"-f", "-"}
@@ -0,0 +1,784 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/kubernetes/util"
+)
+
+const (
+	// ImplementationKubectl is the Client that shells out to `kubectl`
+	ImplementationKubectl = "kubectl"
+	// ImplementationGo is the Client built on client-go (see `Dynamic`)
+	ImplementationGo = "go"
+)
+
+// fieldManager is the field manager used for server-side apply
+const fieldManager = "tanka"
+
+// Dynamic uses client-go's dynamic client and discovery to operate on a
+// Kubernetes cluster. Unlike Kubectl, it does not require any external
+// binaries.
+type Dynamic struct {
+	info Info
+
+	dynamic   dynamic.Interface
+	discovery discovery.DiscoveryInterface
+
+	// namespace used for namespaced objects that don't specify one
+	namespace string
+
+	// out receives the per-object progress lines, similar to kubectl
+	out io.Writer
+
+	resources Resources
+}
+
+// NewDynamic returns a Dynamic client for the context of $KUBECONFIG that uses
+// the given apiServer endpoint.
+func NewDynamic(endpoint string) (*Dynamic, error) {
+	raw, err := loadKubeconfig()
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := dynamicContextFromEndpoint(raw, endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "finding usable context")
+	}
+
+	return newDynamicFromContext(raw, name)
+}
+
+// NewDynamicFromNames returns a Dynamic client for the first context of
+// $KUBECONFIG that matches one of names.
+func NewDynamicFromNames(names []string) (*Dynamic, error) {
+	raw, err := loadKubeconfig()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range names {
+		name, err := dynamicContextFromName(raw, n)
+		if _, ok := err.(ErrorNoContext); ok {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrap(err, "finding usable context")
+		}
+		return newDynamicFromContext(raw, name)
+	}
+
+	return nil, errors.Wrap(ErrorNoContext(fmt.Sprintf("%v", names)), "finding usable context")
+}
+
+func newDynamicFromContext(raw *clientcmdapi.Config, name string) (*Dynamic, error) {
+	cfg, err := clientcmd.NewNonInteractiveClientConfig(*raw, name, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "building client config")
+	}
+
+	dyn, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	disco, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	kctx := raw.Contexts[name]
+	var info Info
+	info.Kubeconfig.Context.Name = name
+	info.Kubeconfig.Context.Context.Cluster = kctx.Cluster
+	info.Kubeconfig.Context.Context.User = kctx.AuthInfo
+	info.Kubeconfig.Context.Context.Namespace = kctx.Namespace
+	info.Kubeconfig.Cluster.Name = kctx.Cluster
+	if cluster, ok := raw.Clusters[kctx.Cluster]; ok {
+		info.Kubeconfig.Cluster.Cluster.Server = cluster.Server
+	}
+
+	d, err := newDynamic(info, dyn, disco)
+	if err != nil {
+		return nil, errors.Wrap(err, "obtaining versions")
+	}
+	return d, nil
+}
+
+// newDynamic wires a Dynamic from already constructed clients. Split from
+// NewDynamic so tests can pass in fakes.
+func newDynamic(info Info, dyn dynamic.Interface, disco discovery.DiscoveryInterface) (*Dynamic, error) {
+	d := Dynamic{
+		info:      info,
+		dynamic:   dyn,
+		discovery: disco,
+		namespace: info.Kubeconfig.Context.Context.Namespace,
+		out:       os.Stdout,
+	}
+	if d.namespace == "" {
+		d.namespace = "default"
+	}
+
+	v, err := disco.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	d.info.ServerVersion, err = semver.NewVersion(v.GitVersion)
+	if err != nil {
+		return nil, errors.Wrap(err, "server version")
+	}
+
+	return &d, nil
+}
+
+func loadKubeconfig() (*clientcmdapi.Config, error) {
+	raw, err := clientcmd.NewDefaultClientConfigLoadingRules().Load()
+	if err != nil {
+		return nil, errors.Wrap(err, "loading $KUBECONFIG")
+	}
+	return raw, nil
+}
+
+// dynamicContextFromEndpoint mirrors ContextFromIP for a parsed kubeconfig
+func dynamicContextFromEndpoint(raw *clientcmdapi.Config, endpoint string) (string, error) {
+	if len(raw.Clusters) == 0 {
+		return "", ErrorMissingKubeconfigSection("clusters")
+	}
+
+	re, err := regexp.Compile(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	cluster := ""
+	for _, name := range sortedKeys(raw.Clusters) {
+		if re.MatchString(raw.Clusters[name].Server) {
+			cluster = name
+			break
+		}
+	}
+	if cluster == "" {
+		return "", ErrorNoCluster(endpoint)
+	}
+
+	for _, name := range sortedKeys(raw.Contexts) {
+		if raw.Contexts[name].Cluster == cluster {
+			return name, nil
+		}
+	}
+	return "", ErrorNoContext(cluster)
+}
+
+// dynamicContextFromName mirrors ContextFromName for a parsed kubeconfig
+func dynamicContextFromName(raw *clientcmdapi.Config, contextName string) (string, error) {
+	if len(raw.Contexts) == 0 {
+		return "", ErrorMissingKubeconfigSection("contexts")
+	}
+
+	re, err := regexp.Compile(fmt.Sprintf("^%s$", contextName))
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range sortedKeys(raw.Contexts) {
+		if !re.MatchString(name) {
+			continue
+		}
+		if _, ok := raw.Clusters[raw.Contexts[name].Cluster]; !ok {
+			return "", ErrorNoCluster(contextName)
+		}
+		return name, nil
+	}
+	return "", ErrorNoContext(contextName)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Info returns known informational data about the client and its environment.
+// ClientVersion is always nil, as there is no kubectl involved.
+func (d *Dynamic) Info() Info {
+	return d.info
+}
+
+// Close runs final cleanup
+func (d *Dynamic) Close() error {
+	return nil
+}
+
+// Resources returns all API resources known to the server, in their preferred
+// version. The result is cached for the lifetime of the client.
+func (d *Dynamic) Resources() (Resources, error) {
+	if d.resources != nil {
+		return d.resources, nil
+	}
+
+	lists, err := discovery.ServerPreferredResources(d.discovery)
+	if err != nil {
+		// aggregated apis being down should not break everything else
+		if !discovery.IsGroupDiscoveryFailedError(err) || lists == nil {
+			return nil, err
+		}
+		log.Warn().Err(err).Msg("some api groups could not be discovered")
+	}
+
+	var res Resources
+	for _, l := range lists {
+		for _, r := range l.APIResources {
+			// subresources such as deployments/status
+			if strings.Contains(r.Name, "/") {
+				continue
+			}
+			res = append(res, Resource{
+				APIVersion: l.GroupVersion,
+				Kind:       r.Kind,
+				Name:       r.Name,
+				Namespaced: r.Namespaced,
+				Shortnames: strings.Join(r.ShortNames, ","),
+				Verbs:      fmt.Sprintf("%v", []string(r.Verbs)),
+				Categories: strings.Join(r.Categories, ","),
+			})
+		}
+	}
+
+	// core group first, so that lookups by bare kind behave like kubectl.
+	// Discovery returns groups in random order
+	sort.SliceStable(res, func(i, j int) bool {
+		gi, gj := res[i].group(), res[j].group()
+		if (gi == "") != (gj == "") {
+			return gi == ""
+		}
+		if gi != gj {
+			return gi < gj
+		}
+		return res[i].Name < res[j].Name
+	})
+
+	d.resources = res
+	return res, nil
+}
+
+func (r Resource) group() string {
+	if pos := strings.Index(r.APIVersion, "/"); pos > 0 {
+		return r.APIVersion[:pos]
+	}
+	return ""
+}
+
+// resource finds the api-resource for the given kind. kind may be a Kind
+// (Deployment), a plural name (deployments) or a FQN (deployments.apps).
+// When apiVersion is set, the version of the returned resource matches it.
+func (d *Dynamic) resource(apiVersion, kind string) (schema.GroupVersionResource, bool, error) {
+	res, err := d.Resources()
+	if err != nil {
+		return schema.GroupVersionResource{}, false, err
+	}
+
+	var gv schema.GroupVersion
+	if apiVersion != "" {
+		gv, err = schema.ParseGroupVersion(apiVersion)
+		if err != nil {
+			return schema.GroupVersionResource{}, false, err
+		}
+	}
+
+	for _, r := range res {
+		if apiVersion != "" && r.group() != gv.Group {
+			continue
+		}
+		if !strings.EqualFold(r.Kind, kind) && r.Name != kind && r.FQN() != kind {
+			continue
+		}
+
+		gvr := schema.GroupVersionResource{Group: r.group(), Resource: r.Name}
+		if apiVersion != "" {
+			gvr.Version = gv.Version
+		} else {
+			v, err := schema.ParseGroupVersion(r.APIVersion)
+			if err != nil {
+				return schema.GroupVersionResource{}, false, err
+			}
+			gvr.Version = v.Version
+		}
+		return gvr, r.Namespaced, nil
+	}
+
+	return schema.GroupVersionResource{}, false, ErrorUnknownResource{
+		errOut: fmt.Sprintf("error: the server doesn't have a resource type %q", kind),
+	}
+}
+
+// client returns the dynamic client for the given resource, scoped to
+// namespace if the resource is namespaced. An empty namespace selects the
+// default namespace, unless allNamespaces is set.
+func (d *Dynamic) client(apiVersion, kind, namespace string, allNamespaces bool) (dynamic.ResourceInterface, schema.GroupVersionResource, error) {
+	gvr, namespaced, err := d.resource(apiVersion, kind)
+	if err != nil {
+		return nil, gvr, err
+	}
+
+	if !namespaced || (namespace == "" && allNamespaces) {
+		return d.dynamic.Resource(gvr), gvr, nil
+	}
+	if namespace == "" {
+		namespace = d.namespace
+	}
+	return d.dynamic.Resource(gvr).Namespace(namespace), gvr, nil
+}
+
+// Get retrieves a single Kubernetes object from the cluster
+func (d *Dynamic) Get(namespace, kind, name string) (manifest.Manifest, error) {
+	ri, _, err := d.client("", kind, namespace, false)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := ri.Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, ErrorNotFound{err.Error()}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return manifest.Manifest(obj.Object), nil
+}
+
+// GetByLabels retrieves all objects matched by the given labels from the
+// cluster. kind may be a comma-separated list. Set namespace to empty string
+// for all namespaces.
+func (d *Dynamic) GetByLabels(namespace, kind string, lbls map[string]string) (manifest.List, error) {
+	selector := labels.SelectorFromSet(lbls).String()
+
+	var list manifest.List
+	for _, k := range strings.Split(kind, ",") {
+		if k == "" {
+			continue
+		}
+
+		ri, _, err := d.client("", k, namespace, true)
+		if err != nil {
+			return nil, err
+		}
+
+		items, err := ri.List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range items.Items {
+			list = append(list, manifest.Manifest(i.Object))
+		}
+	}
+
+	return list, nil
+}
+
+// GetByState returns the full object, including runtime fields for each
+// resource in the state
+func (d *Dynamic) GetByState(data manifest.List, opts GetByStateOpts) (manifest.List, error) {
+	var list manifest.List
+	for _, m := range data {
+		live, err := d.live(m)
+		if err != nil {
+			return nil, err
+		}
+		if live == nil {
+			if opts.IgnoreNotFound {
+				continue
+			}
+			return nil, ErrorNotFound{fmt.Sprintf("Error from server (NotFound): %s %q not found", m.Kind(), m.Metadata().Name())}
+		}
+		list = append(list, manifest.Manifest(live.Object))
+	}
+
+	// same as kubectl, which returns nothing at all in this case
+	if len(list) == 0 {
+		return nil, ErrorNothingReturned{}
+	}
+
+	return list, nil
+}
+
+// Events returns the events the cluster recorded for the given object
+func (d *Dynamic) Events(namespace, kind, name string) (manifest.List, error) {
+	ri, _, err := d.client("v1", "Event", namespace, false)
+	if err != nil {
+		return nil, err
+	}
+
+	selector := fields.SelectorFromSet(fields.Set{
+		"involvedObject.kind": kind,
+		"involvedObject.name": name,
+	}).String()
+	items, err := ri.List(context.TODO(), metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	list := make(manifest.List, 0, len(items.Items))
+	for _, i := range items.Items {
+		list = append(list, manifest.Manifest(i.Object))
+	}
+	return list, nil
+}
+
+// live returns the cluster state of m, or nil if it does not exist
+func (d *Dynamic) live(m manifest.Manifest) (*unstructured.Unstructured, error) {
+	ri, _, err := d.client(m.APIVersion(), m.Kind(), m.Metadata().Namespace(), false)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := ri.Get(context.TODO(), m.Metadata().Name(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return obj, err
+}
+
+// Namespaces of the cluster
+func (d *Dynamic) Namespaces() (map[string]bool, error) {
+	list, err := d.dynamic.Resource(namespacesGVR).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := make(map[string]bool)
+	for _, ns := range list.Items {
+		namespaces[ns.GetName()] = true
+	}
+	return namespaces, nil
+}
+
+var namespacesGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// Namespace finds a single namespace in the cluster
+func (d *Dynamic) Namespace(namespace string) (manifest.Manifest, error) {
+	ns, err := d.dynamic.Resource(namespacesGVR).Get(context.TODO(), namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, ErrNamespaceNotFound{Namespace: namespace}
+	} else if err != nil {
+		return nil, err
+	}
+	return manifest.Manifest(ns.Object), nil
+}
+
+// Delete deletes the given Kubernetes resource from the cluster
+func (d *Dynamic) Delete(namespace, apiVersion, kind, name string, opts DeleteOpts) error {
+	ri, gvr, err := d.client(apiVersion, kind, namespace, false)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Str("name", name).Str("apiVersion", apiVersion).Str("kind", kind).Str("namespace", namespace).Msg("Preparing to delete")
+
+	if opts.DryRun == "client" {
+		fmt.Fprintf(d.out, "%s %q deleted (dry run)\n", kindString(kind, gvr), name)
+		return nil
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	delOpts := metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+		DryRun:            dryRun(opts.DryRun),
+	}
+	if opts.Force {
+		grace := int64(0)
+		delOpts.GracePeriodSeconds = &grace
+	}
+
+	err = ri.Delete(context.TODO(), name, delOpts)
+	if apierrors.IsNotFound(err) {
+		log.Warn().Msgf("Delete failed: %s", err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(d.out, "%s %q deleted%s\n", kindString(kind, gvr), name, dryRunSuffix(opts.DryRun))
+	return nil
+}
+
+// Apply applies the given manifests to the cluster. The server strategy uses
+// server-side apply. The client strategy stores the last-applied-configuration
+// annotation and sends a three-way JSON merge patch, like `kubectl apply` does
+// for resources without a strategic merge schema.
+func (d *Dynamic) Apply(data manifest.List, opts ApplyOpts) error {
+	for _, m := range data {
+		_, action, err := d.apply(m, opts)
+		if err != nil {
+			return errors.Wrapf(err, "applying %s", m.KindName())
+		}
+		fmt.Fprintf(d.out, "%s\n", action)
+	}
+	return nil
+}
+
+// DryRunApply applies the given manifests using a server-side dry-run and
+// returns the objects as the server would persist them
+func (d *Dynamic) DryRunApply(data manifest.List, opts ApplyOpts) (manifest.List, error) {
+	opts.DryRun = "server"
+
+	list := make(manifest.List, 0, len(data))
+	for _, m := range data {
+		obj, _, err := d.apply(m, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "applying %s", m.KindName())
+		}
+		list = append(list, manifest.Manifest(obj.Object))
+	}
+	return list, nil
+}
+
+// apply applies a single manifest, returning the resulting object and a
+// kubectl-style line describing what happened
+func (d *Dynamic) apply(m manifest.Manifest, opts ApplyOpts) (*unstructured.Unstructured, string, error) {
+	ri, gvr, err := d.client(m.APIVersion(), m.Kind(), m.Metadata().Namespace(), false)
+	if err != nil {
+		return nil, "", err
+	}
+
+	name := m.Metadata().Name()
+	line := func(action string) string {
+		return fmt.Sprintf("%s/%s %s%s", kindString(m.Kind(), gvr), name, action, dryRunSuffix(opts.DryRun))
+	}
+
+	obj := &unstructured.Unstructured{Object: runtimeCopy(m)}
+	if opts.DryRun == "client" {
+		return obj, line("configured"), nil
+	}
+
+	validation := "Strict"
+	if !opts.Validate {
+		validation = "Ignore"
+	}
+
+	if opts.ApplyStrategy == "server" {
+		body, err := json.Marshal(obj.Object)
+		if err != nil {
+			return nil, "", err
+		}
+		res, err := ri.Patch(context.TODO(), name, types.ApplyPatchType, body, metav1.PatchOptions{
+			FieldManager:    fieldManager,
+			Force:           &opts.Force,
+			DryRun:          dryRun(opts.DryRun),
+			FieldValidation: validation,
+		})
+		return res, line("serverside-applied"), err
+	}
+
+	modified, err := withLastApplied(obj)
+	if err != nil {
+		return nil, "", err
+	}
+
+	current, err := ri.Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		res, err := ri.Create(context.TODO(), obj, metav1.CreateOptions{
+			DryRun:          dryRun(opts.DryRun),
+			FieldValidation: validation,
+		})
+		return res, line("created"), err
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	currentJSON, err := current.MarshalJSON()
+	if err != nil {
+		return nil, "", err
+	}
+	original := []byte(current.GetAnnotations()[lastAppliedAnnotation])
+
+	patch, err := jsonmergepatch.CreateThreeWayJSONMergePatch(original, modified, currentJSON)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "computing patch")
+	}
+	if string(patch) == "{}" {
+		return current, line("unchanged"), nil
+	}
+
+	res, err := ri.Patch(context.TODO(), name, types.MergePatchType, patch, metav1.PatchOptions{
+		DryRun:          dryRun(opts.DryRun),
+		FieldValidation: validation,
+	})
+	if err == nil || !opts.Force || !(apierrors.IsConflict(err) || apierrors.IsInvalid(err)) {
+		return res, line("configured"), err
+	}
+
+	// --force: delete and re-create if the patch could not be applied
+	if err := ri.Delete(context.TODO(), name, metav1.DeleteOptions{DryRun: dryRun(opts.DryRun)}); err != nil {
+		return nil, "", err
+	}
+	res, err = ri.Create(context.TODO(), obj, metav1.CreateOptions{
+		DryRun:          dryRun(opts.DryRun),
+		FieldValidation: validation,
+	})
+	return res, line("replaced"), err
+}
+
+// lastAppliedAnnotation is kubectl's last-applied-configuration annotation
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// withLastApplied sets the last-applied-configuration annotation on obj and
+// returns the JSON of the result
+func withLastApplied(obj *unstructured.Unstructured) ([]byte, error) {
+	annotations := obj.GetAnnotations()
+	delete(annotations, lastAppliedAnnotation)
+	obj.SetAnnotations(annotations)
+
+	original, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[lastAppliedAnnotation] = string(original)
+	obj.SetAnnotations(annotations)
+
+	return obj.MarshalJSON()
+}
+
+// DiffServerSide takes the desired state and computes the differences
+// server-side, returning them in `diff(1)` format
+func (d *Dynamic) DiffServerSide(data manifest.List) (*string, error) {
+	return d.diff(data, true)
+}
+
+// DiffClientSide takes the desired state and computes the differences,
+// returning them in `diff(1)` format
+func (d *Dynamic) DiffClientSide(data manifest.List) (*string, error) {
+	return d.diff(data, false)
+}
+
+// ValidateServerSide validates the desired state using server-side apply, but
+// returns the client-side diff
+func (d *Dynamic) ValidateServerSide(data manifest.List) (*string, error) {
+	if _, err := d.diff(data, true); err != nil {
+		return nil, err
+	}
+	return d.diff(data, false)
+}
+
+// DiffExitCode returns true if applying data would change the cluster
+func (d *Dynamic) DiffExitCode(data manifest.List) (bool, error) {
+	s, err := d.diff(data, false)
+	if err != nil {
+		return false, err
+	}
+	return s != nil, nil
+}
+
+// diff compares the live objects to the result of a dry-run apply, the same
+// way `kubectl diff` does
+func (d *Dynamic) diff(data manifest.List, serverSide bool) (*string, error) {
+	opts := ApplyOpts{DryRun: "server", Validate: true}
+	if serverSide {
+		opts.ApplyStrategy = "server"
+		opts.Force = true
+	}
+
+	s := ""
+	for _, m := range data {
+		live, err := d.live(m)
+		if err != nil {
+			return nil, err
+		}
+		merged, _, err := d.apply(m, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "diffing %s", m.KindName())
+		}
+
+		is := ""
+		if live != nil {
+			is = diffable(live)
+		}
+
+		out, err := util.DiffStr(util.DiffName(m), is, diffable(merged))
+		if err != nil {
+			return nil, err
+		}
+		s += out
+	}
+
+	if s == "" {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+// diffable returns obj as yaml, without fields that are not useful in a diff
+func diffable(obj *unstructured.Unstructured) string {
+	obj = obj.DeepCopy()
+	unstructured.RemoveNestedField(obj.Object, "metadata", "managedFields")
+	return manifest.Manifest(obj.Object).String()
+}
+
+// runtimeCopy deep-copies m into types that unstructured can handle
+func runtimeCopy(m manifest.Manifest) map[string]interface{} {
+	data, err := json.Marshal(m)
+	if err != nil {
+		// manifests are always JSON compatible
+		panic(errors.Wrap(err, "copying manifest"))
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		panic(errors.Wrap(err, "copying manifest"))
+	}
+	return out
+}
+
+func dryRun(mode string) []string {
+	if mode == "server" {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+func dryRunSuffix(mode string) string {
+	switch mode {
+	case "server":
+		return " (server dry run)"
+	case "client":
+		return " (dry run)"
+	}
+	return ""
+}
+
+// kindString formats kind like kubectl does in its output, e.g. deployment.apps
+func kindString(kind string, gvr schema.GroupVersionResource) string {
+	s := strings.ToLower(kind)
+	if gvr.Group != "" {
+		s += "." + gvr.Group
+	}
+	return s
+}
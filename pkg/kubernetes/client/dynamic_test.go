@@ -0,0 +1,237 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+func newTestDynamic(t *testing.T, objs ...runtime.Object) (*Dynamic, *bytes.Buffer) {
+	t.Helper()
+
+	disco := &fakediscovery.FakeDiscovery{
+		Fake: &clienttesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: []string{"get", "list", "create", "delete"}, ShortNames: []string{"cm"}},
+						{Name: "namespaces", Kind: "Namespace", Namespaced: false, Verbs: []string{"get", "list"}},
+					},
+				},
+				{
+					GroupVersion: "apps/v1",
+					APIResources: []metav1.APIResource{
+						{Name: "deployments", Kind: "Deployment", Namespaced: true, Verbs: []string{"get", "list"}},
+						{Name: "deployments/status", Kind: "Deployment", Namespaced: true, Verbs: []string{"get"}},
+					},
+				},
+			},
+		},
+		FakedServerVersion: &version.Info{GitVersion: "v1.30.2"},
+	}
+
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Version: "v1", Resource: "configmaps"}:                 "ConfigMapList",
+		{Version: "v1", Resource: "namespaces"}:                 "NamespaceList",
+		{Group: "apps", Version: "v1", Resource: "deployments"}: "DeploymentList",
+	}, objs...)
+
+	d, err := newDynamic(Info{}, dyn, disco)
+	require.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	d.out = out
+	return d, out
+}
+
+func testObject(apiVersion, kind, namespace, name string, labels map[string]interface{}) *unstructured.Unstructured {
+	metadata := map[string]interface{}{"name": name}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	if labels != nil {
+		metadata["labels"] = labels
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata":   metadata,
+	}}
+}
+
+func TestDynamicInfo(t *testing.T) {
+	d, _ := newTestDynamic(t)
+	assert.Equal(t, "1.30.2", d.Info().ServerVersion.String())
+	assert.Nil(t, d.Info().ClientVersion)
+}
+
+func TestDynamicResources(t *testing.T) {
+	d, _ := newTestDynamic(t)
+
+	res, err := d.Resources()
+	require.NoError(t, err)
+
+	var fqns []string
+	for _, r := range res {
+		fqns = append(fqns, r.FQN())
+	}
+	assert.Equal(t, []string{"configmaps", "namespaces", "deployments.apps"}, fqns)
+	assert.True(t, res[0].Namespaced)
+	assert.Equal(t, "cm", res[0].Shortnames)
+	assert.Contains(t, res[0].Verbs, "list")
+}
+
+func TestDynamicGet(t *testing.T) {
+	d, _ := newTestDynamic(t, testObject("v1", "ConfigMap", "default", "foo", nil))
+
+	m, err := d.Get("", "ConfigMap", "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", m.Metadata().Name())
+
+	_, err = d.Get("default", "ConfigMap", "bar")
+	assert.IsType(t, ErrorNotFound{}, err)
+
+	_, err = d.Get("default", "Unknown", "bar")
+	assert.IsType(t, ErrorUnknownResource{}, err)
+}
+
+func TestDynamicGetByLabels(t *testing.T) {
+	d, _ := newTestDynamic(t,
+		testObject("v1", "ConfigMap", "a", "cm", map[string]interface{}{"env": "x"}),
+		testObject("v1", "ConfigMap", "b", "other", map[string]interface{}{"env": "y"}),
+		testObject("apps/v1", "Deployment", "b", "deploy", map[string]interface{}{"env": "x"}),
+	)
+
+	got, err := d.GetByLabels("", "configmaps,deployments.apps", map[string]string{"env": "x"})
+	require.NoError(t, err)
+
+	var names []string
+	for _, m := range got {
+		names = append(names, m.KindName())
+	}
+	assert.ElementsMatch(t, []string{"ConfigMap/cm", "Deployment/deploy"}, names)
+
+	got, err = d.GetByLabels("a", "configmaps,deployments.apps", map[string]string{"env": "x"})
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, "ConfigMap/cm", got[0].KindName())
+}
+
+func TestDynamicGetByState(t *testing.T) {
+	d, _ := newTestDynamic(t, testObject("v1", "ConfigMap", "default", "foo", nil))
+
+	state := manifest.List{
+		manifest.Manifest(testObject("v1", "ConfigMap", "default", "foo", nil).Object),
+		manifest.Manifest(testObject("v1", "ConfigMap", "default", "missing", nil).Object),
+	}
+
+	_, err := d.GetByState(state, GetByStateOpts{})
+	assert.IsType(t, ErrorNotFound{}, err)
+
+	got, err := d.GetByState(state, GetByStateOpts{IgnoreNotFound: true})
+	require.NoError(t, err)
+	assert.Len(t, got, 1)
+
+	_, err = d.GetByState(state[1:], GetByStateOpts{IgnoreNotFound: true})
+	assert.IsType(t, ErrorNothingReturned{}, err)
+}
+
+func TestDynamicApplyClientSide(t *testing.T) {
+	d, out := newTestDynamic(t)
+
+	cm := manifest.Manifest(testObject("v1", "ConfigMap", "default", "foo", nil).Object)
+	cm["data"] = map[string]interface{}{"key": "a"}
+
+	require.NoError(t, d.Apply(manifest.List{cm}, ApplyOpts{Validate: true}))
+	assert.Equal(t, "configmap/foo created\n", out.String())
+
+	live, err := d.Get("default", "ConfigMap", "foo")
+	require.NoError(t, err)
+	assert.Contains(t, live.Metadata().Annotations(), lastAppliedAnnotation)
+
+	out.Reset()
+	require.NoError(t, d.Apply(manifest.List{cm}, ApplyOpts{Validate: true}))
+	assert.Equal(t, "configmap/foo unchanged\n", out.String())
+
+	out.Reset()
+	cm["data"] = map[string]interface{}{"key": "b"}
+	require.NoError(t, d.Apply(manifest.List{cm}, ApplyOpts{Validate: true}))
+	assert.Equal(t, "configmap/foo configured\n", out.String())
+
+	live, err = d.Get("default", "ConfigMap", "foo")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"key": "b"}, live["data"])
+}
+
+func TestDynamicDelete(t *testing.T) {
+	d, out := newTestDynamic(t, testObject("apps/v1", "Deployment", "default", "foo", nil))
+
+	require.NoError(t, d.Delete("default", "apps/v1", "Deployment", "foo", DeleteOpts{}))
+	assert.Equal(t, "deployment.apps \"foo\" deleted\n", out.String())
+
+	_, err := d.Get("default", "Deployment", "foo")
+	assert.IsType(t, ErrorNotFound{}, err)
+
+	// deleting something that is already gone is not an error
+	assert.NoError(t, d.Delete("default", "apps/v1", "Deployment", "foo", DeleteOpts{}))
+}
+
+func TestDynamicNamespaces(t *testing.T) {
+	d, _ := newTestDynamic(t,
+		testObject("v1", "Namespace", "", "default", nil),
+		testObject("v1", "Namespace", "", "kube-system", nil),
+	)
+
+	namespaces, err := d.Namespaces()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"default": true, "kube-system": true}, namespaces)
+
+	ns, err := d.Namespace("kube-system")
+	require.NoError(t, err)
+	assert.Equal(t, "kube-system", ns.Metadata().Name())
+
+	_, err = d.Namespace("missing")
+	assert.Equal(t, ErrNamespaceNotFound{Namespace: "missing"}, err)
+}
+
+func TestDynamicContextDiscovery(t *testing.T) {
+	raw := &clientcmdapi.Config{
+		Clusters: map[string]*clientcmdapi.Cluster{
+			"dev":  {Server: "https://dev.example.com:6443"},
+			"prod": {Server: "https://prod.example.com:6443"},
+		},
+		Contexts: map[string]*clientcmdapi.Context{
+			"dev-admin":  {Cluster: "dev"},
+			"prod-admin": {Cluster: "prod"},
+			"prod-ro":    {Cluster: "prod"},
+		},
+	}
+
+	name, err := dynamicContextFromEndpoint(raw, "https://prod.example.com:6443")
+	require.NoError(t, err)
+	assert.Equal(t, "prod-admin", name)
+
+	_, err = dynamicContextFromEndpoint(raw, "https://missing.example.com")
+	assert.Equal(t, ErrorNoCluster("https://missing.example.com"), err)
+
+	name, err = dynamicContextFromName(raw, "prod-r.*")
+	require.NoError(t, err)
+	assert.Equal(t, "prod-ro", name)
+
+	_, err = dynamicContextFromName(raw, "staging")
+	assert.Equal(t, ErrorNoContext("staging"), err)
+}
@@ -54,6 +54,17 @@ func (k Kubectl) GetByState(data manifest.List, opts GetByStateOpts) (manifest.L
 	return unwrapList(list)
 }
 
+// Events returns the events the cluster recorded for the given object
+func (k Kubectl) Events(namespace, kind, name string) (manifest.List, error) {
+	selector := fmt.Sprintf("--field-selector=involvedObject.kind=%s,involvedObject.name=%s", kind, name)
+	list, err := k.get(namespace, "events", []string{selector}, getOpts{})
+	if err != nil {
+		return nil, err
+	}
+
+	return unwrapList(list)
+}
+
 type getOpts struct {
 	allNamespaces  bool
 	ignoreNotFound bool
@@ -4,64 +4,48 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/Masterminds/semver"
 	"github.com/pkg/errors"
 
 	"github.com/grafana/tanka/pkg/kubernetes/client"
 	"github.com/grafana/tanka/pkg/kubernetes/manifest"
 	"github.com/grafana/tanka/pkg/kubernetes/util"
+	"github.com/grafana/tanka/pkg/process"
 )
 
-// Diff takes the desired state and returns the differences from the cluster
+// Diff takes the desired state and returns the differences from the cluster.
+// Unless opts.ShowSecrets is set, Secrets are compared using
+// MaskedSecretDiffer regardless of the strategy, so their values are masked.
 func (k *Kubernetes) Diff(ctx context.Context, state manifest.List, opts DiffOpts) (*string, error) {
 	_, span := tracer.Start(ctx, "kubernetes.Diff")
 	span.End()
-	// prevent https://github.com/kubernetes/kubernetes/issues/89762 until fixed
-	if k.ctl.Info().ClientVersion.Equal(semver.MustParse("1.18.0")) {
-		return nil, fmt.Errorf(`you seem to be using kubectl 1.18.0, which contains an unfixed issue
-that makes 'kubectl diff' modify resources in your cluster.
-Please upgrade kubectl to at least version 1.18.1`)
-	}
 
-	// required for separating
-	namespaces, err := k.ctl.Namespaces()
+	// hooks that are never applied can't be compared either
+	state = process.WithoutSkippedHooks(state)
+
+	live, soon, err := k.separate(state)
 	if err != nil {
-		resourceNamespaces := state.Namespaces()
-		namespaces = map[string]bool{}
-		for _, namespace := range resourceNamespaces {
-			_, err = k.ctl.Namespace(namespace)
-			if err != nil {
-				if errors.As(err, &client.ErrNamespaceNotFound{}) {
-					continue
-				}
-				return nil, errors.Wrap(err, "retrieving namespaces")
-			}
-			namespaces[namespace] = true
-		}
+		return nil, err
 	}
-	resources, err := k.ctl.Resources()
+
+	// hide fields excluded by spec.diffIgnore
+	live, err = k.ignoreDifferences(live)
 	if err != nil {
-		return nil, errors.Wrap(err, "listing known api-resources")
+		return nil, err
 	}
 
-	// separate resources in groups
-	//
-	// soon: resources that have unmet dependencies that will be met during
-	// apply. These will be diffed statically, because checking with the cluster
-	// would cause an error
-	//
-	// live: all other resources
-	live, soon := separate(state, k.Env.Spec.Namespace, separateOpts{
-		namespaces: namespaces,
-		resources:  resources,
-	})
-
 	// differ for live resources
 	liveDiff, err := k.differ(opts.Strategy)
 	if err != nil {
 		return nil, err
 	}
 
+	// Secrets are compared in-process, so that their values can be masked
+	var liveSecrets manifest.List
+	if !opts.ShowSecrets {
+		live, liveSecrets = splitSecrets(live)
+		soon = MaskSecrets(soon)
+	}
+
 	// reports all resources as created
 	staticDiffAllCreated := StaticDiffer(true)
 
@@ -76,11 +60,15 @@ Please upgrade kubectl to at least version 1.18.1`)
 		if err != nil {
 			return nil, err
 		}
+		if !opts.ShowSecrets {
+			orphaned = MaskSecrets(orphaned)
+		}
 	}
 
 	// run the diff
 	d, err := multiDiff{
 		{differ: liveDiff, state: live},
+		{differ: MaskedSecretDiffer(k.ctl), state: liveSecrets},
 		{differ: staticDiffAllCreated, state: soon},
 		{differ: staticDiffAllDeleted, state: orphaned},
 	}.diff()
@@ -103,9 +91,54 @@ Please upgrade kubectl to at least version 1.18.1`)
 // HasChanges performs a lightweight check to determine if there are any changes
 // between the desired state and cluster using kubectl diff --exit-code (no output)
 func (k *Kubernetes) HasChanges(state manifest.List) (bool, error) {
+	// hooks that are never applied would always be reported as changed
+	state = process.WithoutSkippedHooks(state)
+
+	state, err := k.ignoreDifferences(state)
+	if err != nil {
+		return false, err
+	}
 	return k.ctl.DiffExitCode(state)
 }
 
+// separate splits state into resources that can be diffed against the cluster
+// (live) and those that depend on namespaces created during apply (soon)
+func (k *Kubernetes) separate(state manifest.List) (live manifest.List, soon manifest.List, err error) {
+	// required for separating
+	namespaces, err := k.ctl.Namespaces()
+	if err != nil {
+		resourceNamespaces := state.Namespaces()
+		namespaces = map[string]bool{}
+		for _, namespace := range resourceNamespaces {
+			_, err = k.ctl.Namespace(namespace)
+			if err != nil {
+				if errors.As(err, &client.ErrNamespaceNotFound{}) {
+					continue
+				}
+				return nil, nil, errors.Wrap(err, "retrieving namespaces")
+			}
+			namespaces[namespace] = true
+		}
+	}
+	resources, err := k.ctl.Resources()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "listing known api-resources")
+	}
+
+	// separate resources in groups
+	//
+	// soon: resources that have unmet dependencies that will be met during
+	// apply. These will be diffed statically, because checking with the cluster
+	// would cause an error
+	//
+	// live: all other resources
+	live, soon = separate(state, k.Env.Spec.Namespace, separateOpts{
+		namespaces: namespaces,
+		resources:  resources,
+	})
+	return live, soon, nil
+}
+
 type separateOpts struct {
 	namespaces map[string]bool
 	resources  client.Resources
@@ -161,11 +194,16 @@ func (e ErrorDiffStrategyUnknown) Error() string {
 	return fmt.Sprintf("diff strategy `%s` does not exist. Pick one of: %v", e.Requested, strats)
 }
 
-func (k *Kubernetes) differ(override string) (Differ, error) {
-	strategy := k.Env.Spec.DiffStrategy
+// strategy returns the diff strategy to use, preferring override if set
+func (k *Kubernetes) strategy(override string) string {
 	if override != "" {
-		strategy = override
+		return override
 	}
+	return k.Env.Spec.DiffStrategy
+}
+
+func (k *Kubernetes) differ(override string) (Differ, error) {
+	strategy := k.strategy(override)
 
 	d, ok := k.differs[strategy]
 	if !ok {
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/grafana/tanka/pkg/kubernetes/client"
 	"github.com/grafana/tanka/pkg/kubernetes/manifest"
@@ -145,3 +146,31 @@ func m(apiVersion, kind, name, namespace string) manifest.Manifest {
 		},
 	}
 }
+
+// exitCodeClient records the state passed to DiffExitCode
+type exitCodeClient struct {
+	fakeClient
+	diffed manifest.List
+}
+
+func (e *exitCodeClient) DiffExitCode(data manifest.List) (bool, error) {
+	e.diffed = data
+	return len(data) > 0, nil
+}
+
+func TestHasChangesSkipsHooks(t *testing.T) {
+	c := &exitCodeClient{}
+	k := &Kubernetes{Env: testEnv(), ctl: c}
+
+	test := m("v1", "Pod", "test", "default")
+	test.Metadata()["annotations"] = map[string]interface{}{"helm.sh/hook": "test"}
+	changed, err := k.HasChanges(manifest.List{test})
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	cm := m("v1", "ConfigMap", "cfg", "default")
+	changed, err = k.HasChanges(manifest.List{test, cm})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, manifest.List{cm}, c.diffed)
+}
@@ -0,0 +1,539 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/spec/v1alpha1"
+)
+
+// ErrorInvalidFieldPath occurs when a spec.diffIgnore expression cannot be
+// parsed
+type ErrorInvalidFieldPath struct {
+	Expr   string
+	Reason string
+}
+
+func (e ErrorInvalidFieldPath) Error() string {
+	return fmt.Sprintf("invalid field path `%s`: %s", e.Expr, e.Reason)
+}
+
+// ignoreRule is a compiled spec.diffIgnore entry
+type ignoreRule struct {
+	v1alpha1.DiffIgnore
+	paths []fieldPath
+}
+
+func compileIgnoreRules(rules []v1alpha1.DiffIgnore) ([]ignoreRule, error) {
+	compiled := make([]ignoreRule, 0, len(rules))
+	for i, r := range rules {
+		rule := ignoreRule{DiffIgnore: r}
+		for _, p := range r.JSONPointers {
+			fp, err := ParseJSONPointer(p)
+			if err != nil {
+				return nil, errors.Wrapf(err, "spec.diffIgnore[%d]", i)
+			}
+			rule.paths = append(rule.paths, fp)
+		}
+		for _, p := range r.JSONPaths {
+			fp, err := ParseJSONPath(p)
+			if err != nil {
+				return nil, errors.Wrapf(err, "spec.diffIgnore[%d]", i)
+			}
+			rule.paths = append(rule.paths, fp)
+		}
+		compiled = append(compiled, rule)
+	}
+	return compiled, nil
+}
+
+// matches returns whether the rule selects m
+func (r ignoreRule) matches(m manifest.Manifest) bool {
+	group := ""
+	if parts := strings.SplitN(m.APIVersion(), "/", 2); len(parts) == 2 {
+		group = parts[0]
+	}
+
+	switch {
+	case r.Group != "" && r.Group != group:
+		return false
+	case r.Kind != "" && r.Kind != m.Kind():
+		return false
+	case !matchPattern(r.Name, m.Metadata().Name()):
+		return false
+	case !matchPattern(r.Namespace, m.Metadata().Namespace()):
+		return false
+	}
+	return true
+}
+
+func matchPattern(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, err := path.Match(pattern, s)
+	return err == nil && ok
+}
+
+// ignoreDifferences applies spec.diffIgnore to state: Ignored fields are set
+// to their live values, or removed if not set in the cluster. Diffing the
+// result does not report differences in these fields, regardless of the
+// strategy. state itself is not modified.
+func (k *Kubernetes) ignoreDifferences(state manifest.List) (manifest.List, error) {
+	if len(k.Env.Spec.DiffIgnore) == 0 {
+		return state, nil
+	}
+
+	rules, err := compileIgnoreRules(k.Env.Spec.DiffIgnore)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected manifest.List
+	for _, m := range state {
+		for _, r := range rules {
+			if r.matches(m) {
+				selected = append(selected, m)
+				break
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return state, nil
+	}
+
+	live, err := k.ctl.GetByState(selected, client.GetByStateOpts{IgnoreNotFound: true})
+	if _, ok := err.(client.ErrorNothingReturned); ok {
+		live = nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "getting state from cluster")
+	}
+	index := indexObjects(live)
+
+	out := make(manifest.List, 0, len(state))
+	for _, m := range state {
+		l, ok := lookupObject(index, m)
+		if !ok {
+			out = append(out, m)
+			continue
+		}
+		out = append(out, ignoreFields(l, m, rules))
+	}
+	return out, nil
+}
+
+// ignoreFields returns a copy of desired, with the fields selected by the
+// matching rules taken from live
+func ignoreFields(live, desired manifest.Manifest, rules []ignoreRule) manifest.Manifest {
+	l := normalize(map[string]interface{}(live))
+	var d interface{} = normalize(map[string]interface{}(desired))
+
+	for _, r := range rules {
+		if !r.matches(desired) {
+			continue
+		}
+		for _, p := range r.paths {
+			d = ignoreAt(l, d, p)
+		}
+	}
+
+	m, _ := d.(map[string]interface{})
+	return manifest.Manifest(m)
+}
+
+// absent marks a value that does not exist
+type absent struct{}
+
+// ignoreAt returns desired, with the values selected by p replaced by those
+// of live. Selected values missing from live are removed (absent is returned
+// if desired itself is to be removed). Elements of list-maps are paired by
+// their key, so that e.g. injected containers can be ignored.
+func ignoreAt(live, desired interface{}, p fieldPath) interface{} {
+	if len(p) == 0 {
+		return live
+	}
+	seg, rest := p[0], p[1:]
+	_, desiredAbsent := desired.(absent)
+
+	if lm, dm, ok := asMaps(live, desired); ok && seg.kind != segFilter {
+		var keys []string
+		switch seg.kind {
+		case segField:
+			keys = []string{seg.field}
+		case segWildcard:
+			keys = unionKeys(lm, dm)
+		default:
+			return desired
+		}
+
+		out := make(map[string]interface{}, len(dm))
+		for k, v := range dm {
+			out[k] = v
+		}
+		for _, k := range keys {
+			nv := ignoreAt(lookup(lm, k), lookup(dm, k), rest)
+			if _, ok := nv.(absent); ok {
+				delete(out, k)
+				continue
+			}
+			out[k] = nv
+		}
+
+		if desiredAbsent && len(out) == 0 {
+			return absent{}
+		}
+		return out
+	}
+
+	ll, dl, ok := asLists(live, desired)
+	if !ok {
+		return desired
+	}
+
+	// elements of the result, with the live element each one is paired with
+	type entry struct {
+		value   interface{}
+		live    interface{}
+		process bool
+	}
+	entries := make([]entry, 0, len(dl))
+	for _, v := range dl {
+		entries = append(entries, entry{value: v, live: absent{}})
+	}
+
+	switch seg.kind {
+	case segField, segIndex:
+		i := seg.index
+		if seg.kind == segField {
+			n, err := strconv.Atoi(seg.field)
+			if err != nil {
+				return desired
+			}
+			i = n
+		}
+
+		lv := interface{}(absent{})
+		if i >= 0 && i < len(ll) {
+			lv = ll[i]
+		}
+		switch {
+		case i >= 0 && i < len(entries):
+			entries[i].live, entries[i].process = lv, true
+		case i == len(entries):
+			entries = append(entries, entry{value: absent{}, live: lv, process: true})
+		}
+	case segWildcard, segFilter:
+		var liveSel, desiredSel []int
+		for i, v := range ll {
+			if seg.kind == segWildcard || seg.filter.matches(v) {
+				liveSel = append(liveSel, i)
+			}
+		}
+		for i, v := range dl {
+			if seg.kind == segWildcard || seg.filter.matches(v) {
+				desiredSel = append(desiredSel, i)
+			}
+		}
+
+		pairs := pairElements(ll, liveSel, dl, desiredSel)
+		for _, di := range desiredSel {
+			entries[di].process = true
+			if li, ok := pairs[di]; ok {
+				entries[di].live = ll[li]
+			}
+		}
+
+		// live elements without a counterpart are inserted at their live
+		// position, so positional differs line them up
+		paired := make(map[int]bool, len(pairs))
+		for _, li := range pairs {
+			paired[li] = true
+		}
+		for _, li := range liveSel {
+			if paired[li] {
+				continue
+			}
+			e := entry{value: absent{}, live: ll[li], process: true}
+			pos := li
+			if pos > len(entries) {
+				pos = len(entries)
+			}
+			entries = append(entries[:pos], append([]entry{e}, entries[pos:]...)...)
+		}
+	}
+
+	out := make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		v := e.value
+		if e.process {
+			v = ignoreAt(e.live, e.value, rest)
+		}
+		if _, ok := v.(absent); ok {
+			continue
+		}
+		out = append(out, v)
+	}
+
+	if desiredAbsent && len(out) == 0 {
+		return absent{}
+	}
+	return out
+}
+
+// pairElements pairs the selected elements of live and desired, by their
+// list-map key if possible, or by order otherwise. The returned map points
+// from desired to live indexes
+func pairElements(live []interface{}, liveSel []int, desired []interface{}, desiredSel []int) map[int]int {
+	pick := func(list []interface{}, sel []int) []interface{} {
+		out := make([]interface{}, 0, len(sel))
+		for _, i := range sel {
+			out = append(out, list[i])
+		}
+		return out
+	}
+
+	pairs := make(map[int]int)
+	keys := listMapKey(pick(live, liveSel), pick(desired, desiredSel))
+	if keys == nil {
+		for n := 0; n < len(liveSel) && n < len(desiredSel); n++ {
+			pairs[desiredSel[n]] = liveSel[n]
+		}
+		return pairs
+	}
+
+	byKey := make(map[string]int, len(liveSel))
+	for _, li := range liveSel {
+		byKey[elementKey(live[li], keys)] = li
+	}
+	for _, di := range desiredSel {
+		if li, ok := byKey[elementKey(desired[di], keys)]; ok {
+			pairs[di] = li
+		}
+	}
+	return pairs
+}
+
+// asMaps returns live and desired as maps, if both are maps or absent (but not
+// both absent)
+func asMaps(live, desired interface{}) (map[string]interface{}, map[string]interface{}, bool) {
+	lm, lok := live.(map[string]interface{})
+	dm, dok := desired.(map[string]interface{})
+	_, lAbsent := live.(absent)
+	_, dAbsent := desired.(absent)
+	return lm, dm, (lok || dok) && (lok || lAbsent) && (dok || dAbsent)
+}
+
+// asLists is like asMaps, but for lists
+func asLists(live, desired interface{}) ([]interface{}, []interface{}, bool) {
+	ll, lok := live.([]interface{})
+	dl, dok := desired.([]interface{})
+	_, lAbsent := live.(absent)
+	_, dAbsent := desired.(absent)
+	return ll, dl, (lok || dok) && (lok || lAbsent) && (dok || dAbsent)
+}
+
+func lookup(m map[string]interface{}, key string) interface{} {
+	v, ok := m[key]
+	if !ok {
+		return absent{}
+	}
+	return v
+}
+
+func unionKeys(a, b map[string]interface{}) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+type segmentKind int
+
+const (
+	// segField selects a map key. On lists, numeric fields select an index
+	segField segmentKind = iota
+	// segIndex selects a list element
+	segIndex
+	// segWildcard selects all map values or list elements
+	segWildcard
+	// segFilter selects the list elements matching a filter
+	segFilter
+)
+
+type pathSegment struct {
+	kind   segmentKind
+	field  string
+	index  int
+	filter *pathFilter
+}
+
+// fieldPath selects fields of an object
+type fieldPath []pathSegment
+
+// pathFilter is a JSONPath filter expression, e.g. `?(@.name=="app")`
+type pathFilter struct {
+	path  []string
+	equal bool
+	value interface{}
+}
+
+func (f pathFilter) matches(v interface{}) bool {
+	for _, k := range f.path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return !f.equal
+		}
+		if v, ok = m[k]; !ok {
+			return !f.equal
+		}
+	}
+	return reflect.DeepEqual(normalize(v), f.value) == f.equal
+}
+
+// ParseJSONPointer parses a JSON pointer (RFC 6901), e.g. `/spec/replicas`
+func ParseJSONPointer(s string) (fieldPath, error) {
+	if !strings.HasPrefix(s, "/") {
+		return nil, ErrorInvalidFieldPath{Expr: s, Reason: "JSON pointers must start with `/`"}
+	}
+
+	var fp fieldPath
+	for _, token := range strings.Split(s[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		fp = append(fp, pathSegment{kind: segField, field: token})
+	}
+	return fp, nil
+}
+
+// ParseJSONPath parses the subset of JSONPath supported by kubectl that
+// selects fields: `.field`, `['field']`, `[0]`, `[*]` and filters like
+// `[?(@.name=="app")]`. The expression may be wrapped in `{}` and start with
+// `$`.
+func ParseJSONPath(s string) (fieldPath, error) {
+	invalid := func(reason string) error {
+		return ErrorInvalidFieldPath{Expr: s, Reason: reason}
+	}
+
+	expr := strings.TrimSpace(s)
+	if strings.HasPrefix(expr, "{") && strings.HasSuffix(expr, "}") {
+		expr = expr[1 : len(expr)-1]
+	}
+	expr = strings.TrimPrefix(expr, "$")
+
+	var fp fieldPath
+	for expr != "" {
+		switch expr[0] {
+		case '.':
+			expr = expr[1:]
+			if strings.HasPrefix(expr, ".") {
+				return nil, invalid("recursive descent (`..`) is not supported")
+			}
+			if strings.HasPrefix(expr, "*") {
+				fp = append(fp, pathSegment{kind: segWildcard})
+				expr = expr[1:]
+				continue
+			}
+			end := strings.IndexAny(expr, ".[")
+			if end == -1 {
+				end = len(expr)
+			}
+			if end == 0 {
+				return nil, invalid("empty field name")
+			}
+			fp = append(fp, pathSegment{kind: segField, field: expr[:end]})
+			expr = expr[end:]
+		case '[':
+			seg, n, err := parseBracket(expr)
+			if err != nil {
+				return nil, invalid(err.Error())
+			}
+			fp = append(fp, seg)
+			expr = expr[n:]
+		default:
+			return nil, invalid(fmt.Sprintf("unexpected `%c`", expr[0]))
+		}
+	}
+
+	if len(fp) == 0 {
+		return nil, invalid("selects the whole object")
+	}
+	return fp, nil
+}
+
+// parseBracket parses a bracket expression at the start of expr and returns
+// the number of bytes consumed
+func parseBracket(expr string) (pathSegment, int, error) {
+	switch {
+	case strings.HasPrefix(expr, "['") || strings.HasPrefix(expr, `["`):
+		quote := expr[1]
+		end := strings.IndexByte(expr[2:], quote)
+		if end == -1 || !strings.HasPrefix(expr[2+end+1:], "]") {
+			return pathSegment{}, 0, errors.New("unterminated quoted field")
+		}
+		return pathSegment{kind: segField, field: expr[2 : 2+end]}, 2 + end + 2, nil
+	case strings.HasPrefix(expr, "[*]"):
+		return pathSegment{kind: segWildcard}, 3, nil
+	case strings.HasPrefix(expr, "[?("):
+		end := strings.Index(expr, ")]")
+		if end == -1 {
+			return pathSegment{}, 0, errors.New("unterminated filter")
+		}
+		f, err := parseFilter(expr[3:end])
+		if err != nil {
+			return pathSegment{}, 0, err
+		}
+		return pathSegment{kind: segFilter, filter: f}, end + 2, nil
+	}
+
+	end := strings.IndexByte(expr, ']')
+	if end == -1 {
+		return pathSegment{}, 0, errors.New("unterminated `[`")
+	}
+	i, err := strconv.Atoi(expr[1:end])
+	if err != nil || i < 0 {
+		return pathSegment{}, 0, fmt.Errorf("unsupported subscript `%s`", expr[:end+1])
+	}
+	return pathSegment{kind: segIndex, index: i}, end + 1, nil
+}
+
+// parseFilter parses `@.field=="value"` and `@.field!=value`
+func parseFilter(s string) (*pathFilter, error) {
+	f := pathFilter{equal: true}
+
+	op := "=="
+	i := strings.Index(s, op)
+	if j := strings.Index(s, "!="); j != -1 && (i == -1 || j < i) {
+		op, i, f.equal = "!=", j, false
+	}
+	if i == -1 {
+		return nil, fmt.Errorf("filter `%s` must compare using == or !=", s)
+	}
+
+	left, right := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+len(op):])
+	if !strings.HasPrefix(left, "@.") {
+		return nil, fmt.Errorf("filter `%s` must start with `@.`", s)
+	}
+	f.path = strings.Split(strings.TrimPrefix(left, "@."), ".")
+
+	if len(right) >= 2 && right[0] == '\'' && right[len(right)-1] == '\'' {
+		f.value = right[1 : len(right)-1]
+		return &f, nil
+	}
+	if err := json.Unmarshal([]byte(right), &f.value); err != nil {
+		return nil, fmt.Errorf("filter `%s` has an invalid value: %s", s, right)
+	}
+	return &f, nil
+}
@@ -0,0 +1,262 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/spec/v1alpha1"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	cases := []struct {
+		expr     string
+		expected fieldPath
+		err      bool
+	}{
+		{
+			expr: ".spec.replicas",
+			expected: fieldPath{
+				{kind: segField, field: "spec"},
+				{kind: segField, field: "replicas"},
+			},
+		},
+		{
+			expr: "{$.metadata.annotations['app.kubernetes.io/name']}",
+			expected: fieldPath{
+				{kind: segField, field: "metadata"},
+				{kind: segField, field: "annotations"},
+				{kind: segField, field: "app.kubernetes.io/name"},
+			},
+		},
+		{
+			expr: `.webhooks[*].clientConfig.caBundle`,
+			expected: fieldPath{
+				{kind: segField, field: "webhooks"},
+				{kind: segWildcard},
+				{kind: segField, field: "clientConfig"},
+				{kind: segField, field: "caBundle"},
+			},
+		},
+		{
+			expr: `.spec.containers[?(@.name == "istio-proxy")].image`,
+			expected: fieldPath{
+				{kind: segField, field: "spec"},
+				{kind: segField, field: "containers"},
+				{kind: segFilter, filter: &pathFilter{path: []string{"name"}, equal: true, value: "istio-proxy"}},
+				{kind: segField, field: "image"},
+			},
+		},
+		{
+			expr: `.ports[?(@.port!=80)][0]`,
+			expected: fieldPath{
+				{kind: segField, field: "ports"},
+				{kind: segFilter, filter: &pathFilter{path: []string{"port"}, equal: false, value: float64(80)}},
+				{kind: segIndex, index: 0},
+			},
+		},
+		{expr: "$", err: true},
+		{expr: "..spec", err: true},
+		{expr: ".spec[", err: true},
+		{expr: ".spec[-1]", err: true},
+		{expr: `.a[?(@.name)]`, err: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expr, func(t *testing.T) {
+			got, err := ParseJSONPath(c.expr)
+			if c.err {
+				assert.IsType(t, ErrorInvalidFieldPath{}, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.expected, got)
+		})
+	}
+}
+
+func TestParseJSONPointer(t *testing.T) {
+	got, err := ParseJSONPointer("/metadata/annotations/app.kubernetes.io~1name/0/a~0b")
+	require.NoError(t, err)
+	assert.Equal(t, fieldPath{
+		{kind: segField, field: "metadata"},
+		{kind: segField, field: "annotations"},
+		{kind: segField, field: "app.kubernetes.io/name"},
+		{kind: segField, field: "0"},
+		{kind: segField, field: "a~b"},
+	}, got)
+
+	_, err = ParseJSONPointer("spec")
+	assert.IsType(t, ErrorInvalidFieldPath{}, err)
+}
+
+func TestIgnoreFields(t *testing.T) {
+	deploy := func(spec map[string]interface{}) manifest.Manifest {
+		m := m("apps/v1", "Deployment", "app", "default")
+		m["spec"] = spec
+		return m
+	}
+	container := func(name, image string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "image": image}
+	}
+
+	tests := []struct {
+		name     string
+		rule     v1alpha1.DiffIgnore
+		live     manifest.Manifest
+		desired  manifest.Manifest
+		expected manifest.Manifest
+	}{
+		{
+			name:     "pointer/replaced",
+			rule:     v1alpha1.DiffIgnore{JSONPointers: []string{"/spec/replicas"}},
+			live:     deploy(map[string]interface{}{"replicas": 5}),
+			desired:  deploy(map[string]interface{}{"replicas": 1}),
+			expected: deploy(map[string]interface{}{"replicas": 5}),
+		},
+		{
+			name:     "pointer/removed",
+			rule:     v1alpha1.DiffIgnore{JSONPointers: []string{"/spec/replicas"}},
+			live:     deploy(map[string]interface{}{}),
+			desired:  deploy(map[string]interface{}{"replicas": 1}),
+			expected: deploy(map[string]interface{}{}),
+		},
+		{
+			name:     "pointer/created",
+			rule:     v1alpha1.DiffIgnore{JSONPointers: []string{"/spec/strategy/type"}},
+			live:     deploy(map[string]interface{}{"strategy": map[string]interface{}{"type": "Recreate"}}),
+			desired:  deploy(map[string]interface{}{}),
+			expected: deploy(map[string]interface{}{"strategy": map[string]interface{}{"type": "Recreate"}}),
+		},
+		{
+			name:     "pointer/index",
+			rule:     v1alpha1.DiffIgnore{JSONPointers: []string{"/spec/containers/0/image"}},
+			live:     deploy(map[string]interface{}{"containers": []interface{}{container("app", "app:2")}}),
+			desired:  deploy(map[string]interface{}{"containers": []interface{}{container("app", "app:1")}}),
+			expected: deploy(map[string]interface{}{"containers": []interface{}{container("app", "app:2")}}),
+		},
+		{
+			name: "jsonpath/injected-sidecar",
+			rule: v1alpha1.DiffIgnore{JSONPaths: []string{`.spec.containers[?(@.name=="istio-proxy")]`}},
+			live: deploy(map[string]interface{}{"containers": []interface{}{
+				container("istio-proxy", "istio:1"), container("app", "app:1"),
+			}}),
+			desired: deploy(map[string]interface{}{"containers": []interface{}{container("app", "app:2")}}),
+			expected: deploy(map[string]interface{}{"containers": []interface{}{
+				container("istio-proxy", "istio:1"), container("app", "app:2"),
+			}}),
+		},
+		{
+			name: "jsonpath/wildcard-list-map",
+			rule: v1alpha1.DiffIgnore{JSONPaths: []string{`.spec.containers[*].image`}},
+			live: deploy(map[string]interface{}{"containers": []interface{}{
+				container("b", "b:live"), container("a", "a:live"),
+			}}),
+			desired: deploy(map[string]interface{}{"containers": []interface{}{
+				container("a", "a:1"), container("b", "b:1"),
+			}}),
+			expected: deploy(map[string]interface{}{"containers": []interface{}{
+				container("a", "a:live"), container("b", "b:live"),
+			}}),
+		},
+		{
+			name: "jsonpath/wildcard-map",
+			rule: v1alpha1.DiffIgnore{JSONPaths: []string{`.spec.selector.*`}},
+			live: deploy(map[string]interface{}{"selector": map[string]interface{}{"a": "1", "b": "2"}}),
+			desired: deploy(map[string]interface{}{
+				"selector": map[string]interface{}{"a": "x", "c": "3"},
+			}),
+			expected: deploy(map[string]interface{}{"selector": map[string]interface{}{"a": "1", "b": "2"}}),
+		},
+		{
+			name:     "selector/kind-mismatch",
+			rule:     v1alpha1.DiffIgnore{Kind: "StatefulSet", JSONPointers: []string{"/spec/replicas"}},
+			live:     deploy(map[string]interface{}{"replicas": 5}),
+			desired:  deploy(map[string]interface{}{"replicas": 1}),
+			expected: deploy(map[string]interface{}{"replicas": 1}),
+		},
+		{
+			name:     "selector/match",
+			rule:     v1alpha1.DiffIgnore{Group: "apps", Kind: "Deployment", Name: "a*", Namespace: "default", JSONPointers: []string{"/spec/replicas"}},
+			live:     deploy(map[string]interface{}{"replicas": 5}),
+			desired:  deploy(map[string]interface{}{"replicas": 1}),
+			expected: deploy(map[string]interface{}{"replicas": 5}),
+		},
+		{
+			name:     "selector/group-mismatch",
+			rule:     v1alpha1.DiffIgnore{Group: "extensions", JSONPointers: []string{"/spec/replicas"}},
+			live:     deploy(map[string]interface{}{"replicas": 5}),
+			desired:  deploy(map[string]interface{}{"replicas": 1}),
+			expected: deploy(map[string]interface{}{"replicas": 1}),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := compileIgnoreRules([]v1alpha1.DiffIgnore{tc.rule})
+			require.NoError(t, err)
+
+			desired := manifest.Manifest(normalize(map[string]interface{}(tc.desired)).(map[string]interface{}))
+			got := ignoreFields(tc.live, tc.desired, rules)
+			assert.Equal(t, normalize(map[string]interface{}(tc.expected)), map[string]interface{}(got))
+
+			// the input is not modified
+			assert.Equal(t, desired, manifest.Manifest(normalize(map[string]interface{}(tc.desired)).(map[string]interface{})))
+		})
+	}
+}
+
+func TestDiffIgnore(t *testing.T) {
+	withReplicas := func(m manifest.Manifest, replicas int) manifest.Manifest {
+		m["spec"] = map[string]interface{}{"replicas": replicas}
+		return m
+	}
+
+	c := &structuredClient{
+		fakeClient: fakeClient{resources: client.Resources{{Kind: "Deployment", Namespaced: true}}},
+		live: manifest.List{
+			withReplicas(m("apps/v1", "Deployment", "hpa", "default"), 5),
+		},
+	}
+
+	var diffed manifest.List
+	k := &Kubernetes{
+		Env: testEnv(),
+		ctl: c,
+		differs: map[string]Differ{"native": func(state manifest.List) (*string, error) {
+			diffed = state
+			return nil, nil
+		}},
+	}
+	k.Env.Spec.DiffStrategy = "native"
+	k.Env.Spec.DiffIgnore = []v1alpha1.DiffIgnore{
+		{Kind: "Deployment", Name: "hpa", JSONPointers: []string{"/spec/replicas"}},
+	}
+
+	state := manifest.List{
+		withReplicas(m("apps/v1", "Deployment", "hpa", "default"), 1),
+		withReplicas(m("apps/v1", "Deployment", "other", "default"), 1),
+	}
+
+	_, err := k.Diff(context.Background(), state, DiffOpts{})
+	require.NoError(t, err)
+	require.Len(t, diffed, 2)
+	assert.Equal(t, float64(5), diffed[0]["spec"].(map[string]interface{})["replicas"])
+	assert.Equal(t, 1, diffed[1]["spec"].(map[string]interface{})["replicas"])
+
+	// the state to apply is left untouched
+	assert.Equal(t, 1, state[0]["spec"].(map[string]interface{})["replicas"])
+
+	k.differs["semantic"] = nil
+	result, err := k.StructuredDiff(context.Background(), state[:1], DiffOpts{Strategy: "semantic"})
+	require.NoError(t, err)
+	assert.False(t, result.HasChanges())
+
+	k.Env.Spec.DiffIgnore = []v1alpha1.DiffIgnore{{JSONPaths: []string{"..invalid"}}}
+	_, err = k.Diff(context.Background(), state, DiffOpts{})
+	assert.ErrorAs(t, err, &ErrorInvalidFieldPath{})
+}
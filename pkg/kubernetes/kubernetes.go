@@ -1,9 +1,12 @@
 package kubernetes
 
 import (
+	"fmt"
+
 	"github.com/Masterminds/semver"
 
 	"github.com/grafana/tanka/internal/telemetry"
+	"github.com/grafana/tanka/pkg/history"
 	"github.com/grafana/tanka/pkg/kubernetes/client"
 	"github.com/grafana/tanka/pkg/kubernetes/manifest"
 	"github.com/grafana/tanka/pkg/spec/v1alpha1"
@@ -15,7 +18,7 @@ var tracer = telemetry.Tracer("kubernetes")
 type Kubernetes struct {
 	Env v1alpha1.Environment
 
-	// Client (kubectl)
+	// Client (kubectl or client-go, see spec.kubernetesClient)
 	ctl client.Client
 
 	// Diffing
@@ -29,13 +32,7 @@ type Differ func(manifest.List) (*string, error)
 // New creates a new Kubernetes with an initialized client
 func New(env v1alpha1.Environment) (*Kubernetes, error) {
 	// setup client
-	var ctl *client.Kubectl
-	var err error
-	if len(env.Spec.ContextNames) < 1 {
-		ctl, err = client.New(env.Spec.APIServer)
-	} else {
-		ctl, err = client.NewFromNames(env.Spec.ContextNames)
-	}
+	ctl, err := newClient(env.Spec)
 	if err != nil {
 		return nil, err
 	}
@@ -61,12 +58,40 @@ func New(env v1alpha1.Environment) (*Kubernetes, error) {
 			"validate": ctl.ValidateServerSide,
 			"server":   ctl.DiffServerSide,
 			"subset":   SubsetDiffer(ctl),
+			"semantic": SemanticDiffer(ctl),
 		},
 	}
 
 	return &k, nil
 }
 
+// differClient is a client.Client that additionally supports the client-side
+// diff strategies. Both client implementations satisfy it.
+type differClient interface {
+	client.Client
+	DiffClientSide(data manifest.List) (*string, error)
+	ValidateServerSide(data manifest.List) (*string, error)
+}
+
+// newClient creates the client.Client implementation selected by
+// spec.kubernetesClient
+func newClient(spec v1alpha1.Spec) (differClient, error) {
+	switch spec.KubernetesClient {
+	case "", client.ImplementationKubectl:
+		if len(spec.ContextNames) < 1 {
+			return client.New(spec.APIServer)
+		}
+		return client.NewFromNames(spec.ContextNames)
+	case client.ImplementationGo:
+		if len(spec.ContextNames) < 1 {
+			return client.NewDynamic(spec.APIServer)
+		}
+		return client.NewDynamicFromNames(spec.ContextNames)
+	default:
+		return nil, fmt.Errorf("kubernetes client `%s` does not exist. Pick one of: [%s, %s]", spec.KubernetesClient, client.ImplementationKubectl, client.ImplementationGo)
+	}
+}
+
 // Close runs final cleanup
 func (k *Kubernetes) Close() error {
 	return k.ctl.Close()
@@ -81,9 +106,18 @@ type DiffOpts struct {
 
 	// Set the diff-strategy. If unset, the value set in the spec is used
 	Strategy string
+
+	// ShowSecrets prints the values of Secrets instead of masking them
+	ShowSecrets bool
 }
 
 // Info about the client, etc.
 func (k *Kubernetes) Info() client.Info {
 	return k.ctl.Info()
 }
+
+// History returns the history.Store configured in spec.history. Relative local
+// paths are resolved against root
+func (k *Kubernetes) History(root string) (*history.Store, error) {
+	return history.New(k.Env, root, k.ctl)
+}
@@ -0,0 +1,276 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+// phaseClient records the batches passed to Apply. Applied objects are
+// reported back as established by GetByState.
+type phaseClient struct {
+	fakeClient
+	applied [][]string
+	live    manifest.List
+}
+
+func (p *phaseClient) Apply(data manifest.List, opts client.ApplyOpts) error {
+	var names []string
+	for _, m := range data {
+		names = append(names, m.KindName())
+
+		live := manifest.Manifest{"kind": m.Kind(), "metadata": map[string]interface{}(m.Metadata())}
+		if m.Kind() == "CustomResourceDefinition" {
+			live["status"] = map[string]interface{}{"conditions": []interface{}{
+				map[string]interface{}{"type": "Established", "status": "True"},
+			}}
+		}
+		p.live = append(p.live, live)
+	}
+	p.applied = append(p.applied, names)
+	return nil
+}
+
+func (p *phaseClient) GetByState(data manifest.List, opts client.GetByStateOpts) (manifest.List, error) {
+	return p.live, nil
+}
+
+func phaseObj(kind, name, namespace string) manifest.Manifest {
+	metadata := map[string]interface{}{"name": name}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	return manifest.Manifest{"apiVersion": "v1", "kind": kind, "metadata": metadata}
+}
+
+func TestApplyPhases(t *testing.T) {
+	pc := &phaseClient{}
+	k := &Kubernetes{Env: testEnv(), ctl: pc}
+
+	state := manifest.List{
+		phaseObj("Namespace", "app", ""),
+		phaseObj("CustomResourceDefinition", "certs", ""),
+		phaseObj("ConfigMap", "cfg", "app"),
+		phaseObj("Certificate", "cert", "app"),
+	}
+
+	require.NoError(t, k.Apply(t.Context(), state, ApplyOpts{}))
+	assert.Equal(t, [][]string{
+		{"CustomResourceDefinition/certs"},
+		{"Namespace/app"},
+		{"ConfigMap/cfg", "Certificate/cert"},
+	}, pc.applied)
+}
+
+func TestApplyPhaseNotEstablished(t *testing.T) {
+	wc := &waitClient{states: []manifest.List{{}}}
+	k := &Kubernetes{Env: testEnv(), ctl: wc}
+
+	state := manifest.List{
+		phaseObj("CustomResourceDefinition", "certs", ""),
+		phaseObj("Certificate", "cert", "app"),
+	}
+
+	err := k.Apply(t.Context(), state, ApplyOpts{WaitPhases: true, PhaseTimeout: 1})
+	var waitErr ErrorWaitFailed
+	require.ErrorAs(t, err, &waitErr)
+	assert.Contains(t, err.Error(), "apply phase `crds`")
+}
+
+func TestApplyPhasesNoWait(t *testing.T) {
+	wc := &waitClient{states: []manifest.List{{}}}
+	k := &Kubernetes{Env: testEnv(), ctl: wc}
+
+	state := manifest.List{
+		phaseObj("ClusterRole", "reader", ""),
+		phaseObj("ConfigMap", "cfg", "app"),
+	}
+
+	// phases other than crds and namespaces are only awaited when asked to
+	require.NoError(t, k.Apply(t.Context(), state, ApplyOpts{}))
+	assert.Equal(t, 0, wc.calls)
+}
+
+func TestApplyPhaseCRDsAlwaysAwaited(t *testing.T) {
+	wc := &waitClient{states: []manifest.List{{}}}
+	k := &Kubernetes{Env: testEnv(), ctl: wc}
+
+	state := manifest.List{
+		phaseObj("CustomResourceDefinition", "certs", ""),
+		phaseObj("Certificate", "cert", "app"),
+	}
+
+	err := k.Apply(t.Context(), state, ApplyOpts{PhaseTimeout: 1})
+	var waitErr ErrorWaitFailed
+	require.ErrorAs(t, err, &waitErr)
+	assert.Contains(t, err.Error(), "apply phase `crds`")
+}
+
+func TestApplyPhaseCanceled(t *testing.T) {
+	wc := &waitClient{states: []manifest.List{{}}}
+	k := &Kubernetes{Env: testEnv(), ctl: wc}
+
+	state := manifest.List{
+		phaseObj("CustomResourceDefinition", "certs", ""),
+		phaseObj("Certificate", "cert", "app"),
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	err := k.Apply(ctx, state, ApplyOpts{WaitPhases: true})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestApplyPhasesDryRun(t *testing.T) {
+	wc := &waitClient{states: []manifest.List{{}}}
+	k := &Kubernetes{Env: testEnv(), ctl: wc}
+
+	state := manifest.List{
+		phaseObj("CustomResourceDefinition", "certs", ""),
+		phaseObj("Certificate", "cert", "app"),
+	}
+
+	require.NoError(t, k.Apply(t.Context(), state, ApplyOpts{ApplyOpts: client.ApplyOpts{DryRun: "server"}}))
+	assert.Equal(t, 0, wc.calls)
+}
+
+// hookClient records applies and deletes. Applied Jobs complete immediately,
+// unless listed in failing
+type hookClient struct {
+	phaseClient
+	failing map[string]bool
+	events  []string
+}
+
+func (h *hookClient) Apply(data manifest.List, opts client.ApplyOpts) error {
+	for _, m := range data {
+		h.events = append(h.events, "apply "+m.KindName())
+
+		live := manifest.Manifest{}
+		for k, v := range m {
+			live[k] = v
+		}
+		if m.Kind() == "Job" {
+			condition := "Complete"
+			if h.failing[m.Metadata().Name()] {
+				condition = "Failed"
+			}
+			live["status"] = map[string]interface{}{"conditions": []interface{}{
+				map[string]interface{}{"type": condition, "status": "True"},
+			}}
+		}
+		h.live = append(h.live, live)
+	}
+	return nil
+}
+
+func (h *hookClient) Delete(namespace, apiVersion, kind, name string, opts client.DeleteOpts) error {
+	h.events = append(h.events, "delete "+kind+"/"+name)
+
+	var live manifest.List
+	for _, m := range h.live {
+		if m.Kind() != kind || m.Metadata().Name() != name {
+			live = append(live, m)
+		}
+	}
+	h.live = live
+	return nil
+}
+
+func hookObj(kind, name string, annotations map[string]interface{}) manifest.Manifest {
+	m := phaseObj(kind, name, "app")
+	m.Metadata()["annotations"] = annotations
+	return m
+}
+
+func TestApplyPhasesDryRunNone(t *testing.T) {
+	wc := &waitClient{states: []manifest.List{{}}}
+	k := &Kubernetes{Env: testEnv(), ctl: wc}
+
+	state := manifest.List{
+		phaseObj("CustomResourceDefinition", "certs", ""),
+		phaseObj("Certificate", "cert", "app"),
+	}
+
+	// --dry-run=none applies for real, so phases are awaited
+	err := k.Apply(t.Context(), state, ApplyOpts{ApplyOpts: client.ApplyOpts{DryRun: "none"}, PhaseTimeout: 1})
+	var waitErr ErrorWaitFailed
+	require.ErrorAs(t, err, &waitErr)
+}
+
+func TestApplyHelmHooks(t *testing.T) {
+	hc := &hookClient{}
+	k := &Kubernetes{Env: testEnv(), ctl: hc}
+
+	state := manifest.List{
+		phaseObj("Namespace", "app", ""),
+		phaseObj("ConfigMap", "cfg", "app"),
+		hookObj("Job", "migrate", map[string]interface{}{"helm.sh/hook": "pre-install,pre-upgrade"}),
+		hookObj("Job", "notify", map[string]interface{}{
+			"helm.sh/hook":               "post-install,post-upgrade",
+			"helm.sh/hook-delete-policy": "hook-succeeded",
+		}),
+		hookObj("Pod", "test", map[string]interface{}{"helm.sh/hook": "test"}),
+	}
+
+	require.NoError(t, k.Apply(t.Context(), state, ApplyOpts{}))
+	assert.Equal(t, []string{
+		"apply Namespace/app",
+		"apply Job/migrate",
+		"apply ConfigMap/cfg",
+		"apply Job/notify",
+		"delete Job/notify",
+	}, hc.events)
+
+	// hooks are re-created on every apply, like Helm does
+	hc.events = nil
+	require.NoError(t, k.Apply(t.Context(), state, ApplyOpts{}))
+	assert.Equal(t, []string{
+		"apply Namespace/app",
+		"delete Job/migrate",
+		"apply Job/migrate",
+		"apply ConfigMap/cfg",
+		"apply Job/notify",
+		"delete Job/notify",
+	}, hc.events)
+}
+
+func TestApplyHelmHookFailed(t *testing.T) {
+	hc := &hookClient{failing: map[string]bool{"migrate": true}}
+	k := &Kubernetes{Env: testEnv(), ctl: hc}
+
+	state := manifest.List{
+		phaseObj("ConfigMap", "cfg", "app"),
+		hookObj("Job", "migrate", map[string]interface{}{
+			"helm.sh/hook":               "pre-upgrade",
+			"helm.sh/hook-delete-policy": "hook-failed",
+		}),
+	}
+
+	err := k.Apply(t.Context(), state, ApplyOpts{})
+	var waitErr ErrorWaitFailed
+	require.ErrorAs(t, err, &waitErr)
+	assert.Contains(t, err.Error(), "hooks of apply phase `helm-pre-hooks` did not succeed")
+	assert.Equal(t, []string{"apply Job/migrate", "delete Job/migrate"}, hc.events)
+}
+
+func TestApplyHelmHooksDryRun(t *testing.T) {
+	hc := &hookClient{}
+	k := &Kubernetes{Env: testEnv(), ctl: hc}
+
+	state := manifest.List{
+		hookObj("Job", "migrate", map[string]interface{}{
+			"helm.sh/hook":               "pre-install",
+			"helm.sh/hook-delete-policy": "before-hook-creation,hook-succeeded",
+		}),
+	}
+	hc.live = manifest.List{state[0]}
+
+	require.NoError(t, k.Apply(t.Context(), state, ApplyOpts{ApplyOpts: client.ApplyOpts{DryRun: "server"}}))
+	assert.Equal(t, []string{"apply Job/migrate"}, hc.events)
+}
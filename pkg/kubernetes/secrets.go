@@ -0,0 +1,116 @@
+package kubernetes
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/secrets"
+)
+
+// secretFields are the fields of a Secret that hold its values
+var secretFields = []string{"data", "stringData"}
+
+// IsSecret returns whether m is a core/v1 Secret
+func IsSecret(m manifest.Manifest) bool {
+	return m.Kind() == "Secret" && m.APIVersion() == "v1"
+}
+
+// MaskSecret replaces the values of the Secret live and its desired state
+// desired in place, so they can be printed. Values that are equal on both sides
+// become secrets.Redacted. Changed values are redacted in live and marked with
+// the hashes of both values in desired, e.g.
+// `<changed, sha256 1a2b3c4d..→5e6f7a8b..>`. Either side may be nil.
+// When live is given, the stringData of desired is folded into its data first,
+// because that is how the API server stores it.
+func MaskSecret(live, desired map[string]interface{}) {
+	if live != nil {
+		foldStringData(desired)
+	}
+
+	for _, field := range secretFields {
+		l, _ := live[field].(map[string]interface{})
+		d, _ := desired[field].(map[string]interface{})
+
+		for k, dv := range d {
+			lv, ok := l[k]
+			switch {
+			case ok && fmt.Sprint(lv) != fmt.Sprint(dv):
+				d[k] = fmt.Sprintf("<changed, sha256 %s..→%s..>", shortHash(lv), shortHash(dv))
+			default:
+				d[k] = secrets.Redacted
+			}
+		}
+		for k := range l {
+			l[k] = secrets.Redacted
+		}
+	}
+
+	// kubectl keeps a plain copy of the applied object
+	for _, obj := range []map[string]interface{}{live, desired} {
+		meta, _ := obj["metadata"].(map[string]interface{})
+		annotations, _ := meta["annotations"].(map[string]interface{})
+		if _, ok := annotations[AnnotationLastApplied]; ok {
+			annotations[AnnotationLastApplied] = secrets.Redacted
+		}
+	}
+}
+
+// foldStringData moves the stringData of the Secret obj into its data, encoding
+// the values in base64. Keys of stringData take precedence, like on the server.
+func foldStringData(obj map[string]interface{}) {
+	sd, ok := obj["stringData"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	data, ok := obj["data"].(map[string]interface{})
+	if !ok {
+		data = make(map[string]interface{}, len(sd))
+		obj["data"] = data
+	}
+	for k, v := range sd {
+		data[k] = base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(v)))
+	}
+	delete(obj, "stringData")
+}
+
+// MaskSecrets returns list with the values of all Secrets redacted. Other
+// objects are returned unchanged, Secrets are copied before masking.
+func MaskSecrets(list manifest.List) manifest.List {
+	out := make(manifest.List, 0, len(list))
+	for _, m := range list {
+		if IsSecret(m) {
+			c, _ := normalize(map[string]interface{}(m)).(map[string]interface{})
+			MaskSecret(nil, c)
+			m = manifest.Manifest(c)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// MaskedSecretDiffer returns a Differ for Secrets that compares them like
+// SemanticDiffer, but masks their values using MaskSecret
+func MaskedSecretDiffer(c client.Client) Differ {
+	return semanticDiffer(c, MaskSecret)
+}
+
+// splitSecrets separates Secrets from all other objects of list
+func splitSecrets(list manifest.List) (others, secrets manifest.List) {
+	for _, m := range list {
+		if IsSecret(m) {
+			secrets = append(secrets, m)
+			continue
+		}
+		others = append(others, m)
+	}
+	return others, secrets
+}
+
+// shortHash returns the first 8 hex characters of the sha256 of v
+func shortHash(v interface{}) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprint(v))))[:8]
+}
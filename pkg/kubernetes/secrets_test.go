@@ -0,0 +1,134 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+func secret(name string, data map[string]interface{}) manifest.Manifest {
+	s := m("v1", "Secret", name, "default")
+	s["data"] = data
+	return s
+}
+
+func TestMaskSecret(t *testing.T) {
+	live := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{AnnotationLastApplied: `{"data":{"password":"b2xk"}}`},
+		},
+		"data": map[string]interface{}{"same": "c2FtZQ==", "password": "b2xk", "removed": "Z29uZQ==", "plain": "dGV4dA==", "token": "b2xk"},
+	}
+	desired := map[string]interface{}{
+		"data":       map[string]interface{}{"same": "c2FtZQ==", "password": "bmV3", "added": "bmV3"},
+		"stringData": map[string]interface{}{"plain": "text", "token": "new"},
+	}
+
+	MaskSecret(live, desired)
+
+	assert.Equal(t, map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{AnnotationLastApplied: "<redacted>"},
+		},
+		"data": map[string]interface{}{
+			"same": "<redacted>", "password": "<redacted>", "removed": "<redacted>", "plain": "<redacted>", "token": "<redacted>",
+		},
+	}, live)
+	assert.Equal(t, map[string]interface{}{
+		"data": map[string]interface{}{
+			"same":     "<redacted>",
+			"password": "<changed, sha256 0c2c6b90..→fd9bd588..>",
+			"added":    "<redacted>",
+			"plain":    "<redacted>",
+			"token":    "<changed, sha256 0c2c6b90..→fd9bd588..>",
+		},
+	}, desired)
+
+	// without a live object, stringData is kept as is
+	desired = map[string]interface{}{"stringData": map[string]interface{}{"plain": "text"}}
+	MaskSecret(nil, desired)
+	assert.Equal(t, map[string]interface{}{"stringData": map[string]interface{}{"plain": "<redacted>"}}, desired)
+}
+
+func TestMaskSecrets(t *testing.T) {
+	s := secret("creds", map[string]interface{}{"password": "aHVudGVyMg=="})
+	cm := m("v1", "ConfigMap", "config", "default")
+	cm["data"] = map[string]interface{}{"key": "value"}
+
+	masked := MaskSecrets(manifest.List{s, cm})
+	require.Len(t, masked, 2)
+	assert.Equal(t, map[string]interface{}{"password": "<redacted>"}, masked[0]["data"])
+	assert.Equal(t, cm, masked[1])
+
+	// the original is left untouched
+	assert.Equal(t, "aHVudGVyMg==", s["data"].(map[string]interface{})["password"])
+}
+
+func TestDiffMasksSecrets(t *testing.T) {
+	c := &structuredClient{
+		fakeClient: fakeClient{resources: client.Resources{
+			{Kind: "Secret", Namespaced: true},
+			{Kind: "Deployment", Namespaced: true},
+		}},
+		live: manifest.List{
+			secret("creds", map[string]interface{}{"user": "YWRtaW4=", "password": "b2xk"}),
+		},
+	}
+
+	var diffed manifest.List
+	k := &Kubernetes{
+		Env: testEnv(),
+		ctl: c,
+		differs: map[string]Differ{"native": func(state manifest.List) (*string, error) {
+			diffed = state
+			return nil, nil
+		}},
+	}
+	k.Env.Spec.DiffStrategy = "native"
+
+	state := manifest.List{
+		secret("creds", map[string]interface{}{"user": "YWRtaW4=", "password": "bmV3"}),
+		m("apps/v1", "Deployment", "app", "default"),
+	}
+
+	diff, err := k.Diff(context.Background(), state, DiffOpts{})
+	require.NoError(t, err)
+	require.NotNil(t, diff)
+	assert.Equal(t, manifest.List{state[1]}, diffed)
+	assert.Equal(t, `diff -u -N LIVE/v1.Secret.default.creds MERGED/v1.Secret.default.creds
+--- LIVE/v1.Secret.default.creds
++++ MERGED/v1.Secret.default.creds
+@@ -1,6 +1,6 @@
+ apiVersion: v1
+ data:
+-  password: <redacted>
++  password: <changed, sha256 0c2c6b90..→fd9bd588..>
+   user: <redacted>
+ kind: Secret
+ metadata:
+`, *diff)
+
+	// the kubectl based differ gets to see the Secret when requested
+	_, err = k.Diff(context.Background(), state, DiffOpts{ShowSecrets: true})
+	require.NoError(t, err)
+	assert.Equal(t, state, diffed)
+
+	k.differs["subset"] = nil
+	result, err := k.StructuredDiff(context.Background(), state[:1], DiffOpts{Strategy: "subset"})
+	require.NoError(t, err)
+	require.Len(t, result.Objects, 1)
+	assert.Equal(t, []FieldChange{
+		{Op: "replace", Path: "/data/password", Value: "<changed, sha256 0c2c6b90..→fd9bd588..>", Old: "<redacted>"},
+	}, result.Objects[0].Fields)
+
+	result, err = k.StructuredDiff(context.Background(), state[:1], DiffOpts{Strategy: "subset", ShowSecrets: true})
+	require.NoError(t, err)
+	assert.Equal(t, []FieldChange{
+		{Op: "replace", Path: "/data/password", Value: "bmV3", Old: "b2xk"},
+	}, result.Objects[0].Fields)
+}
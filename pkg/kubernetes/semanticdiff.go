@@ -0,0 +1,332 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/kubernetes/util"
+)
+
+// listMapKeys are the fields Kubernetes uses to merge lists of objects, most
+// specific first. A list is treated as a map if all of its elements carry one
+// of these sets of fields.
+var listMapKeys = [][]string{
+	{"containerPort", "protocol"},
+	{"port", "protocol"},
+	{"mountPath"},
+	{"devicePath"},
+	{"topologyKey", "whenUnsatisfiable"},
+	{"ip"},
+	{"name"},
+	{"type"},
+}
+
+// listMapDefaults are filled into list-map keys missing on an element, the
+// same way the API server defaults them
+var listMapDefaults = map[string]interface{}{
+	"protocol": "TCP",
+}
+
+// fieldManagers are the managers whose fields count as set by Tanka
+var fieldManagers = map[string]bool{
+	"tanka":                     true,
+	"kubectl":                   true,
+	"kubectl-client-side-apply": true,
+}
+
+// SemanticDiffer returns an implementation of Differ that compares the desired
+// state to the cluster in-process, without invoking kubectl or diff(1).
+// Lists Kubernetes merges by key, like containers or ports, are matched by
+// that key instead of by position, and fields only set by the server (defaults,
+// status, metadata) are hidden unless they were previously applied by Tanka.
+func SemanticDiffer(c client.Client) Differ {
+	return semanticDiffer(c, nil)
+}
+
+// semanticDiffer implements SemanticDiffer. If set, transform is applied to
+// both sides of each object before printing them.
+func semanticDiffer(c client.Client, transform func(live, desired map[string]interface{})) Differ {
+	return func(state manifest.List) (*string, error) {
+		if len(state) == 0 {
+			return nil, nil
+		}
+
+		live, err := c.GetByState(state, client.GetByStateOpts{IgnoreNotFound: true})
+		if _, ok := err.(client.ErrorNothingReturned); ok {
+			live = nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "getting state from cluster")
+		}
+		index := indexObjects(live)
+
+		var diffs string
+		for _, m := range state {
+			var view map[string]interface{}
+			if l, ok := lookupObject(index, m); ok {
+				view = SemanticView(l, m)
+			}
+			desired := diffableObject(m)
+			if transform != nil {
+				transform(view, desired)
+			}
+
+			is := ""
+			if view != nil {
+				is = manifest.Manifest(view).String()
+			}
+			should := manifest.Manifest(desired).String()
+
+			diffStr, err := util.DiffStr(util.DiffName(m), is, should)
+			if err != nil {
+				return nil, errors.Wrap(err, "computing diff")
+			}
+			if diffStr != "" {
+				diffStr += "\n"
+			}
+			diffs += diffStr
+		}
+		diffs = strings.TrimSuffix(diffs, "\n")
+
+		if diffs == "" {
+			return nil, nil
+		}
+		return &diffs, nil
+	}
+}
+
+// SemanticView returns the parts of the live object that are relevant when
+// comparing it to desired. Fields not present in desired are only kept if
+// Tanka set them before (according to the last-applied annotation or the
+// managedFields), so that removing them shows up in a diff. Elements of
+// list-maps are reordered to match desired.
+func SemanticView(live, desired manifest.Manifest) map[string]interface{} {
+	owned := ownedFields(live)
+	l := diffableObject(live)
+	d := diffableObject(desired)
+
+	view, _ := align(l, d, owned).(map[string]interface{})
+	return view
+}
+
+// align returns live, reduced to the fields present in desired or owned
+func align(live, desired interface{}, owned fieldSet) interface{} {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return live
+		}
+
+		out := make(map[string]interface{}, len(d))
+		for k, lv := range l {
+			child, isOwned := owned.child("f:" + k)
+			dv, inDesired := d[k]
+			switch {
+			case inDesired:
+				out[k] = align(lv, dv, child)
+			case isOwned:
+				out[k] = lv
+			}
+		}
+		return out
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			return live
+		}
+
+		keys := listMapKey(d, l)
+		if keys == nil {
+			// positional list: compare element by element
+			out := make([]interface{}, len(l))
+			for i, lv := range l {
+				if i < len(d) {
+					out[i] = align(lv, d[i], nil)
+					continue
+				}
+				out[i] = lv
+			}
+			return out
+		}
+
+		byKey := make(map[string]interface{}, len(l))
+		for _, lv := range l {
+			byKey[elementKey(lv, keys)] = lv
+		}
+
+		out := make([]interface{}, 0, len(l))
+		for _, dv := range d {
+			k := elementKey(dv, keys)
+			lv, ok := byKey[k]
+			if !ok {
+				continue
+			}
+			child, _ := owned.child("k:" + k)
+			out = append(out, align(lv, dv, child))
+			delete(byKey, k)
+		}
+
+		// elements only present in the cluster, in their original order
+		for _, lv := range l {
+			k := elementKey(lv, keys)
+			if _, ok := byKey[k]; !ok {
+				continue
+			}
+			if _, isOwned := owned.child("k:" + k); isOwned {
+				out = append(out, lv)
+			}
+		}
+		return out
+	}
+
+	return live
+}
+
+// listMapKey returns the fields the elements of both lists are keyed by, or
+// nil if these are not list-maps
+func listMapKey(lists ...[]interface{}) []string {
+	empty := true
+	for _, list := range lists {
+		empty = empty && len(list) == 0
+	}
+	if empty {
+		return nil
+	}
+
+candidates:
+	for _, keys := range listMapKeys {
+		for _, list := range lists {
+			seen := make(map[string]bool, len(list))
+			for _, e := range list {
+				m, ok := e.(map[string]interface{})
+				if !ok {
+					return nil
+				}
+				for _, k := range keys {
+					if _, ok := m[k]; !ok && listMapDefaults[k] == nil {
+						continue candidates
+					}
+				}
+
+				// keys must be unique
+				key := elementKey(e, keys)
+				if seen[key] {
+					continue candidates
+				}
+				seen[key] = true
+			}
+		}
+		return keys
+	}
+	return nil
+}
+
+// elementKey returns the list-map key of e as JSON, as found in managedFields
+// (`k:{"containerPort":80,"protocol":"TCP"}`)
+func elementKey(e interface{}, keys []string) string {
+	m, _ := e.(map[string]interface{})
+	key := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		v, ok := m[k]
+		if !ok {
+			v = listMapDefaults[k]
+		}
+		key[k] = v
+	}
+	data, _ := json.Marshal(key)
+	return string(data)
+}
+
+// fieldSet is a tree of fields, in the format of managedFields (fieldsV1):
+// `f:<name>` for map fields, `k:<key>` for list-map elements
+type fieldSet map[string]fieldSet
+
+func (f fieldSet) child(key string) (fieldSet, bool) {
+	c, ok := f[key]
+	return c, ok
+}
+
+func (f fieldSet) merge(other fieldSet) fieldSet {
+	if f == nil {
+		f = fieldSet{}
+	}
+	for k, v := range other {
+		f[k] = f[k].merge(v)
+	}
+	return f
+}
+
+// ownedFields returns the fields of live that were set by Tanka
+func ownedFields(live manifest.Manifest) fieldSet {
+	owned := fieldSet{}
+
+	annotations, _ := live.Metadata()["annotations"].(map[string]interface{})
+	if s, ok := annotations[AnnotationLastApplied].(string); ok {
+		var applied interface{}
+		if err := json.Unmarshal([]byte(s), &applied); err == nil {
+			owned = owned.merge(fieldsOf(applied))
+		}
+	}
+
+	entries, _ := live.Metadata()["managedFields"].([]interface{})
+	for _, e := range entries {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if manager, _ := entry["manager"].(string); !fieldManagers[manager] {
+			continue
+		}
+		if fields, ok := entry["fieldsV1"].(map[string]interface{}); ok {
+			owned = owned.merge(parseFieldsV1(fields))
+		}
+	}
+
+	return owned
+}
+
+// fieldsOf returns the fields set in v
+func fieldsOf(v interface{}) fieldSet {
+	fs := fieldSet{}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, c := range t {
+			fs["f:"+k] = fieldsOf(c)
+		}
+	case []interface{}:
+		// lists that are not list-maps are replaced as a whole
+		keys := listMapKey(t)
+		if keys == nil {
+			break
+		}
+		for _, e := range t {
+			fs["k:"+elementKey(e, keys)] = fieldsOf(e)
+		}
+	}
+	return fs
+}
+
+// parseFieldsV1 converts managedFields to a fieldSet. List-map keys are
+// re-encoded, so they match elementKey
+func parseFieldsV1(fields map[string]interface{}) fieldSet {
+	fs := fieldSet{}
+	for k, c := range fields {
+		if k == "." {
+			continue
+		}
+		if strings.HasPrefix(k, "k:") {
+			var key interface{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(k, "k:")), &key); err == nil {
+				data, _ := json.Marshal(key)
+				k = "k:" + string(data)
+			}
+		}
+		child, _ := c.(map[string]interface{})
+		fs[k] = parseFieldsV1(child)
+	}
+	return fs
+}
@@ -0,0 +1,230 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+func TestSemanticView(t *testing.T) {
+	container := func(name string, extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"name": name, "image": name + ":latest"}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+	pod := func(spec map[string]interface{}, annotations map[string]interface{}) manifest.Manifest {
+		m := manifest.Manifest{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata":   map[string]interface{}{"name": "test", "namespace": "default"},
+			"spec":       spec,
+		}
+		if annotations != nil {
+			m.Metadata()["annotations"] = annotations
+		}
+		return m
+	}
+
+	tests := []struct {
+		name     string
+		live     manifest.Manifest
+		desired  manifest.Manifest
+		expected map[string]interface{}
+	}{
+		{
+			name: "server-defaults",
+			live: func() manifest.Manifest {
+				m := pod(map[string]interface{}{
+					"containers":    []interface{}{container("app", map[string]interface{}{"imagePullPolicy": "Always"})},
+					"restartPolicy": "Always",
+				}, nil)
+				m.Metadata()["uid"] = "1234"
+				m["status"] = map[string]interface{}{"phase": "Running"}
+				return m
+			}(),
+			desired: pod(map[string]interface{}{
+				"containers": []interface{}{container("app", nil)},
+			}, nil),
+			expected: pod(map[string]interface{}{
+				"containers": []interface{}{container("app", nil)},
+			}, nil),
+		},
+		{
+			name: "list-map-order",
+			live: pod(map[string]interface{}{
+				"containers": []interface{}{container("sidecar", nil), container("app", nil)},
+			}, nil),
+			desired: pod(map[string]interface{}{
+				"containers": []interface{}{container("app", nil), container("sidecar", nil)},
+			}, nil),
+			expected: pod(map[string]interface{}{
+				"containers": []interface{}{container("app", nil), container("sidecar", nil)},
+			}, nil),
+		},
+		{
+			name: "injected-element",
+			live: pod(map[string]interface{}{
+				"containers": []interface{}{container("app", nil), container("istio-proxy", nil)},
+			}, nil),
+			desired: pod(map[string]interface{}{
+				"containers": []interface{}{container("app", nil)},
+			}, nil),
+			expected: pod(map[string]interface{}{
+				"containers": []interface{}{container("app", nil)},
+			}, nil),
+		},
+		{
+			name: "ports-protocol-default",
+			live: pod(map[string]interface{}{
+				"containers": []interface{}{container("app", map[string]interface{}{"ports": []interface{}{
+					map[string]interface{}{"containerPort": float64(53), "protocol": "UDP"},
+					map[string]interface{}{"containerPort": float64(53), "protocol": "TCP"},
+				}})},
+			}, nil),
+			desired: pod(map[string]interface{}{
+				"containers": []interface{}{container("app", map[string]interface{}{"ports": []interface{}{
+					map[string]interface{}{"containerPort": 53},
+					map[string]interface{}{"containerPort": 53, "protocol": "UDP"},
+				}})},
+			}, nil),
+			// the defaulted protocol is hidden, but still used to match ports
+			expected: pod(map[string]interface{}{
+				"containers": []interface{}{container("app", map[string]interface{}{"ports": []interface{}{
+					map[string]interface{}{"containerPort": float64(53)},
+					map[string]interface{}{"containerPort": float64(53), "protocol": "UDP"},
+				}})},
+			}, nil),
+		},
+		{
+			name: "removed-field-last-applied",
+			live: pod(map[string]interface{}{
+				"containers":         []interface{}{container("app", nil), container("old", nil)},
+				"serviceAccountName": "app",
+				"dnsPolicy":          "ClusterFirst",
+			}, map[string]interface{}{
+				AnnotationLastApplied: `{"spec":{"serviceAccountName":"app","containers":[{"name":"app"},{"name":"old"}]}}`,
+			}),
+			desired: pod(map[string]interface{}{
+				"containers": []interface{}{container("app", nil)},
+			}, nil),
+			expected: pod(map[string]interface{}{
+				"containers":         []interface{}{container("app", nil), container("old", nil)},
+				"serviceAccountName": "app",
+			}, nil),
+		},
+		{
+			name: "removed-field-managed-fields",
+			live: func() manifest.Manifest {
+				m := pod(map[string]interface{}{
+					"containers":         []interface{}{container("app", map[string]interface{}{"args": []interface{}{"-v"}})},
+					"serviceAccountName": "app",
+					"dnsPolicy":          "ClusterFirst",
+				}, nil)
+				m.Metadata()["managedFields"] = []interface{}{
+					map[string]interface{}{
+						"manager": "tanka",
+						"fieldsV1": map[string]interface{}{"f:spec": map[string]interface{}{
+							"f:serviceAccountName": map[string]interface{}{},
+							"f:containers": map[string]interface{}{
+								`k:{"name":"app"}`: map[string]interface{}{".": map[string]interface{}{}, "f:args": map[string]interface{}{}},
+							},
+						}},
+					},
+					map[string]interface{}{
+						"manager":  "kube-controller-manager",
+						"fieldsV1": map[string]interface{}{"f:spec": map[string]interface{}{"f:dnsPolicy": map[string]interface{}{}}},
+					},
+				}
+				return m
+			}(),
+			desired: pod(map[string]interface{}{
+				"containers": []interface{}{container("app", nil)},
+			}, nil),
+			expected: pod(map[string]interface{}{
+				"containers":         []interface{}{container("app", map[string]interface{}{"args": []interface{}{"-v"}})},
+				"serviceAccountName": "app",
+			}, nil),
+		},
+		{
+			name: "positional-list",
+			live: pod(map[string]interface{}{
+				"containers": []interface{}{container("app", map[string]interface{}{"args": []interface{}{"-b", "-a"}})},
+			}, nil),
+			desired: pod(map[string]interface{}{
+				"containers": []interface{}{container("app", map[string]interface{}{"args": []interface{}{"-a", "-b"}})},
+			}, nil),
+			expected: pod(map[string]interface{}{
+				"containers": []interface{}{container("app", map[string]interface{}{"args": []interface{}{"-b", "-a"}})},
+			}, nil),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := SemanticView(tc.live, tc.desired)
+			assert.Equal(t, diffableObject(tc.expected), got)
+		})
+	}
+}
+
+func TestSemanticDiffer(t *testing.T) {
+	withSpec := func(m manifest.Manifest, spec map[string]interface{}) manifest.Manifest {
+		m["spec"] = spec
+		return m
+	}
+	live := func(m manifest.Manifest) manifest.Manifest {
+		m.Metadata()["uid"] = "1234"
+		m["status"] = map[string]interface{}{"replicas": 1}
+		return m
+	}
+
+	c := &structuredClient{
+		live: manifest.List{
+			live(withSpec(m("apps/v1", "Deployment", "same", "default"), map[string]interface{}{"replicas": 1, "defaulted": true})),
+			live(withSpec(m("apps/v1", "Deployment", "changed", "default"), map[string]interface{}{"replicas": 1, "defaulted": true})),
+		},
+	}
+
+	diff, err := SemanticDiffer(c)(manifest.List{
+		withSpec(m("apps/v1", "Deployment", "same", "default"), map[string]interface{}{"replicas": 1}),
+		withSpec(m("apps/v1", "Deployment", "changed", "default"), map[string]interface{}{"replicas": 3}),
+		withSpec(m("apps/v1", "Deployment", "new", "default"), map[string]interface{}{"replicas": 1}),
+	})
+	require.NoError(t, err)
+	require.NotNil(t, diff)
+
+	assert.Equal(t, `diff -u -N LIVE/apps-v1.Deployment.default.changed MERGED/apps-v1.Deployment.default.changed
+--- LIVE/apps-v1.Deployment.default.changed
++++ MERGED/apps-v1.Deployment.default.changed
+@@ -4,4 +4,4 @@
+   name: changed
+   namespace: default
+ spec:
+-  replicas: 1
++  replicas: 3
+
+diff -u -N LIVE/apps-v1.Deployment.default.new MERGED/apps-v1.Deployment.default.new
+--- LIVE/apps-v1.Deployment.default.new
++++ MERGED/apps-v1.Deployment.default.new
+@@ -0,0 +1,7 @@
++apiVersion: apps/v1
++kind: Deployment
++metadata:
++  name: new
++  namespace: default
++spec:
++  replicas: 1
+`, *diff)
+
+	// no changes
+	diff, err = SemanticDiffer(c)(manifest.List{
+		withSpec(m("apps/v1", "Deployment", "same", "default"), map[string]interface{}{"replicas": 1}),
+	})
+	require.NoError(t, err)
+	assert.Nil(t, diff)
+}
@@ -0,0 +1,242 @@
+package kubernetes
+
+import (
+	"fmt"
+
+	"github.com/stretchr/objx"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+// Readiness is the rollout state of a live object, modelled after kstatus
+// (sigs.k8s.io/cli-utils/pkg/kstatus)
+type Readiness string
+
+const (
+	// ReadinessCurrent means the object is fully reconciled and ready
+	ReadinessCurrent Readiness = "Current"
+	// ReadinessInProgress means the object is still being reconciled
+	ReadinessInProgress Readiness = "InProgress"
+	// ReadinessFailed means reconciliation ran into an error that is unlikely
+	// to resolve on its own
+	ReadinessFailed Readiness = "Failed"
+	// ReadinessNotFound means the object does not exist in the cluster
+	ReadinessNotFound Readiness = "NotFound"
+)
+
+// Status computes the Readiness of a live object from its status fields. The
+// returned message is meant for humans.
+func Status(m manifest.Manifest) (Readiness, string) {
+	o := objx.New(map[string]interface{}(m))
+
+	// the controller has not yet seen the latest spec
+	if gen, observed := o.Get("metadata.generation"), o.Get("status.observedGeneration"); gen.Data() != nil && observed.Data() != nil {
+		if toInt(gen.Data()) > toInt(observed.Data()) {
+			return ReadinessInProgress, "Waiting for the controller to observe the latest generation"
+		}
+	}
+
+	switch m.Kind() {
+	case "Deployment":
+		return deploymentStatus(o)
+	case "StatefulSet":
+		return statefulSetStatus(o)
+	case "DaemonSet":
+		return daemonSetStatus(o)
+	case "ReplicaSet":
+		return replicaSetStatus(o)
+	case "Job":
+		return jobStatus(o)
+	case "Pod":
+		return podStatus(o)
+	case "PersistentVolumeClaim":
+		if phase := o.Get("status.phase").Str(); phase != "Bound" {
+			return ReadinessInProgress, fmt.Sprintf("PVC is %s", orDefault(phase, "not bound"))
+		}
+		return ReadinessCurrent, "PVC is Bound"
+	case "Service":
+		if o.Get("spec.type").Str() == "LoadBalancer" && len(o.Get("status.loadBalancer.ingress").InterSlice()) == 0 {
+			return ReadinessInProgress, "Waiting for the load balancer"
+		}
+		return ReadinessCurrent, "Service is ready"
+	case "Namespace":
+		if phase := o.Get("status.phase").Str(); phase != "" && phase != "Active" {
+			return ReadinessInProgress, fmt.Sprintf("Namespace is %s", phase)
+		}
+		return ReadinessCurrent, "Namespace is active"
+	case "CustomResourceDefinition":
+		if c, ok := condition(o, "NamesAccepted"); ok && c.Get("status").Str() == "False" {
+			return ReadinessFailed, c.Get("message").Str()
+		}
+		if c, ok := condition(o, "Established"); ok && c.Get("status").Str() == "True" {
+			return ReadinessCurrent, "CRD is established"
+		}
+		return ReadinessInProgress, "CRD is not yet established"
+	}
+
+	return genericStatus(o)
+}
+
+// genericStatus follows the kstatus conventions for objects with
+// `status.conditions`, e.g. most custom resources
+func genericStatus(o objx.Map) (Readiness, string) {
+	if c, ok := condition(o, "Stalled"); ok && c.Get("status").Str() == "True" {
+		return ReadinessFailed, orDefault(c.Get("message").Str(), "Stalled")
+	}
+	if c, ok := condition(o, "Reconciling"); ok && c.Get("status").Str() == "True" {
+		return ReadinessInProgress, orDefault(c.Get("message").Str(), "Reconciling")
+	}
+	if c, ok := condition(o, "Ready"); ok && c.Get("status").Str() != "True" {
+		return ReadinessInProgress, orDefault(c.Get("message").Str(), "Not ready")
+	}
+	return ReadinessCurrent, "Resource is current"
+}
+
+func deploymentStatus(o objx.Map) (Readiness, string) {
+	replicas := specReplicas(o)
+
+	if c, ok := condition(o, "Progressing"); ok && c.Get("reason").Str() == "ProgressDeadlineExceeded" {
+		return ReadinessFailed, "Progress deadline exceeded"
+	}
+
+	updated := toInt(o.Get("status.updatedReplicas").Data())
+	total := toInt(o.Get("status.replicas").Data())
+	available := toInt(o.Get("status.availableReplicas").Data())
+	ready := toInt(o.Get("status.readyReplicas").Data())
+
+	switch {
+	case updated < replicas:
+		return ReadinessInProgress, fmt.Sprintf("Updated: %d/%d", updated, replicas)
+	case total > updated:
+		return ReadinessInProgress, fmt.Sprintf("Pending termination: %d", total-updated)
+	case available < replicas:
+		return ReadinessInProgress, fmt.Sprintf("Available: %d/%d", available, replicas)
+	case ready < replicas:
+		return ReadinessInProgress, fmt.Sprintf("Ready: %d/%d", ready, replicas)
+	}
+	return ReadinessCurrent, fmt.Sprintf("Deployment is available. Replicas: %d", replicas)
+}
+
+func statefulSetStatus(o objx.Map) (Readiness, string) {
+	replicas := specReplicas(o)
+	ready := toInt(o.Get("status.readyReplicas").Data())
+	updated := toInt(o.Get("status.updatedReplicas").Data())
+
+	if ready < replicas {
+		return ReadinessInProgress, fmt.Sprintf("Ready: %d/%d", ready, replicas)
+	}
+
+	if o.Get("spec.updateStrategy.type").Str() != "OnDelete" {
+		partition := toInt(o.Get("spec.updateStrategy.rollingUpdate.partition").Data())
+		if partition > 0 {
+			if updated < replicas-partition {
+				return ReadinessInProgress, fmt.Sprintf("Partitioned rollout: %d/%d updated", updated, replicas-partition)
+			}
+		} else if o.Get("status.currentRevision").Str() != o.Get("status.updateRevision").Str() {
+			return ReadinessInProgress, fmt.Sprintf("Rolling update: %d/%d updated", updated, replicas)
+		}
+	}
+	return ReadinessCurrent, fmt.Sprintf("All replicas scheduled as expected. Replicas: %d", replicas)
+}
+
+func daemonSetStatus(o objx.Map) (Readiness, string) {
+	desired := toInt(o.Get("status.desiredNumberScheduled").Data())
+	updated := toInt(o.Get("status.updatedNumberScheduled").Data())
+	available := toInt(o.Get("status.numberAvailable").Data())
+	ready := toInt(o.Get("status.numberReady").Data())
+
+	switch {
+	case updated < desired:
+		return ReadinessInProgress, fmt.Sprintf("Updated: %d/%d", updated, desired)
+	case available < desired:
+		return ReadinessInProgress, fmt.Sprintf("Available: %d/%d", available, desired)
+	case ready < desired:
+		return ReadinessInProgress, fmt.Sprintf("Ready: %d/%d", ready, desired)
+	}
+	return ReadinessCurrent, fmt.Sprintf("All replicas scheduled as expected. Replicas: %d", desired)
+}
+
+func replicaSetStatus(o objx.Map) (Readiness, string) {
+	replicas := specReplicas(o)
+	available := toInt(o.Get("status.availableReplicas").Data())
+	ready := toInt(o.Get("status.readyReplicas").Data())
+
+	switch {
+	case available < replicas:
+		return ReadinessInProgress, fmt.Sprintf("Available: %d/%d", available, replicas)
+	case ready < replicas:
+		return ReadinessInProgress, fmt.Sprintf("Ready: %d/%d", ready, replicas)
+	}
+	return ReadinessCurrent, fmt.Sprintf("ReplicaSet is available. Replicas: %d", replicas)
+}
+
+func jobStatus(o objx.Map) (Readiness, string) {
+	if c, ok := condition(o, "Failed"); ok && c.Get("status").Str() == "True" {
+		return ReadinessFailed, orDefault(c.Get("message").Str(), "Job failed")
+	}
+	if c, ok := condition(o, "Complete"); ok && c.Get("status").Str() == "True" {
+		return ReadinessCurrent, "Job completed"
+	}
+	return ReadinessInProgress, fmt.Sprintf("Job in progress. Succeeded: %d", toInt(o.Get("status.succeeded").Data()))
+}
+
+func podStatus(o objx.Map) (Readiness, string) {
+	switch o.Get("status.phase").Str() {
+	case "Succeeded":
+		return ReadinessCurrent, "Pod has completed successfully"
+	case "Failed":
+		return ReadinessFailed, orDefault(o.Get("status.message").Str(), "Pod has failed")
+	}
+
+	for _, cs := range o.Get("status.containerStatuses").InterSlice() {
+		reason := objx.New(cs).Get("state.waiting.reason").Str()
+		switch reason {
+		case "CrashLoopBackOff", "ImagePullBackOff", "ErrImagePull", "CreateContainerConfigError", "InvalidImageName":
+			return ReadinessFailed, fmt.Sprintf("Container %s: %s", objx.New(cs).Get("name").Str(), reason)
+		}
+	}
+
+	if c, ok := condition(o, "Ready"); ok && c.Get("status").Str() == "True" {
+		return ReadinessCurrent, "Pod is ready"
+	}
+	return ReadinessInProgress, "Pod is not ready"
+}
+
+// condition returns the status condition of the given type
+func condition(o objx.Map, typ string) (objx.Map, bool) {
+	for _, c := range o.Get("status.conditions").InterSlice() {
+		cm := objx.New(c)
+		if cm.Get("type").Str() == typ {
+			return cm, true
+		}
+	}
+	return nil, false
+}
+
+// specReplicas returns spec.replicas, which defaults to 1
+func specReplicas(o objx.Map) int64 {
+	if o.Get("spec.replicas").Data() == nil {
+		return 1
+	}
+	return toInt(o.Get("spec.replicas").Data())
+}
+
+// toInt converts the number types JSON and YAML decoding produce
+func toInt(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
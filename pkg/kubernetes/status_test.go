@@ -0,0 +1,162 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+func TestStatus(t *testing.T) {
+	obj := func(kind string, spec, status map[string]interface{}) manifest.Manifest {
+		m := manifest.Manifest{
+			"apiVersion": "v1",
+			"kind":       kind,
+			"metadata":   map[string]interface{}{"name": "test", "generation": float64(2)},
+		}
+		if spec != nil {
+			m["spec"] = spec
+		}
+		if status != nil {
+			m["status"] = status
+		}
+		return m
+	}
+	cond := func(typ, status string, extra ...string) map[string]interface{} {
+		c := map[string]interface{}{"type": typ, "status": status}
+		if len(extra) > 0 {
+			c["reason"] = extra[0]
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		m        manifest.Manifest
+		expected Readiness
+	}{
+		{
+			name: "deployment/stale-generation",
+			m: obj("Deployment", map[string]interface{}{"replicas": float64(1)}, map[string]interface{}{
+				"observedGeneration": float64(1), "updatedReplicas": float64(1), "replicas": float64(1), "availableReplicas": float64(1), "readyReplicas": float64(1),
+			}),
+			expected: ReadinessInProgress,
+		},
+		{
+			name: "deployment/available",
+			m: obj("Deployment", map[string]interface{}{"replicas": float64(2)}, map[string]interface{}{
+				"observedGeneration": float64(2), "updatedReplicas": float64(2), "replicas": float64(2), "availableReplicas": float64(2), "readyReplicas": float64(2),
+			}),
+			expected: ReadinessCurrent,
+		},
+		{
+			name: "deployment/rolling",
+			m: obj("Deployment", map[string]interface{}{"replicas": float64(2)}, map[string]interface{}{
+				"observedGeneration": float64(2), "updatedReplicas": float64(1), "replicas": float64(3), "availableReplicas": float64(2), "readyReplicas": float64(2),
+			}),
+			expected: ReadinessInProgress,
+		},
+		{
+			name: "deployment/deadline-exceeded",
+			m: obj("Deployment", nil, map[string]interface{}{
+				"conditions": []interface{}{cond("Progressing", "False", "ProgressDeadlineExceeded")},
+			}),
+			expected: ReadinessFailed,
+		},
+		{
+			name: "statefulset/revision-mismatch",
+			m: obj("StatefulSet", map[string]interface{}{"replicas": float64(1)}, map[string]interface{}{
+				"readyReplicas": float64(1), "currentRevision": "a", "updateRevision": "b",
+			}),
+			expected: ReadinessInProgress,
+		},
+		{
+			name: "statefulset/current",
+			m: obj("StatefulSet", map[string]interface{}{"replicas": float64(1)}, map[string]interface{}{
+				"readyReplicas": float64(1), "updatedReplicas": float64(1), "currentRevision": "b", "updateRevision": "b",
+			}),
+			expected: ReadinessCurrent,
+		},
+		{
+			name: "daemonset/updating",
+			m: obj("DaemonSet", nil, map[string]interface{}{
+				"desiredNumberScheduled": float64(3), "updatedNumberScheduled": float64(2), "numberAvailable": float64(3), "numberReady": float64(3),
+			}),
+			expected: ReadinessInProgress,
+		},
+		{
+			name:     "job/complete",
+			m:        obj("Job", nil, map[string]interface{}{"conditions": []interface{}{cond("Complete", "True")}}),
+			expected: ReadinessCurrent,
+		},
+		{
+			name:     "job/failed",
+			m:        obj("Job", nil, map[string]interface{}{"conditions": []interface{}{cond("Failed", "True")}}),
+			expected: ReadinessFailed,
+		},
+		{
+			name:     "job/running",
+			m:        obj("Job", nil, map[string]interface{}{"active": float64(1)}),
+			expected: ReadinessInProgress,
+		},
+		{
+			name: "pod/crashloop",
+			m: obj("Pod", nil, map[string]interface{}{
+				"phase": "Running",
+				"containerStatuses": []interface{}{
+					map[string]interface{}{"name": "app", "state": map[string]interface{}{"waiting": map[string]interface{}{"reason": "CrashLoopBackOff"}}},
+				},
+			}),
+			expected: ReadinessFailed,
+		},
+		{
+			name:     "pvc/pending",
+			m:        obj("PersistentVolumeClaim", nil, map[string]interface{}{"phase": "Pending"}),
+			expected: ReadinessInProgress,
+		},
+		{
+			name:     "service/loadbalancer-pending",
+			m:        obj("Service", map[string]interface{}{"type": "LoadBalancer"}, map[string]interface{}{}),
+			expected: ReadinessInProgress,
+		},
+		{
+			name:     "service/clusterip",
+			m:        obj("Service", map[string]interface{}{"type": "ClusterIP"}, nil),
+			expected: ReadinessCurrent,
+		},
+		{
+			name:     "crd/established",
+			m:        obj("CustomResourceDefinition", nil, map[string]interface{}{"conditions": []interface{}{cond("Established", "True")}}),
+			expected: ReadinessCurrent,
+		},
+		{
+			name:     "namespace/terminating",
+			m:        obj("Namespace", nil, map[string]interface{}{"phase": "Terminating"}),
+			expected: ReadinessInProgress,
+		},
+		{
+			name:     "custom/not-ready",
+			m:        obj("Certificate", nil, map[string]interface{}{"conditions": []interface{}{cond("Ready", "False")}}),
+			expected: ReadinessInProgress,
+		},
+		{
+			name:     "custom/stalled",
+			m:        obj("Certificate", nil, map[string]interface{}{"conditions": []interface{}{cond("Stalled", "True")}}),
+			expected: ReadinessFailed,
+		},
+		{
+			name:     "configmap",
+			m:        obj("ConfigMap", nil, nil),
+			expected: ReadinessCurrent,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, msg := Status(tc.m)
+			assert.Equal(t, tc.expected, got, msg)
+			assert.NotEmpty(t, msg)
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/process"
+)
+
+// ChangeType describes what applying the desired state does to an object
+type ChangeType string
+
+const (
+	// ChangeCreate means the object does not exist yet
+	ChangeCreate ChangeType = "create"
+	// ChangeUpdate means the object exists, but differs
+	ChangeUpdate ChangeType = "update"
+	// ChangeDelete means the object is removed by `tk prune`
+	ChangeDelete ChangeType = "delete"
+	// ChangeUnchanged means the object exists and matches
+	ChangeUnchanged ChangeType = "unchanged"
+)
+
+// FieldChange is a change to a single field of an object. Op and Path follow
+// JSON patch (RFC 6902), so that a list of FieldChanges can be used as a patch.
+type FieldChange struct {
+	// Op is one of add, remove or replace
+	Op string `json:"op"`
+	// Path is a JSON pointer (RFC 6901) to the field
+	Path string `json:"path"`
+	// Value is the new value. Unset for remove
+	Value interface{} `json:"value,omitempty"`
+	// Old is the current value. Unset for add
+	Old interface{} `json:"old,omitempty"`
+}
+
+// ObjectDiff is the difference of a single object
+type ObjectDiff struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Name       string        `json:"name"`
+	Namespace  string        `json:"namespace,omitempty"`
+	Change     ChangeType    `json:"change"`
+	Fields     []FieldChange `json:"fields,omitempty"`
+}
+
+// DiffResult is the structured form of the differences between the desired
+// state and the cluster
+type DiffResult struct {
+	Objects []ObjectDiff       `json:"objects"`
+	Summary map[ChangeType]int `json:"summary"`
+}
+
+// HasChanges returns whether any object is not ChangeUnchanged
+func (r DiffResult) HasChanges() bool {
+	for _, o := range r.Objects {
+		if o.Change != ChangeUnchanged {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *DiffResult) add(m manifest.Manifest, change ChangeType, fields []FieldChange) {
+	r.Objects = append(r.Objects, ObjectDiff{
+		APIVersion: m.APIVersion(),
+		Kind:       m.Kind(),
+		Name:       m.Metadata().Name(),
+		Namespace:  m.Metadata().Namespace(),
+		Change:     change,
+		Fields:     fields,
+	})
+	r.Summary[change]++
+}
+
+// StructuredDiff is like Diff, but returns the differences per object, down to
+// individual fields. The live state is compared to the result of a server-side
+// dry-run, except for the subset and semantic strategies, which compare against
+// the desired state and only consider fields it sets. Values of Secrets are
+// masked unless opts.ShowSecrets is set. opts.Summarize is ignored.
+func (k *Kubernetes) StructuredDiff(ctx context.Context, state manifest.List, opts DiffOpts) (*DiffResult, error) {
+	_, span := tracer.Start(ctx, "kubernetes.StructuredDiff")
+	defer span.End()
+
+	// hooks that are never applied can't be compared either
+	state = process.WithoutSkippedHooks(state)
+
+	strategy := k.strategy(opts.Strategy)
+	if _, ok := k.differs[strategy]; !ok {
+		return nil, ErrorDiffStrategyUnknown{Requested: strategy, differs: k.differs}
+	}
+
+	live, soon, err := k.separate(state)
+	if err != nil {
+		return nil, err
+	}
+	if live, err = k.ignoreDifferences(live); err != nil {
+		return nil, err
+	}
+
+	current, err := k.ctl.GetByState(live, client.GetByStateOpts{IgnoreNotFound: true})
+	if _, ok := err.(client.ErrorNothingReturned); ok {
+		current = nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "getting state from cluster")
+	}
+	currentIndex := indexObjects(current)
+
+	// objects that exist are compared to what the server would make of them
+	var existing manifest.List
+	for _, m := range live {
+		if _, ok := lookupObject(currentIndex, m); ok {
+			existing = append(existing, m)
+		}
+	}
+
+	merged := existing
+	if strategy != "subset" && strategy != "semantic" && len(existing) > 0 {
+		applyStrategy := "client"
+		if strategy == "server" {
+			applyStrategy = "server"
+		}
+		merged, err = k.ctl.DryRunApply(existing, client.ApplyOpts{
+			ApplyStrategy: applyStrategy,
+			Force:         true,
+			Validate:      true,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "dry-run apply")
+		}
+	}
+	mergedIndex := indexObjects(merged)
+
+	result := &DiffResult{Summary: map[ChangeType]int{}}
+	for _, m := range live {
+		is, ok := lookupObject(currentIndex, m)
+		if !ok {
+			result.add(m, ChangeCreate, nil)
+			continue
+		}
+		should, ok := lookupObject(mergedIndex, m)
+		if !ok {
+			return nil, errors.Errorf("dry-run apply did not return %s", m.KindName())
+		}
+
+		switch strategy {
+		case "subset":
+			is = subset(m, is)
+		case "semantic":
+			is = SemanticView(is, m)
+		}
+
+		isObj, shouldObj := diffableObject(is), diffableObject(should)
+		if !opts.ShowSecrets && IsSecret(m) {
+			MaskSecret(isObj, shouldObj)
+		}
+
+		fields := FieldChanges(isObj, shouldObj)
+		if len(fields) == 0 {
+			result.add(m, ChangeUnchanged, nil)
+			continue
+		}
+		result.add(m, ChangeUpdate, fields)
+	}
+
+	for _, m := range soon {
+		result.add(m, ChangeCreate, nil)
+	}
+
+	if opts.WithPrune {
+		orphaned, err := k.Orphaned(state, OrphanedOpts{})
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range orphaned {
+			result.add(m, ChangeDelete, nil)
+		}
+	}
+
+	return result, nil
+}
+
+// FieldChanges returns the changes required to turn is into should, as JSON
+// patch operations. Lists are compared element by element.
+func FieldChanges(is, should interface{}) []FieldChange {
+	var changes []FieldChange
+	fieldChanges("", normalize(is), normalize(should), &changes)
+	return changes
+}
+
+func fieldChanges(path string, is, should interface{}, changes *[]FieldChange) {
+	switch s := should.(type) {
+	case map[string]interface{}:
+		i, ok := is.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(i)+len(s))
+		for k := range i {
+			keys = append(keys, k)
+		}
+		for k := range s {
+			if _, ok := i[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			p := path + "/" + escapePointer(k)
+			iv, iok := i[k]
+			sv, sok := s[k]
+			switch {
+			case !iok:
+				*changes = append(*changes, FieldChange{Op: "add", Path: p, Value: sv})
+			case !sok:
+				*changes = append(*changes, FieldChange{Op: "remove", Path: p, Old: iv})
+			default:
+				fieldChanges(p, iv, sv, changes)
+			}
+		}
+		return
+	case []interface{}:
+		i, ok := is.([]interface{})
+		if !ok {
+			break
+		}
+
+		n := len(i)
+		if len(s) < n {
+			n = len(s)
+		}
+		for idx := 0; idx < n; idx++ {
+			fieldChanges(path+"/"+strconv.Itoa(idx), i[idx], s[idx], changes)
+		}
+		for idx := n; idx < len(s); idx++ {
+			*changes = append(*changes, FieldChange{Op: "add", Path: path + "/" + strconv.Itoa(idx), Value: s[idx]})
+		}
+		// remove from the back, so indexes stay valid when applied in order
+		for idx := len(i) - 1; idx >= n; idx-- {
+			*changes = append(*changes, FieldChange{Op: "remove", Path: path + "/" + strconv.Itoa(idx), Old: i[idx]})
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(is, should) {
+		*changes = append(*changes, FieldChange{Op: "replace", Path: path, Value: should, Old: is})
+	}
+}
+
+// escapePointer escapes a key for use in a JSON pointer
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+// normalize round-trips v through JSON, so that numbers of different Go types
+// compare equal
+func normalize(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+// diffableObject returns a copy of m without fields that change on every
+// apply and are not useful in a diff
+func diffableObject(m manifest.Manifest) map[string]interface{} {
+	out, ok := normalize(map[string]interface{}(m)).(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	if meta, ok := out["metadata"].(map[string]interface{}); ok {
+		delete(meta, "managedFields")
+		delete(meta, "resourceVersion")
+		delete(meta, "generation")
+		if annotations, ok := meta["annotations"].(map[string]interface{}); ok {
+			delete(annotations, AnnotationLastApplied)
+			if len(annotations) == 0 {
+				delete(meta, "annotations")
+			}
+		}
+	}
+	return out
+}
+
+// indexObjects indexes list by kind, namespace and name
+func indexObjects(list manifest.List) map[string]manifest.Manifest {
+	index := make(map[string]manifest.Manifest, len(list))
+	for _, m := range list {
+		index[objectKey(m.Kind(), m.Metadata().Namespace(), m.Metadata().Name())] = m
+	}
+	return index
+}
+
+// lookupObject finds m in index. Cluster-wide objects may carry a namespace
+// locally, so these are also looked up without one
+func lookupObject(index map[string]manifest.Manifest, m manifest.Manifest) (manifest.Manifest, bool) {
+	l, ok := index[objectKey(m.Kind(), m.Metadata().Namespace(), m.Metadata().Name())]
+	if !ok {
+		l, ok = index[objectKey(m.Kind(), "", m.Metadata().Name())]
+	}
+	return l, ok
+}
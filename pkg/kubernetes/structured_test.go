@@ -0,0 +1,176 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+func TestFieldChanges(t *testing.T) {
+	cases := []struct {
+		name     string
+		is       interface{}
+		should   interface{}
+		expected []FieldChange
+	}{
+		{
+			name:   "equal",
+			is:     map[string]interface{}{"a": int64(1), "b": []interface{}{"x"}},
+			should: map[string]interface{}{"a": float64(1), "b": []interface{}{"x"}},
+		},
+		{
+			name:   "maps",
+			is:     map[string]interface{}{"a": "1", "b": map[string]interface{}{"c": true}},
+			should: map[string]interface{}{"b": map[string]interface{}{"c": false}, "d": "new"},
+			expected: []FieldChange{
+				{Op: "remove", Path: "/a", Old: "1"},
+				{Op: "replace", Path: "/b/c", Value: false, Old: true},
+				{Op: "add", Path: "/d", Value: "new"},
+			},
+		},
+		{
+			name:   "escaping",
+			is:     map[string]interface{}{"app.kubernetes.io/name": "a", "x~y": "1"},
+			should: map[string]interface{}{"app.kubernetes.io/name": "b", "x~y": "2"},
+			expected: []FieldChange{
+				{Op: "replace", Path: "/app.kubernetes.io~1name", Value: "b", Old: "a"},
+				{Op: "replace", Path: "/x~0y", Value: "2", Old: "1"},
+			},
+		},
+		{
+			name:   "lists",
+			is:     map[string]interface{}{"grow": []interface{}{"a"}, "shrink": []interface{}{"a", "b", "c"}},
+			should: map[string]interface{}{"grow": []interface{}{"a", "b"}, "shrink": []interface{}{"x"}},
+			expected: []FieldChange{
+				{Op: "add", Path: "/grow/1", Value: "b"},
+				{Op: "replace", Path: "/shrink/0", Value: "x", Old: "a"},
+				{Op: "remove", Path: "/shrink/2", Old: "c"},
+				{Op: "remove", Path: "/shrink/1", Old: "b"},
+			},
+		},
+		{
+			name:   "type-change",
+			is:     map[string]interface{}{"a": map[string]interface{}{"b": "c"}},
+			should: map[string]interface{}{"a": "b"},
+			expected: []FieldChange{
+				{Op: "replace", Path: "/a", Value: "b", Old: map[string]interface{}{"b": "c"}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, FieldChanges(c.is, c.should))
+		})
+	}
+}
+
+// structuredClient returns live from GetByState and sets spec.defaulted on
+// DryRunApply, like a defaulting webhook would
+type structuredClient struct {
+	fakeClient
+	live   manifest.List
+	dryRun []client.ApplyOpts
+}
+
+func (s *structuredClient) Namespaces() (map[string]bool, error) {
+	return map[string]bool{"default": true}, nil
+}
+
+func (s *structuredClient) GetByState(data manifest.List, opts client.GetByStateOpts) (manifest.List, error) {
+	return s.live, nil
+}
+
+func (s *structuredClient) DryRunApply(data manifest.List, opts client.ApplyOpts) (manifest.List, error) {
+	s.dryRun = append(s.dryRun, opts)
+
+	var out manifest.List
+	for _, m := range data {
+		merged := manifest.Manifest(normalize(map[string]interface{}(m)).(map[string]interface{}))
+		merged["spec"].(map[string]interface{})["defaulted"] = true
+		merged.Metadata()["resourceVersion"] = "2"
+		out = append(out, merged)
+	}
+	return out, nil
+}
+
+func TestStructuredDiff(t *testing.T) {
+	withSpec := func(m manifest.Manifest, spec map[string]interface{}) manifest.Manifest {
+		m["spec"] = spec
+		return m
+	}
+	live := func(m manifest.Manifest) manifest.Manifest {
+		m.Metadata()["resourceVersion"] = "1"
+		m.Metadata()["annotations"] = map[string]interface{}{AnnotationLastApplied: "{}"}
+		return m
+	}
+
+	c := &structuredClient{
+		fakeClient: fakeClient{resources: client.Resources{
+			{Kind: "Deployment", Namespaced: true},
+			{Kind: "Namespace", Namespaced: false},
+		}},
+		live: manifest.List{
+			live(withSpec(m("apps/v1", "Deployment", "same", "default"), map[string]interface{}{"replicas": 1, "defaulted": true})),
+			live(withSpec(m("apps/v1", "Deployment", "changed", "default"), map[string]interface{}{"replicas": 1, "defaulted": true})),
+		},
+	}
+	k := &Kubernetes{
+		Env:     testEnv(),
+		ctl:     c,
+		differs: map[string]Differ{"native": nil, "server": nil, "subset": nil},
+	}
+	k.Env.Spec.DiffStrategy = "native"
+
+	state := manifest.List{
+		withSpec(m("apps/v1", "Deployment", "same", "default"), map[string]interface{}{"replicas": 1}),
+		withSpec(m("apps/v1", "Deployment", "changed", "default"), map[string]interface{}{"replicas": 3}),
+		withSpec(m("apps/v1", "Deployment", "new", "default"), map[string]interface{}{"replicas": 1}),
+		m("v1", "Namespace", "monitoring", ""),
+		withSpec(m("apps/v1", "Deployment", "soon", "monitoring"), map[string]interface{}{}),
+	}
+
+	result, err := k.StructuredDiff(context.Background(), state, DiffOpts{})
+	require.NoError(t, err)
+	assert.True(t, result.HasChanges())
+	assert.Equal(t, map[ChangeType]int{ChangeUnchanged: 1, ChangeUpdate: 1, ChangeCreate: 3}, result.Summary)
+
+	byName := map[string]ObjectDiff{}
+	for _, o := range result.Objects {
+		byName[o.Name] = o
+	}
+	assert.Equal(t, ChangeUnchanged, byName["same"].Change)
+	assert.Equal(t, ObjectDiff{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Name:       "changed",
+		Namespace:  "default",
+		Change:     ChangeUpdate,
+		Fields: []FieldChange{
+			{Op: "replace", Path: "/spec/replicas", Value: float64(3), Old: float64(1)},
+		},
+	}, byName["changed"])
+	assert.Equal(t, ChangeCreate, byName["new"].Change)
+	assert.Equal(t, ChangeCreate, byName["monitoring"].Change)
+	assert.Equal(t, ChangeCreate, byName["soon"].Change)
+
+	// only existing objects are dry-run applied
+	require.Len(t, c.dryRun, 1)
+	assert.Equal(t, "client", c.dryRun[0].ApplyStrategy)
+
+	// subset ignores fields not set in Jsonnet, so the defaulted field and
+	// annotations do not show up
+	c.dryRun = nil
+	result, err = k.StructuredDiff(context.Background(), state, DiffOpts{Strategy: "subset"})
+	require.NoError(t, err)
+	assert.Empty(t, c.dryRun)
+	assert.Equal(t, 1, result.Summary[ChangeUpdate])
+
+	_, err = k.StructuredDiff(context.Background(), state, DiffOpts{Strategy: "nope"})
+	assert.IsType(t, ErrorDiffStrategyUnknown{}, err)
+}
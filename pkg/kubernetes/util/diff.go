@@ -1,17 +1,16 @@
 package util
 
 import (
-	"bytes"
 	"fmt"
 	"math"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
 
 	"github.com/fatih/color"
+	"github.com/pmezard/go-difflib/difflib"
+
 	"github.com/grafana/tanka/pkg/kubernetes/manifest"
 )
 
@@ -25,37 +24,23 @@ func DiffName(m manifest.Manifest) string {
 	), "/", "-")
 }
 
-// DiffStr computes the differences between the strings `is` and `should` using the
-// UNIX `diff(1)` utility.
+// DiffStr computes the differences between the strings `is` and `should` in
+// unified format, like `diff -u -N` does. It runs in-process, so no `diff(1)`
+// binary is required.
 func DiffStr(name, is, should string) (string, error) {
-	dir, err := os.MkdirTemp("", "diff")
+	live, merged := "LIVE/"+name, "MERGED/"+name
+
+	out, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(is),
+		B:        splitLines(should),
+		FromFile: live,
+		ToFile:   merged,
+		Context:  3,
+	})
 	if err != nil {
 		return "", err
 	}
-	defer os.RemoveAll(dir)
-
-	if err := os.WriteFile(filepath.Join(dir, "LIVE-"+name), []byte(is), os.ModePerm); err != nil {
-		return "", err
-	}
-	if err := os.WriteFile(filepath.Join(dir, "MERGED-"+name), []byte(should), os.ModePerm); err != nil {
-		return "", err
-	}
 
-	buf := bytes.Buffer{}
-	merged := filepath.Join(dir, "MERGED-"+name)
-	live := filepath.Join(dir, "LIVE-"+name)
-	cmd := exec.Command("diff", "-u", "-N", live, merged)
-	cmd.Stdout = &buf
-	err = cmd.Run()
-
-	// the diff utility exits with `1` if there are differences. We need to not fail there.
-	if exitError, ok := err.(*exec.ExitError); ok && err != nil {
-		if exitError.ExitCode() != 1 {
-			return "", err
-		}
-	}
-
-	out := buf.String()
 	if out != "" {
 		out = fmt.Sprintf("diff -u -N %s %s\n%s", live, merged, out)
 	}
@@ -63,6 +48,23 @@ func DiffStr(name, is, should string) (string, error) {
 	return out, nil
 }
 
+// noNewline is appended to lines missing a trailing newline, like diff(1) does
+const noNewline = "\n\\ No newline at end of file\n"
+
+// splitLines splits s into lines, keeping the line endings
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += noNewline
+	return lines
+}
+
 // Diffstat creates a histogram of a diff
 func DiffStat(d string) (string, error) {
 	lines := strings.Split(d, "\n")
@@ -29,3 +29,63 @@ func TestDiffStat(t *testing.T) {
 		})
 	}
 }
+
+func TestDiffStr(t *testing.T) {
+	cases := []struct {
+		name       string
+		is, should string
+		expected   string
+	}{
+		{
+			name:   "equal",
+			is:     "a: 1\n",
+			should: "a: 1\n",
+		},
+		{
+			name:   "changed",
+			is:     "a: 1\nb: 2\nc: 3\n",
+			should: "a: 1\nb: 3\nc: 3\n",
+			expected: `diff -u -N LIVE/test MERGED/test
+--- LIVE/test
++++ MERGED/test
+@@ -1,3 +1,3 @@
+ a: 1
+-b: 2
++b: 3
+ c: 3
+`,
+		},
+		{
+			name:   "created",
+			should: "a: 1\n",
+			expected: `diff -u -N LIVE/test MERGED/test
+--- LIVE/test
++++ MERGED/test
+@@ -0,0 +1 @@
++a: 1
+`,
+		},
+		{
+			name:   "no-trailing-newline",
+			is:     "a",
+			should: "b",
+			expected: `diff -u -N LIVE/test MERGED/test
+--- LIVE/test
++++ MERGED/test
+@@ -1 +1 @@
+-a
+\ No newline at end of file
++b
+\ No newline at end of file
+`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := DiffStr("test", c.is, c.should)
+			require.NoError(t, err)
+			assert.Equal(t, c.expected, got)
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/term"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+// WaitOpts configures Wait
+type WaitOpts struct {
+	// Timeout after which waiting is aborted. Zero waits forever
+	Timeout time.Duration
+	// Interval between two status checks. Defaults to 2s
+	Interval time.Duration
+	// Deleted waits for the objects to be gone from the cluster, instead of
+	// for them to become ready
+	Deleted bool
+	// Out receives the progress table. Defaults to os.Stderr
+	Out io.Writer
+}
+
+// ObjectStatus is the Readiness of a single object of the desired state
+type ObjectStatus struct {
+	Manifest manifest.Manifest
+	Status   Readiness
+	Message  string
+
+	// Events recorded for the object. Only populated for objects that caused
+	// Wait to fail
+	Events []string
+}
+
+// ErrorWaitFailed occurs when not all objects reached the desired state
+type ErrorWaitFailed struct {
+	Reason  string
+	Objects []ObjectStatus
+}
+
+func (e ErrorWaitFailed) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "waiting for resources failed: %s", e.Reason)
+	for _, o := range e.Objects {
+		fmt.Fprintf(&b, "\n  * %s: %s (%s)", o.Manifest.KindName(), o.Status, o.Message)
+		for _, ev := range o.Events {
+			fmt.Fprintf(&b, "\n      %s", ev)
+		}
+	}
+	return b.String()
+}
+
+// Wait blocks until all objects of state are ready (or gone, if opts.Deleted is
+// set), printing a progress table while doing so. It returns ErrorWaitFailed if
+// the timeout is exceeded or all pending objects have failed.
+func (k *Kubernetes) Wait(ctx context.Context, state manifest.List, opts WaitOpts) error {
+	if opts.Interval == 0 {
+		opts.Interval = 2 * time.Second
+	}
+	if opts.Out == nil {
+		opts.Out = os.Stderr
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	table := newProgressTable(opts.Out)
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		statuses, err := k.readiness(state, opts.Deleted)
+		if err != nil {
+			return err
+		}
+		table.render(statuses)
+
+		pending, failed := 0, 0
+		for _, s := range statuses {
+			switch s.Status {
+			case ReadinessCurrent:
+			case ReadinessFailed:
+				failed++
+				pending++
+			default:
+				pending++
+			}
+		}
+
+		switch {
+		case pending == 0:
+			return nil
+		case failed == pending:
+			return k.waitFailed(fmt.Sprintf("%d resources failed", failed), statuses)
+		}
+
+		select {
+		case <-ctx.Done():
+			// interrupted, rather than timed out
+			if ctx.Err() == context.Canceled {
+				return fmt.Errorf("waiting for resources: %w", ctx.Err())
+			}
+			return k.waitFailed(fmt.Sprintf("timed out after %s", opts.Timeout), statuses)
+		case <-ticker.C:
+		}
+	}
+}
+
+// readiness returns the current ObjectStatus of every object in state
+func (k *Kubernetes) readiness(state manifest.List, deleted bool) ([]ObjectStatus, error) {
+	live, err := k.ctl.GetByState(state, client.GetByStateOpts{IgnoreNotFound: true})
+	if _, ok := err.(client.ErrorNothingReturned); ok {
+		live = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	index := indexObjects(live)
+
+	statuses := make([]ObjectStatus, 0, len(state))
+	for _, m := range state {
+		l, ok := lookupObject(index, m)
+
+		s := ObjectStatus{Manifest: m}
+		switch {
+		case deleted && ok:
+			s.Status, s.Message = ReadinessInProgress, "Waiting for deletion"
+		case deleted:
+			s.Status, s.Message = ReadinessCurrent, "Deleted"
+		case !ok:
+			s.Status, s.Message = ReadinessNotFound, "Resource not found"
+		default:
+			s.Status, s.Message = Status(l)
+		}
+		statuses = append(statuses, s)
+	}
+
+	return statuses, nil
+}
+
+func objectKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// maxEvents is the number of most recent events included in ErrorWaitFailed
+const maxEvents = 5
+
+// waitFailed builds ErrorWaitFailed from all objects that are not ready,
+// including their most recent events
+func (k *Kubernetes) waitFailed(reason string, statuses []ObjectStatus) error {
+	e := ErrorWaitFailed{Reason: reason}
+	for _, s := range statuses {
+		if s.Status == ReadinessCurrent {
+			continue
+		}
+
+		events, err := k.ctl.Events(s.Manifest.Metadata().Namespace(), s.Manifest.Kind(), s.Manifest.Metadata().Name())
+		if err != nil {
+			log.Debug().Err(err).Str("resource", s.Manifest.KindName()).Msg("failed to fetch events")
+		}
+		s.Events = formatEvents(events)
+		e.Objects = append(e.Objects, s)
+	}
+	return e
+}
+
+// formatEvents returns the most recent events, one line each
+func formatEvents(events manifest.List) []string {
+	timestamp := func(m manifest.Manifest) string {
+		for _, key := range []string{"lastTimestamp", "eventTime", "firstTimestamp"} {
+			if t, ok := m[key].(string); ok && t != "" {
+				return t
+			}
+		}
+		return ""
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return timestamp(events[i]) < timestamp(events[j])
+	})
+	if len(events) > maxEvents {
+		events = events[len(events)-maxEvents:]
+	}
+
+	lines := make([]string, 0, len(events))
+	for _, ev := range events {
+		lines = append(lines, fmt.Sprintf("%s %s: %s", ev["type"], ev["reason"], strings.TrimSpace(fmt.Sprint(ev["message"]))))
+	}
+	return lines
+}
+
+// progressTable renders ObjectStatuses as a table. On a terminal, the
+// previous table is overwritten in place. Otherwise a new table is only
+// printed when something changed.
+type progressTable struct {
+	out   io.Writer
+	tty   bool
+	lines int
+	last  string
+}
+
+func newProgressTable(out io.Writer) *progressTable {
+	tty := false
+	if f, ok := out.(*os.File); ok {
+		tty = term.IsTerminal(int(f.Fd()))
+	}
+	return &progressTable{out: out, tty: tty}
+}
+
+func (p *progressTable) render(statuses []ObjectStatus) {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tNAMESPACE\tSTATUS\tMESSAGE")
+	for _, s := range statuses {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.Manifest.Kind(), s.Manifest.Metadata().Name(), s.Manifest.Metadata().Namespace(), s.Status, s.Message)
+	}
+	w.Flush()
+
+	table := buf.String()
+	if !p.tty && table == p.last {
+		return
+	}
+
+	if p.tty && p.lines > 0 {
+		// move the cursor up and clear the old table
+		fmt.Fprintf(p.out, "\033[%dA\033[J", p.lines)
+	} else if p.last != "" {
+		fmt.Fprintln(p.out)
+	}
+	fmt.Fprint(p.out, table)
+
+	p.last = table
+	p.lines = strings.Count(table, "\n")
+}
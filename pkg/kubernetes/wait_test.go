@@ -0,0 +1,128 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+// waitClient returns the next entry of states on every GetByState call,
+// repeating the last one once exhausted.
+type waitClient struct {
+	fakeClient
+	states []manifest.List
+	calls  int
+	events manifest.List
+}
+
+func (w *waitClient) GetByState(data manifest.List, opts client.GetByStateOpts) (manifest.List, error) {
+	i := w.calls
+	if i >= len(w.states) {
+		i = len(w.states) - 1
+	}
+	w.calls++
+	if len(w.states[i]) == 0 {
+		return nil, client.ErrorNothingReturned{}
+	}
+	return w.states[i], nil
+}
+
+func (w *waitClient) Events(namespace, kind, name string) (manifest.List, error) {
+	return w.events, nil
+}
+
+func waitDeployment(ready float64) manifest.Manifest {
+	return manifest.Manifest{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "app", "namespace": "default"},
+		"spec":       map[string]interface{}{"replicas": float64(1)},
+		"status": map[string]interface{}{
+			"replicas":          float64(1),
+			"updatedReplicas":   float64(1),
+			"availableReplicas": ready,
+			"readyReplicas":     ready,
+		},
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	wc := &waitClient{states: []manifest.List{
+		{},
+		{waitDeployment(0)},
+		{waitDeployment(1)},
+	}}
+	k := &Kubernetes{Env: testEnv(), ctl: wc}
+
+	out := &bytes.Buffer{}
+	err := k.Wait(context.Background(), manifest.List{waitDeployment(0)}, WaitOpts{Interval: time.Millisecond, Out: out})
+	require.NoError(t, err)
+	assert.Equal(t, 3, wc.calls)
+	assert.Contains(t, out.String(), "NotFound")
+	assert.Contains(t, out.String(), "Available: 0/1")
+	assert.Contains(t, out.String(), "Current")
+}
+
+func TestWaitTimeout(t *testing.T) {
+	wc := &waitClient{
+		states: []manifest.List{{waitDeployment(0)}},
+		events: manifest.List{
+			{"type": "Warning", "reason": "FailedScheduling", "message": "0/3 nodes are available", "lastTimestamp": "2024-01-01T00:00:02Z"},
+			{"type": "Normal", "reason": "ScalingReplicaSet", "message": "Scaled up", "lastTimestamp": "2024-01-01T00:00:01Z"},
+		},
+	}
+	k := &Kubernetes{Env: testEnv(), ctl: wc}
+
+	out := &bytes.Buffer{}
+	err := k.Wait(context.Background(), manifest.List{waitDeployment(0)}, WaitOpts{Timeout: 20 * time.Millisecond, Interval: time.Millisecond, Out: out})
+	require.Error(t, err)
+
+	var waitErr ErrorWaitFailed
+	require.ErrorAs(t, err, &waitErr)
+	require.Len(t, waitErr.Objects, 1)
+	assert.Equal(t, ReadinessInProgress, waitErr.Objects[0].Status)
+	assert.Equal(t, []string{
+		"Normal ScalingReplicaSet: Scaled up",
+		"Warning FailedScheduling: 0/3 nodes are available",
+	}, waitErr.Objects[0].Events)
+	assert.Contains(t, err.Error(), "timed out after 20ms")
+
+	// the table is only printed again when something changes
+	assert.Equal(t, 1, bytes.Count(out.Bytes(), []byte("KIND")))
+}
+
+func TestWaitFailed(t *testing.T) {
+	failed := waitDeployment(0)
+	failed["status"].(map[string]interface{})["conditions"] = []interface{}{
+		map[string]interface{}{"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded"},
+	}
+	wc := &waitClient{states: []manifest.List{{failed}}}
+	k := &Kubernetes{Env: testEnv(), ctl: wc}
+
+	err := k.Wait(context.Background(), manifest.List{waitDeployment(0)}, WaitOpts{Interval: time.Millisecond, Out: &bytes.Buffer{}})
+	var waitErr ErrorWaitFailed
+	require.ErrorAs(t, err, &waitErr)
+	assert.Equal(t, ReadinessFailed, waitErr.Objects[0].Status)
+	assert.Equal(t, 1, wc.calls)
+}
+
+func TestWaitDeleted(t *testing.T) {
+	wc := &waitClient{states: []manifest.List{
+		{waitDeployment(1)},
+		{},
+	}}
+	k := &Kubernetes{Env: testEnv(), ctl: wc}
+
+	out := &bytes.Buffer{}
+	err := k.Wait(context.Background(), manifest.List{waitDeployment(1)}, WaitOpts{Deleted: true, Interval: time.Millisecond, Out: out})
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "Waiting for deletion")
+	assert.Contains(t, out.String(), "Deleted")
+}
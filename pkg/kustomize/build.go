@@ -2,18 +2,77 @@ package kustomize
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/grafana/tanka/pkg/kubernetes/manifest"
 	"github.com/pkg/errors"
 	yaml "gopkg.in/yaml.v3"
+	"sigs.k8s.io/kustomize/api/types"
+	sigsyaml "sigs.k8s.io/yaml"
 )
 
+// BuildOpts are additional, non-required options for Kustomize.Build
+type BuildOpts struct {
+	// EnableHelm allows the helmCharts field, inflating charts using the
+	// `helm` binary
+	EnableHelm bool `json:"enableHelm"`
+	// LoadRestrictor limits which files a Kustomization may load. One of
+	// LoadRestrictionsRootOnly (default) or LoadRestrictionsNone
+	LoadRestrictor string `json:"loadRestrictor"`
+	// EnableAlphaPlugins enables Kustomize plugins
+	EnableAlphaPlugins bool `json:"enableAlphaPlugins"`
+	// Images overrides images, like the images field of a Kustomization
+	Images []types.Image `json:"images"`
+}
+
+// loadRestrictions parses LoadRestrictor
+func (b BuildOpts) loadRestrictions() (types.LoadRestrictions, error) {
+	switch b.LoadRestrictor {
+	case "", types.LoadRestrictionsRootOnly.String():
+		return types.LoadRestrictionsRootOnly, nil
+	case types.LoadRestrictionsNone.String():
+		return types.LoadRestrictionsNone, nil
+	}
+	return types.LoadRestrictionsUnknown, fmt.Errorf("invalid loadRestrictor %q, must be one of %s or %s", b.LoadRestrictor, types.LoadRestrictionsRootOnly, types.LoadRestrictionsNone)
+}
+
+// Flags returns all options apart from Images as their respective `kustomize
+// build` flag equivalent
+func (b BuildOpts) Flags() []string {
+	var flags []string
+
+	if b.EnableHelm {
+		flags = append(flags, "--enable-helm", "--helm-command="+helmCommand())
+	}
+
+	if b.LoadRestrictor != "" {
+		flags = append(flags, "--load-restrictor="+b.LoadRestrictor)
+	}
+
+	if b.EnableAlphaPlugins {
+		flags = append(flags, "--enable-alpha-plugins")
+	}
+
+	return flags
+}
+
 // Build expands a Kustomize into a regular manifest.List using the `kustomize
 // build` command
-func (k ExecKustomize) Build(path string) (manifest.List, error) {
-	cmd := k.cmd("build", path)
+func (k ExecKustomize) Build(path string, opts BuildOpts) (manifest.List, error) {
+	if _, err := opts.loadRestrictions(); err != nil {
+		return nil, err
+	}
+
+	path, cleanup, err := withImages(path, opts.Images)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	cmd := k.cmd("build", append([]string{path}, opts.Flags()...)...)
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
 	cmd.Stderr = os.Stderr
@@ -22,8 +81,54 @@ func (k ExecKustomize) Build(path string) (manifest.List, error) {
 		return nil, errors.Wrap(err, "Expanding Kustomize")
 	}
 
+	return parseManifests(&buf)
+}
+
+// withImages returns a temporary Kustomization that overrides the images of
+// the one at path, as `kustomize build` has no flag for this. Returns path
+// itself if there are no images.
+func withImages(path string, images []types.Image) (string, func(), error) {
+	if len(images) == 0 {
+		return path, func() {}, nil
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp("", "tanka-kustomize-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	// Kustomize does not accept absolute resource paths
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	data, err := sigsyaml.Marshal(map[string]interface{}{
+		"apiVersion": types.KustomizationVersion,
+		"kind":       types.KustomizationKind,
+		"resources":  []string{filepath.ToSlash(rel)},
+		"images":     images,
+	})
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "kustomization.yaml"), data, 0644); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return dir, cleanup, nil
+}
+
+// parseManifests parses the YAML stream output by `kustomize build`
+func parseManifests(r io.Reader) (manifest.List, error) {
 	var list manifest.List
-	d := yaml.NewDecoder(&buf)
+	d := yaml.NewDecoder(r)
 	for {
 		var m manifest.Manifest
 		if err := d.Decode(&m); err != nil {
@@ -0,0 +1,198 @@
+package kustomize
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/grafana/tanka/pkg/cache"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/rs/zerolog/log"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// kustomizationFiles are the names Kustomize looks for in a directory
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// build builds the Kustomization at path, using the disk cache if one is
+// configured and all inputs of the Kustomization are local
+func build(k Kustomize, path string, opts BuildOpts) (manifest.List, error) {
+	disk := cache.Templates()
+	if disk == nil {
+		return k.Build(path, opts)
+	}
+
+	hash, ok := hashInputs(path)
+	if !ok {
+		log.Debug().Msgf("Not caching Kustomization %s, as it has non-local inputs", path)
+		return k.Build(path, opts)
+	}
+	optsJSON, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+	key := cache.Key("kustomizeBuild", fmt.Sprintf("%T", k), string(optsJSON), hash)
+
+	var list manifest.List
+	if ok, err := disk.Get(key, &list); err != nil {
+		return nil, err
+	} else if ok {
+		log.Debug().Msgf("Using build of %s from disk cache", path)
+		return list, nil
+	}
+
+	list, err = k.Build(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := disk.Store(key, list); err != nil {
+		log.Warn().Err(err).Msgf("Failed to cache build of %s", path)
+	}
+	return list, nil
+}
+
+// hashInputs returns a hash over all files the Kustomization at root may
+// read: its own directory, the local resources, bases and components it
+// includes (recursively) and any other file it refers to. Returns false if
+// the Kustomization has remote inputs or cannot be read.
+func hashInputs(root string) (string, bool) {
+	root = filepath.Clean(root)
+	in := inputs{dirs: map[string]bool{}, files: map[string]bool{}, visited: map[string]bool{}}
+	if !in.collect(root) {
+		return "", false
+	}
+
+	var paths []string
+	for p := range in.dirs {
+		paths = append(paths, p)
+	}
+	for p := range in.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, p := range paths {
+		var sum string
+		if in.dirs[p] {
+			s, err := cache.HashDir(p)
+			if err != nil {
+				return "", false
+			}
+			sum = s
+		} else {
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return "", false
+			}
+			sum = fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return "", false
+		}
+		fmt.Fprintf(h, "%s  %s\n", sum, filepath.ToSlash(rel))
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), true
+}
+
+type inputs struct {
+	dirs, files map[string]bool
+	visited     map[string]bool
+}
+
+// collect adds the Kustomization directory dir and everything it refers to
+func (in inputs) collect(dir string) bool {
+	if in.visited[dir] {
+		return true
+	}
+	in.visited[dir] = true
+	in.dirs[dir] = true
+
+	var k map[string]interface{}
+	for _, name := range kustomizationFiles {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return false
+		}
+		if err := yaml.Unmarshal(data, &k); err != nil {
+			return false
+		}
+		break
+	}
+
+	for _, field := range []string{"resources", "bases", "components"} {
+		entries, _ := k[field].([]interface{})
+		for _, e := range entries {
+			s, ok := e.(string)
+			if !ok {
+				return false
+			}
+
+			// anything that is not on disk is a remote resource
+			p := filepath.Join(dir, s)
+			info, err := os.Stat(p)
+			if err != nil {
+				return false
+			}
+			if !info.IsDir() {
+				in.files[p] = true
+			} else if !in.collect(p) {
+				return false
+			}
+		}
+	}
+
+	// charts that are not vendored yet are pulled from their repository
+	chartHome := "charts"
+	if globals, ok := k["helmGlobals"].(map[string]interface{}); ok {
+		if home, ok := globals["chartHome"].(string); ok && home != "" {
+			chartHome = home
+		}
+	}
+	charts, _ := k["helmCharts"].([]interface{})
+	for _, c := range charts {
+		chart, _ := c.(map[string]interface{})
+		if repo, _ := chart["repo"].(string); repo == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, chartHome)); err != nil {
+			return false
+		}
+	}
+
+	// other fields, like patches or generators, may refer to files outside of
+	// dir as well
+	walkStrings(k, func(s string) {
+		p := filepath.Join(dir, s)
+		if info, err := os.Stat(p); err != nil {
+			return
+		} else if info.IsDir() {
+			in.dirs[p] = true
+		} else {
+			in.files[p] = true
+		}
+	})
+	return true
+}
+
+func walkStrings(v interface{}, f func(string)) {
+	switch v := v.(type) {
+	case string:
+		f(v)
+	case map[string]interface{}:
+		for _, e := range v {
+			walkStrings(e, f)
+		}
+	case []interface{}:
+		for _, e := range v {
+			walkStrings(e, f)
+		}
+	}
+}
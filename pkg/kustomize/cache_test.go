@@ -0,0 +1,128 @@
+package kustomize
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/tanka/pkg/cache"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+// countingKustomize returns a fixed ConfigMap and counts the builds
+type countingKustomize struct {
+	builds int
+}
+
+func (k *countingKustomize) Build(path string, opts BuildOpts) (manifest.List, error) {
+	k.builds++
+	return manifest.List{{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": filepath.Base(path)},
+	}}, nil
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+}
+
+func TestHashInputs(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"base/kustomization.yaml":    "resources: [deployment.yaml]\n",
+		"base/deployment.yaml":       "kind: Deployment\n",
+		"shared/patch.yaml":          "kind: Deployment\n",
+		"overlay/kustomization.yaml": "resources: [../base]\npatches:\n  - path: ../shared/patch.yaml\n",
+		"remote/kustomization.yaml":  "resources: [https://github.com/example/repo//deploy?ref=v1]\n",
+	})
+
+	hash, ok := hashInputs(filepath.Join(dir, "overlay"))
+	require.True(t, ok)
+
+	// resources of bases are inputs
+	writeFiles(t, dir, map[string]string{"base/deployment.yaml": "kind: StatefulSet\n"})
+	changed, ok := hashInputs(filepath.Join(dir, "overlay"))
+	require.True(t, ok)
+	assert.NotEqual(t, hash, changed)
+
+	// so are files referred to by other fields
+	writeFiles(t, dir, map[string]string{"shared/patch.yaml": "kind: StatefulSet\n"})
+	patched, ok := hashInputs(filepath.Join(dir, "overlay"))
+	require.True(t, ok)
+	assert.NotEqual(t, changed, patched)
+
+	// unrelated files are not
+	writeFiles(t, dir, map[string]string{"unrelated.yaml": "kind: Service\n"})
+	same, ok := hashInputs(filepath.Join(dir, "overlay"))
+	require.True(t, ok)
+	assert.Equal(t, patched, same)
+
+	_, ok = hashInputs(filepath.Join(dir, "remote"))
+	assert.False(t, ok)
+}
+
+func TestClearBuildCache(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"local/kustomization.yaml": "resources: []\n"})
+
+	k := &countingKustomize{}
+	build := func() {
+		_, err := NativeFunc(k).Func([]interface{}{"./local", map[string]interface{}{
+			"calledFrom": filepath.Join(dir, "main.jsonnet"),
+		}})
+		require.NoError(t, err)
+	}
+
+	ClearBuildCache()
+	build()
+	build()
+	assert.Equal(t, 1, k.builds)
+
+	ClearBuildCache()
+	build()
+	assert.Equal(t, 2, k.builds)
+}
+
+func TestBuildDiskCache(t *testing.T) {
+	cache.SetTemplates(&cache.Disk{Directory: t.TempDir()})
+	t.Cleanup(func() { cache.SetTemplates(nil) })
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"local/kustomization.yaml":  "resources: [configmap.yaml]\n",
+		"local/configmap.yaml":      "kind: ConfigMap\n",
+		"remote/kustomization.yaml": "resources: [github.com/example/repo/deploy]\n",
+	})
+
+	k := &countingKustomize{}
+	build := func(path string) interface{} {
+		// a new process starts with an empty in-memory cache
+		ClearBuildCache()
+		out, err := NativeFunc(k).Func([]interface{}{path, map[string]interface{}{
+			"calledFrom": filepath.Join(dir, "main.jsonnet"),
+		}})
+		require.NoError(t, err)
+		return out
+	}
+
+	first := build("./local")
+	assert.Equal(t, first, build("./local"))
+	assert.Equal(t, 1, k.builds)
+
+	writeFiles(t, dir, map[string]string{"local/configmap.yaml": "kind: Secret\n"})
+	build("./local")
+	assert.Equal(t, 2, k.builds)
+
+	// remote resources may change at any time
+	build("./remote")
+	build("./remote")
+	assert.Equal(t, 4, k.builds)
+}
@@ -0,0 +1,91 @@
+package kustomize
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/grafana/tanka/pkg/cache"
+	"github.com/grafana/tanka/pkg/git"
+)
+
+// fetch vendors r to dest, which must not exist yet, and returns what was
+// fetched. Git sources are checked out at ref
+func fetch(r Requirement, ref, dest string) (LockedSource, error) {
+	fetched := LockedSource{Requirement: r}
+	fetched.Directory = r.directory()
+
+	var err error
+	if r.Git != "" {
+		fetched.Commit, err = fetchGit(r, ref, dest)
+	} else {
+		fetched.Digest, err = fetchHTTP(r, dest)
+	}
+	if err != nil {
+		os.RemoveAll(dest)
+		return fetched, fmt.Errorf("fetching %s: %w", r, err)
+	}
+
+	fetched.Hash, err = cache.HashDir(dest)
+	if err != nil {
+		os.RemoveAll(dest)
+		return fetched, err
+	}
+	return fetched, nil
+}
+
+// fetchGit checks out r.Path of the git repository at ref to dest, and
+// returns the commit that was checked out
+func fetchGit(r Requirement, ref, dest string) (string, error) {
+	// clone next to dest, so the result can be moved into place
+	tmp, err := os.MkdirTemp(filepath.Dir(dest), ".fetch-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	commit, err := git.Checkout(r.Git, ref, tmp)
+	if err != nil {
+		return "", err
+	}
+
+	src := filepath.Join(tmp, filepath.FromSlash(r.Path))
+	if info, err := os.Stat(src); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("path %q is not a directory of the repository", r.Path)
+	}
+	if err := os.RemoveAll(filepath.Join(src, ".git")); err != nil {
+		return "", err
+	}
+
+	return commit, os.Rename(src, dest)
+}
+
+// fetchHTTP downloads r.HTTP into dest and returns its digest. If r pins a
+// digest, the download must match it.
+func fetchHTTP(r Requirement, dest string) (string, error) {
+	resp, err := http.Get(r.HTTP)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	if r.Digest != "" && digest != r.Digest {
+		return "", fmt.Errorf("digest mismatch: expected %s, got %s", r.Digest, digest)
+	}
+
+	if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+		return "", err
+	}
+	return digest, os.WriteFile(filepath.Join(dest, r.filename()), data, 0644)
+}
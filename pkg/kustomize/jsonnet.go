@@ -5,15 +5,29 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/google/go-jsonnet"
 	"github.com/google/go-jsonnet/ast"
 	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/rs/zerolog/log"
 )
 
+// kustomizeBuildCache caches the built Kustomizations, as manifest.List
+var kustomizeBuildCache sync.Map
+
+// ClearBuildCache forgets all built Kustomizations. Long-running processes
+// evaluating environments repeatedly need to call it in between, so changes
+// to the Kustomizations are picked up.
+func ClearBuildCache() {
+	kustomizeBuildCache.Clear()
+}
+
 // JsonnetOpts are additional properties the consumer of the native func might
 // pass.
 type JsonnetOpts struct {
+	BuildOpts
+
 	// CalledFrom is the file that calls kustomizeBuild. This is used to find the
 	// vendored Kustomize relative to this file
 	CalledFrom string `json:"calledFrom"`
@@ -50,11 +64,24 @@ func NativeFunc(k Kustomize) *jsonnet.NativeFunction {
 				return nil, fmt.Errorf("kustomizeBuild: Failed to find kustomization at '%s': %s. See https://tanka.dev/kustomize#failed-to-find-kustomization", actualPath, err)
 			}
 
-			// render resources
-			list, err := k.Build(actualPath)
+			// check if resources exist in cache
+			key, err := buildKey(actualPath, opts.BuildOpts)
 			if err != nil {
 				return nil, err
 			}
+			cached, ok := kustomizeBuildCache.Load(key)
+			var list manifest.List
+			if ok {
+				log.Debug().Msgf("Using cached build of %s", actualPath)
+				list = cached.(manifest.List)
+			} else {
+				// render resources
+				list, err = build(k, actualPath, opts.BuildOpts)
+				if err != nil {
+					return nil, err
+				}
+				kustomizeBuildCache.Store(key, list)
+			}
 
 			// convert list to map
 			out, err := manifest.ListAsMap(list, opts.NameFormat)
@@ -67,6 +94,20 @@ func NativeFunc(k Kustomize) *jsonnet.NativeFunction {
 	}
 }
 
+// buildKey returns the key used in kustomizeBuildCache for the Kustomization
+// at path
+func buildKey(path string, opts BuildOpts) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	optsJSON, err := json.Marshal(opts)
+	if err != nil {
+		return "", err
+	}
+	return abs + "\x00" + string(optsJSON), nil
+}
+
 func parseOpts(data interface{}) (*JsonnetOpts, error) {
 	c, err := json.Marshal(data)
 	if err != nil {
@@ -77,6 +118,10 @@ func parseOpts(data interface{}) (*JsonnetOpts, error) {
 		return nil, err
 	}
 
+	if _, err := opts.loadRestrictions(); err != nil {
+		return nil, fmt.Errorf("kustomizeBuild: %w", err)
+	}
+
 	// Kustomize paths are only allowed at relative paths. Use conf.CalledFrom to find the callers directory
 	if opts.CalledFrom == "" {
 		return nil, fmt.Errorf("kustomizeBuild: 'opts.calledFrom' is unset or empty.\nTanka needs this to find your Kustomize. See https://tanka.dev/kustomize#optscalledfrom-unset")
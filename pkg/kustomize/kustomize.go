@@ -4,13 +4,33 @@ import (
 	"os"
 	"os/exec"
 
+	"github.com/rs/zerolog/log"
+
 	"github.com/grafana/tanka/pkg/kubernetes/manifest"
 )
 
+// EnvBuildEngine selects the Kustomize implementation used by kustomizeBuild:
+// "sdk" (default) builds in-process, "exec" runs the `kustomize` binary
+const EnvBuildEngine = "TANKA_KUSTOMIZE_BUILD"
+
 // Kustomize provides high level access to some Kustomize operations
 type Kustomize interface {
 	// Build returns the individual resources of a Kustomize
-	Build(path string) (manifest.List, error)
+	Build(path string, opts BuildOpts) (manifest.List, error)
+}
+
+// DefaultKustomize returns the Kustomize implementation to use for building,
+// as selected by EnvBuildEngine
+func DefaultKustomize() Kustomize {
+	switch engine := os.Getenv(EnvBuildEngine); engine {
+	case "exec":
+		return ExecKustomize{}
+	case "", "sdk":
+		return SDKKustomize{}
+	default:
+		log.Warn().Msgf("Unknown %s=%q, building Kustomizations in-process", EnvBuildEngine, engine)
+		return SDKKustomize{}
+	}
 }
 
 // ExecKustomize is a Kustomize implementation powered by the `kustomize`
@@ -37,3 +57,11 @@ func kustomizeCmd(args ...string) *exec.Cmd {
 
 	return exec.Command(bin, args...)
 }
+
+// helmCommand returns the `helm` binary Kustomize uses to inflate charts
+func helmCommand() string {
+	if env := os.Getenv("TANKA_HELM_PATH"); env != "" {
+		return env
+	}
+	return "helm"
+}
@@ -0,0 +1,319 @@
+package kustomize
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"sigs.k8s.io/yaml"
+
+	"github.com/grafana/tanka/pkg/cache"
+)
+
+const (
+	// Version of the current Kustomizefile implementation
+	Version = 1
+
+	// Filename of the Kustomizefile
+	Filename = "kustomizefile.yaml"
+
+	// DefaultDir is the directory used for storing remote resources if not
+	// specified otherwise
+	DefaultDir = "kustomize"
+)
+
+var digestExp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// Kustomizefile is the schema used to declaratively define remote resources
+// of Kustomizations, so they can be vendored like Helm Charts
+type Kustomizefile struct {
+	// Version of the Kustomizefile schema (for future use)
+	Version uint `json:"version"`
+
+	// Requires lists resources expected to be present in the vendor folder
+	Requires Requirements `json:"requires"`
+
+	// Folder to use for storing resources. Defaults to 'kustomize'
+	Directory string `json:"directory,omitempty"`
+}
+
+// Requirement describes a single remote resource. It is either a directory of
+// a git repository at a pinned Ref, or a single file downloaded over HTTP.
+type Requirement struct {
+	// Git is the URL of a git repository
+	Git string `json:"git,omitempty"`
+	// Ref is the tag, branch or commit of Git to vendor
+	Ref string `json:"ref,omitempty"`
+	// Path is the directory inside of Git to vendor. Defaults to the whole
+	// repository
+	Path string `json:"path,omitempty"`
+
+	// HTTP is the URL of a single file
+	HTTP string `json:"http,omitempty"`
+	// Digest pins the file downloaded from HTTP (sha256:...)
+	Digest string `json:"digest,omitempty"`
+
+	// Directory the resource is vendored to. Defaults to the last element of
+	// Path, or the name of the repository or file
+	Directory string `json:"directory,omitempty"`
+}
+
+func (r Requirement) String() string {
+	if r.Git != "" {
+		src := r.Git
+		if r.Path != "" {
+			src += "//" + r.Path
+		}
+		return fmt.Sprintf("%s@%s (dir: %s)", src, r.Ref, r.directory())
+	}
+	return fmt.Sprintf("%s (dir: %s)", r.HTTP, r.directory())
+}
+
+// directory returns the directory r is vendored to
+func (r Requirement) directory() string {
+	switch {
+	case r.Directory != "":
+		return r.Directory
+	case r.Path != "":
+		return path.Base(r.Path)
+	case r.Git != "":
+		return strings.TrimSuffix(path.Base(urlPath(r.Git)), ".git")
+	default:
+		name := path.Base(urlPath(r.HTTP))
+		return strings.TrimSuffix(name, path.Ext(name))
+	}
+}
+
+// filename returns the name of the file downloaded from HTTP
+func (r Requirement) filename() string {
+	return path.Base(urlPath(r.HTTP))
+}
+
+// urlPath returns the path of a URL, or s itself if it is not a valid URL,
+// such as scp-like git remotes (git@github.com:org/repo)
+func urlPath(s string) string {
+	if u, err := url.Parse(s); err == nil && u.Path != "" {
+		return u.Path
+	}
+	return s
+}
+
+// Requirements is an aggregate of all required resources
+type Requirements []Requirement
+
+// Validate checks that each requirement is complete and that no two
+// requirements are vendored to the same directory
+func (r Requirements) Validate() error {
+	outputDirs := make(map[string]Requirement)
+	errs := make([]string, 0)
+
+	for i, req := range r {
+		switch {
+		case req.Git == "" && req.HTTP == "":
+			errs = append(errs, fmt.Sprintf("requires[%v]: either 'git' or 'http' must be set", i))
+			continue
+		case req.Git != "" && req.HTTP != "":
+			errs = append(errs, fmt.Sprintf("requires[%v]: 'git' and 'http' cannot be used together", i))
+			continue
+		case req.Git != "" && req.Ref == "":
+			errs = append(errs, fmt.Sprintf("%s: 'ref' must be set for git sources, to pin a tag, branch or commit", req))
+			continue
+		case req.Git != "" && req.Digest != "":
+			errs = append(errs, fmt.Sprintf("%s: 'digest' is only supported for http sources", req))
+			continue
+		case req.HTTP != "" && (req.Ref != "" || req.Path != ""):
+			errs = append(errs, fmt.Sprintf("%s: 'ref' and 'path' are only supported for git sources", req))
+			continue
+		case req.Digest != "" && !digestExp.MatchString(req.Digest):
+			errs = append(errs, fmt.Sprintf("%s: digest %q is not valid. Expecting sha256:<hex>.", req, req.Digest))
+			continue
+		case req.Path != "" && !filepath.IsLocal(req.Path):
+			errs = append(errs, fmt.Sprintf("%s: path %q must be relative to the repository root", req, req.Path))
+			continue
+		}
+
+		dir := req.directory()
+		if !filepath.IsLocal(dir) || strings.ContainsAny(dir, `/\`) {
+			errs = append(errs, fmt.Sprintf("%s: directory %q must be a plain directory name", req, dir))
+			continue
+		}
+		if previous, ok := outputDirs[dir]; ok {
+			errs = append(errs, fmt.Sprintf(`output directory %q is used twice, by %s and %s`, dir, previous, req))
+		}
+		outputDirs[dir] = req
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("validation errors:\n - %s", strings.Join(errs, "\n - "))
+	}
+
+	return nil
+}
+
+// LoadKustomizefile opens a Kustomizefile tree
+func LoadKustomizefile(projectRoot string) (*Remotes, error) {
+	// make sure project root is valid
+	abs, err := filepath.Abs(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	// open kustomizefile
+	data, err := os.ReadFile(filepath.Join(abs, Filename))
+	if err != nil {
+		return nil, err
+	}
+
+	// parse it
+	k := Kustomizefile{
+		Version:   Version,
+		Directory: DefaultDir,
+	}
+	if err := yaml.UnmarshalStrict(data, &k); err != nil {
+		return nil, err
+	}
+
+	return &Remotes{
+		Manifest:    k,
+		projectRoot: abs,
+	}, nil
+}
+
+// InitKustomizefile creates an empty Kustomizefile at path
+func InitKustomizefile(path string) (*Remotes, error) {
+	k := Kustomizefile{
+		Version:  Version,
+		Requires: make(Requirements, 0),
+	}
+
+	if err := write(k, path); err != nil {
+		return nil, err
+	}
+
+	return LoadKustomizefile(filepath.Dir(path))
+}
+
+// Remotes exposes the central Kustomizefile management functions
+type Remotes struct {
+	// Manifest are the kustomizefile.yaml contents
+	Manifest Kustomizefile
+
+	// projectRoot is the enclosing directory of kustomizefile.yaml
+	projectRoot string
+}
+
+// Dir returns the directory remote resources are vendored to
+func (k Remotes) Dir() string {
+	return filepath.Join(k.projectRoot, k.Manifest.Directory)
+}
+
+// ManifestFile returns the full path to the kustomizefile.yaml
+func (k Remotes) ManifestFile() string {
+	return filepath.Join(k.projectRoot, Filename)
+}
+
+// Vendor fetches all resources specified in the manifest into the local
+// vendor directory. What was fetched is recorded in the lock file. Resources
+// that are locked and present are not fetched again.
+func (k Remotes) Vendor(prune bool) error {
+	dir := k.Dir()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	// Check that there are no output conflicts before vendoring
+	if err := k.Manifest.Requires.Validate(); err != nil {
+		return err
+	}
+
+	lock, err := loadLock(k.LockFile())
+	if err != nil {
+		return err
+	}
+	newLock := Lockfile{Version: LockVersion}
+
+	expectedDirs := make(map[string]bool)
+
+	log.Info().Msg("Vendoring...")
+	for _, r := range k.Manifest.Requires {
+		subDir := r.directory()
+		dest := filepath.Join(dir, subDir)
+		expectedDirs[subDir] = true
+		locked, isLocked := lock.Find(r)
+
+		if _, err := os.Stat(dest); err == nil {
+			if isLocked {
+				if hash, err := cache.HashDir(dest); err != nil || hash != locked.Hash {
+					log.Warn().Msgf("%s was modified after vendoring", r)
+				}
+				log.Info().Msgf("%s exists", r)
+				newLock.Sources = append(newLock.Sources, locked)
+				continue
+			}
+
+			log.Info().Msgf("%s is missing from %s, fetching it again", r, LockFilename)
+			if err := os.RemoveAll(dest); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		// check out exactly what was locked before, even if the ref moved
+		ref := r.Ref
+		if isLocked && locked.Commit != "" {
+			ref = locked.Commit
+		}
+
+		log.Info().Msgf("Fetching %s ...", r)
+		fetched, err := fetch(r, ref, dest)
+		if err != nil {
+			return err
+		}
+		if isLocked && (fetched.Commit != locked.Commit || fetched.Digest != locked.Digest || fetched.Hash != locked.Hash) {
+			if err := os.RemoveAll(dest); err != nil {
+				return err
+			}
+			return ErrorLockMismatch{Requirement: r, Locked: locked, Fetched: fetched}
+		}
+		newLock.Sources = append(newLock.Sources, fetched)
+
+		log.Info().Msgf("%s fetched (%s)", r, fetched.Hash)
+	}
+
+	if prune {
+		items, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("error listing the content of the vendor dir: %w", err)
+		}
+		for _, i := range items {
+			if !expectedDirs[i.Name()] {
+				itemType := "file"
+				if i.IsDir() {
+					itemType = "directory"
+				}
+				log.Info().Msgf("Pruning %s: %s", itemType, i.Name())
+				if err := os.RemoveAll(filepath.Join(dir, i.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return writeLock(newLock, k.LockFile())
+}
+
+// write saves a Kustomizefile to dest
+func write(k Kustomizefile, dest string) error {
+	data, err := yaml.Marshal(k)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dest, data, 0644)
+}
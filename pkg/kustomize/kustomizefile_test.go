@@ -0,0 +1,189 @@
+package kustomize
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+
+	"github.com/grafana/tanka/pkg/git"
+)
+
+func TestRequirementsValidate(t *testing.T) {
+	digest := "sha256:" + fmt.Sprintf("%064x", 1)
+
+	testCases := []struct {
+		name string
+		reqs Requirements
+		err  string
+	}{
+		{
+			name: "valid",
+			reqs: Requirements{
+				{Git: "https://github.com/fluxcd/flux2.git", Ref: "v0.4.3", Path: "manifests/bases/source-controller"},
+				{Git: "https://github.com/fluxcd/flux2.git", Ref: "v0.4.3"},
+				{HTTP: "https://example.com/crds.yaml", Digest: digest},
+			},
+		},
+		{
+			name: "no-source",
+			reqs: Requirements{{Ref: "v1"}},
+			err:  "requires[0]: either 'git' or 'http' must be set",
+		},
+		{
+			name: "git-without-ref",
+			reqs: Requirements{{Git: "https://github.com/fluxcd/flux2.git"}},
+			err:  "https://github.com/fluxcd/flux2.git@ (dir: flux2): 'ref' must be set for git sources, to pin a tag, branch or commit",
+		},
+		{
+			name: "http-with-ref",
+			reqs: Requirements{{HTTP: "https://example.com/crds.yaml", Ref: "v1"}},
+			err:  "https://example.com/crds.yaml (dir: crds): 'ref' and 'path' are only supported for git sources",
+		},
+		{
+			name: "invalid-digest",
+			reqs: Requirements{{HTTP: "https://example.com/crds.yaml", Digest: "md5:abc"}},
+			err:  `https://example.com/crds.yaml (dir: crds): digest "md5:abc" is not valid. Expecting sha256:<hex>.`,
+		},
+		{
+			name: "path-outside-repo",
+			reqs: Requirements{{Git: "https://github.com/fluxcd/flux2.git", Ref: "v1", Path: "../other", Directory: "other"}},
+			err:  `https://github.com/fluxcd/flux2.git//../other@v1 (dir: other): path "../other" must be relative to the repository root`,
+		},
+		{
+			name: "nested-directory",
+			reqs: Requirements{{HTTP: "https://example.com/crds.yaml", Directory: "a/b"}},
+			err:  `https://example.com/crds.yaml (dir: a/b): directory "a/b" must be a plain directory name`,
+		},
+		{
+			name: "duplicate-directory",
+			reqs: Requirements{
+				{Git: "https://github.com/org/a.git", Ref: "v1", Path: "deploy/base"},
+				{Git: "https://github.com/org/b.git", Ref: "v1", Path: "base"},
+			},
+			err: `output directory "base" is used twice, by https://github.com/org/a.git//deploy/base@v1 (dir: base) and https://github.com/org/b.git//base@v1 (dir: base)`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.reqs.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, "validation errors:\n - "+tc.err)
+		})
+	}
+}
+
+// gitRepo creates a git repository in dir holding files, tagged as tag
+func gitRepo(t *testing.T, dir, tag string, files map[string]string) string {
+	writeFiles(t, dir, files)
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "--allow-empty", "-m", tag},
+		{"tag", "--force", tag},
+	} {
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	commit, err := git.Run(dir, "rev-parse", "HEAD")
+	require.NoError(t, err)
+	return commit
+}
+
+func TestVendor(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repo := filepath.Join(t.TempDir(), "flux2")
+	commit := gitRepo(t, repo, "v1", map[string]string{
+		"manifests/base/kustomization.yaml": "resources: [cm.yaml]\n",
+		"manifests/base/cm.yaml":            "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: flux\n",
+	})
+
+	crds := []byte("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: crds\n")
+	downloads := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		downloads++
+		_, _ = w.Write(crds)
+	}))
+	defer srv.Close()
+
+	project := t.TempDir()
+	data, err := yaml.Marshal(Kustomizefile{
+		Version: Version,
+		Requires: Requirements{
+			{Git: "file://" + repo, Ref: "v1", Path: "manifests/base", Directory: "flux"},
+			{HTTP: srv.URL + "/crds.yaml"},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(project, Filename), data, 0644))
+
+	k, err := LoadKustomizefile(project)
+	require.NoError(t, err)
+	require.NoError(t, k.Vendor(false))
+
+	vendored := filepath.Join(project, DefaultDir)
+	assert.FileExists(t, filepath.Join(vendored, "flux", "cm.yaml"))
+	assert.NoDirExists(t, filepath.Join(vendored, "flux", ".git"))
+	got, err := os.ReadFile(filepath.Join(vendored, "crds", "crds.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, crds, got)
+
+	lock, err := loadLock(k.LockFile())
+	require.NoError(t, err)
+	require.Len(t, lock.Sources, 2)
+	assert.Equal(t, commit, lock.Sources[0].Commit)
+	assert.Equal(t, "flux", lock.Sources[0].Directory)
+	assert.Equal(t, "crds", lock.Sources[1].Directory)
+	assert.Regexp(t, digestExp, lock.Sources[1].Digest)
+
+	// the vendored build works without network access
+	list, err := SDKKustomize{}.Build(filepath.Join(vendored, "flux"), BuildOpts{})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "ConfigMap/flux", list[0].KindName())
+
+	// locked sources are not fetched again, unknown files are pruned
+	require.NoError(t, os.MkdirAll(filepath.Join(vendored, "stale"), 0755))
+	require.NoError(t, k.Vendor(true))
+	assert.Equal(t, 1, downloads)
+	assert.NoDirExists(t, filepath.Join(vendored, "stale"))
+
+	// missing sources are fetched at the locked commit, even if the tag moved
+	gitRepo(t, repo, "v1", map[string]string{
+		"manifests/base/cm.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: moved\n",
+	})
+	require.NoError(t, os.RemoveAll(filepath.Join(vendored, "flux")))
+	require.NoError(t, k.Vendor(false))
+	got, err = os.ReadFile(filepath.Join(vendored, "flux", "cm.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(got), "name: flux")
+
+	// content differing from the lock file is detected
+	lock.Sources[0].Hash = "sha256:" + fmt.Sprintf("%064x", 1)
+	require.NoError(t, writeLock(*lock, k.LockFile()))
+	require.NoError(t, os.RemoveAll(filepath.Join(vendored, "flux")))
+	err = k.Vendor(false)
+	var mismatch ErrorLockMismatch
+	require.ErrorAs(t, err, &mismatch)
+	assert.Equal(t, commit, mismatch.Fetched.Commit)
+	assert.NoDirExists(t, filepath.Join(vendored, "flux"))
+
+	// a pinned digest must match
+	k.Manifest.Requires = Requirements{{HTTP: srv.URL + "/crds.yaml", Digest: "sha256:" + fmt.Sprintf("%064x", 1)}}
+	require.NoError(t, os.RemoveAll(filepath.Join(vendored, "crds")))
+	assert.ErrorContains(t, k.Vendor(false), "digest mismatch")
+}
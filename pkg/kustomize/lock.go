@@ -0,0 +1,104 @@
+package kustomize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// LockVersion of the current lock file implementation
+	LockVersion = 1
+
+	// LockFilename of the lock file, which is kept next to the Kustomizefile
+	LockFilename = "kustomizefile.lock"
+)
+
+// Lockfile records what was vendored for each Requirement of the
+// Kustomizefile
+type Lockfile struct {
+	// Version of the lock file schema
+	Version uint `json:"version"`
+
+	// Sources that were vendored, in the order of the requirements
+	Sources []LockedSource `json:"sources"`
+}
+
+// LockedSource is a vendored remote resource
+type LockedSource struct {
+	Requirement `json:",inline"`
+
+	// Commit that Ref resolved to, for git sources
+	Commit string `json:"commit,omitempty"`
+
+	// Hash of the contents of the vendored directory, see cache.HashDir
+	Hash string `json:"hash"`
+}
+
+// Find returns the locked source for r. The source and directory must match,
+// as well as the digest if r pins one.
+func (l Lockfile) Find(r Requirement) (LockedSource, bool) {
+	for _, s := range l.Sources {
+		if s.Git != r.Git || s.Ref != r.Ref || s.Path != r.Path || s.HTTP != r.HTTP || s.Directory != r.directory() {
+			continue
+		}
+		if r.Digest != "" && s.Digest != r.Digest {
+			continue
+		}
+		return s, true
+	}
+	return LockedSource{}, false
+}
+
+// LockFile returns the full path to the kustomizefile.lock
+func (k Remotes) LockFile() string {
+	return filepath.Join(k.projectRoot, LockFilename)
+}
+
+// loadLock reads the lock file. A missing lock file yields an empty Lockfile
+func loadLock(file string) (*Lockfile, error) {
+	lock := &Lockfile{Version: LockVersion}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return lock, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.UnmarshalStrict(data, lock); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+	return lock, nil
+}
+
+// writeLock saves a Lockfile to dest
+func writeLock(l Lockfile, dest string) error {
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dest, data, 0644)
+}
+
+// ErrorLockMismatch means that upstream no longer serves the locked resource,
+// e.g. because a branch moved or a tag was changed
+type ErrorLockMismatch struct {
+	Requirement     Requirement
+	Locked, Fetched LockedSource
+}
+
+func (e ErrorLockMismatch) Error() string {
+	return fmt.Sprintf(`%s does not match %s:
+  locked:  commit %s, digest %s, content hash %s
+  fetched: commit %s, digest %s, content hash %s
+If this change is expected, remove the source from %s and vendor again`,
+		e.Requirement, LockFilename,
+		e.Locked.Commit, e.Locked.Digest, e.Locked.Hash,
+		e.Fetched.Commit, e.Fetched.Digest, e.Fetched.Hash,
+		LockFilename,
+	)
+}
@@ -0,0 +1,53 @@
+package kustomize
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+// SDKKustomize is a Kustomize implementation that builds Kustomizations
+// in-process using the kustomize Go API (krusty), so no `kustomize` binary
+// is required. Inflating Helm charts still requires the `helm` binary.
+type SDKKustomize struct{}
+
+// Build expands a Kustomize into a regular manifest.List, like `kustomize
+// build` does
+func (k SDKKustomize) Build(path string, opts BuildOpts) (manifest.List, error) {
+	restrictions, err := opts.loadRestrictions()
+	if err != nil {
+		return nil, err
+	}
+
+	path, cleanup, err := withImages(path, opts.Images)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	kopts := krusty.MakeDefaultOptions()
+	// the default of `kustomize build`
+	kopts.Reorder = krusty.ReorderOptionLegacy
+	kopts.LoadRestrictions = restrictions
+	if opts.EnableAlphaPlugins {
+		kopts.PluginConfig = types.EnabledPluginConfig(types.BploUseStaticallyLinked)
+	}
+	kopts.PluginConfig.HelmConfig.Enabled = opts.EnableHelm
+	kopts.PluginConfig.HelmConfig.Command = helmCommand()
+
+	resources, err := krusty.MakeKustomizer(kopts).Run(filesys.MakeFsOnDisk(), path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Expanding Kustomize")
+	}
+	data, err := resources.AsYaml()
+	if err != nil {
+		return nil, errors.Wrap(err, "Expanding Kustomize")
+	}
+
+	return parseManifests(bytes.NewReader(data))
+}
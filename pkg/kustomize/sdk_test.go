@@ -0,0 +1,127 @@
+package kustomize
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/api/types"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+func testKustomization(t *testing.T, dir string) {
+	writeFiles(t, dir, map[string]string{
+		"app/kustomization.yaml": "namePrefix: prod-\nresources: [deployment.yaml, service.yaml]\n",
+		"app/service.yaml": `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+`,
+		"app/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+        - name: web
+          image: nginx:1.25
+`,
+		"outside/kustomization.yaml": "resources: [../shared.yaml]\n",
+		"shared.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: shared
+`,
+	})
+}
+
+func image(m manifest.Manifest) string {
+	containers := m["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"].([]interface{})
+	return containers[0].(map[string]interface{})["image"].(string)
+}
+
+func TestSDKKustomizeBuild(t *testing.T) {
+	dir := t.TempDir()
+	testKustomization(t, dir)
+
+	list, err := SDKKustomize{}.Build(filepath.Join(dir, "app"), BuildOpts{})
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	// legacy order, like `kustomize build`
+	assert.Equal(t, "Service/prod-web", list[0].KindName())
+	assert.Equal(t, "Deployment/prod-web", list[1].KindName())
+	assert.Equal(t, "nginx:1.25", image(list[1]))
+
+	list, err = SDKKustomize{}.Build(filepath.Join(dir, "app"), BuildOpts{
+		Images: []types.Image{{Name: "nginx", NewName: "registry.example.com/nginx", NewTag: "1.27"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "registry.example.com/nginx:1.27", image(list[1]))
+}
+
+func TestSDKKustomizeLoadRestrictor(t *testing.T) {
+	dir := t.TempDir()
+	testKustomization(t, dir)
+
+	_, err := SDKKustomize{}.Build(filepath.Join(dir, "outside"), BuildOpts{})
+	assert.ErrorContains(t, err, "security; file")
+
+	list, err := SDKKustomize{}.Build(filepath.Join(dir, "outside"), BuildOpts{LoadRestrictor: "LoadRestrictionsNone"})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "ConfigMap/shared", list[0].KindName())
+
+	_, err = SDKKustomize{}.Build(filepath.Join(dir, "outside"), BuildOpts{LoadRestrictor: "none"})
+	assert.EqualError(t, err, `invalid loadRestrictor "none", must be one of LoadRestrictionsRootOnly or LoadRestrictionsNone`)
+}
+
+func TestBuildOptsFlags(t *testing.T) {
+	t.Setenv("TANKA_HELM_PATH", "/opt/helm")
+	assert.Equal(t, []string{
+		"--enable-helm",
+		"--helm-command=/opt/helm",
+		"--load-restrictor=LoadRestrictionsNone",
+		"--enable-alpha-plugins",
+	}, BuildOpts{EnableHelm: true, LoadRestrictor: "LoadRestrictionsNone", EnableAlphaPlugins: true}.Flags())
+
+	assert.Empty(t, BuildOpts{}.Flags())
+}
+
+func TestNativeFuncCache(t *testing.T) {
+	dir := t.TempDir()
+	testKustomization(t, dir)
+
+	k := &countingKustomize{}
+	build := func(opts map[string]interface{}) {
+		opts["calledFrom"] = filepath.Join(dir, "main.jsonnet")
+		_, err := NativeFunc(k).Func([]interface{}{"./app", opts})
+		require.NoError(t, err)
+	}
+
+	build(map[string]interface{}{})
+	build(map[string]interface{}{})
+	assert.Equal(t, 1, k.builds)
+
+	// different options are built separately
+	build(map[string]interface{}{"images": []interface{}{map[string]interface{}{"name": "nginx", "newTag": "1.27"}}})
+	assert.Equal(t, 2, k.builds)
+
+	_, err := NativeFunc(k).Func([]interface{}{"./app", map[string]interface{}{
+		"calledFrom":     filepath.Join(dir, "main.jsonnet"),
+		"loadRestrictor": "none",
+	}})
+	assert.ErrorContains(t, err, "kustomizeBuild: invalid loadRestrictor")
+}
+
+func TestDefaultKustomize(t *testing.T) {
+	t.Setenv(EnvBuildEngine, "")
+	assert.Equal(t, SDKKustomize{}, DefaultKustomize())
+
+	t.Setenv(EnvBuildEngine, "exec")
+	assert.Equal(t, ExecKustomize{}, DefaultKustomize())
+}
@@ -0,0 +1,120 @@
+package process
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+// Annotations used by Helm to mark and configure chart hooks, see
+// https://helm.sh/docs/topics/charts_hooks/
+const (
+	AnnotationHelmHook             = "helm.sh/hook"
+	AnnotationHelmHookWeight       = "helm.sh/hook-weight"
+	AnnotationHelmHookDeletePolicy = "helm.sh/hook-delete-policy"
+)
+
+// Helm hook delete policies
+const (
+	HookBeforeCreation = "before-hook-creation"
+	HookSucceeded      = "hook-succeeded"
+	HookFailed         = "hook-failed"
+)
+
+// hookType tells whether m is a Helm hook that runs before (pre) or after
+// (post) installing or upgrading a release. Other hooks, e.g. tests, are not
+// run by `tk apply` and therefore not reported, see IsSkippedHook.
+func hookType(m manifest.Manifest) (string, bool) {
+	s, ok := annotation(m, AnnotationHelmHook)
+	if !ok {
+		return "", false
+	}
+
+	events := splitList(s)
+	for _, e := range events {
+		if e == "pre-install" || e == "pre-upgrade" {
+			return PhasePreHooks, true
+		}
+	}
+	for _, e := range events {
+		if e == "post-install" || e == "post-upgrade" {
+			return PhasePostHooks, true
+		}
+	}
+	return "", false
+}
+
+// IsHook tells whether m is a Helm hook that `tk apply` runs in a hook phase,
+// see Phases
+func IsHook(m manifest.Manifest) bool {
+	if _, ok := annotation(m, AnnotationApplyPhase); ok {
+		return false
+	}
+	_, ok := hookType(m)
+	return ok
+}
+
+// IsSkippedHook tells whether m is a Helm hook of an event that `tk apply` does
+// not run, e.g. a test, pre-delete or pre-rollback hook. These are never
+// applied or diffed, unless moved into a phase using AnnotationApplyPhase.
+func IsSkippedHook(m manifest.Manifest) bool {
+	if _, ok := annotation(m, AnnotationApplyPhase); ok {
+		return false
+	}
+	if _, ok := annotation(m, AnnotationHelmHook); !ok {
+		return false
+	}
+	_, ok := hookType(m)
+	return !ok
+}
+
+// WithoutSkippedHooks returns list without the hooks reported by IsSkippedHook
+func WithoutSkippedHooks(list manifest.List) manifest.List {
+	out := make(manifest.List, 0, len(list))
+	for _, m := range list {
+		if !IsSkippedHook(m) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// hookWeight returns the helm.sh/hook-weight of m. Like Helm, invalid weights
+// are treated as 0
+func hookWeight(m manifest.Manifest) int {
+	s, _ := annotation(m, AnnotationHelmHookWeight)
+	w, _ := strconv.Atoi(strings.TrimSpace(s))
+	return w
+}
+
+// HookDeletePolicies returns the helm.sh/hook-delete-policy of m. Like Helm,
+// this defaults to HookBeforeCreation
+func HookDeletePolicies(m manifest.Manifest) []string {
+	s, ok := annotation(m, AnnotationHelmHookDeletePolicy)
+	if !ok || strings.TrimSpace(s) == "" {
+		return []string{HookBeforeCreation}
+	}
+	return splitList(s)
+}
+
+// HasHookDeletePolicy tells whether policy is one of the
+// HookDeletePolicies of m
+func HasHookDeletePolicy(m manifest.Manifest, policy string) bool {
+	for _, p := range HookDeletePolicies(m) {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
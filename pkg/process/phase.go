@@ -0,0 +1,167 @@
+package process
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+const (
+	// AnnotationApplyPhase can be set on any resource to override the apply
+	// phase it is assigned to
+	AnnotationApplyPhase = MetadataPrefix + "/apply-phase"
+)
+
+// Built-in apply phases, in the order they are applied
+const (
+	PhaseCRDs       = "crds"
+	PhaseCluster    = "cluster"
+	PhaseNamespaces = "namespaces"
+	PhaseWorkloads  = "workloads"
+)
+
+// Phases of Helm hooks. Hooks are grouped by their weight, see Phases
+const (
+	PhasePreHooks  = "helm-pre-hooks"
+	PhasePostHooks = "helm-post-hooks"
+)
+
+var phaseOrder = []string{
+	PhaseCRDs,
+	PhaseCluster,
+	PhaseNamespaces,
+	PhaseWorkloads,
+}
+
+// Phase is a group of manifests that is applied at once, before any later
+// Phase is started
+type Phase struct {
+	Name      string
+	Manifests manifest.List
+
+	// Hook is set for phases of Helm hooks. These are always awaited and
+	// deleted according to their hook-delete-policy
+	Hook bool
+}
+
+// Phases splits list into apply phases. Without any annotations, these are:
+//   - CustomResourceDefinitions
+//   - other cluster-wide resources
+//   - Namespaces
+//   - everything else
+//
+// The AnnotationApplyPhase annotation moves a resource into another phase. This
+// may be a built-in phase or a custom one. Custom phases are applied after the
+// built-in ones, in alphabetical order.
+//
+// Helm hooks (AnnotationHelmHook) that run on install or upgrade are put into
+// PhasePreHooks, right before the workloads, or PhasePostHooks, after all
+// other phases. Hooks of different weights (AnnotationHelmHookWeight) are
+// separate phases, applied in ascending order of weight. Hooks of other events
+// are omitted, see IsSkippedHook.
+//
+// Empty phases are omitted and the order of manifests inside a phase is
+// retained, so sort list using Sort first.
+func Phases(list manifest.List) []Phase {
+	byName := make(map[string]manifest.List)
+	hooks := map[string]map[int]manifest.List{
+		PhasePreHooks:  {},
+		PhasePostHooks: {},
+	}
+	for _, m := range WithoutSkippedHooks(list) {
+		if IsHook(m) {
+			typ, _ := hookType(m)
+			w := hookWeight(m)
+			hooks[typ][w] = append(hooks[typ][w], m)
+			continue
+		}
+
+		name := phaseOf(m)
+		byName[name] = append(byName[name], m)
+	}
+
+	var custom []string
+	for name := range byName {
+		if !isBuiltinPhase(name) {
+			custom = append(custom, name)
+		}
+	}
+	sort.Strings(custom)
+
+	var phases []Phase
+	for _, name := range append(append([]string{}, phaseOrder...), custom...) {
+		if name == PhaseWorkloads {
+			phases = append(phases, hookPhases(PhasePreHooks, hooks[PhasePreHooks])...)
+		}
+		if len(byName[name]) == 0 {
+			continue
+		}
+		phases = append(phases, Phase{Name: name, Manifests: byName[name]})
+	}
+	phases = append(phases, hookPhases(PhasePostHooks, hooks[PhasePostHooks])...)
+	return phases
+}
+
+// hookPhases returns a phase per weight of hooks, ordered by weight. Phases of
+// hooks with a weight other than 0 are named `name/weight`
+func hookPhases(name string, byWeight map[int]manifest.List) []Phase {
+	weights := make([]int, 0, len(byWeight))
+	for w := range byWeight {
+		weights = append(weights, w)
+	}
+	sort.Ints(weights)
+
+	phases := make([]Phase, 0, len(weights))
+	for _, w := range weights {
+		p := Phase{Name: name, Manifests: byWeight[w], Hook: true}
+		if w != 0 {
+			p.Name = fmt.Sprintf("%s/%d", name, w)
+		}
+		phases = append(phases, p)
+	}
+	return phases
+}
+
+// phaseOf returns the name of the apply phase m belongs to
+func phaseOf(m manifest.Manifest) string {
+	if phase, ok := annotation(m, AnnotationApplyPhase); ok && phase != "" {
+		return phase
+	}
+
+	switch m.Kind() {
+	case "CustomResourceDefinition":
+		return PhaseCRDs
+	case "Namespace":
+		return PhaseNamespaces
+	}
+
+	namespaced := !clusterWideKinds[m.Kind()]
+	if s, ok := annotation(m, AnnotationNamespaced); ok {
+		namespaced = s == "true"
+	}
+	if !namespaced {
+		return PhaseCluster
+	}
+	return PhaseWorkloads
+}
+
+func isBuiltinPhase(name string) bool {
+	for _, p := range phaseOrder {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// annotation returns the string annotation key of m. Unlike
+// Metadata().Annotations(), it does not create the annotations map if missing.
+func annotation(m manifest.Manifest, key string) (string, bool) {
+	annotations, ok := m.Metadata()["annotations"].(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	s, ok := annotations[key].(string)
+	return s, ok
+}
@@ -0,0 +1,132 @@
+package process
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+func TestPhases(t *testing.T) {
+	withAnnotation := func(m manifest.Manifest, key, value string) manifest.Manifest {
+		m.Metadata()["annotations"] = map[string]interface{}{key: value}
+		return m
+	}
+
+	list := manifest.List{
+		mkobj("Namespace", "ns", ""),
+		mkobj("ConfigMap", "cm", "ns"),
+		mkobj("CustomResourceDefinition", "crd", ""),
+		mkobj("ClusterRole", "role", ""),
+		withAnnotation(mkobj("Certificate", "cert", ""), AnnotationNamespaced, "false"),
+		mkobj("Deployment", "deploy", "ns"),
+		withAnnotation(mkobj("Issuer", "issuer", "ns"), AnnotationApplyPhase, "issuers"),
+		withAnnotation(mkobj("Job", "migrate", "ns"), AnnotationApplyPhase, "migrations"),
+		withAnnotation(mkobj("Secret", "early", "ns"), AnnotationApplyPhase, PhaseNamespaces),
+	}
+
+	names := func(phases []Phase) map[string][]string {
+		got := make(map[string][]string)
+		for _, p := range phases {
+			for _, m := range p.Manifests {
+				got[p.Name] = append(got[p.Name], m.KindName())
+			}
+		}
+		return got
+	}
+
+	phases := Phases(list)
+
+	var order []string
+	for _, p := range phases {
+		order = append(order, p.Name)
+	}
+	assert.Equal(t, []string{PhaseCRDs, PhaseCluster, PhaseNamespaces, PhaseWorkloads, "issuers", "migrations"}, order)
+
+	assert.Equal(t, map[string][]string{
+		PhaseCRDs:       {"CustomResourceDefinition/crd"},
+		PhaseCluster:    {"ClusterRole/role", "Certificate/cert"},
+		PhaseNamespaces: {"Namespace/ns", "Secret/early"},
+		PhaseWorkloads:  {"ConfigMap/cm", "Deployment/deploy"},
+		"issuers":       {"Issuer/issuer"},
+		"migrations":    {"Job/migrate"},
+	}, names(phases))
+
+	// reading the phase must not add empty annotations
+	_, ok := list[1].Metadata()["annotations"]
+	assert.False(t, ok)
+}
+
+func TestPhasesSingle(t *testing.T) {
+	phases := Phases(manifest.List{mkobj("ConfigMap", "a", "default"), mkobj("Service", "b", "default")})
+	assert.Len(t, phases, 1)
+	assert.Equal(t, PhaseWorkloads, phases[0].Name)
+
+	assert.Empty(t, Phases(nil))
+}
+
+func TestPhasesHelmHooks(t *testing.T) {
+	hook := func(kind, name string, annotations map[string]interface{}) manifest.Manifest {
+		m := manifest.Manifest(mkobj(kind, name, "ns"))
+		m.Metadata()["annotations"] = annotations
+		return m
+	}
+
+	list := manifest.List{
+		mkobj("Namespace", "ns", ""),
+		mkobj("Deployment", "app", "ns"),
+		hook("Job", "migrate", map[string]interface{}{AnnotationHelmHook: "pre-install,pre-upgrade"}),
+		hook("ServiceAccount", "migrate", map[string]interface{}{AnnotationHelmHook: "pre-upgrade", AnnotationHelmHookWeight: "-5"}),
+		hook("Job", "notify", map[string]interface{}{AnnotationHelmHook: "post-install, post-upgrade"}),
+		hook("Job", "backup", map[string]interface{}{AnnotationHelmHook: "pre-delete"}),
+		hook("Pod", "test", map[string]interface{}{AnnotationHelmHook: "test"}),
+		hook("Job", "pinned", map[string]interface{}{AnnotationHelmHook: "pre-install", AnnotationApplyPhase: "late"}),
+	}
+
+	phases := Phases(list)
+
+	type phase struct {
+		name      string
+		hook      bool
+		resources []string
+	}
+	var got []phase
+	for _, p := range phases {
+		var names []string
+		for _, m := range p.Manifests {
+			names = append(names, m.KindName())
+		}
+		got = append(got, phase{p.Name, p.Hook, names})
+	}
+
+	assert.Equal(t, []phase{
+		{PhaseNamespaces, false, []string{"Namespace/ns"}},
+		{PhasePreHooks + "/-5", true, []string{"ServiceAccount/migrate"}},
+		{PhasePreHooks, true, []string{"Job/migrate"}},
+		// hooks not run on install or upgrade are omitted
+		{PhaseWorkloads, false, []string{"Deployment/app"}},
+		{"late", false, []string{"Job/pinned"}},
+		{PhasePostHooks, true, []string{"Job/notify"}},
+	}, got)
+
+	assert.True(t, IsHook(list[2]))
+	assert.False(t, IsHook(list[6]))
+	assert.False(t, IsHook(list[7]))
+
+	assert.True(t, IsSkippedHook(list[5]))
+	assert.True(t, IsSkippedHook(list[6]))
+	assert.False(t, IsSkippedHook(list[2]))
+	assert.False(t, IsSkippedHook(list[7]))
+	assert.False(t, IsSkippedHook(list[1]))
+}
+
+func TestHookDeletePolicies(t *testing.T) {
+	m := manifest.Manifest(mkobj("Job", "migrate", "ns"))
+	assert.Equal(t, []string{HookBeforeCreation}, HookDeletePolicies(m))
+
+	m.Metadata()["annotations"] = map[string]interface{}{AnnotationHelmHookDeletePolicy: "hook-succeeded, hook-failed"}
+	assert.Equal(t, []string{HookSucceeded, HookFailed}, HookDeletePolicies(m))
+	assert.True(t, HasHookDeletePolicy(m, HookFailed))
+	assert.False(t, HasHookDeletePolicy(m, HookBeforeCreation))
+}
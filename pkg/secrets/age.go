@@ -0,0 +1,87 @@
+package secrets
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/pkg/errors"
+)
+
+// Environment variables holding age identities, same as used by SOPS
+const (
+	EnvAgeKey     = "SOPS_AGE_KEY"
+	EnvAgeKeyFile = "SOPS_AGE_KEY_FILE"
+)
+
+// DefaultAgeKeyFile returns the path SOPS reads age identities from if neither
+// SOPS_AGE_KEY nor SOPS_AGE_KEY_FILE are set
+func DefaultAgeKeyFile() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		var err error
+		if dir, err = os.UserConfigDir(); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, "sops", "age", "keys.txt"), nil
+}
+
+// Identities returns the local age identities: those in SOPS_AGE_KEY, in the
+// file at SOPS_AGE_KEY_FILE and in DefaultAgeKeyFile
+func Identities() ([]age.Identity, error) {
+	var ids []age.Identity
+
+	if keys := os.Getenv(EnvAgeKey); keys != "" {
+		parsed, err := age.ParseIdentities(strings.NewReader(keys))
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing "+EnvAgeKey)
+		}
+		ids = append(ids, parsed...)
+	}
+
+	files := []string{}
+	if file := os.Getenv(EnvAgeKeyFile); file != "" {
+		files = append(files, file)
+	}
+	if file, err := DefaultAgeKeyFile(); err == nil {
+		files = append(files, file)
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		parsed, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing %s", file)
+		}
+		ids = append(ids, parsed...)
+	}
+
+	if len(ids) == 0 {
+		return nil, ErrorNoIdentities{}
+	}
+	return ids, nil
+}
+
+// DecryptAge decrypts an age encrypted file, binary or ASCII armored
+func DecryptAge(data []byte, identities []age.Identity) ([]byte, error) {
+	var in io.Reader = bytes.NewReader(data)
+	if isArmored(data) {
+		in = armor.NewReader(bytes.NewReader(bytes.TrimSpace(data)))
+	}
+
+	r, err := age.Decrypt(in, identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
@@ -0,0 +1,39 @@
+package secrets
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrorNotSopsFile means that a file has no `sops` metadata
+type ErrorNotSopsFile struct{}
+
+func (e ErrorNotSopsFile) Error() string {
+	return "file is not encrypted by SOPS: no 'sops' metadata found"
+}
+
+// ErrorNoIdentities means that no local age identities were found
+type ErrorNoIdentities struct{}
+
+func (e ErrorNoIdentities) Error() string {
+	return fmt.Sprintf("no age identities found. Set %s or %s, or put your keys into the default key file", EnvAgeKey, EnvAgeKeyFile)
+}
+
+// ErrorNoMatchingKey means that none of the local age identities can decrypt
+// the SOPS data key
+type ErrorNoMatchingKey struct {
+	Recipients []string
+}
+
+func (e ErrorNoMatchingKey) Error() string {
+	return fmt.Sprintf("none of the local age identities matches the recipients of the file:\n - %s", strings.Join(e.Recipients, "\n - "))
+}
+
+// ErrorSopsMacMismatch means that the contents of a SOPS file do not match
+// its message authentication code, e.g. because values were changed, added or
+// removed without re-encrypting the file
+type ErrorSopsMacMismatch struct{}
+
+func (e ErrorSopsMacMismatch) Error() string {
+	return "failed to verify the integrity of the file: the message authentication code does not match its contents"
+}
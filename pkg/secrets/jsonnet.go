@@ -0,0 +1,133 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// IdentitiesFunc returns the age identities to decrypt with, e.g. Identities
+type IdentitiesFunc func() ([]age.Identity, error)
+
+// JsonnetOpts are additional properties the consumer of the native funcs
+// might pass.
+type JsonnetOpts struct {
+	// CalledFrom is the file that calls decryptSops or decryptAge. This is
+	// used to find the encrypted file relative to this file
+	CalledFrom string `json:"calledFrom"`
+	// Format overrides the SOPS format derived from the file extension:
+	// yaml, json or binary
+	Format string `json:"format"`
+}
+
+// SopsNativeFunc returns a jsonnet native function that decrypts a file
+// encrypted by SOPS using age. Files are required to be present at a relative
+// location to the file that calls `std.native('decryptSops')`. Decrypted
+// strings are registered for redaction.
+func SopsNativeFunc(identities IdentitiesFunc) *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Name:   "decryptSops",
+		Params: ast.Identifiers{"path", "opts"},
+		Func: func(data []interface{}) (interface{}, error) {
+			file, opts, err := readEncrypted("decryptSops", data)
+			if err != nil {
+				return nil, err
+			}
+
+			ids, err := identities()
+			if err != nil {
+				return nil, fmt.Errorf("decryptSops: %s", err)
+			}
+
+			format := opts.Format
+			if format == "" {
+				format = SopsFormat(file.path)
+			}
+			out, err := DecryptSops(file.content, format, ids)
+			if err != nil {
+				return nil, fmt.Errorf("decryptSops: Failed to decrypt '%s': %s", file.path, err)
+			}
+			return out, nil
+		},
+	}
+}
+
+// AgeNativeFunc returns a jsonnet native function that decrypts a file
+// encrypted by age and returns its content as string. Files are required to
+// be present at a relative location to the file that calls
+// `std.native('decryptAge')`. The content is registered for redaction.
+func AgeNativeFunc(identities IdentitiesFunc) *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Name:   "decryptAge",
+		Params: ast.Identifiers{"path", "opts"},
+		Func: func(data []interface{}) (interface{}, error) {
+			file, _, err := readEncrypted("decryptAge", data)
+			if err != nil {
+				return nil, err
+			}
+
+			ids, err := identities()
+			if err != nil {
+				return nil, fmt.Errorf("decryptAge: %s", err)
+			}
+
+			out, err := DecryptAge(file.content, ids)
+			if err != nil {
+				return nil, fmt.Errorf("decryptAge: Failed to decrypt '%s': %s", file.path, err)
+			}
+			Register(string(out))
+			return string(out), nil
+		},
+	}
+}
+
+type encryptedFile struct {
+	path    string
+	content []byte
+}
+
+// readEncrypted reads the file given to the native func name, relative to
+// opts.calledFrom
+func readEncrypted(name string, data []interface{}) (*encryptedFile, *JsonnetOpts, error) {
+	path, ok := data[0].(string)
+	if !ok {
+		return nil, nil, fmt.Errorf("argument 'path' must be of 'string' type, got '%T' instead", data[0])
+	}
+
+	opts, err := parseOpts(name, data[1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// resolve the file relative to the caller
+	actualPath := filepath.Join(filepath.Dir(opts.CalledFrom), path)
+	content, err := os.ReadFile(actualPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: Failed to read encrypted file at '%s': %s", name, actualPath, err)
+	}
+
+	return &encryptedFile{path: actualPath, content: content}, opts, nil
+}
+
+func parseOpts(name string, data interface{}) (*JsonnetOpts, error) {
+	c, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var opts JsonnetOpts
+	if err := json.Unmarshal(c, &opts); err != nil {
+		return nil, err
+	}
+
+	// Encrypted files are only allowed at relative paths. Use conf.CalledFrom to find the callers directory
+	if opts.CalledFrom == "" {
+		return nil, fmt.Errorf("%s: 'opts.calledFrom' is unset or empty.\nTanka needs this to find your encrypted files. See https://tanka.dev/secrets#optscalledfrom-unset", name)
+	}
+
+	return &opts, nil
+}
@@ -0,0 +1,116 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+)
+
+// Redacted replaces decrypted values in output
+const Redacted = "<redacted>"
+
+// MinRedactLength is the length below which values are not redacted, as
+// replacing them would mangle unrelated output
+const MinRedactLength = 4
+
+// registry holds all values decrypted by this process
+var registry = struct {
+	sync.RWMutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}{values: make(map[string]bool)}
+
+// decryptions increases whenever secrets are decrypted or registered
+var decryptions atomic.Uint64
+
+// Register marks s as secret, so Redact removes it from output. Its base64
+// encoding and each of its lines are registered as well, as this is how
+// secrets usually appear in manifests. Values shorter than MinRedactLength
+// and lines that are structure rather than content, such as PEM armor or
+// closing braces, are not registered.
+func Register(s string) {
+	candidates := []string{s, base64.StdEncoding.EncodeToString([]byte(s))}
+	if strings.Contains(s, "\n") {
+		for _, line := range strings.Split(s, "\n") {
+			if line = strings.TrimSpace(line); !structural(line) {
+				candidates = append(candidates, line)
+			}
+		}
+	}
+
+	decryptions.Add(1)
+	registry.Lock()
+	defer registry.Unlock()
+	for _, c := range candidates {
+		if len(c) < MinRedactLength || registry.values[c] {
+			continue
+		}
+		registry.values[c] = true
+		registry.replacer = nil
+	}
+}
+
+// structural tells whether line of a multi-line secret is part of its format
+// and likely to appear in unrelated output as well
+func structural(line string) bool {
+	if strings.HasPrefix(line, "-----BEGIN ") || strings.HasPrefix(line, "-----END ") {
+		return true
+	}
+	return !strings.ContainsFunc(line, func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	})
+}
+
+// Decryptions returns a counter that increases whenever secrets are decrypted
+// or registered. Comparing it before and after an operation tells whether
+// that operation handled secrets.
+func Decryptions() uint64 {
+	return decryptions.Load()
+}
+
+// Redact replaces all registered values in s with Redacted
+func Redact(s string) string {
+	registry.RLock()
+	r := registry.replacer
+	n := len(registry.values)
+	registry.RUnlock()
+
+	if n == 0 {
+		return s
+	}
+	if r == nil {
+		r = buildReplacer()
+	}
+	return r.Replace(s)
+}
+
+// buildReplacer creates a replacer for all registered values, preferring
+// longer values over their substrings
+func buildReplacer() *strings.Replacer {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.replacer != nil {
+		return registry.replacer
+	}
+
+	values := make([]string, 0, len(registry.values))
+	for v := range registry.values {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+
+	oldnew := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		oldnew = append(oldnew, v, Redacted)
+	}
+	registry.replacer = strings.NewReplacer(oldnew...)
+	return registry.replacer
+}
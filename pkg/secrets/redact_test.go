@@ -0,0 +1,72 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	before := Decryptions()
+	Register("correct-horse")
+	Register("correct-horse-battery-staple")
+	Register("first line\nsecond line")
+	Register("abc")
+	Register("-----BEGIN CERTIFICATE-----\nMIIBszCCAVmgAwIBAgIU\n-----END CERTIFICATE-----\n")
+	Register("{\n  \"key\": \"s3cr3t-key\"\n}\n1")
+	assert.Equal(t, before+6, Decryptions())
+
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "plain",
+			in:   "password: correct-horse",
+			want: "password: <redacted>",
+		},
+		{
+			name: "longest match",
+			in:   "password: correct-horse-battery-staple",
+			want: "password: <redacted>",
+		},
+		{
+			name: "base64",
+			in:   "password: " + base64.StdEncoding.EncodeToString([]byte("correct-horse")),
+			want: "password: <redacted>",
+		},
+		{
+			name: "lines",
+			in:   "cert: |\n  first line\n  second line\n",
+			want: "cert: |\n  <redacted>\n  <redacted>\n",
+		},
+		{
+			name: "too short",
+			in:   "name: abc",
+			want: "name: abc",
+		},
+		{
+			name: "pem",
+			in:   "cert: |\n  -----BEGIN CERTIFICATE-----\n  MIIBszCCAVmgAwIBAgIU\n  -----END CERTIFICATE-----\n",
+			want: "cert: |\n  -----BEGIN CERTIFICATE-----\n  <redacted>\n  -----END CERTIFICATE-----\n",
+		},
+		{
+			name: "structural lines",
+			in:   "@@ -1 +1 @@\n-{\n+}\n  \"key\": \"s3cr3t-key\"",
+			want: "@@ -1 +1 @@\n-{\n+}\n  <redacted>",
+		},
+		{
+			name: "unrelated",
+			in:   "replicas: 3",
+			want: "replicas: 3",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, Redact(c.in))
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/getsops/sops/v3"
+	sopsaes "github.com/getsops/sops/v3/aes"
+	sopsage "github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/config"
+	sopsjson "github.com/getsops/sops/v3/stores/json"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Formats of SOPS encrypted files
+const (
+	FormatYAML   = "yaml"
+	FormatJSON   = "json"
+	FormatBinary = "binary"
+)
+
+// SopsFormat returns the format SOPS uses for the file at path, based on its
+// extension
+func SopsFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".json":
+		return FormatJSON
+	default:
+		return FormatBinary
+	}
+}
+
+// DecryptSops decrypts the contents of a file encrypted by SOPS, using the
+// data key encrypted to one of the given age identities. YAML and JSON files
+// are returned as objects without the `sops` metadata, binary files as string.
+// All decrypted strings are registered for redaction.
+//
+// Like `sops --decrypt`, the message authentication code of the file is
+// verified, so values cannot be replaced, added or removed without the data
+// key. Only age is supported, other key management systems are ignored.
+func DecryptSops(data []byte, format string, identities []age.Identity) (interface{}, error) {
+	var store sops.Store
+	switch format {
+	case FormatYAML:
+		store = sopsyaml.NewStore(&config.YAMLStoreConfig{})
+	case FormatJSON:
+		store = sopsjson.NewStore(&config.JSONStoreConfig{})
+	case FormatBinary:
+		store = sopsjson.NewBinaryStore(&config.JSONBinaryStoreConfig{})
+	default:
+		return nil, fmt.Errorf("unknown format '%s'", format)
+	}
+
+	tree, err := store.LoadEncryptedFile(data)
+	if errors.Is(err, sops.MetadataNotFound) {
+		return nil, ErrorNotSopsFile{}
+	} else if err != nil {
+		return nil, errors.Wrap(err, "parsing sops file")
+	}
+
+	key, err := dataKey(tree.Metadata, identities)
+	if err != nil {
+		return nil, err
+	}
+
+	decryptions.Add(1)
+	c := registeringCipher{sopsaes.NewCipher()}
+	mac, err := tree.Decrypt(key, c)
+	if err != nil {
+		return nil, err
+	}
+	originalMac, err := c.Decrypt(tree.Metadata.MessageAuthenticationCode, key, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypting message authentication code")
+	}
+	if originalMac != mac {
+		return nil, ErrorSopsMacMismatch{}
+	}
+
+	plain, err := store.EmitPlainFile(tree.Branches)
+	if err != nil {
+		return nil, err
+	}
+
+	var out interface{}
+	switch format {
+	case FormatBinary:
+		return string(plain), nil
+	case FormatYAML:
+		var doc interface{}
+		if err := yaml.Unmarshal(plain, &doc); err != nil {
+			return nil, errors.Wrap(err, "parsing yaml")
+		}
+		// convert to JSON types
+		raw, err := json.Marshal(doc)
+		if err != nil {
+			return nil, errors.Wrap(err, "converting yaml to json")
+		}
+		plain = raw
+	}
+	if err := json.Unmarshal(plain, &out); err != nil {
+		return nil, errors.Wrap(err, "parsing json")
+	}
+	return out, nil
+}
+
+// dataKey decrypts the SOPS data key using the first matching identity
+func dataKey(meta sops.Metadata, identities []age.Identity) ([]byte, error) {
+	if len(meta.KeyGroups) > 1 {
+		return nil, errors.New("files encrypted using key groups are not supported")
+	}
+
+	var recipients []string
+	for _, group := range meta.KeyGroups {
+		for _, k := range group {
+			a, ok := k.(*sopsage.MasterKey)
+			if !ok {
+				continue
+			}
+			recipients = append(recipients, a.Recipient)
+			key, err := DecryptAge([]byte(a.EncryptedKey), identities)
+			if err == nil {
+				return key, nil
+			}
+			var noMatch *age.NoIdentityMatchError
+			if !errors.As(err, &noMatch) {
+				return nil, errors.Wrapf(err, "decrypting data key for %s", a.Recipient)
+			}
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("file is not encrypted to any age recipient")
+	}
+	return nil, ErrorNoMatchingKey{Recipients: recipients}
+}
+
+// registeringCipher registers all decrypted strings for redaction
+type registeringCipher struct {
+	sops.Cipher
+}
+
+func (c registeringCipher) Decrypt(ciphertext string, key []byte, additionalData string) (interface{}, error) {
+	plain, err := c.Cipher.Decrypt(ciphertext, key, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	switch v := plain.(type) {
+	case string:
+		Register(v)
+	case []byte:
+		Register(string(v))
+	}
+	return plain, nil
+}
+
+// isArmored reports whether data is an ASCII armored age file
+func isArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN AGE ENCRYPTED FILE-----"))
+}
@@ -105,11 +105,14 @@ type Spec struct {
 	Namespace                   string           `json:"namespace"`
 	DiffStrategy                string           `json:"diffStrategy,omitempty"`
 	ApplyStrategy               string           `json:"applyStrategy,omitempty"`
+	KubernetesClient            string           `json:"kubernetesClient,omitempty"`
 	InjectLabels                bool             `json:"injectLabels,omitempty"`
 	TankaEnvLabelFromFields     []string         `json:"tankaEnvLabelFromFields,omitempty"`
 	ResourceDefaults            ResourceDefaults `json:"resourceDefaults"`
 	ExpectVersions              ExpectVersions   `json:"expectVersions"`
 	ExportJsonnetImplementation string           `json:"exportJsonnetImplementation,omitempty"`
+	History                     History          `json:"history,omitempty"`
+	DiffIgnore                  []DiffIgnore     `json:"diffIgnore,omitempty"`
 }
 
 // ExpectVersions holds semantic version constraints
@@ -118,6 +121,36 @@ type ExpectVersions struct {
 	Tanka string `json:"tanka,omitempty"`
 }
 
+// History configures recording applied states as revisions, which can be
+// listed using `tk history` and re-applied using `tk rollback`
+type History struct {
+	// Storage is one of configmap, secret or local. History is disabled if unset
+	Storage string `json:"storage,omitempty"`
+	// Namespace holding the ConfigMaps or Secrets. Defaults to spec.namespace
+	Namespace string `json:"namespace,omitempty"`
+	// Path of the directory used by local storage
+	Path string `json:"path,omitempty"`
+	// Limit is the number of revisions to keep
+	Limit int `json:"limit,omitempty"`
+}
+
+// DiffIgnore excludes fields of the selected resources from diffs, e.g. ones
+// mutated by controllers or webhooks. Empty selectors match any resource.
+type DiffIgnore struct {
+	// Group is the API group (without version), e.g. "apps"
+	Group string `json:"group,omitempty"`
+	Kind  string `json:"kind,omitempty"`
+	// Name and Namespace support shell patterns, e.g. "*-webhook"
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+
+	// JSONPointers select fields using RFC 6901, e.g. "/spec/replicas"
+	JSONPointers []string `json:"jsonPointers,omitempty"`
+	// JSONPaths select fields using kubectl-style JSONPath, e.g.
+	// `.spec.template.spec.containers[?(@.name=="istio-proxy")]`
+	JSONPaths []string `json:"jsonPaths,omitempty"`
+}
+
 // ResourceDefaults will be inserted in any manifests that tanka processes.
 type ResourceDefaults struct {
 	Annotations map[string]string `json:"annotations,omitempty"`
@@ -0,0 +1,125 @@
+package tanka
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/grafana/tanka/internal/telemetry"
+	"github.com/grafana/tanka/pkg/git"
+	"github.com/grafana/tanka/pkg/jsonnet"
+	"github.com/grafana/tanka/pkg/jsonnet/jpath"
+)
+
+// ChangedEnvs are the environments affected by the changes made since a git
+// ref
+type ChangedEnvs struct {
+	// Entrypoints (main.jsonnet files) of environments that need to be
+	// exported again
+	Entrypoints []string
+	// Environments that have been deleted, relative to the project root. See
+	// ExportEnvOpts.MergeDeletedEnvs
+	Deleted []string
+}
+
+// FindChangedEnvs returns the environments below paths that directly or
+// transitively import a file that changed between ref and the working tree.
+// All paths must belong to the same project.
+func FindChangedEnvs(ctx context.Context, paths []string, ref string) (*ChangedEnvs, error) {
+	ctx, span := tracer.Start(ctx, "tanka.FindChangedEnvs")
+	defer span.End()
+	span.SetAttributes(attribute.String("tanka.since", ref))
+
+	var root string
+	dirs := make([]string, 0, len(paths))
+	for _, path := range paths {
+		r, err := jpath.FindRoot(path)
+		if err != nil {
+			return nil, fmt.Errorf("finding root of %s: %w", path, err)
+		}
+		if r, err = filepath.EvalSymlinks(r); err != nil {
+			return nil, err
+		}
+		if root != "" && r != root {
+			return nil, fmt.Errorf("paths belong to different projects (%s and %s), which is not supported when exporting changes", root, r)
+		}
+		root = r
+
+		dir, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		if dir, err = filepath.EvalSymlinks(dir); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+	}
+
+	changed, deleted, err := git.ChangedFiles(root, ref)
+	if err != nil {
+		err = fmt.Errorf("listing files changed since %s: %w", ref, err)
+		telemetry.FailSpanWithError(span, err)
+		return nil, err
+	}
+
+	var files []string
+	for _, f := range changed {
+		if within(root, f) {
+			files = append(files, f)
+		}
+	}
+	for _, f := range deleted {
+		if within(root, f) {
+			files = append(files, "deleted:"+f)
+		}
+	}
+	span.SetAttributes(attribute.Int("tanka.changed_files", len(files)))
+
+	envs := &ChangedEnvs{}
+	if len(files) == 0 {
+		return envs, nil
+	}
+
+	mainFiles, err := jsonnet.FindImporterForFiles(ctx, root, files)
+	if err != nil {
+		return nil, fmt.Errorf("resolving importers of changed files: %w", err)
+	}
+
+	for _, file := range mainFiles {
+		if !withinAny(dirs, file) {
+			continue
+		}
+
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			namespace, err := filepath.Rel(root, file)
+			if err != nil {
+				return nil, err
+			}
+			envs.Deleted = append(envs.Deleted, namespace)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		envs.Entrypoints = append(envs.Entrypoints, file)
+	}
+
+	return envs, nil
+}
+
+// within returns whether path is dir or inside of it
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+func withinAny(dirs []string, path string) bool {
+	for _, dir := range dirs {
+		if within(dir, path) {
+			return true
+		}
+	}
+	return false
+}
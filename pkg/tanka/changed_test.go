@@ -0,0 +1,67 @@
+package tanka
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/tanka/pkg/git"
+)
+
+func TestFindChangedEnvs(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	write := func(file, content string) {
+		path := filepath.Join(root, file)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	gitRun := func(args ...string) {
+		_, err := git.Run(root, args...)
+		require.NoError(t, err)
+	}
+
+	write("jsonnetfile.json", "{}")
+	write("lib/config.libsonnet", "{ replicas: 1 }")
+	write("environments/uses-lib/main.jsonnet", "import 'config.libsonnet'")
+	write("environments/unchanged/main.jsonnet", "{}")
+	write("environments/local-change/main.jsonnet", "import 'config.jsonnet'")
+	write("environments/local-change/config.jsonnet", "{}")
+	write("environments/deleted/main.jsonnet", "{}")
+	write("other/main.jsonnet", "import 'config.libsonnet'")
+	write("README.md", "")
+	gitRun("init", "--quiet")
+	gitRun("add", "-A")
+	gitRun("-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "initial")
+
+	write("lib/config.libsonnet", "{ replicas: 2 }")
+	write("environments/local-change/config.jsonnet", "{ a: 1 }")
+	write("environments/added/main.jsonnet", "{}")
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "environments/deleted")))
+
+	changed, err := FindChangedEnvs(t.Context(), []string{filepath.Join(root, "environments")}, "HEAD")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(root, "environments/uses-lib/main.jsonnet"),
+		filepath.Join(root, "environments/local-change/main.jsonnet"),
+		filepath.Join(root, "environments/added/main.jsonnet"),
+	}, changed.Entrypoints)
+	assert.Equal(t, []string{filepath.Join("environments", "deleted", "main.jsonnet")}, changed.Deleted)
+
+	// only environments below the given paths are considered
+	changed, err = FindChangedEnvs(t.Context(), []string{filepath.Join(root, "other")}, "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(root, "other/main.jsonnet")}, changed.Entrypoints)
+	assert.Empty(t, changed.Deleted)
+
+	_, err = FindChangedEnvs(t.Context(), []string{root}, "missing")
+	assert.ErrorContains(t, err, "listing files changed since missing")
+}
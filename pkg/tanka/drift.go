@@ -0,0 +1,116 @@
+package tanka
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/grafana/tanka/pkg/spec/v1alpha1"
+)
+
+// DriftResult is the outcome of checking a single environment for changes
+// compared to the cluster
+type DriftResult struct {
+	// Env is the name of the environment
+	Env string
+	// Changed reports whether applying the environment would change the
+	// cluster. If Err is set, this is a best guess
+	Changed bool
+	// Err is set if the environment could not be checked
+	Err error
+}
+
+// DriftOpts specify additional properties for FindDrift
+type DriftOpts struct {
+	Opts
+
+	// Selector restricts the environments checked
+	Selector labels.Selector
+	// Parallelism of finding environments. Defaults to 8
+	Parallelism int
+}
+
+// FindDrift finds all environments in baseDir and checks each of them for
+// changes using CheckEnvironmentsDrift
+func FindDrift(ctx context.Context, baseDir string, opts DriftOpts) ([]DriftResult, error) {
+	parallelism := opts.Parallelism
+	if parallelism == 0 {
+		parallelism = 8 // magic number for now
+	}
+
+	envs, err := FindEnvs(ctx, baseDir, FindOpts{
+		JsonnetOpts:           opts.JsonnetOpts,
+		JsonnetImplementation: opts.JsonnetImplementation,
+		Selector:              opts.Selector,
+		Parallelism:           parallelism,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return CheckEnvironmentsDrift(ctx, envs, opts.Opts), nil
+}
+
+// CheckEnvironmentsDrift checks envs for changes in parallel, using kubectl
+// diff --exit-code. Results are returned in the order of envs.
+func CheckEnvironmentsDrift(ctx context.Context, envs []*v1alpha1.Environment, opts Opts) []DriftResult {
+	results := make([]DriftResult, len(envs))
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(4)
+
+	for i, env := range envs {
+		g.Go(func() error {
+			results[i] = checkEnvironmentDrift(ctx, env, opts)
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		log.Warn().Err(err).Msg("Failed to check environments for changes")
+	}
+	return results
+}
+
+// checkEnvironmentDrift uses kubectl diff to quickly check for changes
+func checkEnvironmentDrift(ctx context.Context, env *v1alpha1.Environment, opts Opts) DriftResult {
+	envName := env.Spec.Namespace
+	if env.Metadata.Name != "" {
+		envName = env.Metadata.Name
+	}
+	result := DriftResult{Env: envName}
+
+	// Load only this single environment to get its resources
+	tempOpts := opts
+	tempOpts.Name = env.Metadata.Name
+
+	// Use the environment's path for loading
+	envPath := env.Metadata.Namespace
+	l, err := Load(ctx, envPath, tempOpts)
+	if err != nil {
+		log.Warn().Err(err).Str("env", envName).Msg("Failed to load environment, assuming no changes")
+		result.Err = errors.Wrap(err, "loading environment")
+		return result
+	}
+
+	kube, err := l.Connect()
+	if err != nil {
+		log.Warn().Err(err).Str("env", envName).Msg("Failed to connect, assuming no changes")
+		result.Err = errors.Wrap(err, "connecting to cluster")
+		return result
+	}
+	defer kube.Close()
+
+	// Use a lightweight check via `kubectl diff --exit-code`
+	result.Changed, err = kube.HasChanges(l.Resources)
+	if err != nil {
+		log.Warn().Err(err).Str("env", envName).Msg("Failed to check changes, assuming changes exist")
+		result.Changed = true
+		result.Err = errors.Wrap(err, "checking for changes")
+	}
+
+	return result
+}
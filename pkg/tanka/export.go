@@ -17,6 +17,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 	"k8s.io/apimachinery/pkg/labels"
 
@@ -35,6 +36,14 @@ const BelRune = string(rune(7))
 // debugging purposes.
 const manifestFile = "manifest.json"
 
+// stagingDirSuffix is appended to the name of the output directory to name the
+// directory the new output is built in, and the one the previous output is
+// moved to while swapping them
+const (
+	stagingDirSuffix  = ".tanka-export-*"
+	previousDirSuffix = ".tanka-previous-*"
+)
+
 type ExportMergeStrategy string
 
 const (
@@ -66,6 +75,10 @@ type ExportEnvOpts struct {
 	MergeDeletedEnvs []string
 	// Skip generating manifest.json file that tracks exported files
 	SkipManifest bool
+	// optional: where to write the manifests to instead of one file per
+	// manifest below the output directory. Merge strategies and manifest.json
+	// only apply to the output directory.
+	Sink ExportSink
 }
 
 func ExportEnvironments(ctx context.Context, envs []*v1alpha1.Environment, to string, opts *ExportEnvOpts) error {
@@ -74,6 +87,24 @@ func ExportEnvironments(ctx context.Context, envs []*v1alpha1.Environment, to st
 
 	span.SetAttributes(telemetry.AttrNumEnvs(len(envs)))
 
+	parallelism := opts.Parallelism
+
+	if parallelism <= 0 {
+		parallelism = defaultParallelism
+	}
+
+	if parallelism > len(envs) {
+		parallelism = len(envs)
+	}
+
+	if opts.Sink != nil {
+		if opts.MergeStrategy != ExportMergeStrategyNone || len(opts.MergeDeletedEnvs) > 0 {
+			return fmt.Errorf("merge strategies are only supported when exporting to a directory")
+		}
+		_, err := exportEnvironments(ctx, envs, parallelism, opts.Sink, opts)
+		return err
+	}
+
 	// dir must be empty
 	empty, err := dirEmpty(to)
 	if err != nil {
@@ -83,50 +114,80 @@ func ExportEnvironments(ctx context.Context, envs []*v1alpha1.Environment, to st
 		return fmt.Errorf("output dir `%s` not empty. Pass a different --merge-strategy to ignore this", to)
 	}
 
-	// delete files previously exported by the targeted envs.
+	// files previously exported by the targeted envs, and by environments
+	// that have been deleted since the last export
+	var replaced []string
 	if opts.MergeStrategy == ExportMergeStrategyReplaceEnvs {
-		if err := deletePreviouslyExportedManifestsFromTankaEnvs(to, envs, opts.SkipManifest); err != nil {
-			return fmt.Errorf("deleting previously exported manifests: %w", err)
+		files, err := previouslyExportedManifestsFromTankaEnvs(to, envs)
+		if err != nil {
+			return fmt.Errorf("reading previously exported manifests: %w", err)
 		}
+		replaced = append(replaced, files...)
+	}
+	files, err := previouslyExportedManifests(to, opts.MergeDeletedEnvs)
+	if err != nil {
+		return fmt.Errorf("reading previously exported manifests from deleted environments: %w", err)
 	}
+	replaced = append(replaced, files...)
 
-	// delete files that were exported by environments that have been deleted since the last export.
-	if err := deletePreviouslyExportedManifests(to, opts.MergeDeletedEnvs, opts.SkipManifest); err != nil {
-		return fmt.Errorf("deleting previously exported manifests from deleted environments: %w", err)
+	// The new output is built in a staging dir, starting from the files that
+	// are kept, and swapped in once all environments succeeded. A failing
+	// environment thereby leaves the output dir untouched.
+	target := to
+	to, err = outputDir(to)
+	if err != nil {
+		return err
 	}
+	staging, inside, err := stagingDir(to)
+	if err != nil {
+		return fmt.Errorf("creating staging dir: %w", err)
+	}
+	defer os.RemoveAll(staging)
 
-	parallelism := opts.Parallelism
+	if !empty {
+		if err := copyExportDir(to, staging, replaced); err != nil {
+			return fmt.Errorf("copying output dir: %w", err)
+		}
+	}
 
-	if parallelism <= 0 {
-		parallelism = defaultParallelism
+	sink := dirSink{dir: staging, target: target}
+	fileToEnv, err := exportEnvironments(ctx, envs, parallelism, sink, opts)
+	if err != nil {
+		return err
 	}
 
-	if parallelism > len(envs) {
-		parallelism = len(envs)
+	if !opts.SkipManifest {
+		if err := exportManifestFile(staging, fileToEnv, replaced); err != nil {
+			return err
+		}
 	}
 
+	if inside {
+		return swapEntries(staging, to)
+	}
+	return swapDir(staging, to)
+}
+
+// exportEnvironments loads envs and writes their manifests to sink. It returns
+// the path of each manifest mapped to its environment.
+func exportEnvironments(ctx context.Context, envs []*v1alpha1.Environment, parallelism int, sink ExportSink, opts *ExportEnvOpts) (map[string]string, error) {
 	// get all environments for paths
 	loadedEnvs, err := parallelLoadEnvironments(ctx, envs, parallelOpts{
 		Opts:        opts.Opts,
 		Selector:    opts.Selector,
 		Parallelism: parallelism,
 	})
-	if err != nil {
-		return err
+	if cache := opts.Opts.JsonnetOpts.Cache; cache != nil {
+		trace.SpanFromContext(ctx).SetAttributes(cache.Attributes()...)
 	}
-
-	fileToEnv, err := manifestEnvironments(ctx, loadedEnvs, parallelism, to, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if opts.SkipManifest {
-		return nil
-	}
-	return exportManifestFile(to, fileToEnv, nil)
+	return manifestEnvironments(ctx, loadedEnvs, parallelism, sink, opts)
 }
 
-func manifestEnvironments(ctx context.Context, loadedEnvs []*v1alpha1.Environment, parallelism int, to string, opts *ExportEnvOpts) (map[string]string, error) {
+func manifestEnvironments(ctx context.Context, loadedEnvs []*v1alpha1.Environment, parallelism int, sink ExportSink, opts *ExportEnvOpts) (map[string]string, error) {
 	ctx, span := tracer.Start(ctx, "tanka.manifestEnvironments")
 	defer span.End()
 	span.SetAttributes(telemetry.AttrNumEnvs(len(loadedEnvs)))
@@ -156,7 +217,7 @@ func manifestEnvironments(ctx context.Context, loadedEnvs []*v1alpha1.Environmen
 						// Channel is empty and closed
 						return nil
 					}
-					localFileToEnv, err := manifestSingleEnv(ctx, work, to, opts)
+					localFileToEnv, err := manifestSingleEnv(ctx, work, sink, opts)
 					if err != nil {
 						telemetry.FailSpanWithError(span, err)
 						return err
@@ -193,7 +254,7 @@ func manifestEnvironments(ctx context.Context, loadedEnvs []*v1alpha1.Environmen
 	return fileToEnv, nil
 }
 
-func manifestSingleEnv(ctx context.Context, work *v1alpha1.Environment, to string, opts *ExportEnvOpts) (map[string]string, error) {
+func manifestSingleEnv(ctx context.Context, work *v1alpha1.Environment, sink ExportSink, opts *ExportEnvOpts) (map[string]string, error) {
 	ctx, span := tracer.Start(ctx, "tanka.manifestSingleEnv")
 	defer span.End()
 	span.SetAttributes(telemetry.AttrEnv(work)...)
@@ -240,22 +301,10 @@ func manifestSingleEnv(ctx context.Context, work *v1alpha1.Environment, to strin
 			return nil, fmt.Errorf("executing name template: %w", err)
 		}
 
-		// Create all subfolders in path
 		relpath := name + "." + opts.Extension
-		path := filepath.Join(to, relpath)
-
 		fileToEnv[relpath] = env.Metadata.Namespace
 
-		// Abort if already exists
-		if exists, err := fileExists(path); err != nil {
-			return nil, err
-		} else if exists {
-			return nil, fmt.Errorf("file '%s' already exists. Aborting", path)
-		}
-
-		// Write manifest
-		data := m.String()
-		if err := writeExportFile(path, []byte(data)); err != nil {
+		if err := sink.WriteManifest(relpath, env, m); err != nil {
 			return nil, err
 		}
 	}
@@ -276,7 +325,7 @@ func fileExists(name string) (bool, error) {
 func dirEmpty(dir string) (bool, error) {
 	f, err := os.Open(dir)
 	if os.IsNotExist(err) {
-		return true, os.MkdirAll(dir, os.ModePerm)
+		return true, nil
 	} else if err != nil {
 		return false, err
 	}
@@ -289,17 +338,19 @@ func dirEmpty(dir string) (bool, error) {
 	return false, err
 }
 
-func deletePreviouslyExportedManifestsFromTankaEnvs(path string, envs []*v1alpha1.Environment, skipManifest bool) error {
+func previouslyExportedManifestsFromTankaEnvs(path string, envs []*v1alpha1.Environment) ([]string, error) {
 	envNames := []string{}
 	for _, env := range envs {
 		envNames = append(envNames, env.Metadata.Namespace)
 	}
-	return deletePreviouslyExportedManifests(path, envNames, skipManifest)
+	return previouslyExportedManifests(path, envNames)
 }
 
-func deletePreviouslyExportedManifests(path string, tankaEnvNames []string, skipManifest bool) error {
+// previouslyExportedManifests returns the files that manifest.json lists for
+// the given environments
+func previouslyExportedManifests(path string, tankaEnvNames []string) ([]string, error) {
 	if len(tankaEnvNames) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	envNamesMap := make(map[string]struct{})
@@ -313,34 +364,223 @@ func deletePreviouslyExportedManifests(path string, tankaEnvNames []string, skip
 	manifestContent, err := os.ReadFile(manifestFilePath)
 	if errors.Is(err, fs.ErrNotExist) {
 		log.Warn().Msgf("No manifest file found at %s, skipping deletion of previously exported manifests\n", manifestFilePath)
-		return nil
+		return nil, nil
 	} else if err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := json.Unmarshal(manifestContent, &fileToEnvMap); err != nil {
-		return err
+		return nil, err
 	}
 
-	var deletedManifestKeys []string
+	var manifestKeys []string
 	for exportedManifest, manifestEnv := range fileToEnvMap {
 		if _, ok := envNamesMap[manifestEnv]; ok {
-			deletedManifestKeys = append(deletedManifestKeys, exportedManifest)
-			if err := os.Remove(filepath.Join(path, exportedManifest)); err != nil && !os.IsNotExist(err) {
+			manifestKeys = append(manifestKeys, exportedManifest)
+		}
+	}
+
+	return manifestKeys, nil
+}
+
+// copyExportDir copies the contents of the output dir from to dir, except for
+// the skipped files. Files are hard-linked where possible, as they are never
+// written to afterwards.
+func copyExportDir(from, dir string, skipped []string) error {
+	skip := make(map[string]bool, len(skipped))
+	for _, f := range skipped {
+		skip[filepath.Clean(f)] = true
+	}
+
+	return filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// dir may be inside of from, see stagingDir
+		if path == dir {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case skip[rel]:
+			return nil
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
 				return err
 			}
+			return os.Symlink(link, target)
+		case !d.Type().IsRegular():
+			return fmt.Errorf("'%s' is not a regular file", path)
+		}
+
+		if err := os.Link(path, target); err == nil {
+			return nil
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+func copyFile(from, to string, perm fs.FileMode) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// outputDir returns the absolute path of the output dir to. If it is a
+// symlink, its target is returned, so the link is kept.
+func outputDir(to string) (string, error) {
+	if resolved, err := filepath.EvalSymlinks(to); err == nil {
+		to = resolved
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	return filepath.Abs(to)
+}
+
+// stagingDir creates the directory the new output for dir is built in. This
+// is a sibling of dir, so that swapDir can replace dir using renames. If dir
+// can't be renamed, the staging dir is created inside of it instead, which
+// inside reports. Its entries are then swapped in using swapEntries.
+func stagingDir(dir string) (staging string, inside bool, err error) {
+	pattern := "." + filepath.Base(dir) + stagingDirSuffix
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(dir), os.ModePerm); err != nil {
+			return "", false, err
+		}
+		staging, err := os.MkdirTemp(filepath.Dir(dir), pattern)
+		return staging, false, err
+	} else if err != nil {
+		return "", false, err
+	}
+
+	// fails if the parent is not writable
+	if renamable(dir) {
+		if staging, err := os.MkdirTemp(filepath.Dir(dir), pattern); err == nil {
+			return staging, false, nil
+		}
+	}
+	staging, err = os.MkdirTemp(dir, pattern)
+	return staging, true, err
+}
+
+// renamable tells whether dir can be moved: mount points can't, and neither
+// can the working directory or its parents without the shell losing track
+func renamable(dir string) bool {
+	if wd, err := os.Getwd(); err == nil {
+		rel, err := filepath.Rel(dir, wd)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return false
+		}
+	}
+	return !mountPoint(dir)
+}
+
+// swapDir replaces the directory to with dir, using renames. If to exists, it
+// is moved aside first and restored if dir can't be moved in its place.
+func swapDir(dir, to string) error {
+	info, err := os.Stat(to)
+	if os.IsNotExist(err) {
+		if err := os.Chmod(dir, 0755); err != nil {
+			return err
+		}
+		return os.Rename(dir, to)
+	} else if err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	// reserve a name for the previous output
+	previous, err := os.MkdirTemp(filepath.Dir(to), "."+filepath.Base(to)+previousDirSuffix)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(previous); err != nil {
+		return err
+	}
+
+	if err := os.Rename(to, previous); err != nil {
+		return fmt.Errorf("moving previous output aside: %w. Is '%s' a mount point?", err, to)
+	}
+	if err := os.Rename(dir, to); err != nil {
+		if rerr := os.Rename(previous, to); rerr != nil {
+			return fmt.Errorf("moving new output in place: %w. The previous output is left at '%s': %s", err, previous, rerr)
+		}
+		return fmt.Errorf("moving new output in place: %w", err)
+	}
+	return os.RemoveAll(previous)
+}
+
+// swapEntries replaces the contents of dir with those of staging, which is
+// inside of dir. Unlike swapDir, this is not atomic: each entry is replaced
+// using its own rename.
+func swapEntries(staging, dir string) error {
+	previous, err := os.MkdirTemp(dir, "."+filepath.Base(dir)+previousDirSuffix)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var moved []string
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+		if p == staging || p == previous {
+			continue
+		}
+		if err := os.Rename(p, filepath.Join(previous, e.Name())); err != nil {
+			for _, name := range moved {
+				_ = os.Rename(filepath.Join(previous, name), filepath.Join(dir, name))
+			}
+			os.Remove(previous)
+			return fmt.Errorf("moving previous output aside: %w", err)
 		}
+		moved = append(moved, e.Name())
 	}
 
-	// Only update manifest.json if not skipping manifest processing
-	if !skipManifest {
-		return exportManifestFile(path, nil, deletedManifestKeys)
+	entries, err = os.ReadDir(staging)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.Rename(filepath.Join(staging, e.Name()), filepath.Join(dir, e.Name())); err != nil {
+			return fmt.Errorf("moving new output in place: %w. The previous output is left at '%s'", err, previous)
+		}
 	}
-	return nil
+	return os.RemoveAll(previous)
 }
 
 // exportManifestFile writes a manifest file that maps the exported files to their environment.
-// If the file already exists, the newString entries will be merged with the existing ones.
+// If the file already exists, the deleted keys are removed from it and the new entries are merged with the existing ones.
+// The file is replaced atomically, so readers never see a partially written manifest.
 func exportManifestFile(path string, newFileToEnvMap map[string]string, deletedKeys []string) error {
 	if len(newFileToEnvMap) == 0 && len(deletedKeys) == 0 {
 		return nil
@@ -358,12 +598,13 @@ func exportManifestFile(path string, newFileToEnvMap map[string]string, deletedK
 		}
 	}
 
-	for k, v := range newFileToEnvMap {
-		currentFileToEnvMap[k] = v
-	}
+	// Files of re-exported environments may have the same name as before, so deletions come first
 	for _, k := range deletedKeys {
 		delete(currentFileToEnvMap, k)
 	}
+	for k, v := range newFileToEnvMap {
+		currentFileToEnvMap[k] = v
+	}
 
 	// Write manifest file
 	data, err := json.MarshalIndent(currentFileToEnvMap, "", "    ")
@@ -371,7 +612,29 @@ func exportManifestFile(path string, newFileToEnvMap map[string]string, deletedK
 		return fmt.Errorf("marshalling manifest file: %w", err)
 	}
 
-	return writeExportFile(manifestFilePath, data)
+	return writeFileAtomic(manifestFilePath, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it to path
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
 
 func writeExportFile(path string, data []byte) error {
@@ -0,0 +1,242 @@
+package tanka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/grafana/tanka/internal/telemetry"
+	"github.com/grafana/tanka/pkg/git"
+	"github.com/grafana/tanka/pkg/helm"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/spec/v1alpha1"
+)
+
+// Media types of environments exported as OCI artifacts. The layer is a
+// regular gzipped tar, so it can be extracted by any OCI client.
+const (
+	OCIExportArtifactType   = "application/vnd.grafana.tanka.export.v1"
+	OCIExportLayerMediaType = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// Annotations of environments exported as OCI artifacts
+const (
+	OCIAnnotationEnvName      = "tanka.dev/environment.name"
+	OCIAnnotationEnvNamespace = "tanka.dev/environment.namespace"
+	// OCIAnnotationEnvLabels holds the labels of the environment as JSON
+	OCIAnnotationEnvLabels = "tanka.dev/environment.labels"
+
+	ociAnnotationTitle    = "org.opencontainers.image.title"
+	ociAnnotationRevision = "org.opencontainers.image.revision"
+)
+
+var ociTagExp = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+// OCIOpts configure exporting to an OCI registry
+type OCIOpts struct {
+	// Ref is the registry/repository[:tag] to push to, optionally prefixed
+	// with oci://. Each environment is pushed to its own repository below it.
+	Ref string
+	// Revision of the exported sources, such as a git commit. It is recorded
+	// as annotation and used as tag if Ref has none.
+	Revision string
+	// SkipManifest omits manifest.json from the artifacts
+	SkipManifest bool
+	// Client to push with. Defaults to a helm.OCIClient using the Docker
+	// credentials
+	Client *helm.OCIClient
+}
+
+// OCISink is an ExportSink that pushes the manifests of each environment as
+// an OCI artifact, once Push is called. The artifact has a single layer, a
+// gzipped tar of the files of the environment, as written by StreamSink.
+type OCISink struct {
+	opts OCIOpts
+	ref  helm.OCIReference
+	tag  string
+
+	mu   sync.Mutex
+	envs map[string]*ociEnv
+}
+
+type ociEnv struct {
+	meta v1alpha1.Metadata
+	sink *StreamSink
+	buf  bytes.Buffer
+}
+
+// NewOCISink returns a sink pushing to opts.Ref
+func NewOCISink(opts OCIOpts) (*OCISink, error) {
+	s := strings.TrimPrefix(opts.Ref, helm.OCIPrefix)
+	if strings.Contains(s, "@") {
+		return nil, fmt.Errorf("%q must not contain a digest", opts.Ref)
+	}
+
+	tag := opts.Revision
+	if i := strings.LastIndex(s, ":"); i > strings.LastIndex(s, "/") {
+		s, tag = s[:i], s[i+1:]
+	}
+	if tag == "" {
+		return nil, fmt.Errorf("%q has no tag and the revision of the sources is unknown. Pass a tag, like %s:v1", opts.Ref, opts.Ref)
+	}
+	if !ociTagExp.MatchString(tag) {
+		return nil, fmt.Errorf("%q is not a valid tag", tag)
+	}
+
+	ref, err := helm.ParseOCIReference(helm.OCIPrefix + s)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Client == nil {
+		opts.Client = &helm.OCIClient{}
+	}
+
+	return &OCISink{
+		opts: opts,
+		ref:  ref,
+		tag:  tag,
+		envs: make(map[string]*ociEnv),
+	}, nil
+}
+
+// WriteManifest implements ExportSink
+func (s *OCISink) WriteManifest(path string, env *v1alpha1.Environment, m manifest.Manifest) error {
+	key := env.Metadata.Namespace + ":" + env.Metadata.Name
+
+	s.mu.Lock()
+	e, ok := s.envs[key]
+	if !ok {
+		e = &ociEnv{meta: env.Metadata}
+		sink, err := NewStreamSink(&e.buf, ExportSinkTarGz, s.opts.SkipManifest)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		e.sink = sink
+		s.envs[key] = e
+	}
+	s.mu.Unlock()
+
+	return e.sink.WriteManifest(path, env, m)
+}
+
+// Push pushes one artifact per environment that has manifests
+func (s *OCISink) Push(ctx context.Context) error {
+	_, span := tracer.Start(ctx, "tanka.OCISink.Push")
+	defer span.End()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	span.SetAttributes(attribute.Int("tanka.oci.artifacts", len(s.envs)))
+
+	refs := make(map[string]*ociEnv, len(s.envs))
+	for _, e := range s.envs {
+		ref := s.ref
+		ref.Repository += "/" + ociRepositoryName(e.meta.Name)
+		if other, ok := refs[ref.Repository]; ok {
+			return fmt.Errorf("environments %q and %q would both be pushed to %s. Rename one of them", other.meta.Name, e.meta.Name, ref)
+		}
+		refs[ref.Repository] = e
+	}
+
+	repositories := make([]string, 0, len(refs))
+	for r := range refs {
+		repositories = append(repositories, r)
+	}
+	sort.Strings(repositories)
+
+	for _, repository := range repositories {
+		e := refs[repository]
+		ref := s.ref
+		ref.Repository = repository
+
+		artifact, err := s.artifact(e)
+		if err != nil {
+			return err
+		}
+		digest, err := s.opts.Client.Push(ref, s.tag, artifact)
+		if err != nil {
+			telemetry.FailSpanWithError(span, err)
+			return err
+		}
+		log.Info().Str("environment", e.meta.Name).Str("digest", digest).Msgf("Pushed %s:%s", strings.TrimPrefix(ref.String(), helm.OCIPrefix), s.tag)
+	}
+
+	return nil
+}
+
+// artifact returns the artifact of e
+func (s *OCISink) artifact(e *ociEnv) (helm.OCIArtifact, error) {
+	if err := e.sink.Close(); err != nil {
+		return helm.OCIArtifact{}, err
+	}
+
+	annotations := map[string]string{
+		OCIAnnotationEnvName:      e.meta.Name,
+		OCIAnnotationEnvNamespace: e.meta.Namespace,
+	}
+	if len(e.meta.Labels) > 0 {
+		labels, err := json.Marshal(e.meta.Labels)
+		if err != nil {
+			return helm.OCIArtifact{}, err
+		}
+		annotations[OCIAnnotationEnvLabels] = string(labels)
+	}
+	if s.opts.Revision != "" {
+		annotations[ociAnnotationRevision] = s.opts.Revision
+	}
+
+	return helm.OCIArtifact{
+		ArtifactType:     OCIExportArtifactType,
+		Annotations:      annotations,
+		Layer:            e.buf.Bytes(),
+		LayerMediaType:   OCIExportLayerMediaType,
+		LayerAnnotations: map[string]string{ociAnnotationTitle: "manifests.tar.gz"},
+	}, nil
+}
+
+// SourceRevision returns the git commit checked out at path, or "" if path is
+// not part of a git repository
+func SourceRevision(path string) string {
+	dir := path
+	if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+		dir = filepath.Dir(path)
+	}
+	revision, err := git.Run(dir, "rev-parse", "HEAD")
+	if err != nil {
+		log.Debug().Err(err).Msgf("Not recording the git revision of %s", path)
+		return ""
+	}
+	return revision
+}
+
+var ociRepositoryInvalidExp = regexp.MustCompile(`[^a-z0-9]+`)
+
+// ociRepositoryName turns the name of an environment into a valid repository
+// path: it is lowercased and other characters than letters and digits are
+// replaced by dashes
+func ociRepositoryName(name string) string {
+	var components []string
+	for _, c := range strings.Split(strings.ToLower(name), "/") {
+		c = strings.Trim(ociRepositoryInvalidExp.ReplaceAllString(c, "-"), "-")
+		if c != "" {
+			components = append(components, c)
+		}
+	}
+	if len(components) == 0 {
+		return "default"
+	}
+	return strings.Join(components, "/")
+}
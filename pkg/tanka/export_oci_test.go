@@ -0,0 +1,156 @@
+package tanka
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/grafana/tanka/internal/ocitest"
+	"github.com/grafana/tanka/pkg/jsonnet"
+)
+
+type fakeArtifact struct {
+	ArtifactType string            `json:"artifactType"`
+	Annotations  map[string]string `json:"annotations"`
+	Layers       []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+	} `json:"layers"`
+}
+
+// artifact returns the manifest and the files of the layer pushed to
+// repository:tag
+func artifact(t *testing.T, registry *ocitest.Registry, repository, tag string) (fakeArtifact, map[string]string) {
+	data, ok := registry.Manifest(repository, tag)
+	require.True(t, ok, "%s:%s was not pushed", repository, tag)
+	var m fakeArtifact
+	require.NoError(t, json.Unmarshal(data, &m))
+	require.Len(t, m.Layers, 1)
+
+	layer, ok := registry.Blob(m.Layers[0].Digest)
+	require.True(t, ok)
+	gz, err := gzip.NewReader(bytes.NewReader(layer))
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	files := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = string(content)
+	}
+	return m, files
+}
+
+func TestOCISink(t *testing.T) {
+	registry := ocitest.NewRegistry(t)
+
+	require.NoError(t, os.Chdir("testdata"))
+	defer func() { require.NoError(t, os.Chdir("..")) }()
+
+	envs, err := FindEnvs(t.Context(), "test-export-envs", FindOpts{Selector: labels.Everything()})
+	require.NoError(t, err)
+
+	sink, err := NewOCISink(OCIOpts{
+		Ref:      "oci://" + registry.Host() + "/rendered",
+		Revision: "0123456789abcdef",
+	})
+	require.NoError(t, err)
+	opts := &ExportEnvOpts{
+		Format:    "{{.metadata.namespace}}/{{.metadata.name}}",
+		Extension: "yaml",
+		Sink:      sink,
+	}
+	opts.Opts.ExtCode = jsonnet.InjectedCode{
+		"deploymentName": "'my-deployment'",
+		"serviceName":    "'my-service'",
+	}
+	require.NoError(t, ExportEnvironments(t.Context(), envs, "", opts))
+	require.NoError(t, sink.Push(t.Context()))
+
+	// one artifact per environment, tagged with the revision
+	assert.Equal(t, []string{
+		"rendered/inline-namespace1:0123456789abcdef",
+		"rendered/inline-namespace2:0123456789abcdef",
+		"rendered/test-export-envs/static-env:0123456789abcdef",
+	}, registry.TagList())
+	m, files := artifact(t, registry, "rendered/test-export-envs/static-env", "0123456789abcdef")
+	assert.Equal(t, OCIExportArtifactType, m.ArtifactType)
+	assert.Equal(t, OCIExportLayerMediaType, m.Layers[0].MediaType)
+	assert.Equal(t, map[string]string{
+		OCIAnnotationEnvName:                "test-export-envs/static-env",
+		OCIAnnotationEnvNamespace:           "test-export-envs/static-env/main.jsonnet",
+		OCIAnnotationEnvLabels:              `{"type":"static"}`,
+		"org.opencontainers.image.revision": "0123456789abcdef",
+	}, m.Annotations)
+	assert.Equal(t, []string{"manifest.json", "static/my-deployment.yaml", "static/my-service.yaml"}, keys(files))
+	assert.JSONEq(t, `{
+		"static/my-deployment.yaml": "test-export-envs/static-env/main.jsonnet",
+		"static/my-service.yaml": "test-export-envs/static-env/main.jsonnet"
+	}`, files["manifest.json"])
+
+	m, files = artifact(t, registry, "rendered/inline-namespace1", "0123456789abcdef")
+	assert.Equal(t, "inline-namespace1", m.Annotations[OCIAnnotationEnvName])
+	assert.Len(t, files, 4)
+}
+
+func TestNewOCISink(t *testing.T) {
+	cases := []struct {
+		ref, revision string
+		repository    string
+		tag           string
+		err           string
+	}{
+		{ref: "ghcr.io/org/rendered:v1", revision: "abc", repository: "org/rendered", tag: "v1"},
+		{ref: "oci://localhost:5000/rendered", revision: "abc", repository: "rendered", tag: "abc"},
+		{ref: "localhost:5000/rendered", err: "has no tag and the revision of the sources is unknown"},
+		{ref: "ghcr.io/org/rendered@sha256:abc", err: "must not contain a digest"},
+		{ref: "ghcr.io/org/rendered:v+1", err: "is not a valid tag"},
+		{ref: "ghcr.io:v1", err: "is not of form"},
+	}
+	for _, c := range cases {
+		t.Run(c.ref, func(t *testing.T) {
+			sink, err := NewOCISink(OCIOpts{Ref: c.ref, Revision: c.revision})
+			if c.err != "" {
+				assert.ErrorContains(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.repository, sink.ref.Repository)
+			assert.Equal(t, c.tag, sink.tag)
+		})
+	}
+}
+
+func TestOCIRepositoryName(t *testing.T) {
+	for name, want := range map[string]string{
+		"environments/prod":      "environments/prod",
+		"Environments/Prod.EU_1": "environments/prod-eu-1",
+		"/default//":             "default",
+		"..":                     "default",
+	} {
+		assert.Equal(t, want, ociRepositoryName(name), name)
+	}
+}
+
+func keys(m map[string]string) []string {
+	var k []string
+	for key := range m {
+		k = append(k, key)
+	}
+	sort.Strings(k)
+	return k
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package tanka
+
+// mountPoint tells whether dir is on another device than its parent. This is
+// not detected on this platform
+func mountPoint(dir string) bool {
+	return false
+}
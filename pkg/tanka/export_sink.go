@@ -0,0 +1,233 @@
+package tanka
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/spec/v1alpha1"
+)
+
+// ExportSink receives the manifests of ExportEnvironments. Implementations
+// must be safe for concurrent use, as environments are exported in parallel.
+type ExportSink interface {
+	// WriteManifest stores m, exported by env, under the relative path
+	// computed from ExportEnvOpts.Format and Extension
+	WriteManifest(path string, env *v1alpha1.Environment, m manifest.Manifest) error
+}
+
+// ExportSinkFormat selects an ExportSink that writes to a single stream
+type ExportSinkFormat string
+
+const (
+	// ExportSinkYAML writes a multi-document YAML stream, like `tk show`
+	ExportSinkYAML ExportSinkFormat = "yaml"
+	// ExportSinkJSONLines writes one JSON object per manifest, holding the
+	// path, the environment metadata and the manifest itself
+	ExportSinkJSONLines ExportSinkFormat = "jsonl"
+	// ExportSinkTarGz writes a gzipped tar archive with one file per manifest
+	ExportSinkTarGz ExportSinkFormat = "tar.gz"
+	// ExportSinkZip writes a zip archive with one file per manifest
+	ExportSinkZip ExportSinkFormat = "zip"
+)
+
+// StreamSink is an ExportSink that writes all manifests to w once closed.
+// Manifests are ordered by path, so the output does not depend on the order in
+// which environments were exported.
+type StreamSink struct {
+	w      io.Writer
+	format ExportSinkFormat
+	// index adds manifest.json to archives
+	index bool
+
+	mu    sync.Mutex
+	files map[string]exportedManifest
+}
+
+type exportedManifest struct {
+	Path     string            `json:"path"`
+	Env      v1alpha1.Metadata `json:"environment"`
+	Manifest manifest.Manifest `json:"manifest"`
+}
+
+// NewStreamSink returns a sink writing the given format to w. Unless
+// skipManifest is set, archives include a manifest.json file mapping the
+// exported files to their environment.
+func NewStreamSink(w io.Writer, format ExportSinkFormat, skipManifest bool) (*StreamSink, error) {
+	switch format {
+	case ExportSinkYAML, ExportSinkJSONLines, ExportSinkTarGz, ExportSinkZip:
+	default:
+		return nil, fmt.Errorf("invalid export sink %q, must be one of %s, %s, %s or %s", format, ExportSinkYAML, ExportSinkJSONLines, ExportSinkTarGz, ExportSinkZip)
+	}
+
+	return &StreamSink{
+		w:      w,
+		format: format,
+		index:  !skipManifest,
+		files:  make(map[string]exportedManifest),
+	}, nil
+}
+
+// WriteManifest implements ExportSink
+func (s *StreamSink) WriteManifest(path string, env *v1alpha1.Environment, m manifest.Manifest) error {
+	path = filepath.ToSlash(path)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[path]; ok {
+		return fmt.Errorf("file '%s' already exists. Aborting", path)
+	}
+	s.files[path] = exportedManifest{Path: path, Env: env.Metadata, Manifest: m}
+	return nil
+}
+
+// Close writes all manifests to the underlying writer. It does not close the
+// writer itself.
+func (s *StreamSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := make([]exportedManifest, 0, len(s.files))
+	for _, f := range s.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	switch s.format {
+	case ExportSinkYAML:
+		list := make(manifest.List, 0, len(files))
+		for _, f := range files {
+			list = append(list, f.Manifest)
+		}
+		_, err := io.WriteString(s.w, list.String())
+		return err
+	case ExportSinkJSONLines:
+		enc := json.NewEncoder(s.w)
+		for _, f := range files {
+			if err := enc.Encode(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ExportSinkTarGz:
+		return s.writeTarGz(files)
+	case ExportSinkZip:
+		return s.writeZip(files)
+	}
+	return nil
+}
+
+// archiveFiles returns the content of each file of an archive
+func (s *StreamSink) archiveFiles(files []exportedManifest) ([]string, map[string][]byte, error) {
+	names := make([]string, 0, len(files)+1)
+	contents := make(map[string][]byte, len(files)+1)
+	fileToEnv := make(map[string]string, len(files))
+	for _, f := range files {
+		names = append(names, f.Path)
+		contents[f.Path] = []byte(f.Manifest.String())
+		fileToEnv[f.Path] = f.Env.Namespace
+	}
+
+	if s.index {
+		data, err := json.MarshalIndent(fileToEnv, "", "    ")
+		if err != nil {
+			return nil, nil, fmt.Errorf("marshalling manifest file: %w", err)
+		}
+		names = append(names, manifestFile)
+		contents[manifestFile] = data
+	}
+	return names, contents, nil
+}
+
+func (s *StreamSink) writeTarGz(files []exportedManifest) error {
+	names, contents, err := s.archiveFiles(files)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(s.w)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		data := contents[name]
+		// no timestamps, so the same manifests always yield the same archive
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			ModTime:  time.Unix(0, 0),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// zipEpoch is the earliest time zip archives can hold
+var zipEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (s *StreamSink) writeZip(files []exportedManifest) error {
+	names, contents, err := s.archiveFiles(files)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(s.w)
+	for _, name := range names {
+		hdr := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: zipEpoch}
+		hdr.SetMode(0644)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(contents[name]); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// dirSink writes each manifest to its own file below a directory. It is the
+// default of ExportEnvironments, which writes to a staging directory first.
+type dirSink struct {
+	dir string
+	// optional: directory the files are moved to afterwards, reported in
+	// errors instead of dir
+	target string
+}
+
+// WriteManifest implements ExportSink
+func (d dirSink) WriteManifest(path string, _ *v1alpha1.Environment, m manifest.Manifest) error {
+	name := filepath.Join(d.dir, path)
+	if d.target != "" {
+		name = filepath.Join(d.target, path)
+	}
+
+	path = filepath.Join(d.dir, path)
+
+	// Abort if already exists
+	if exists, err := fileExists(path); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("file '%s' already exists. Aborting", name)
+	}
+
+	// Write manifest
+	return writeExportFile(path, []byte(m.String()))
+}
@@ -0,0 +1,168 @@
+package tanka
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/grafana/tanka/pkg/jsonnet"
+)
+
+func exportToSink(t *testing.T, format ExportSinkFormat, skipManifest bool) []byte {
+	t.Helper()
+	require.NoError(t, os.Chdir("testdata"))
+	defer func() { require.NoError(t, os.Chdir("..")) }()
+
+	envs, err := FindEnvs(t.Context(), "test-export-envs", FindOpts{Selector: labels.Everything()})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	sink, err := NewStreamSink(&buf, format, skipManifest)
+	require.NoError(t, err)
+	opts := &ExportEnvOpts{
+		Format:    "{{.metadata.namespace}}/{{.metadata.name}}",
+		Extension: "yaml",
+		Sink:      sink,
+	}
+	opts.Opts.ExtCode = jsonnet.InjectedCode{
+		"deploymentName": "'my-deployment'",
+		"serviceName":    "'my-service'",
+	}
+	// the output directory is not used
+	require.NoError(t, ExportEnvironments(t.Context(), envs, "does-not-exist", opts))
+	require.NoError(t, sink.Close())
+	assert.NoDirExists(t, "does-not-exist")
+	return buf.Bytes()
+}
+
+var exportedPaths = []string{
+	"inline-namespace1/my-configmap.yaml",
+	"inline-namespace1/my-deployment.yaml",
+	"inline-namespace1/my-service.yaml",
+	"inline-namespace2/my-deployment.yaml",
+	"inline-namespace2/my-service.yaml",
+	"static/my-deployment.yaml",
+	"static/my-service.yaml",
+}
+
+func TestStreamSinkYAML(t *testing.T) {
+	out := exportToSink(t, ExportSinkYAML, false)
+	docs := bytes.Split(out, []byte("---\n"))
+	require.Len(t, docs, len(exportedPaths))
+	// ordered by path
+	assert.Contains(t, string(docs[0]), "kind: ConfigMap")
+	assert.Contains(t, string(docs[6]), "  name: my-service\n  namespace: static\n")
+}
+
+func TestStreamSinkJSONLines(t *testing.T) {
+	out := exportToSink(t, ExportSinkJSONLines, false)
+
+	var paths []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		var line exportedManifest
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		paths = append(paths, line.Path)
+		if line.Path == "static/my-service.yaml" {
+			assert.Equal(t, "test-export-envs/static-env/main.jsonnet", line.Env.Namespace)
+			assert.Equal(t, map[string]string{"type": "static"}, line.Env.Labels)
+			assert.Equal(t, "Service", line.Manifest.Kind())
+		}
+	}
+	assert.Equal(t, exportedPaths, paths)
+}
+
+func TestStreamSinkArchives(t *testing.T) {
+	readTarGz := func(t *testing.T, data []byte) map[string]string {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		tr := tar.NewReader(gz)
+		files := map[string]string{}
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			content, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			files[hdr.Name] = string(content)
+		}
+		return files
+	}
+	readZip := func(t *testing.T, data []byte) map[string]string {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+		files := map[string]string{}
+		for _, f := range zr.File {
+			r, err := f.Open()
+			require.NoError(t, err)
+			content, err := io.ReadAll(r)
+			require.NoError(t, err)
+			files[f.Name] = string(content)
+		}
+		return files
+	}
+
+	for _, tc := range []struct {
+		format ExportSinkFormat
+		read   func(*testing.T, []byte) map[string]string
+	}{
+		{ExportSinkTarGz, readTarGz},
+		{ExportSinkZip, readZip},
+	} {
+		t.Run(string(tc.format), func(t *testing.T) {
+			out := exportToSink(t, tc.format, false)
+			files := tc.read(t, out)
+			assert.Len(t, files, len(exportedPaths)+1)
+			for _, p := range exportedPaths {
+				assert.Contains(t, files, p)
+			}
+			assert.Contains(t, files["static/my-service.yaml"], "  name: my-service\n")
+			assert.Contains(t, files["manifest.json"], `"static/my-service.yaml": "test-export-envs/static-env/main.jsonnet"`)
+
+			// archives are reproducible
+			assert.Equal(t, out, exportToSink(t, tc.format, false))
+
+			files = tc.read(t, exportToSink(t, tc.format, true))
+			assert.NotContains(t, files, "manifest.json")
+		})
+	}
+}
+
+func TestStreamSinkConflicts(t *testing.T) {
+	require.NoError(t, os.Chdir("testdata"))
+	defer func() { require.NoError(t, os.Chdir("..")) }()
+
+	envs, err := FindEnvs(t.Context(), "test-export-envs", FindOpts{Selector: labels.Everything()})
+	require.NoError(t, err)
+
+	sink, err := NewStreamSink(io.Discard, ExportSinkYAML, false)
+	require.NoError(t, err)
+	opts := &ExportEnvOpts{
+		Format:    "{{.metadata.name}}",
+		Extension: "yaml",
+		Sink:      sink,
+	}
+	opts.Opts.ExtCode = jsonnet.InjectedCode{
+		"deploymentName": "'my-deployment'",
+		"serviceName":    "'my-service'",
+	}
+	assert.ErrorContains(t, ExportEnvironments(t.Context(), envs, "", opts), "already exists. Aborting")
+
+	opts.MergeStrategy = ExportMergeStrategyReplaceEnvs
+	assert.EqualError(t, ExportEnvironments(t.Context(), envs, "", opts), "merge strategies are only supported when exporting to a directory")
+
+	_, err = NewStreamSink(io.Discard, "blabla", false)
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@ package tanka
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -238,6 +239,110 @@ func TestExportEnvironmentsSkipManifest(t *testing.T) {
 	assert.True(t, os.IsNotExist(err), "manifest.json should not exist when SkipManifest is true")
 }
 
+func TestExportEnvironmentsReplaceSameFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	require.NoError(t, os.Chdir("testdata"))
+	defer func() { require.NoError(t, os.Chdir("..")) }()
+
+	envs, err := FindEnvs(t.Context(), "test-export-envs", FindOpts{Selector: labels.SelectorFromSet(labels.Set{"type": "static"})})
+	require.NoError(t, err)
+
+	opts := &ExportEnvOpts{
+		Format:        "{{.metadata.namespace}}/{{.metadata.name}}",
+		Extension:     "yaml",
+		MergeStrategy: ExportMergeStrategyReplaceEnvs,
+	}
+	opts.Opts.ExtCode = jsonnet.InjectedCode{
+		"deploymentName": "'deployment'",
+		"serviceName":    "'service'",
+	}
+
+	// Re-exported files that keep their names must stay in manifest.json
+	for i := 0; i < 2; i++ {
+		require.NoError(t, ExportEnvironments(t.Context(), envs, tempDir, opts))
+		checkFiles(t, tempDir, []string{
+			filepath.Join(tempDir, "static", "deployment.yaml"),
+			filepath.Join(tempDir, "static", "service.yaml"),
+			filepath.Join(tempDir, "manifest.json"),
+		})
+		manifestContent, err := os.ReadFile(filepath.Join(tempDir, "manifest.json"))
+		require.NoError(t, err)
+		assert.Equal(t, `{
+    "static/deployment.yaml": "test-export-envs/static-env/main.jsonnet",
+    "static/service.yaml": "test-export-envs/static-env/main.jsonnet"
+}`, string(manifestContent))
+	}
+}
+
+func TestExportEnvironmentsFailureKeepsOutput(t *testing.T) {
+	tempDir := t.TempDir()
+	require.NoError(t, os.Chdir("testdata"))
+	defer func() { require.NoError(t, os.Chdir("..")) }()
+
+	envs, err := FindEnvs(t.Context(), "test-export-envs", FindOpts{Selector: labels.Everything()})
+	require.NoError(t, err)
+	brokenEnvs, err := FindEnvs(t.Context(), "test-export-envs-broken", FindOpts{Selector: labels.Everything()})
+	require.NoError(t, err)
+
+	opts := &ExportEnvOpts{
+		Format:    "{{.metadata.namespace}}/{{.metadata.name}}",
+		Extension: "yaml",
+	}
+	opts.Opts.ExtCode = jsonnet.InjectedCode{
+		"deploymentName": "'initial-deployment'",
+		"serviceName":    "'initial-service'",
+	}
+	require.NoError(t, ExportEnvironments(t.Context(), envs, tempDir, opts))
+	expectedFiles := []string{
+		filepath.Join(tempDir, "inline-namespace1", "my-configmap.yaml"),
+		filepath.Join(tempDir, "inline-namespace1", "my-deployment.yaml"),
+		filepath.Join(tempDir, "inline-namespace1", "my-service.yaml"),
+		filepath.Join(tempDir, "inline-namespace2", "my-deployment.yaml"),
+		filepath.Join(tempDir, "inline-namespace2", "my-service.yaml"),
+		filepath.Join(tempDir, "static", "initial-deployment.yaml"),
+		filepath.Join(tempDir, "static", "initial-service.yaml"),
+		filepath.Join(tempDir, "manifest.json"),
+	}
+	manifestContent, err := os.ReadFile(filepath.Join(tempDir, "manifest.json"))
+	require.NoError(t, err)
+
+	// Re-export along with a broken env: nothing is deleted or written
+	opts.Opts.ExtCode = jsonnet.InjectedCode{
+		"deploymentName": "'updated-deployment'",
+		"serviceName":    "'updated-service'",
+	}
+	opts.MergeStrategy = ExportMergeStrategyReplaceEnvs
+	opts.MergeDeletedEnvs = []string{"test-export-envs/inline-envs/main.jsonnet"}
+	var schemaError *manifest.SchemaError
+	require.ErrorAs(t, ExportEnvironments(t.Context(), append(envs, brokenEnvs...), tempDir, opts), &schemaError)
+
+	checkFiles(t, tempDir, expectedFiles)
+	updatedManifestContent, err := os.ReadFile(filepath.Join(tempDir, "manifest.json"))
+	require.NoError(t, err)
+	assert.Equal(t, string(manifestContent), string(updatedManifestContent))
+	deploymentContent, err := os.ReadFile(filepath.Join(tempDir, "static", "initial-deployment.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(deploymentContent), "name: initial-deployment")
+
+	// Conflicts are detected before anything is moved as well
+	opts.MergeStrategy = ExportMergeStrategyFailConflicts
+	opts.MergeDeletedEnvs = nil
+	assert.ErrorContains(t, ExportEnvironments(t.Context(), envs, tempDir, opts), "already exists. Aborting")
+	checkFiles(t, tempDir, expectedFiles)
+
+	// no staging directories are left behind
+	siblings, err := os.ReadDir(filepath.Dir(tempDir))
+	require.NoError(t, err)
+	require.Len(t, siblings, 1)
+	assert.Equal(t, filepath.Base(tempDir), siblings[0].Name())
+
+	// a new output dir is only created on success
+	newDir := filepath.Join(tempDir, "new")
+	opts.MergeStrategy = ExportMergeStrategyNone
+	require.Error(t, ExportEnvironments(t.Context(), brokenEnvs, newDir, opts))
+	assert.NoDirExists(t, newDir)
+}
+
 func checkFiles(t testing.TB, dir string, files []string) {
 	t.Helper()
 
@@ -256,3 +361,64 @@ func checkFiles(t testing.TB, dir string, files []string) {
 
 	assert.ElementsMatch(t, files, existingFiles)
 }
+
+func TestExportEnvironmentsUnrenamableDir(t *testing.T) {
+	opts := &ExportEnvOpts{
+		Format:        "{{.metadata.namespace}}/{{.metadata.name}}",
+		Extension:     "yaml",
+		MergeStrategy: ExportMergeStrategyReplaceEnvs,
+	}
+	opts.Opts.ExtCode = jsonnet.InjectedCode{
+		"deploymentName": "'initial-deployment'",
+		"serviceName":    "'initial-service'",
+	}
+
+	// exporting into the working directory, which can't be moved, so the
+	// output is swapped in entry by entry. Other files are kept.
+	outDir := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, os.CopyFS(outDir, os.DirFS("testdata")))
+	require.NoError(t, os.RemoveAll(filepath.Join(outDir, "cases")))
+	require.NoError(t, os.RemoveAll(filepath.Join(outDir, "test-export-envs-broken")))
+	var sources []string
+	require.NoError(t, filepath.WalkDir(outDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			sources = append(sources, path)
+		}
+		return err
+	}))
+	t.Chdir(outDir)
+
+	envs, err := FindEnvs(t.Context(), "test-export-envs", FindOpts{Selector: labels.Everything()})
+	require.NoError(t, err)
+	require.NoError(t, ExportEnvironments(t.Context(), envs, ".", opts))
+	files := append(sources,
+		filepath.Join(outDir, "inline-namespace1", "my-configmap.yaml"),
+		filepath.Join(outDir, "inline-namespace1", "my-deployment.yaml"),
+		filepath.Join(outDir, "inline-namespace1", "my-service.yaml"),
+		filepath.Join(outDir, "inline-namespace2", "my-deployment.yaml"),
+		filepath.Join(outDir, "inline-namespace2", "my-service.yaml"),
+		filepath.Join(outDir, "static", "initial-deployment.yaml"),
+		filepath.Join(outDir, "static", "initial-service.yaml"),
+		filepath.Join(outDir, "manifest.json"),
+	)
+	checkFiles(t, outDir, files)
+	siblings, err := os.ReadDir(filepath.Dir(outDir))
+	require.NoError(t, err)
+	assert.Len(t, siblings, 1)
+
+	// a symlinked output dir is kept, and its target replaced
+	link := filepath.Join(t.TempDir(), "link")
+	require.NoError(t, os.Symlink(outDir, link))
+	opts.Opts.ExtCode = jsonnet.InjectedCode{
+		"deploymentName": "'updated-deployment'",
+		"serviceName":    "'updated-service'",
+	}
+	require.NoError(t, ExportEnvironments(t.Context(), envs, link, opts))
+
+	info, err := os.Lstat(link)
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSymlink, info.Mode().Type())
+	files[len(files)-3] = filepath.Join(outDir, "static", "updated-deployment.yaml")
+	files[len(files)-2] = filepath.Join(outDir, "static", "updated-service.yaml")
+	checkFiles(t, outDir, files)
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package tanka
+
+import (
+	"path/filepath"
+	"syscall"
+)
+
+// mountPoint tells whether dir is on another device than its parent
+func mountPoint(dir string) bool {
+	var st, parent syscall.Stat_t
+	if syscall.Stat(dir, &st) != nil || syscall.Stat(filepath.Dir(dir), &parent) != nil {
+		return false
+	}
+	return st.Dev != parent.Dev
+}
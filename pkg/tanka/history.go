@@ -0,0 +1,144 @@
+package tanka
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/grafana/tanka/pkg/history"
+	"github.com/grafana/tanka/pkg/jsonnet/jpath"
+	"github.com/grafana/tanka/pkg/kubernetes"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/process"
+	"github.com/grafana/tanka/pkg/spec/v1alpha1"
+)
+
+// RollbackOpts specify additional properties for the Rollback action
+type RollbackOpts struct {
+	ApplyOpts
+
+	// To is the number of the revision to roll back to
+	To int
+}
+
+// History returns the revisions recorded for the environment at baseDir,
+// oldest first
+func History(ctx context.Context, baseDir string, opts Opts) ([]history.Revision, error) {
+	ctx, span := tracer.Start(ctx, "tanka.History")
+	defer span.End()
+
+	env, err := LoadEnvironment(ctx, baseDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !history.Enabled(*env) {
+		return nil, history.ErrorDisabled{Env: env.Metadata.Name}
+	}
+
+	store, closeFn, err := openHistory(baseDir, env)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+
+	return store.List()
+}
+
+// Rollback re-applies a previously recorded revision of the environment at
+// baseDir. Like Apply, it shows a diff and asks for confirmation first. The
+// rollback itself is recorded as a new revision.
+func Rollback(ctx context.Context, baseDir string, opts RollbackOpts) error {
+	ctx, span := tracer.Start(ctx, "tanka.Rollback")
+	defer span.End()
+
+	env, err := LoadEnvironment(ctx, baseDir, opts.Opts)
+	if err != nil {
+		return err
+	}
+	if !history.Enabled(*env) {
+		return history.ErrorDisabled{Env: env.Metadata.Name}
+	}
+
+	store, closeFn, err := openHistory(baseDir, env)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	rev, err := store.Get(opts.To)
+	if err != nil {
+		return err
+	}
+
+	l := &LoadResult{Env: env, Resources: rev.State}
+	if len(opts.Filters) > 0 {
+		l.Resources = process.Filter(l.Resources, opts.Filters)
+	}
+
+	log.Info().Int("revision", rev.Number).Time("applied", rev.Timestamp).Msg("Rolling back")
+	return apply(ctx, baseDir, l, opts.ApplyOpts, fmt.Sprintf("Rollback to %d", rev.Number))
+}
+
+// openHistory returns the history.Store of env. For cluster storage, this
+// connects to the cluster, which is closed by the returned function
+func openHistory(baseDir string, env *v1alpha1.Environment) (*history.Store, func() error, error) {
+	root, err := historyRoot(baseDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if history.Local(*env) {
+		store, err := history.New(*env, root, nil)
+		return store, func() error { return nil }, err
+	}
+
+	kube, err := LoadResult{Env: env}.Connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := kube.History(root)
+	if err != nil {
+		kube.Close()
+		return nil, nil, err
+	}
+	return store, kube.Close, nil
+}
+
+// record stores state as a new revision, if history is enabled. Failures are
+// logged only, as the state has already been applied at this point
+func record(baseDir string, kube *kubernetes.Kubernetes, state manifest.List, description string) {
+	if !history.Enabled(kube.Env) {
+		return
+	}
+
+	root, err := historyRoot(baseDir)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to record revision")
+		return
+	}
+
+	store, err := kube.History(root)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to record revision")
+		return
+	}
+
+	rev, err := store.Save(state, description)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to record revision")
+		return
+	}
+	log.Info().Int("revision", rev.Number).Msg("Recorded revision")
+}
+
+// historyRoot returns the project root local history paths are relative to.
+// baseDir may also be the name of an environment, in which case the current
+// directory is used to find the root
+func historyRoot(baseDir string) (string, error) {
+	if _, err := os.Stat(baseDir); err != nil {
+		baseDir = "."
+	}
+	return jpath.FindRoot(baseDir)
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/fatih/color"
 
 	"github.com/grafana/tanka/pkg/kubernetes"
-	"github.com/grafana/tanka/pkg/term"
 )
 
 // PruneOpts specify additional properties for the Prune action
@@ -48,13 +47,17 @@ func Prune(ctx context.Context, baseDir string, opts PruneOpts) error {
 	}
 
 	// print diff
-	diff, err := kubernetes.StaticDiffer(false)(orphaned)
+	printed := orphaned
+	if !opts.ShowSecrets {
+		printed = kubernetes.MaskSecrets(orphaned)
+	}
+	diff, err := kubernetes.StaticDiffer(false)(printed)
 	if err != nil {
 		// static diff can't fail normally, so unlike in apply, this is fatal
 		// here
 		return err
 	}
-	fmt.Print(term.Colordiff(*diff).String())
+	fmt.Print(opts.RenderDiff(*diff).String())
 
 	// print namespace removal warning
 	namespaces := []string{}
@@ -84,8 +87,12 @@ func Prune(ctx context.Context, baseDir string, opts PruneOpts) error {
 	}
 
 	// delete resources
-	return kube.Delete(orphaned, kubernetes.DeleteOpts{
+	if err := kube.Delete(orphaned, kubernetes.DeleteOpts{
 		Force:  opts.Force,
 		DryRun: opts.DryRun,
-	})
+	}); err != nil {
+		return err
+	}
+
+	return wait(ctx, kube, orphaned, opts.ApplyBaseOpts, true)
 }
@@ -1,20 +1,22 @@
 package tanka
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
 	"sort"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/sync/errgroup"
 
 	"github.com/grafana/tanka/pkg/kubernetes"
 	"github.com/grafana/tanka/pkg/kubernetes/client"
 	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/process"
+	"github.com/grafana/tanka/pkg/secrets"
 	"github.com/grafana/tanka/pkg/spec/v1alpha1"
 	"github.com/grafana/tanka/pkg/term"
 )
@@ -43,11 +45,39 @@ type ApplyBaseOpts struct {
 	DryRun string
 	// Force ignores any warnings kubectl might have
 	Force bool
+
+	// Wait blocks until applied resources are ready, or deleted resources are
+	// gone from the cluster
+	Wait bool
+	// WaitTimeout limits how long Wait blocks. Zero waits forever
+	WaitTimeout time.Duration
 }
 
 type DiffBaseOpts struct {
 	// Color controls color output
 	Color string
+	// SideBySide renders diffs in two columns instead of unified format
+	SideBySide bool
+	// ShowSecrets disables masking the values of Secrets and redacting
+	// decrypted secrets
+	ShowSecrets bool
+}
+
+// RenderDiff formats diff output for the terminal, as configured by opts
+func (o DiffBaseOpts) RenderDiff(diff string) *bytes.Buffer {
+	diff = o.Redact(diff)
+	if o.SideBySide {
+		return term.SideBySide(diff, term.Width())
+	}
+	return term.Colordiff(diff)
+}
+
+// Redact removes decrypted secrets from s, unless ShowSecrets is set
+func (o DiffBaseOpts) Redact(s string) string {
+	if o.ShowSecrets {
+		return s
+	}
+	return secrets.Redact(s)
 }
 
 // ApplyOpts specify additional properties for the Apply action
@@ -63,6 +93,9 @@ type ApplyOpts struct {
 
 	// ServerSide bool passed to kubectl as --server-side
 	ServerSide bool
+	// WaitPhases waits for each apply phase to be established before the
+	// next one is applied. Implied by Wait
+	WaitPhases bool
 }
 
 // ErrorApplyStrategyUnknown occurs when an apply-strategy is requested that does
@@ -85,6 +118,13 @@ func Apply(ctx context.Context, baseDir string, opts ApplyOpts) error {
 		return err
 	}
 
+	return apply(ctx, baseDir, l, opts, "Apply")
+}
+
+// apply shows the diff of the loaded resources, asks for confirmation and
+// applies them. If enabled, the applied state is recorded in the environments
+// history using the given description.
+func apply(ctx context.Context, baseDir string, l *LoadResult, opts ApplyOpts, description string) error {
 	// If the apply strategy was not set on the command-line, draw from spec or use default
 	if opts.ApplyStrategy == "" {
 		if l.Env.Spec.ApplyStrategy != "" {
@@ -111,7 +151,10 @@ func Apply(ctx context.Context, baseDir string, opts ApplyOpts) error {
 	var noChanges bool
 	if opts.DiffStrategy != "none" {
 		// show diff
-		diff, err := kube.Diff(ctx, l.Resources, kubernetes.DiffOpts{Strategy: opts.DiffStrategy})
+		diff, err := kube.Diff(ctx, l.Resources, kubernetes.DiffOpts{
+			Strategy:    opts.DiffStrategy,
+			ShowSecrets: opts.ShowSecrets,
+		})
 		switch {
 		case err != nil:
 			// This is not fatal, the diff is not strictly required
@@ -127,23 +170,73 @@ func Apply(ctx context.Context, baseDir string, opts ApplyOpts) error {
 
 		// in case of non-fatal error diff may be nil
 		if diff != nil {
-			b := term.Colordiff(*diff)
+			b := opts.RenderDiff(*diff)
 			fmt.Print(b.String())
 		}
 	}
 
 	// prompt for confirmation
-	if opts.AutoApprove != AutoApproveAlways && !(noChanges && opts.AutoApprove == AutoApproveNoChanges) && opts.DryRun == "" {
+	if opts.AutoApprove != AutoApproveAlways && !(noChanges && opts.AutoApprove == AutoApproveNoChanges) && !client.IsDryRun(opts.DryRun) {
 		if err := confirmPrompt("Applying to", l.Env.Spec.Namespace, kube.Info()); err != nil {
 			return err
 		}
 	}
 
-	return kube.Apply(l.Resources, kubernetes.ApplyOpts{
-		Force:         opts.Force,
-		Validate:      opts.Validate,
-		DryRun:        opts.DryRun,
-		ApplyStrategy: opts.ApplyStrategy,
+	if err := kube.Apply(ctx, l.Resources, kubernetes.ApplyOpts{
+		ApplyOpts: client.ApplyOpts{
+			Force:         opts.Force,
+			Validate:      opts.Validate,
+			DryRun:        opts.DryRun,
+			ApplyStrategy: opts.ApplyStrategy,
+		},
+		WaitPhases:   opts.Wait || opts.WaitPhases,
+		PhaseTimeout: opts.WaitTimeout,
+	}); err != nil {
+		return err
+	}
+
+	if recordRevision(opts) {
+		record(baseDir, kube, l.Resources, description)
+	}
+
+	return wait(ctx, kube, l.Resources, opts.ApplyBaseOpts, false)
+}
+
+// recordRevision tells whether an apply using opts is recorded in the history.
+// Dry-runs change nothing, and a --target applies only part of the
+// environment, which is no revision to roll back to.
+func recordRevision(opts ApplyOpts) bool {
+	switch {
+	case client.IsDryRun(opts.DryRun):
+		return false
+	case len(opts.Filters) > 0:
+		log.Info().Msg("Not recording a revision, as --target applied only part of the environment")
+		return false
+	}
+	return true
+}
+
+// wait blocks until state is ready (or deleted) if requested by opts
+func wait(ctx context.Context, kube *kubernetes.Kubernetes, state manifest.List, opts ApplyBaseOpts, deleted bool) error {
+	if !opts.Wait || client.IsDryRun(opts.DryRun) {
+		return nil
+	}
+
+	// Helm hooks were awaited during apply already, and may have been
+	// deleted afterwards. Skipped hooks were never applied.
+	if !deleted {
+		var resources manifest.List
+		for _, m := range state {
+			if !process.IsHook(m) && !process.IsSkippedHook(m) {
+				resources = append(resources, m)
+			}
+		}
+		state = resources
+	}
+
+	return kube.Wait(ctx, state, kubernetes.WaitOpts{
+		Timeout: opts.WaitTimeout,
+		Deleted: deleted,
 	})
 }
 
@@ -200,25 +293,41 @@ func Diff(ctx context.Context, baseDir string, opts DiffOpts) (*string, error) {
 	defer kube.Close()
 
 	return kube.Diff(ctx, l.Resources, kubernetes.DiffOpts{
-		Summarize: opts.Summarize,
-		Strategy:  opts.Strategy,
-		WithPrune: opts.WithPrune,
+		Summarize:   opts.Summarize,
+		Strategy:    opts.Strategy,
+		WithPrune:   opts.WithPrune,
+		ShowSecrets: opts.ShowSecrets,
+	})
+}
+
+// StructuredDiff is like Diff, but returns the differences per object and
+// field, e.g. for machine-readable output
+func StructuredDiff(ctx context.Context, baseDir string, opts DiffOpts) (*kubernetes.DiffResult, error) {
+	l, err := Load(ctx, baseDir, opts.Opts)
+	if err != nil {
+		return nil, err
+	}
+	kube, err := l.Connect()
+	if err != nil {
+		return nil, err
+	}
+	defer kube.Close()
+
+	return kube.StructuredDiff(ctx, l.Resources, kubernetes.DiffOpts{
+		Strategy:    opts.Strategy,
+		WithPrune:   opts.WithPrune,
+		ShowSecrets: opts.ShowSecrets,
 	})
 }
 
 // ListChangedEnvironments performs a high-level check using kubectl dry-run to identify environments with changes
 func ListChangedEnvironments(ctx context.Context, baseDir string, opts DiffOpts) (*string, error) {
-	// Find all environments in the directory
-	envMetas, err := FindEnvs(ctx, baseDir, FindOpts{
-		JsonnetOpts:           opts.JsonnetOpts,
-		JsonnetImplementation: opts.JsonnetImplementation,
-		Parallelism:           8, // magic number for now
-	})
+	results, err := FindDrift(ctx, baseDir, DriftOpts{Opts: opts.Opts})
 	if err != nil {
 		return nil, err
 	}
 
-	changed := CheckEnvironmentsForChanges(ctx, envMetas, opts)
+	changed := changedEnvironments(results)
 	if len(changed) == 0 {
 		return nil, nil
 	}
@@ -231,64 +340,17 @@ func ListChangedEnvironments(ctx context.Context, baseDir string, opts DiffOpts)
 
 // CheckEnvironmentsForChanges performs a high-level parallel check using kubectl diff --exit-code
 func CheckEnvironmentsForChanges(ctx context.Context, envs []*v1alpha1.Environment, opts DiffOpts) []string {
-	var mu sync.Mutex
-	var changed []string
-
-	g, ctx := errgroup.WithContext(ctx)
-	g.SetLimit(4)
-
-	for _, env := range envs {
-		envLoop := env
-		g.Go(func() error {
-			if hasChanges, envName := checkSingleEnvironmentChanges(ctx, envLoop, opts); hasChanges {
-				mu.Lock()
-				changed = append(changed, envName)
-				mu.Unlock()
-			}
-			return nil
-		})
-	}
-
-	if err := g.Wait(); err != nil {
-		log.Warn().Err(err).Msg("Failed to check environments for changes")
-	}
-	return changed
+	return changedEnvironments(CheckEnvironmentsDrift(ctx, envs, opts.Opts))
 }
 
-// checkSingleEnvironmentChanges uses kubectl diff to quickly check for changes
-func checkSingleEnvironmentChanges(ctx context.Context, env *v1alpha1.Environment, opts DiffOpts) (bool, string) {
-	envName := env.Spec.Namespace
-	if env.Metadata.Name != "" {
-		envName = env.Metadata.Name
-	}
-
-	// Load only this single environment to get its resources
-	tempOpts := opts.Opts
-	tempOpts.Name = env.Metadata.Name
-
-	// Use the environment's path for loading
-	envPath := env.Metadata.Namespace
-	l, err := Load(ctx, envPath, tempOpts)
-	if err != nil {
-		log.Warn().Err(err).Str("env", envName).Msg("Failed to load environment, assuming no changes")
-		return false, envName
-	}
-
-	kube, err := l.Connect()
-	if err != nil {
-		log.Warn().Err(err).Str("env", envName).Msg("Failed to connect, assuming no changes")
-		return false, envName
-	}
-	defer kube.Close()
-
-	// Use a lightweight check via `kubectl diff --exit-code`
-	hasChanges, err := kube.HasChanges(l.Resources)
-	if err != nil {
-		log.Warn().Err(err).Str("env", envName).Msg("Failed to check changes, assuming changes exist")
-		return true, envName
+func changedEnvironments(results []DriftResult) []string {
+	var changed []string
+	for _, r := range results {
+		if r.Changed {
+			changed = append(changed, r.Env)
+		}
 	}
-
-	return hasChanges, envName
+	return changed
 }
 
 // DeleteOpts specify additional properties for the Delete operation
@@ -310,7 +372,7 @@ func Delete(ctx context.Context, baseDir string, opts DeleteOpts) error {
 	}
 	defer kube.Close()
 
-	if opts.DryRun == "" {
+	if !client.IsDryRun(opts.DryRun) {
 		// show diff
 		// static differ will never fail and always return something if input is not nil
 		diff, err := kubernetes.StaticDiffer(false)(l.Resources)
@@ -321,22 +383,26 @@ func Delete(ctx context.Context, baseDir string, opts DeleteOpts) error {
 
 		// in case of non-fatal error diff may be nil
 		if diff != nil {
-			b := term.Colordiff(*diff)
+			b := opts.RenderDiff(*diff)
 			fmt.Print(b.String())
 		}
 	}
 
 	// prompt for confirmation
-	if opts.AutoApprove != AutoApproveAlways && opts.DryRun == "" {
+	if opts.AutoApprove != AutoApproveAlways && !client.IsDryRun(opts.DryRun) {
 		if err := confirmPrompt("Deleting from", l.Env.Spec.Namespace, kube.Info()); err != nil {
 			return err
 		}
 	}
 
-	return kube.Delete(l.Resources, kubernetes.DeleteOpts{
+	if err := kube.Delete(l.Resources, kubernetes.DeleteOpts{
 		Force:  opts.Force,
 		DryRun: opts.DryRun,
-	})
+	}); err != nil {
+		return err
+	}
+
+	return wait(ctx, kube, l.Resources, opts.ApplyBaseOpts, true)
 }
 
 // Show parses the environment at the given directory (a `baseDir`) and returns
@@ -0,0 +1,25 @@
+package tanka
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grafana/tanka/pkg/process"
+)
+
+func TestRecordRevision(t *testing.T) {
+	dryRun := func(mode string) ApplyOpts {
+		var opts ApplyOpts
+		opts.DryRun = mode
+		return opts
+	}
+	targeted := ApplyOpts{}
+	targeted.Filters = process.MustStrExps("deployment/app")
+
+	assert.True(t, recordRevision(ApplyOpts{}))
+	assert.True(t, recordRevision(dryRun("none")))
+	assert.False(t, recordRevision(dryRun("client")))
+	assert.False(t, recordRevision(dryRun("server")))
+	assert.False(t, recordRevision(targeted))
+}
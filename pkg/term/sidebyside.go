@@ -0,0 +1,128 @@
+package term
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"golang.org/x/term"
+)
+
+// DefaultWidth is used by Width if the terminal size cannot be determined
+const DefaultWidth = 160
+
+// Width returns the width of the terminal attached to stdout
+func Width() int {
+	w, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || w <= 0 {
+		return DefaultWidth
+	}
+	return w
+}
+
+// SideBySide renders unified diff output (diff -u -N) in two columns of
+// width/2, the old version on the left and the new one on the right. Removed
+// and added lines are paired up, so changed lines end up next to each other.
+func SideBySide(d string, width int) *bytes.Buffer {
+	s := sideBySide{col: (width - 3) / 2}
+	if s.col < 10 {
+		s.col = 10
+	}
+
+	for _, l := range strings.Split(strings.TrimSuffix(d, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(l, "diff -u -N"):
+			s.flush()
+			s.inHunk = false
+			color.New(color.FgBlue, color.Bold).Fprintln(&s.buf, l)
+		case strings.HasPrefix(l, "--- ") && !s.inHunk:
+			s.oldFile = strings.TrimPrefix(l, "--- ")
+		case strings.HasPrefix(l, "+++ ") && !s.inHunk:
+			bold := color.New(color.Bold)
+			s.row(s.oldFile, bold, "|", strings.TrimPrefix(l, "+++ "), bold)
+		case strings.HasPrefix(l, "@"):
+			s.flush()
+			s.inHunk = true
+			color.New(color.FgMagenta, color.Bold).Fprintln(&s.buf, l)
+		case strings.HasPrefix(l, "-") && s.inHunk:
+			s.del = append(s.del, l[1:])
+		case strings.HasPrefix(l, "+") && s.inHunk:
+			s.add = append(s.add, l[1:])
+		case strings.HasPrefix(l, " ") && s.inHunk:
+			s.flush()
+			s.row(l[1:], nil, " ", l[1:], nil)
+		case strings.HasPrefix(l, `\`):
+			// "\ No newline at end of file"
+		default:
+			s.flush()
+			s.inHunk = false
+			fmt.Fprintln(&s.buf, l)
+		}
+	}
+	s.flush()
+
+	return &s.buf
+}
+
+type sideBySide struct {
+	buf bytes.Buffer
+	col int
+
+	inHunk   bool
+	oldFile  string
+	del, add []string
+}
+
+// flush writes the pending removed and added lines, pairing them up
+func (s *sideBySide) flush() {
+	n := len(s.del)
+	if len(s.add) > n {
+		n = len(s.add)
+	}
+
+	red, green := color.New(color.FgRed), color.New(color.FgGreen)
+	for i := 0; i < n; i++ {
+		switch {
+		case i < len(s.del) && i < len(s.add):
+			s.row(s.del[i], red, "|", s.add[i], green)
+		case i < len(s.del):
+			s.row(s.del[i], red, "<", "", nil)
+		default:
+			s.row("", nil, ">", s.add[i], green)
+		}
+	}
+
+	s.del, s.add = nil, nil
+}
+
+// row writes a single line, colorizing each side using lc and rc if set
+func (s *sideBySide) row(left string, lc *color.Color, sep, right string, rc *color.Color) {
+	left, right = s.cell(left), s.trim(right)
+	if lc != nil {
+		left = lc.Sprint(left)
+	}
+	if rc != nil && right != "" {
+		right = rc.Sprint(right)
+	}
+
+	fmt.Fprintln(&s.buf, strings.TrimRight(fmt.Sprintf("%s %s %s", left, sep, right), " "))
+}
+
+// cell truncates or pads str to exactly the column width
+func (s *sideBySide) cell(str string) string {
+	str = s.trim(str)
+	return str + strings.Repeat(" ", s.col-utf8.RuneCountInString(str))
+}
+
+// trim truncates str to the column width
+func (s *sideBySide) trim(str string) string {
+	str = strings.ReplaceAll(str, "\t", "    ")
+	if utf8.RuneCountInString(str) <= s.col {
+		return str
+	}
+	r := []rune(str)
+	return string(r[:s.col-1]) + "…"
+}
@@ -0,0 +1,49 @@
+package term
+
+import (
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSideBySide(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	data := `diff -u -N LIVE/v1.ConfigMap.default.test MERGED/v1.ConfigMap.default.test
+--- LIVE/v1.ConfigMap.default.test
++++ MERGED/v1.ConfigMap.default.test
+@@ -1,5 +1,5 @@
+ data:
+-  foo: bar
++  foo: baz
++  new: value
+ kind: ConfigMap
+-old: a-very-long-line-that-does-not-fit
+\ No newline at end of file
+diff -u -N LIVE/v1.Secret.default.test MERGED/v1.Secret.default.test
+--- LIVE/v1.Secret.default.test
++++ MERGED/v1.Secret.default.test
+@@ -1 +1 @@
+--- a
++-- b
+`
+
+	expected := `diff -u -N LIVE/v1.ConfigMap.default.test MERGED/v1.ConfigMap.default.test
+LIVE/v1.ConfigMap.de… | MERGED/v1.ConfigMap.…
+@@ -1,5 +1,5 @@
+data:                   data:
+  foo: bar            |   foo: baz
+                      >   new: value
+kind: ConfigMap         kind: ConfigMap
+old: a-very-long-lin… <
+diff -u -N LIVE/v1.Secret.default.test MERGED/v1.Secret.default.test
+LIVE/v1.Secret.defau… | MERGED/v1.Secret.def…
+@@ -1 +1 @@
+-- a                  | -- b
+`
+
+	assert.Equal(t, expected, SideBySide(data, 46).String())
+}